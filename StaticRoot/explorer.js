@@ -11,6 +11,39 @@ const GlobalStore = {
 }
 
 
+//////////////////////////////////////////////////////////////////////////
+// fetch every page of a registry query, following X-Next-Cursor, and
+// merge the pages in to a single result without the total
+
+function regFetchAll(url) {
+    var result = { }
+
+    var fetchPage = function(cursor) {
+        var params = cursor ? { cursor: cursor } : { }
+        return axios
+            .get(url, { params: params })
+            .then(response => {
+                var data = response.data
+                delete data.total
+
+                for (const key in data) {
+                    // type listings may be split across pages
+                    if (Array.isArray(result[key])) {
+                        result[key] = result[key].concat(data[key])
+                    }
+                    else {
+                        result[key] = data[key]
+                    }
+                }
+
+                var next = response.headers['x-next-cursor']
+                return next ? fetchPage(next) : result
+            })
+    }
+
+    return fetchPage(null)
+}
+
 //////////////////////////////////////////////////////////////////////////
 // registry object component
 
@@ -144,10 +177,9 @@ Vue.component('registry-stats', {
             this.store.RegStats = null
             this.state = "loading"
 
-            axios
-                .get('/api/registry/')
-                .then(response => {
-                    this.store.RegStats = response.data
+            regFetchAll('/api/registry/')
+                .then(data => {
+                    this.store.RegStats = data
                     this.state = 'complete'
                 })
                 .catch(error => {
@@ -216,10 +248,9 @@ Vue.component('app-search', {
             this.state = 'loading'
             this.$root.$emit('SearchChanged', 'Initialising ...')
             
-            axios
-                .get('/api/registry/*')
-                .then(response => {
-                    this.store.Index = response.data
+            regFetchAll('/api/registry/*')
+                .then(data => {
+                    this.store.Index = data
                     
                     // if a query parameter has been passed,
                     // then go search
@@ -312,11 +343,10 @@ Vue.component('app-search', {
                 this.state = 'loading'
                 query = '/api/registry/' + objname[0] + '/' + objname[1]
                 
-                axios
-                    .get(query)
-                    .then(response => {
+                regFetchAll(query)
+                    .then(data => {
                         this.state = 'result'
-                        this.result = response.data
+                        this.result = data
                     })
                     .catch(error => {
                         this.error = error
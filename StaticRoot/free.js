@@ -2,6 +2,39 @@
 // DN42 IP Explorer
 //////////////////////////////////////////////////////////////////////////
 
+//////////////////////////////////////////////////////////////////////////
+// fetch every page of a registry query, following X-Next-Cursor, and
+// merge the pages in to a single result without the total
+
+function regFetchAll(url) {
+    var result = { }
+
+    var fetchPage = function(cursor) {
+        var params = cursor ? { cursor: cursor } : { }
+        return axios
+            .get(url, { params: params })
+            .then(response => {
+                var data = response.data
+                delete data.total
+
+                for (const key in data) {
+                    // type listings may be split across pages
+                    if (Array.isArray(result[key])) {
+                        result[key] = result[key].concat(data[key])
+                    }
+                    else {
+                        result[key] = data[key]
+                    }
+                }
+
+                var next = response.headers['x-next-cursor']
+                return next ? fetchPage(next) : result
+            })
+    }
+
+    return fetchPage(null)
+}
+
 //////////////////////////////////////////////////////////////////////////
 // root component
 
@@ -63,10 +96,9 @@ Vue.component('app-free4', {
             this.state = "loading"
 
             // IPv4 prefixes
-            axios
-                .get('/api/registry/inetnum/*')
-                .then(response => {
-                    this.inetnum = response.data
+            regFetchAll('/api/registry/inetnum/*')
+                .then(data => {
+                    this.inetnum = data
                     this.processIPv4()
                 })
                 .catch(error => {
@@ -421,10 +453,9 @@ Vue.component('app-free6', {
 
 
             // IPv6 prefixes
-            axios
-                .get('/api/registry/inet6num/*')
-                .then(response => {
-                    this.inet6num = response.data
+            regFetchAll('/api/registry/inet6num/*')
+                .then(data => {
+                    this.inet6num = data
                     this.processIPv6()
                 })
                 .catch(error => {
@@ -658,10 +689,9 @@ Vue.component('app-asn', {
             this.state = "loading"
 
             // fetch ASN list
-            axios
-                .get('/api/registry/aut-num')
-                .then(response => {
-                    this.autnum = response.data['aut-num']
+            regFetchAll('/api/registry/aut-num')
+                .then(data => {
+                    this.autnum = data['aut-num']
                     this.processASN()
                 })
                 .catch(error => {
@@ -0,0 +1,283 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ASPA (Autonomous System Provider Authorisation)
+//
+// Provider sets are defined on aut-num objects, using either:
+//
+// x-aspa-provider: AS4242420000
+// remarks:         aspa-provider: AS4242420000
+//
+// both forms may be repeated and may list several ASNs separated by
+// commas or spaces. A provider of AS0 declares that the AS has no
+// providers.
+
+type ASPARecord struct {
+	CustomerASID uint32   `json:"customer_asid"`
+	Expires      uint32   `json:"expires"`
+	Providers    []uint32 `json:"providers"`
+	Object       string   `json:"-"`
+}
+
+type ASPA struct {
+	CTime   time.Time
+	Commit  string
+	Records []*ASPARecord
+}
+
+var ASPAData *ASPA
+
+type ASPAMetaData struct {
+	Counts    uint   `json:"counts"`
+	Generated uint32 `json:"generated"`
+	Valid     uint32 `json:"valid"`
+}
+
+// the JSON format follows the rpki-client output
+type ASPAJSON struct {
+	MetaData ASPAMetaData  `json:"metadata"`
+	ASPAs    []*ASPARecord `json:"aspas"`
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitASPAAPI)
+	EventBus.Listen("RegistryUpdate", ASPAUpdate)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitASPAAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	s := router.
+		Methods("GET").
+		PathPrefix("/aspa").
+		Subrouter()
+
+	s.HandleFunc("/json", aspaJSONHandler)
+	s.HandleFunc("/bird", aspaBirdHandler)
+	s.HandleFunc("/obgpd", aspaOBGPdHandler)
+
+	log.Info("ASPA API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// api handlers
+
+// set the common response headers
+func aspaHeaders(w http.ResponseWriter, ctype string) {
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// cache for up to a week, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=604800")
+	w.Header().Set("ETag", ASPAData.Commit)
+}
+
+// return JSON formatted ASPA data
+func aspaJSONHandler(w http.ResponseWriter, r *http.Request) {
+
+	// cache for up to a week, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=604800")
+	w.Header().Set("ETag", ASPAData.Commit)
+
+	utime := uint32(ASPAData.CTime.Unix())
+	(&Response{
+		Data: &ASPAJSON{
+			MetaData: ASPAMetaData{
+				Counts:    uint(len(ASPAData.Records)),
+				Generated: utime,
+				Valid:     utime + (ROA_JSON_VALIDITY_PERIOD * 3600),
+			},
+			ASPAs: ASPAData.Records,
+		},
+		Records: ASPAData.Records,
+	}).Write(w, r)
+}
+
+// return ASPA in bird 2 static protocol format
+func aspaBirdHandler(w http.ResponseWriter, r *http.Request) {
+
+	aspaHeaders(w, "text/plain")
+
+	fmt.Fprintf(w, "#\n# dn42regsrv ASPA Generator\n# Last Updated: %s\n"+
+		"# Commit: %s\n#\n", ASPAData.CTime.String(), ASPAData.Commit)
+
+	for _, record := range ASPAData.Records {
+		if len(record.Providers) == 0 {
+			fmt.Fprintf(w, "route aspa %d transit;\n", record.CustomerASID)
+			continue
+		}
+		fmt.Fprintf(w, "route aspa %d providers %s;\n",
+			record.CustomerASID, joinASNs(record.Providers, ", "))
+	}
+}
+
+// return ASPA in OpenBGPd format
+func aspaOBGPdHandler(w http.ResponseWriter, r *http.Request) {
+
+	aspaHeaders(w, "text/plain")
+
+	fmt.Fprintf(w, "#\n# dn42regsrv ASPA Generator\n# Last Updated: %s\n"+
+		"# Commit: %s\n#\naspa-set {\n", ASPAData.CTime.String(), ASPAData.Commit)
+
+	for _, record := range ASPAData.Records {
+		// ASes without providers are left out, rather than writing
+		// an empty provider-as list
+		if len(record.Providers) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  customer-as %d provider-as { %s }\n",
+			record.CustomerASID, joinASNs(record.Providers, " "))
+	}
+
+	fmt.Fprintf(w, "}\n")
+}
+
+// format a list of ASNs
+func joinASNs(asns []uint32, sep string) string {
+	s := make([]string, len(asns))
+	for ix, asn := range asns {
+		s[ix] = strconv.FormatUint(uint64(asn), 10)
+	}
+	return strings.Join(s, sep)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called whenever the registry is updated
+
+func ASPAUpdate(params ...interface{}) {
+
+	registry := params[0].(*Registry)
+
+	aspa := &ASPA{
+		CTime:   time.Now(),
+		Commit:  registry.Commit,
+		Records: CompileASPA(registry),
+	}
+
+	expires := uint32(aspa.CTime.Unix()) + (ROA_JSON_VALIDITY_PERIOD * 3600)
+	for _, record := range aspa.Records {
+		record.Expires = expires
+	}
+
+	ASPAData = aspa
+
+	log.WithFields(log.Fields{
+		"aspas": len(aspa.Records),
+	}).Debug("ASPA data updated")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// compile ASPA records from the aut-num objects
+
+func CompileASPA(registry *Registry) []*ASPARecord {
+
+	records := make([]*ASPARecord, 0)
+
+	autnums := registry.Types["aut-num"]
+	if autnums == nil {
+		return records
+	}
+
+	for _, object := range autnums.Objects {
+
+		// collect the provider declarations
+		var declared []string
+		for _, a := range object.GetKey("x-aspa-provider") {
+			declared = append(declared, a.RawValue)
+		}
+		for _, a := range object.GetKey("remarks") {
+			value := strings.TrimSpace(a.RawValue)
+			if strings.HasPrefix(strings.ToLower(value), "aspa-provider:") {
+				declared = append(declared, value[len("aspa-provider:"):])
+			}
+		}
+		if len(declared) == 0 {
+			continue
+		}
+
+		customer, err := parseASN(object.Ref[len("aut-num/"):])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"object": object.Ref,
+				"error":  err,
+			}).Warn("Unable to parse aut-num for ASPA")
+			continue
+		}
+
+		providers := make(map[uint32]bool)
+		for _, d := range declared {
+			for _, field := range strings.FieldsFunc(d, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			}) {
+				asn, err := parseASN(field)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"object":   object.Ref,
+						"provider": field,
+					}).Warn("Invalid ASPA provider")
+					continue
+				}
+
+				// AS0 and the customer itself are not valid providers
+				if asn != 0 && asn != customer {
+					providers[asn] = true
+				}
+			}
+		}
+
+		record := &ASPARecord{
+			CustomerASID: customer,
+			Providers:    make([]uint32, 0, len(providers)),
+			Object:       object.Ref,
+		}
+		for asn := range providers {
+			record.Providers = append(record.Providers, asn)
+		}
+		sort.Slice(record.Providers, func(i, j int) bool {
+			return record.Providers[i] < record.Providers[j]
+		})
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CustomerASID < records[j].CustomerASID
+	})
+
+	return records
+}
+
+// parse an ASN, with or without the AS prefix
+func parseASN(s string) (uint32, error) {
+	number := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	asn, err := strconv.ParseUint(number, 10, 32)
+	return uint32(asn), err
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,158 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// load the test registry with extra aut-num objects declaring providers
+func testASPARegistry(t *testing.T) *Registry {
+
+	data := testCopyRegistry(t)
+
+	autnums := map[string][]string{
+		// providers from both forms, with the customer and AS0 ignored
+		"AS4242420003": {
+			"x-aspa-provider:    AS4242420001, 4242420002 AS4242420003",
+			"remarks:            aspa-provider: AS0",
+		},
+		// no providers
+		"AS4242420004": {
+			"remarks:            ASPA-Provider: AS0",
+			"remarks:            not a provider AS4242420002",
+		},
+		// invalid providers are skipped
+		"AS4242420005": {
+			"x-aspa-provider:    bogus,AS4242420001",
+			"remarks:            aspa-provider:AS4242420001",
+		},
+	}
+
+	for asn, lines := range autnums {
+		content := fmt.Sprintf("aut-num:            %s\n"+
+			"as-name:            %s\n"+
+			"mnt-by:             FOO-MNT\n"+
+			"%s\n"+
+			"source:             DN42\n", asn, asn, strings.Join(lines, "\n"))
+		err := ioutil.WriteFile(filepath.Join(data, "aut-num", asn),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return LoadRegistry(data, testRegistryCommit)
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestCompileASPA(t *testing.T) {
+
+	records := CompileASPA(testASPARegistry(t))
+
+	expected := []string{
+		"4242420002 aut-num/AS4242420002 [4242420001 4242420010]",
+		"4242420003 aut-num/AS4242420003 [4242420001 4242420002]",
+		"4242420004 aut-num/AS4242420004 []",
+		"4242420005 aut-num/AS4242420005 [4242420001]",
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("%d records, expected %d", len(records), len(expected))
+	}
+	for ix, record := range records {
+		got := fmt.Sprintf("%d %s %v", record.CustomerASID, record.Object,
+			record.Providers)
+		if got != expected[ix] {
+			t.Errorf("got '%s', expected '%s'", got, expected[ix])
+		}
+	}
+}
+
+func TestASPAAPI(t *testing.T) {
+
+	saved := ASPAData
+	ASPAUpdate(testASPARegistry(t))
+	t.Cleanup(func() { ASPAData = saved })
+
+	router := mux.NewRouter()
+	InitASPAAPI(router)
+
+	// JSON
+	w := testAPIRequest(router, "/aspa/json")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != testRegistryCommit {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	response := &ASPAJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	if response.MetaData.Counts != 4 || len(response.ASPAs) != 4 ||
+		response.MetaData.Valid != response.MetaData.Generated+
+			ROA_JSON_VALIDITY_PERIOD*3600 {
+		t.Errorf("unexpected metadata %+v", response.MetaData)
+	}
+	for _, aspa := range response.ASPAs {
+		if aspa.Expires != response.MetaData.Valid || aspa.Providers == nil {
+			t.Errorf("unexpected record %+v", aspa)
+		}
+	}
+	// an AS without providers has an empty list
+	if !strings.Contains(w.Body.String(),
+		`{"customer_asid":4242420004,"expires":`+
+			fmt.Sprint(response.MetaData.Valid)+`,"providers":[]}`) {
+		t.Errorf("no transit record in %s", w.Body.String())
+	}
+
+	// the JSON records may be streamed
+	w = testAPIRequest(router, "/aspa/json?format=ndjson")
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], `{"customer_asid":4242420002,`) {
+		t.Errorf("unexpected NDJSON %s", w.Body.String())
+	}
+
+	// bird
+	w = testAPIRequest(router, "/aspa/bird")
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "text/plain" ||
+		!strings.HasPrefix(body, "#\n# dn42regsrv ASPA Generator\n") ||
+		!strings.Contains(body, "# Commit: "+testRegistryCommit+"\n") ||
+		!strings.HasSuffix(body, "#\n"+
+			"route aspa 4242420002 providers 4242420001, 4242420010;\n"+
+			"route aspa 4242420003 providers 4242420001, 4242420002;\n"+
+			"route aspa 4242420004 transit;\n"+
+			"route aspa 4242420005 providers 4242420001;\n") {
+		t.Errorf("unexpected bird output:\n%s", body)
+	}
+
+	// OpenBGPd, without the AS that has no providers
+	w = testAPIRequest(router, "/aspa/obgpd")
+	body = w.Body.String()
+	if w.Header().Get("Content-Type") != "text/plain" ||
+		!strings.HasPrefix(body, "#\n# dn42regsrv ASPA Generator\n") ||
+		!strings.HasSuffix(body, "#\naspa-set {\n"+
+			"  customer-as 4242420002 provider-as { 4242420001 4242420010 }\n"+
+			"  customer-as 4242420003 provider-as { 4242420001 4242420002 }\n"+
+			"  customer-as 4242420005 provider-as { 4242420001 }\n"+
+			"}\n") {
+		t.Errorf("unexpected OpenBGPd output:\n%s", body)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
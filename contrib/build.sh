@@ -19,8 +19,8 @@ ${RUNTIME} run --rm \
            -v "${SOURCEPATH}:/go/src/dn42regsrv" \
            -v "${PWD}:/go/bin" \
            -w "/go/src/dn42regsrv" \
-           docker.io/golang:1.12 \
-           go get
+           docker.io/golang:1.19 \
+           go install
 
 ##########################################################################
 # end of code
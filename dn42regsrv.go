@@ -7,6 +7,7 @@ package main
 //////////////////////////////////////////////////////////////////////////
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"github.com/gorilla/handlers"
@@ -64,6 +65,51 @@ func ResponseJSON(w http.ResponseWriter, v interface{}) {
 	w.Write(data)
 }
 
+//////////////////////////////////////////////////////////////////////////
+// a JSON object that keeps its keys in the order they were added
+
+type OrderedJSON struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func NewOrderedJSON() *OrderedJSON {
+	return &OrderedJSON{
+		keys:   make([]string, 0),
+		values: make(map[string]interface{}),
+	}
+}
+
+// set a value, keys that already exist keep their original position
+func (o *OrderedJSON) Set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *OrderedJSON) MarshalJSON() ([]byte, error) {
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for ix, key := range o.keys {
+		if ix > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
 //////////////////////////////////////////////////////////////////////////
 // utility function to set the log level
 
@@ -125,12 +171,95 @@ func main() {
 		autoPull        = flag.BoolP("AutoPull", "a", true, "Automatically pull the registry")
 		branch          = flag.StringP("Branch", "p", "master", "git branch to pull")
 		authToken       = flag.StringP("AuthToken", "t", "secret", "Auth token for refresh endpoint")
+		roaKey          = flag.String("ROASigningKey", "", "PEM private key for signing ROA JSON")
+		verifyROA       = flag.String("VerifyROA", "", "Verify a ROA JSON file and exit")
+		roaPubKey       = flag.String("ROAPublicKey", "", "PEM public key for --VerifyROA")
+		as0Unallocated  = flag.Bool("ROAAS0Unallocated", false, "Generate AS0 ROAs for unallocated space")
+		as0Deny         = flag.Bool("ROAAS0Deny", false, "Generate AS0 ROAs for denied filter ranges")
+		validateRoutes  = flag.String("ValidateRoutes", "", "Validate a MRT or bird table dump and exit")
+		roaSources      = flag.StringArray("ROASource", nil, "Additional ROA source, name=path")
+		rpkiDir         = flag.String("RPKIDir", "", "Publish RPKI objects to this directory")
+		rpkiURI         = flag.String("RPKIURI", "rsync://rpki.dn42/dn42/", "Base URI for published RPKI objects")
+		verifyRPKI      = flag.String("VerifyRPKI", "", "Verify a published RPKI directory and exit")
+		dnsAddress      = flag.String("DNSBindAddress", "", "Authoritative DNS server bind address")
+		dnsNS           = flag.StringArray("DNSNameServer", nil, "Apex NS name for generated zones (default delegation-servers.dn42)")
+		dnsContact      = flag.String("DNSContact", "", "SOA contact for generated zones")
+		dnsTTL          = flag.Uint32("DNSTTL", 3600, "Default TTL for generated zones")
+		dnsRefresh      = flag.Uint32("DNSRefresh", 3600, "SOA refresh for generated zones")
+		dnsRetry        = flag.Uint32("DNSRetry", 900, "SOA retry for generated zones")
+		dnsExpire       = flag.Uint32("DNSExpire", 604800, "SOA expire for generated zones")
+		dnsMinimum      = flag.Uint32("DNSMinimum", 300, "SOA minimum (negative TTL) for generated zones")
+		dnsSerial       = flag.String("DNSSerial", "unixtime", "SOA serial scheme, 'unixtime' or 'date'")
+		dnsAuthZones    = flag.String("DNSAuthZones", "", "Config file listing authoritative zones")
+		dnsAuthFromReg  = flag.Bool("DNSAuthZonesFromRegistry", true, "Derive authoritative reverse zones from the registry")
+		dnsPushPDNS     = flag.String("DNSPushPowerDNS", "", "Push root zone changes to this PowerDNS API server URL")
+		dnsPushPDNSKey  = flag.String("DNSPushPowerDNSKey", "", "PowerDNS API key")
+		dnsPushServer   = flag.String("DNSPushServer", "", "Push root zone changes to this server using RFC 2136, host[:port]")
+		dnsPushTSIG     = flag.String("DNSPushTSIG", "", "TSIG key for RFC 2136 updates, [algorithm:]name:secret")
+		dnsPushIgnore   = flag.StringArray("DNSPushIgnore", nil, "Name to leave unchanged when pushing, '*.' prefix for subdomains")
+		dnsPushDryRun   = flag.Bool("DNSPushDryRun", false, "Log root zone changes without pushing them")
+		dnsPushState    = flag.String("DNSPushState", "", "File holding the last pushed root zone")
+		dnsSecondaries  = flag.StringArray("DNSSecondary", nil, "Secondary allowed AXFR and sent NOTIFY, IP[:port]")
+		dnsRecursive    = flag.StringArray("DNSRecursiveServer", nil, "Recursive server address for forwarding resolver configs")
+		dnssecKeys      = flag.StringArray("DNSSECKey", nil, "BIND format DNSSEC key for signing the root zone")
+		dnssecDenial    = flag.String("DNSSECDenial", "nsec", "DNSSEC denial of existence, 'nsec' or 'nsec3'")
+		dnssecValidity  = flag.Duration("DNSSECValidity", 14*24*time.Hour, "DNSSEC signature validity period")
+		dnssecGenerate  = flag.String("DNSSECGenerateKey", "", "Generate a DNSSEC key and exit, dir:algorithm:ksk|zsk")
+		verifyDNSSEC    = flag.String("VerifyDNSSEC", "", "Transfer the root zone from a server, verify its signatures and exit")
+		gqlMaxDepth     = flag.Int("GraphQLMaxDepth", 10, "Maximum depth of GraphQL queries")
+		gqlMaxCost      = flag.Int("GraphQLMaxCost", 20000, "Maximum number of fields resolved by a GraphQL query")
 	)
 	flag.Parse()
 
 	// now initialise logging properly based on the cmd line options
 	setLogLevel(*logLevel)
 
+	// just verify a ROA file ?
+	if *verifyROA != "" {
+		VerifyROAFile(*verifyROA, *roaPubKey)
+		os.Exit(0)
+	}
+
+	// or an RPKI repository ?
+	if *verifyRPKI != "" {
+		VerifyRPKIRepository(*verifyRPKI)
+		os.Exit(0)
+	}
+
+	// or a signed zone ?
+	if *verifyDNSSEC != "" {
+		VerifyDNSSECServer(*verifyDNSSEC)
+		os.Exit(0)
+	}
+
+	// generate a DNSSEC key ?
+	if *dnssecGenerate != "" {
+		GenerateDNSSECKey(*dnssecGenerate)
+		os.Exit(0)
+	}
+
+	// load the ROA signing key, before the registry is first loaded
+	InitialiseROASigning(*roaKey)
+	InitialiseROAAS0(*as0Unallocated, *as0Deny)
+	InitialiseROASources(*roaSources)
+
+	// just validate a table dump ?
+	if *validateRoutes != "" {
+		ValidateRoutesFile(*validateRoutes, *regDir, *gitPath)
+		os.Exit(0)
+	}
+
+	InitialiseRPKI(*rpkiDir, *rpkiURI)
+	InitialiseDNSConfig(*dnsNS, *dnsContact, *dnsTTL, *dnsRefresh, *dnsRetry,
+		*dnsExpire, *dnsMinimum, *dnsSerial)
+	InitialiseDNSAuthZones(*dnsAuthZones, *dnsAuthFromReg)
+	InitialiseDNSSEC(*dnssecKeys, *dnssecDenial, *dnssecValidity)
+	InitialiseDNSServer(*dnsAddress, *dnsSecondaries)
+	InitialiseDNSResolver(*dnsRecursive)
+	InitialiseDNSPush(*dnsPushPDNS, *dnsPushPDNSKey, *dnsPushServer,
+		*dnsPushTSIG, *dnsPushIgnore, *dnsPushDryRun, *dnsPushState)
+	InitialiseGraphQL(*gqlMaxDepth, *gqlMaxCost)
+
 	// parse the refreshInterval and start data collection
 	interval, err := time.ParseDuration(*refreshInterval)
 	if err != nil {
@@ -7,6 +7,7 @@ package main
 //////////////////////////////////////////////////////////////////////////
 
 import (
+	"encoding/csv"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -36,28 +37,20 @@ type DNSRecord struct {
 }
 
 type DNSZone struct {
+	Zone      string
 	Records   []*DNSRecord
+	AuthZones []*DNSAuthZoneSource `json:",omitempty"`
 	Commit    string
+	Serial    uint32
 	Generated time.Time
+
+	auth *DNSAuthZone
 }
 
 var DNSRootZone *DNSZone
 
-//////////////////////////////////////////////////////////////////////////
-// fixed set of authoritative zones
-
-var DNSRootAuthZones = map[string]string{
-	"dn42":                    "domain/dn42",
-	"recursive-servers.dn42":  "domain/recursive-servers.dn42",
-	"delegation-servers.dn42": "domain/delegation-servers.dn42",
-	"d.f.ip6.arpa":            "inet6num/fd00::_8",
-	"20.172.in-addr.arpa":     "inetnum/172.20.0.0_16",
-	"21.172.in-addr.arpa":     "inetnum/172.21.0.0_16",
-	"22.172.in-addr.arpa":     "inetnum/172.22.0.0_16",
-	"23.172.in-addr.arpa":     "inetnum/172.23.0.0_16",
-	"31.172.in-addr.arpa":     "inetnum/172.31.0.0_16",
-	"10.in-addr.arpa":         "inetnum/10.0.0.0_8",
-}
+// delegation zones for each authoritative zone
+var DNSZones map[string]*DNSZone
 
 //////////////////////////////////////////////////////////////////////////
 // called from main to initialise the API routing
@@ -72,6 +65,8 @@ func InitDNSAPI(params ...interface{}) {
 		Subrouter()
 
 	s.HandleFunc("/root-zone", dnsRZoneHandler)
+	s.HandleFunc("/auth-zones", dnsAuthZonesHandler)
+	s.HandleFunc("/zone/{zone}", dnsZoneHandler)
 
 	log.Info("DNS API installed")
 }
@@ -86,7 +81,7 @@ func dnsRZoneHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	format = query["format"]
 	if format == nil || len(format) != 1 {
-		format = []string{"json"}
+		format = []string{""}
 	}
 
 	// cache for up to a day
@@ -96,11 +91,59 @@ func dnsRZoneHandler(w http.ResponseWriter, r *http.Request) {
 	case "bind":
 		DNSRootZone.WriteBindFormat(w)
 
-	case "json":
-		ResponseJSON(w, DNSRootZone)
+	case "zone":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		DNSSECSigned(DNSRootZone.auth).WriteMasterFile(w,
+			DNSRootZone.Generated)
+
+	default:
+		DNSRootZone.response().Write(w, r)
+	}
+}
+
+// return the zones that are authoritative within DN42, and their source
+func dnsAuthZonesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	(&Response{Data: DNSRootZone.AuthZones}).Write(w, r)
+}
+
+// return the delegations for an authoritative zone
+func dnsZoneHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	name := strings.TrimSuffix(strings.ToLower(vars["zone"]), ".")
+
+	zone := DNSZones[name]
+	if zone == nil {
+		http.Error(w, "Zone '"+name+"' not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+
+	switch r.URL.Query().Get("format") {
+	case "zone":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		zone.auth.WriteMasterFile(w, zone.Generated)
 
 	default:
-		ResponseJSON(w, DNSRootZone)
+		zone.response().Write(w, r)
+	}
+}
+
+// render a zone, with CSV and NDJSON providing just the records
+func (zone *DNSZone) response() *Response {
+	return &Response{
+		Data:    zone,
+		Records: zone.Records,
+		CSV: func(w *csv.Writer) {
+			w.Write([]string{"name", "type", "content", "comment"})
+			for _, rr := range zone.Records {
+				w.Write([]string{rr.Name, rr.Type, rr.Content, rr.Comment})
+			}
+		},
 	}
 }
 
@@ -110,16 +153,23 @@ func dnsRZoneHandler(w http.ResponseWriter, r *http.Request) {
 func DNSUpdate(params ...interface{}) {
 
 	registry := params[0].(*Registry)
-	//	path := params[1].(string)
+	path := params[1].(string)
 
+	// the serial is derived from the commit time
 	zone := &DNSZone{
+		Zone:      ".",
 		Generated: time.Now(),
 		Commit:    registry.Commit,
+		Serial:    DNSConfig.Serial(registry.CommitTime),
 	}
 
 	// add zones that are authoritative within DN42
-	for name, object := range DNSRootAuthZones {
-		zone.AddRecords(registry, name, object, "DN42 Authoritative Zone")
+	zone.AuthZones = DNSCompileAuthZones(registry, path)
+	authoritative := make(map[string]bool)
+	for _, source := range zone.AuthZones {
+		zone.AddRecords(registry, source.Zone, source.Object,
+			"DN42 Authoritative Zone")
+		authoritative[source.Zone] = true
 	}
 
 	// search all domain objects and add stub records for each TLD
@@ -128,13 +178,41 @@ func DNSUpdate(params ...interface{}) {
 		// domain is a TLD if it doesn't contain a '.'
 		if strings.IndexRune(name, '.') == -1 {
 			// don't include zones which are authoritative within DN42
-			if DNSRootAuthZones[name] == "" {
+			if !authoritative[name] {
 				zone.AddRecords(registry, name, object.Ref, "Forward Zone")
 			}
 		}
 	}
 
+	zone.auth = zone.AuthZone(".", DNSRootZoneConfig(registry))
+
+	// sign the zone, if enabled
+	published := zone.auth
+	if DNSSigner != nil {
+		published = DNSSigner.Sign(zone.auth)
+	}
+
+	// generate the delegations for each authoritative zone
+	zones := make(map[string]*DNSZone)
+	for _, source := range zone.AuthZones {
+		if z := DNSBuildZone(registry, source, zone.Serial); z != nil {
+			zones[source.Zone] = z
+		}
+	}
+
 	DNSRootZone = zone
+	DNSZones = zones
+
+	// lint the delegations against the new set of zones
+	DNSLintUpdate(registry, zone.AuthZones)
+
+	// push any changes to an external server, if enabled
+	DNSPushUpdate(zone)
+
+	// update the built-in server, if enabled
+	if DNSAuthServer != nil {
+		DNSAuthServer.Update(published)
+	}
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -174,17 +252,14 @@ func (zone *DNSZone) AddRecords(registry *Registry, name string,
 		if len(fields) == 2 {
 			// add a record for the NS, together with a stub A or AAAA record
 
-			var stubtype string
-			if strings.IndexRune(fields[1], ':') == -1 {
-				// no : so IPv4
-				stubtype = "A"
-			} else {
-				// has : so IPv6
-				stubtype = "AAAA"
-			}
-
 			zone.AddRecord(name, "NS", fields[0]+".", comment)
-			zone.AddRecord(fields[0], stubtype, fields[1], comment)
+
+			// glue is only accepted for names within the delegation, so
+			// an object can't supply addresses for another's name servers
+			if DNSIsSubdomain(DNSFQDN(fields[0]), DNSFQDN(name)) {
+				zone.AddRecord(fields[0], dnsAddressType(fields[1]), fields[1],
+					comment)
+			}
 
 		} else {
 			// no, just add an NS record as it was presented
@@ -200,6 +275,55 @@ func (zone *DNSZone) AddRecords(registry *Registry, name string,
 
 }
 
+// return the record type for a stub address
+func dnsAddressType(address string) string {
+	if strings.IndexRune(address, ':') == -1 {
+		// no : so IPv4
+		return "A"
+	}
+	// has : so IPv6
+	return "AAAA"
+}
+
+//////////////////////////////////////////////////////////////////////////
+// convert the zone records to an authoritative zone
+
+func (zone *DNSZone) AuthZone(origin string,
+	config *DNSZoneConfig) *DNSAuthZone {
+
+	auth := NewDNSAuthZone(origin, zone.Serial, config)
+	auth.Commit = zone.Commit
+
+	for _, record := range zone.Records {
+
+		t, ok := DNSTypeFromString(record.Type)
+		if !ok {
+			continue
+		}
+
+		rdata, err := DNSRDataFromText(t, record.Content)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"name":    record.Name,
+				"type":    record.Type,
+				"content": record.Content,
+				"error":   err,
+			}).Warn("DNS: unable to convert record")
+			continue
+		}
+
+		auth.Add(&DNSRR{
+			Name:  DNSFQDN(record.Name),
+			Type:  t,
+			Class: DNS_CLASS_IN,
+			TTL:   config.TTL,
+			RData: rdata,
+		})
+	}
+
+	return auth
+}
+
 //////////////////////////////////////////////////////////////////////////
 // Functions for outputting zone records in different formats
 
@@ -226,6 +350,12 @@ func (zone *DNSZone) WriteBindFormat(w http.ResponseWriter) {
 		";; Commit Reference: %s\n;; Generated: %s\n",
 		zone.Commit, zone.Generated)
 
+	// list the authoritative zones and where they came from
+	for _, source := range zone.AuthZones {
+		fmt.Fprintf(w, ";; Authoritative Zone: %s (%s, %s)\n",
+			source.Zone, source.Object, source.Source)
+	}
+
 	// then simply output each record in turn
 	for _, record := range zone.Records {
 		fmt.Fprintln(w, record.ToBindString())
@@ -0,0 +1,482 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// authoritative zone data
+//
+// An authoritative zone holds records in wire format, grouped in to
+// RRsets by owner name and type, and answers queries following the
+// algorithm in RFC 1034 section 4.3.2.
+
+type DNSAuthZone struct {
+	Origin string
+	Serial uint32
+	Commit string
+
+	rrsets map[string]map[uint16][]*DNSRR
+	names  map[string]bool
+
+	// owners of the NSEC or NSEC3 records, in canonical order
+	chain []string
+}
+
+// SOA and apex settings for generated zones
+type DNSZoneConfig struct {
+	NameServers  []string
+	Contact      string
+	TTL          uint32
+	Refresh      uint32
+	Retry        uint32
+	Expire       uint32
+	Minimum      uint32
+	SerialScheme string
+}
+
+var DNSConfig = &DNSZoneConfig{
+	Contact:      "hostmaster.dn42.",
+	TTL:          3600,
+	Refresh:      3600,
+	Retry:        900,
+	Expire:       604800,
+	Minimum:      300,
+	SerialScheme: "unixtime",
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to set the zone configuration
+
+func InitialiseDNSConfig(nameservers []string, contact string, ttl uint32,
+	refresh uint32, retry uint32, expire uint32, minimum uint32,
+	scheme string) {
+
+	if len(nameservers) > 0 {
+		DNSConfig.NameServers = nameservers
+	}
+	if contact != "" {
+		DNSConfig.Contact = contact
+	}
+
+	DNSConfig.TTL = ttl
+	DNSConfig.Refresh = refresh
+	DNSConfig.Retry = retry
+	DNSConfig.Expire = expire
+	DNSConfig.Minimum = minimum
+
+	switch scheme {
+	case "unixtime", "date":
+		DNSConfig.SerialScheme = scheme
+	default:
+		log.WithFields(log.Fields{
+			"scheme": scheme,
+		}).Fatal("DNS serial scheme must be one of 'unixtime' or 'date'")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the configuration for the root zone, if no name servers were
+// configured the apex NS default to those of delegation-servers.dn42
+
+func DNSRootZoneConfig(registry *Registry) *DNSZoneConfig {
+
+	if len(DNSConfig.NameServers) > 0 {
+		return DNSConfig
+	}
+
+	config := *DNSConfig
+	if object := registry.GetObject(
+		"domain/delegation-servers.dn42"); object != nil {
+		for _, ns := range object.GetKey("nserver") {
+			if fields := strings.Fields(ns.RawValue); len(fields) > 0 {
+				config.NameServers = append(config.NameServers, fields[0])
+			}
+		}
+	}
+
+	if len(config.NameServers) == 0 {
+		log.Warn("DNS: no name servers configured or found in " +
+			"delegation-servers.dn42, the root zone has no apex NS")
+	}
+
+	return &config
+}
+
+//////////////////////////////////////////////////////////////////////////
+// derive a zone serial from the registry commit time
+//
+// both schemes depend only on the commit, so all instances serving the
+// same commit produce the same serial. The 'date' scheme uses the
+// YYYYMMDDnn form, with nn counting 864 second intervals through the
+// day (UTC).
+
+func (config *DNSZoneConfig) Serial(commit time.Time) uint32 {
+
+	if config.SerialScheme == "date" {
+		commit = commit.UTC()
+		midnight := time.Date(commit.Year(), commit.Month(), commit.Day(),
+			0, 0, 0, 0, time.UTC)
+		nn := uint32(commit.Sub(midnight) / (864 * time.Second))
+		return uint32(commit.Year())*1000000 + uint32(commit.Month())*10000 +
+			uint32(commit.Day())*100 + nn
+	}
+
+	return uint32(commit.Unix())
+}
+
+//////////////////////////////////////////////////////////////////////////
+// create a new zone, with a SOA and apex NS records from the config
+
+func NewDNSAuthZone(origin string, serial uint32,
+	config *DNSZoneConfig) *DNSAuthZone {
+
+	zone := newDNSAuthZone(origin, serial)
+
+	mname := "localhost."
+	if len(config.NameServers) > 0 {
+		mname = DNSFQDN(config.NameServers[0])
+	}
+
+	zone.Add(&DNSRR{
+		Name:  zone.Origin,
+		Type:  DNS_TYPE_SOA,
+		Class: DNS_CLASS_IN,
+		TTL:   config.TTL,
+		RData: DNSRDataSOA(mname, DNSFQDN(config.Contact), serial,
+			config.Refresh, config.Retry, config.Expire, config.Minimum),
+	})
+
+	for _, ns := range config.NameServers {
+		zone.Add(&DNSRR{
+			Name:  zone.Origin,
+			Type:  DNS_TYPE_NS,
+			Class: DNS_CLASS_IN,
+			TTL:   config.TTL,
+			RData: dnsAppendName(nil, DNSFQDN(ns)),
+		})
+	}
+
+	return zone
+}
+
+// create an empty zone
+func newDNSAuthZone(origin string, serial uint32) *DNSAuthZone {
+	return &DNSAuthZone{
+		Origin: DNSFQDN(origin),
+		Serial: serial,
+		rrsets: make(map[string]map[uint16][]*DNSRR),
+		names:  make(map[string]bool),
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// add a record to the zone, duplicates are ignored and the TTL of an
+// RRset is always that of the first record added. Signatures keep the
+// TTL of the RRset they cover.
+
+func (zone *DNSAuthZone) Add(rr *DNSRR) {
+
+	if !DNSIsSubdomain(rr.Name, zone.Origin) {
+		return
+	}
+
+	types := zone.rrsets[rr.Name]
+	if types == nil {
+		types = make(map[uint16][]*DNSRR)
+		zone.rrsets[rr.Name] = types
+	}
+
+	rrset := types[rr.Type]
+	for _, existing := range rrset {
+		if bytes.Equal(existing.RData, rr.RData) {
+			return
+		}
+	}
+	if len(rrset) > 0 && rr.Type != DNS_TYPE_RRSIG {
+		rr.TTL = rrset[0].TTL
+	}
+	types[rr.Type] = append(rrset, rr)
+
+	// record the name, and any empty non-terminals above it
+	for name := rr.Name; !zone.names[name]; name = DNSParent(name) {
+		zone.names[name] = true
+		if name == zone.Origin {
+			break
+		}
+	}
+}
+
+// return an RRset
+func (zone *DNSAuthZone) RRSet(name string, t uint16) []*DNSRR {
+	if types := zone.rrsets[name]; types != nil {
+		return types[t]
+	}
+	return nil
+}
+
+// return the SOA record
+func (zone *DNSAuthZone) SOA() *DNSRR {
+	if soa := zone.RRSet(zone.Origin, DNS_TYPE_SOA); len(soa) == 1 {
+		return soa[0]
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// canonical ordering (RFC 4034 section 6.1)
+
+// return true if name a sorts before name b
+func DNSCanonicalLess(a string, b string) bool {
+
+	la := strings.Split(strings.TrimSuffix(a, "."), ".")
+	lb := strings.Split(strings.TrimSuffix(b, "."), ".")
+	if a == "." {
+		la = nil
+	}
+	if b == "." {
+		lb = nil
+	}
+
+	for ia, ib := len(la)-1, len(lb)-1; ia >= 0 && ib >= 0; ia, ib = ia-1, ib-1 {
+		if c := strings.Compare(la[ia], lb[ib]); c != 0 {
+			return c < 0
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// return the owner names that hold records, in canonical order
+func (zone *DNSAuthZone) Names() []string {
+	names := make([]string, 0, len(zone.rrsets))
+	for name := range zone.rrsets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return DNSCanonicalLess(names[i], names[j])
+	})
+	return names
+}
+
+// return the types present at a name, in numeric order
+func (zone *DNSAuthZone) Types(name string) []uint16 {
+	types := make([]uint16, 0, len(zone.rrsets[name]))
+	for t := range zone.rrsets[name] {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// return all records in the zone, SOA first and then in canonical order
+func (zone *DNSAuthZone) Records() []*DNSRR {
+
+	records := make([]*DNSRR, 0)
+	if soa := zone.SOA(); soa != nil {
+		records = append(records, soa)
+	}
+
+	for _, name := range zone.Names() {
+		for _, t := range zone.Types(name) {
+			if name == zone.Origin && t == DNS_TYPE_SOA {
+				continue
+			}
+			rrset := append([]*DNSRR(nil), zone.rrsets[name][t]...)
+			sort.Slice(rrset, func(i, j int) bool {
+				return bytes.Compare(rrset[i].RData, rrset[j].RData) < 0
+			})
+			records = append(records, rrset...)
+		}
+	}
+
+	return records
+}
+
+//////////////////////////////////////////////////////////////////////////
+// find the highest zone cut between the origin and a name
+//
+// returns an empty string if the name is not below a delegation
+
+func (zone *DNSAuthZone) FindCut(name string) string {
+
+	// build the list of names from just below the origin to the name
+	var chain []string
+	for n := name; n != zone.Origin && n != "."; n = DNSParent(n) {
+		chain = append(chain, n)
+	}
+
+	for ix := len(chain) - 1; ix >= 0; ix-- {
+		if len(zone.RRSet(chain[ix], DNS_TYPE_NS)) > 0 {
+			return chain[ix]
+		}
+	}
+
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////
+// answer a query, filling in the response sections
+
+func (zone *DNSAuthZone) Answer(response *DNSMessage, qname string,
+	qtype uint16, do bool) {
+
+	// referral to a delegated zone ? DS records are owned by the parent,
+	// so are answered authoritatively at the cut itself
+	if cut := zone.FindCut(qname); cut != "" &&
+		!(qtype == DNS_TYPE_DS && cut == qname) {
+		response.Authority = append(response.Authority,
+			zone.RRSet(cut, DNS_TYPE_NS)...)
+		if do {
+			// signed zones prove an insecure delegation has no DS
+			if ds := zone.RRSet(cut, DNS_TYPE_DS); len(ds) > 0 {
+				response.Authority = append(response.Authority, ds...)
+				response.Authority = append(response.Authority,
+					zone.dnssecRRSIGs(cut, DNS_TYPE_DS)...)
+			} else {
+				zone.addDenial(response, cut, false)
+			}
+		}
+		response.Additional = append(response.Additional,
+			zone.glue(zone.RRSet(cut, DNS_TYPE_NS))...)
+		return
+	}
+
+	response.Authoritative = true
+
+	// follow CNAMEs within the zone
+	for hops := 0; hops < 8; hops++ {
+
+		types := zone.rrsets[qname]
+
+		if types == nil {
+			if !zone.names[qname] {
+				response.RCode = DNS_RCODE_NXDOMAIN
+			}
+			zone.addSOA(response, do)
+			if do {
+				zone.addDenial(response, qname,
+					response.RCode == DNS_RCODE_NXDOMAIN)
+			}
+			return
+		}
+
+		if qtype == DNS_TYPE_ANY {
+			for _, t := range zone.Types(qname) {
+				response.Answer = append(response.Answer, types[t]...)
+			}
+			return
+		}
+
+		if rrset := types[qtype]; len(rrset) > 0 {
+			response.Answer = append(response.Answer, rrset...)
+			if do {
+				response.Answer = append(response.Answer,
+					zone.dnssecRRSIGs(qname, qtype)...)
+			}
+			if qtype == DNS_TYPE_NS {
+				response.Additional = append(response.Additional,
+					zone.glue(rrset)...)
+			}
+			return
+		}
+
+		cname := types[DNS_TYPE_CNAME]
+		if len(cname) == 0 {
+			// NODATA
+			zone.addSOA(response, do)
+			if do {
+				zone.addDenial(response, qname, false)
+			}
+			return
+		}
+
+		response.Answer = append(response.Answer, cname[0])
+		if do {
+			response.Answer = append(response.Answer,
+				zone.dnssecRRSIGs(qname, DNS_TYPE_CNAME)...)
+		}
+		target, _, err := dnsReadName(cname[0].RData, 0)
+		if err != nil || !DNSIsSubdomain(target, zone.Origin) ||
+			zone.FindCut(target) != "" {
+			return
+		}
+		qname = target
+	}
+}
+
+// add the SOA, and any signatures, to the authority section for
+// negative answers, the TTL is limited by the SOA minimum (RFC 2308)
+func (zone *DNSAuthZone) addSOA(response *DNSMessage, do bool) {
+
+	soa := zone.SOA()
+	if soa == nil {
+		return
+	}
+
+	negative := *soa
+	minimum := binary.BigEndian.Uint32(soa.RData[len(soa.RData)-4:])
+	if minimum < negative.TTL {
+		negative.TTL = minimum
+	}
+	response.Authority = append(response.Authority, &negative)
+
+	if do {
+		for _, rrsig := range zone.dnssecRRSIGs(zone.Origin, DNS_TYPE_SOA) {
+			signature := *rrsig
+			signature.TTL = negative.TTL
+			response.Authority = append(response.Authority, &signature)
+		}
+	}
+}
+
+// return address records held in the zone for a set of NS records
+func (zone *DNSAuthZone) glue(nsset []*DNSRR) []*DNSRR {
+
+	glue := make([]*DNSRR, 0)
+	for _, ns := range nsset {
+		target, _, err := dnsReadName(ns.RData, 0)
+		if err != nil {
+			continue
+		}
+		glue = append(glue, zone.RRSet(target, DNS_TYPE_A)...)
+		glue = append(glue, zone.RRSet(target, DNS_TYPE_AAAA)...)
+	}
+	return glue
+}
+
+//////////////////////////////////////////////////////////////////////////
+// write the zone as an RFC 1035 master file
+
+func (zone *DNSAuthZone) WriteMasterFile(w io.Writer, generated time.Time) {
+
+	fmt.Fprintf(w, ";; DN42 Zone: %s\n;; Serial: %d\n"+
+		";; Commit Reference: %s\n;; Generated: %s\n",
+		zone.Origin, zone.Serial, zone.Commit,
+		generated.UTC().Format(time.RFC3339))
+
+	ttl := DNSConfig.TTL
+	if soa := zone.SOA(); soa != nil {
+		ttl = soa.TTL
+	}
+	fmt.Fprintf(w, "$ORIGIN %s\n$TTL %d\n", zone.Origin, ttl)
+
+	for _, rr := range zone.Records() {
+		fmt.Fprintln(w, rr.String())
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,67 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestDNSRootZoneConfig(t *testing.T) {
+
+	saved := DNSConfig.NameServers
+	t.Cleanup(func() { DNSConfig.NameServers = saved })
+
+	registry := testLoadRegistry(t)
+	missing := LoadRegistry(testCopyRegistry(t, "dns/delegation-servers.dn42"),
+		testRegistryCommit)
+
+	tests := []struct {
+		configured []string
+		registry   *Registry
+		soa        string // the SOA MNAME
+		ns         string // the apex NS
+	}{
+		// configured name servers are always used
+		{[]string{"a.root.dn42", "b.root.dn42."}, registry,
+			"a.root.dn42.", "a.root.dn42. b.root.dn42."},
+		// otherwise those of delegation-servers.dn42
+		{nil, registry, "ns1.root.dn42.", "ns1.root.dn42."},
+		{nil, missing, "localhost.", ""},
+	}
+
+	for _, test := range tests {
+		DNSConfig.NameServers = test.configured
+
+		config := DNSRootZoneConfig(test.registry)
+		zone := (&DNSZone{Serial: 1}).AuthZone(".", config)
+
+		soa := DNSRDataToText(DNS_TYPE_SOA, zone.SOA().RData)
+		if !strings.HasPrefix(soa, test.soa+" hostmaster.dn42. 1 ") {
+			t.Errorf("%v: unexpected SOA %s", test.configured, soa)
+		}
+
+		ns := make([]string, 0)
+		for _, rr := range zone.RRSet(".", DNS_TYPE_NS) {
+			ns = append(ns, DNSRDataToText(DNS_TYPE_NS, rr.RData))
+		}
+		if strings.Join(ns, " ") != test.ns {
+			t.Errorf("%v: unexpected apex NS %v", test.configured, ns)
+		}
+
+		// the global configuration is left alone
+		if len(DNSConfig.NameServers) != len(test.configured) {
+			t.Errorf("%v: configuration changed to %v", test.configured,
+				DNSConfig.NameServers)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
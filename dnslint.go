@@ -0,0 +1,537 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// DNS delegation linting
+//
+// checks the nserver and ds-rdata attributes of domain, inetnum and
+// inet6num objects using only the registry data. Each issue is reported
+// against the authoritative zone that publishes the delegation and the
+// mntners of the object.
+
+const (
+	DNS_LINT_ERROR   = "error"
+	DNS_LINT_WARNING = "warning"
+)
+
+type DNSLintIssue struct {
+	Object   string
+	Name     string
+	Zone     string
+	Mntners  []string
+	Severity string
+	Check    string
+	Message  string
+}
+
+type DNSLintCounts struct {
+	Errors   uint
+	Warnings uint
+}
+
+type DNSLint struct {
+	Commit    string
+	Generated time.Time
+	Issues    []*DNSLintIssue
+	Zones     map[string][]*DNSLintIssue
+	Mntners   map[string][]*DNSLintIssue
+}
+
+var DNSLintData *DNSLint
+
+// digest lengths, in bytes, for each DS digest type
+var dnsDSDigestLength = map[uint64]int{
+	1: 20, // SHA-1
+	2: 32, // SHA-256
+	3: 32, // GOST R 34.11-94
+	4: 48, // SHA-384
+}
+
+// DNSSEC algorithms that may be used in DS records, and whether
+// they are deprecated (RFC 8624)
+var dnsDSAlgorithms = map[uint64]bool{
+	1:  true,  // RSAMD5
+	3:  true,  // DSA
+	5:  true,  // RSASHA1
+	6:  true,  // DSA-NSEC3-SHA1
+	7:  true,  // RSASHA1-NSEC3-SHA1
+	8:  false, // RSASHA256
+	10: false, // RSASHA512
+	12: true,  // ECC-GOST
+	13: false, // ECDSAP256SHA256
+	14: false, // ECDSAP384SHA384
+	15: false, // ED25519
+	16: false, // ED448
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitDNSLintAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitDNSLintAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	s := router.
+		Methods("GET").
+		PathPrefix("/dns/lint").
+		Subrouter()
+
+	s.HandleFunc("", dnsLintSummaryHandler)
+	s.HandleFunc("/zone/{zone}", dnsLintZoneHandler)
+	s.HandleFunc("/mntner/{mntner}", dnsLintMntnerHandler)
+
+	log.Info("DNS lint API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// api handlers
+
+type DNSLintSummary struct {
+	Commit    string
+	Generated time.Time
+	Counts    DNSLintCounts
+	Zones     map[string]DNSLintCounts
+	Mntners   map[string]DNSLintCounts
+}
+
+// count the errors and warnings in a list of issues
+func dnsLintCount(issues []*DNSLintIssue) DNSLintCounts {
+	counts := DNSLintCounts{}
+	for _, issue := range issues {
+		if issue.Severity == DNS_LINT_ERROR {
+			counts.Errors++
+		} else {
+			counts.Warnings++
+		}
+	}
+	return counts
+}
+
+// return the number of issues for each zone and mntner
+func dnsLintSummaryHandler(w http.ResponseWriter, r *http.Request) {
+
+	lint := DNSLintData
+	summary := &DNSLintSummary{
+		Commit:    lint.Commit,
+		Generated: lint.Generated,
+		Counts:    dnsLintCount(lint.Issues),
+		Zones:     make(map[string]DNSLintCounts),
+		Mntners:   make(map[string]DNSLintCounts),
+	}
+
+	for zone, issues := range lint.Zones {
+		summary.Zones[zone] = dnsLintCount(issues)
+	}
+	for mntner, issues := range lint.Mntners {
+		summary.Mntners[mntner] = dnsLintCount(issues)
+	}
+
+	(&Response{Data: summary}).Write(w, r)
+}
+
+// return the issues for a zone
+func dnsLintZoneHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	zone := strings.ToLower(vars["zone"])
+	if zone != "." {
+		zone = strings.TrimSuffix(zone, ".")
+	}
+
+	issues := DNSLintData.Zones[zone]
+	if issues == nil {
+		issues = make([]*DNSLintIssue, 0)
+	}
+	(&Response{Data: issues}).Write(w, r)
+}
+
+// return the issues for a mntner
+func dnsLintMntnerHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	mntner := strings.ToUpper(vars["mntner"])
+
+	issues := DNSLintData.Mntners[mntner]
+	if issues == nil {
+		issues = make([]*DNSLintIssue, 0)
+	}
+	(&Response{Data: issues}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from DNSUpdate, once the authoritative zones are known
+
+func DNSLintUpdate(registry *Registry, zones []*DNSAuthZoneSource) {
+
+	lint := &DNSLint{
+		Commit:    registry.Commit,
+		Generated: time.Now(),
+		Issues:    CompileDNSLint(registry, zones),
+		Zones:     make(map[string][]*DNSLintIssue),
+		Mntners:   make(map[string][]*DNSLintIssue),
+	}
+
+	for _, issue := range lint.Issues {
+		lint.Zones[issue.Zone] = append(lint.Zones[issue.Zone], issue)
+		for _, mntner := range issue.Mntners {
+			lint.Mntners[mntner] = append(lint.Mntners[mntner], issue)
+		}
+	}
+
+	DNSLintData = lint
+
+	log.WithFields(log.Fields{
+		"issues": len(lint.Issues),
+	}).Debug("DNS lint updated")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// lint the registry
+
+// a delegating object
+type dnsLintObject struct {
+	object  *RegObject
+	names   []string
+	zone    string
+	mntners []string
+	glue    map[string][]string
+}
+
+func CompileDNSLint(registry *Registry,
+	zones []*DNSAuthZoneSource) []*DNSLintIssue {
+
+	issues := make([]*DNSLintIssue, 0)
+	objects := dnsLintObjects(registry, zones)
+
+	add := func(lo *dnsLintObject, severity string, check string,
+		format string, args ...interface{}) {
+		issues = append(issues, &DNSLintIssue{
+			Object:   lo.object.Ref,
+			Name:     lo.names[0],
+			Zone:     lo.zone,
+			Mntners:  lo.mntners,
+			Severity: severity,
+			Check:    check,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	// which objects delegate each name, and the glue for each NS name
+	delegated := make(map[string][]*dnsLintObject)
+	glue := make(map[string]map[string][]*dnsLintObject)
+
+	for _, lo := range objects {
+
+		nserver := lo.object.GetKey("nserver")
+		dsrdata := lo.object.GetKey("ds-rdata")
+
+		if len(nserver) == 0 {
+			if len(dsrdata) > 0 {
+				add(lo, DNS_LINT_WARNING, "ds-without-ns",
+					"ds-rdata is present, but there are no nserver attributes")
+			}
+			continue
+		}
+
+		for _, name := range lo.names {
+			delegated[name] = append(delegated[name], lo)
+		}
+
+		lo.lintNServers(nserver, add)
+		for _, ds := range dsrdata {
+			lo.lintDS(ds.RawValue, add)
+		}
+
+		for ns, addresses := range lo.glue {
+			key := strings.Join(addresses, " ")
+			if glue[ns] == nil {
+				glue[ns] = make(map[string][]*dnsLintObject)
+			}
+			glue[ns][key] = append(glue[ns][key], lo)
+		}
+	}
+
+	// the same name delegated by more than one object
+	for name, los := range delegated {
+		if len(los) < 2 {
+			continue
+		}
+		for _, lo := range los {
+			others := make([]string, 0, len(los)-1)
+			for _, other := range los {
+				if other != lo {
+					others = append(others, other.object.Ref)
+				}
+			}
+			add(lo, DNS_LINT_ERROR, "delegation-conflict",
+				"%s is also delegated by %s", name, strings.Join(others, ", "))
+		}
+	}
+
+	// the same NS name with different glue addresses
+	for ns, sets := range glue {
+		if len(sets) < 2 {
+			continue
+		}
+		for addresses, los := range sets {
+			for _, lo := range los {
+				others := make([]string, 0)
+				for other := range sets {
+					if other != addresses {
+						others = append(others, "["+other+"]")
+					}
+				}
+				sort.Strings(others)
+				add(lo, DNS_LINT_WARNING, "glue-inconsistent",
+					"glue for %s is [%s], but other objects use %s",
+					ns, addresses, strings.Join(others, ", "))
+			}
+		}
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Object != issues[j].Object {
+			return issues[i].Object < issues[j].Object
+		}
+		if issues[i].Check != issues[j].Check {
+			return issues[i].Check < issues[j].Check
+		}
+		return issues[i].Message < issues[j].Message
+	})
+
+	return issues
+}
+
+// collect the domain, inetnum and inet6num objects, together with the
+// names they delegate and the zone the delegation is published in
+func dnsLintObjects(registry *Registry,
+	zones []*DNSAuthZoneSource) []*dnsLintObject {
+
+	objects := make([]*dnsLintObject, 0)
+
+	for _, tname := range []string{"domain", "inetnum", "inet6num"} {
+
+		rtype := registry.Types[tname]
+		if rtype == nil {
+			continue
+		}
+
+		for oname, object := range rtype.Objects {
+
+			var names []string
+			if tname == "domain" {
+				names = []string{strings.ToLower(oname)}
+			} else {
+				cidr := object.GetKey("cidr")
+				if len(cidr) == 0 {
+					continue
+				}
+				_, network, err := net.ParseCIDR(cidr[0].RawValue)
+				if err != nil {
+					continue
+				}
+				for _, d := range dnsReverseNetworkDelegations(network) {
+					names = append(names, d.Zone)
+				}
+			}
+
+			lo := &dnsLintObject{
+				object:  object,
+				names:   names,
+				zone:    dnsLintZone(names[0], zones),
+				mntners: make([]string, 0),
+				glue:    make(map[string][]string),
+			}
+			for _, mnt := range object.GetKey("mnt-by") {
+				lo.mntners = append(lo.mntners, strings.ToUpper(mnt.RawValue))
+			}
+
+			objects = append(objects, lo)
+		}
+	}
+
+	return objects
+}
+
+// return the authoritative zone that a name is delegated from, names
+// that are not below an authoritative zone are delegated from the root
+func dnsLintZone(name string, zones []*DNSAuthZoneSource) string {
+
+	zone := "."
+	for _, source := range zones {
+		if name != source.Zone &&
+			DNSIsSubdomain(DNSFQDN(name), DNSFQDN(source.Zone)) &&
+			(zone == "." || len(source.Zone) > len(zone)) {
+			zone = source.Zone
+		}
+	}
+	return zone
+}
+
+//////////////////////////////////////////////////////////////////////////
+// individual checks
+
+type dnsLintAdd func(*dnsLintObject, string, string, string, ...interface{})
+
+// check the syntax of nserver attributes, and for in-bailiwick glue
+func (lo *dnsLintObject) lintNServers(nserver []*RegAttribute,
+	add dnsLintAdd) {
+
+	seen := make(map[string]bool)
+	hosts := make(map[string]bool)
+
+	for _, ns := range nserver {
+
+		value := strings.TrimSpace(ns.RawValue)
+		if seen[strings.ToLower(value)] {
+			add(lo, DNS_LINT_WARNING, "nserver-duplicate",
+				"duplicate nserver '%s'", value)
+			continue
+		}
+		seen[strings.ToLower(value)] = true
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 || len(fields) > 2 {
+			add(lo, DNS_LINT_ERROR, "nserver-syntax",
+				"nserver '%s' must be a name, optionally followed by an address",
+				value)
+			continue
+		}
+
+		host := strings.ToLower(strings.TrimSuffix(fields[0], "."))
+		if !dnsValidName(host) || strings.IndexByte(host, '.') == -1 {
+			add(lo, DNS_LINT_ERROR, "nserver-syntax",
+				"nserver name '%s' is not a valid host name", fields[0])
+			continue
+		}
+		hosts[host] = true
+
+		if len(fields) == 2 {
+			ip := net.ParseIP(fields[1])
+			if ip == nil {
+				add(lo, DNS_LINT_ERROR, "nserver-syntax",
+					"nserver address '%s' for %s is not a valid IP address",
+					fields[1], host)
+				continue
+			}
+
+			// glue outside of the delegation is not published
+			if !lo.delegates(host) {
+				add(lo, DNS_LINT_WARNING, "glue-ignored",
+					"glue for %s is ignored, as it is not within %s",
+					host, strings.Join(lo.names, ", "))
+				continue
+			}
+			lo.glue[host] = append(lo.glue[host], ip.String())
+		}
+	}
+
+	if len(hosts) == 1 {
+		add(lo, DNS_LINT_WARNING, "nserver-count",
+			"only one name server is listed")
+	}
+
+	// names within the delegated zone can't be resolved without glue
+	for host := range hosts {
+		for _, name := range lo.names {
+			if DNSIsSubdomain(DNSFQDN(host), DNSFQDN(name)) &&
+				len(lo.glue[host]) == 0 {
+				add(lo, DNS_LINT_ERROR, "glue-missing",
+					"%s is within %s, but no glue address is given",
+					host, name)
+				break
+			}
+		}
+	}
+
+	for host := range lo.glue {
+		sort.Strings(lo.glue[host])
+	}
+}
+
+// return true if a host is at or below one of the delegated names
+func (lo *dnsLintObject) delegates(host string) bool {
+	for _, name := range lo.names {
+		if DNSIsSubdomain(DNSFQDN(host), DNSFQDN(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// check the syntax of a ds-rdata attribute
+func (lo *dnsLintObject) lintDS(value string, add dnsLintAdd) {
+
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		add(lo, DNS_LINT_ERROR, "ds-syntax",
+			"ds-rdata '%s' must have a key tag, algorithm, digest type and digest",
+			value)
+		return
+	}
+
+	if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+		add(lo, DNS_LINT_ERROR, "ds-syntax",
+			"ds-rdata key tag '%s' must be between 0 and 65535", fields[0])
+	}
+
+	algorithm, err := strconv.ParseUint(fields[1], 10, 8)
+	if deprecated, ok := dnsDSAlgorithms[algorithm]; err != nil || !ok {
+		add(lo, DNS_LINT_ERROR, "ds-algorithm",
+			"ds-rdata algorithm '%s' is not a DNSSEC signing algorithm",
+			fields[1])
+	} else if deprecated {
+		add(lo, DNS_LINT_WARNING, "ds-algorithm",
+			"ds-rdata algorithm %d is deprecated", algorithm)
+	}
+
+	dtype, err := strconv.ParseUint(fields[2], 10, 8)
+	length, ok := dnsDSDigestLength[dtype]
+	if err != nil || !ok {
+		add(lo, DNS_LINT_ERROR, "ds-digest",
+			"ds-rdata digest type '%s' is not supported", fields[2])
+		return
+	}
+	if dtype == 1 || dtype == 3 {
+		add(lo, DNS_LINT_WARNING, "ds-digest",
+			"ds-rdata digest type %d is deprecated", dtype)
+	}
+
+	digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		add(lo, DNS_LINT_ERROR, "ds-digest",
+			"ds-rdata digest is not valid hex")
+	} else if len(digest) != length {
+		add(lo, DNS_LINT_ERROR, "ds-digest",
+			"ds-rdata digest is %d bytes, digest type %d requires %d bytes",
+			len(digest), dtype, length)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
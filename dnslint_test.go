@@ -0,0 +1,71 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestCompileDNSLint(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+	zones := DNSCompileAuthZones(registry, testRegistryPath)
+	issues := CompileDNSLint(registry, zones)
+
+	// the checks reported for each object, and the zone they are reported in
+	checks := make(map[string][]string)
+	for _, issue := range issues {
+		checks[issue.Object] = append(checks[issue.Object],
+			issue.Zone+" "+issue.Severity+" "+issue.Check)
+	}
+
+	tests := []struct {
+		object   string
+		expected []string
+	}{
+		{"domain/bar.dn42", []string{
+			"dn42 error nserver-syntax",
+			"dn42 warning glue-ignored",
+			"dn42 warning nserver-count",
+		}},
+		{"domain/foo.dn42", []string{"dn42 error ds-digest"}},
+		{"domain/20.172.in-addr.arpa", []string{
+			". error delegation-conflict",
+			". warning nserver-count",
+		}},
+		{"inet6num/fd42:1::_50", []string{"d.f.ip6.arpa warning nserver-count"}},
+		{"inetnum/172.20.0.0_24", nil},
+	}
+
+	for _, test := range tests {
+		got := checks[test.object]
+		sort.Strings(got)
+		sort.Strings(test.expected)
+		if strings.Join(got, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("%s: got %v, expected %v", test.object, got, test.expected)
+		}
+	}
+
+	// issues are reported against the mntners
+	for _, issue := range issues {
+		if issue.Object == "domain/bar.dn42" &&
+			strings.Join(issue.Mntners, " ") != "BAR-MNT" {
+			t.Errorf("%s: unexpected mntners %v", issue.Object, issue.Mntners)
+		}
+		if issue.Check == "glue-ignored" &&
+			!strings.Contains(issue.Message, "not within") {
+			t.Errorf("%s: unexpected message '%s'", issue.Object, issue.Message)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
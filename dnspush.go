@@ -0,0 +1,677 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// root zone push
+//
+// each time the root zone is updated, the RRsets that have changed since
+// the last push are sent to an external DNS server, using either the
+// PowerDNS HTTP API or RFC 2136 dynamic updates. Records at the zone apex
+// belong to the server and are never changed, and names on the ignore
+// list are left alone so that local records are not removed.
+//
+// the last pushed zone may be saved to a state file, so that changes
+// made while the server was stopped are still pushed. Without a state
+// file, the first push after starting replaces every RRset.
+
+const (
+	DNS_PUSH_RETRY_INTERVAL = 15 * time.Minute
+	DNS_PUSH_BATCH_SIZE     = 500
+	DNS_PUSH_TIMEOUT        = 30 * time.Second
+)
+
+// a change to a single RRset
+type DNSRRSetChange struct {
+	Name    string
+	Type    uint16
+	Delete  bool
+	Records []*DNSRR
+}
+
+// a backend applies a set of changes to a zone
+type dnsPushBackend interface {
+	Push(origin string, changes []*DNSRRSetChange) error
+	String() string
+}
+
+type DNSZonePusher struct {
+	Backend   dnsPushBackend
+	DryRun    bool
+	Ignore    []string
+	StatePath string
+
+	mutex   sync.Mutex
+	pushed  *DNSZone
+	pending *DNSZone
+	status  *DNSPushStatus
+	trigger chan bool
+}
+
+// the pusher, nil if not enabled
+var DNSPusher *DNSZonePusher
+
+// status reported by the API
+type DNSPushChange struct {
+	Name    string
+	Type    string
+	Action  string
+	Records []string `json:",omitempty"`
+}
+
+type DNSPushStatus struct {
+	Backend string
+	DryRun  bool
+	Commit  string
+	Time    time.Time
+	Error   string `json:",omitempty"`
+	Changes []*DNSPushChange
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitDNSPushAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise zone push
+
+func InitialiseDNSPush(pdnsURL string, pdnsKey string, server string,
+	tsig string, ignore []string, dryRun bool, state string) {
+
+	var backend dnsPushBackend
+
+	switch {
+	case pdnsURL != "" && server != "":
+		log.Fatal("Only one of PowerDNS or RFC 2136 zone push may be used")
+
+	case pdnsURL != "":
+		backend = &dnsPowerDNS{
+			URL:    strings.TrimSuffix(pdnsURL, "/"),
+			Key:    pdnsKey,
+			client: &http.Client{Timeout: DNS_PUSH_TIMEOUT},
+		}
+
+	case server != "":
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		rfc2136 := &dnsRFC2136{Server: server}
+		if tsig != "" {
+			key, err := ParseDNSTSIGKey(tsig)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("Unable to parse TSIG key")
+			}
+			rfc2136.Key = key
+		}
+		backend = rfc2136
+
+	case !dryRun:
+		// zone push is disabled
+		return
+	}
+
+	pusher := &DNSZonePusher{
+		Backend:   backend,
+		DryRun:    dryRun,
+		StatePath: state,
+		trigger:   make(chan bool, 1),
+	}
+	for _, name := range ignore {
+		pusher.Ignore = append(pusher.Ignore, strings.ToLower(name))
+	}
+
+	if state != "" {
+		pusher.loadState()
+	}
+
+	DNSPusher = pusher
+	go pusher.run()
+
+	log.WithFields(log.Fields{
+		"backend": pusher.backendName(),
+		"dryrun":  dryRun,
+		"ignore":  len(ignore),
+	}).Info("DNS zone push enabled")
+}
+
+func (p *DNSZonePusher) backendName() string {
+	if p.Backend == nil {
+		return "none"
+	}
+	return p.Backend.String()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitDNSPushAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/dns/push", dnsPushHandler).Methods("GET")
+}
+
+// return the status of the last push
+func dnsPushHandler(w http.ResponseWriter, r *http.Request) {
+
+	if DNSPusher == nil {
+		http.Error(w, "DNS zone push is not enabled", http.StatusNotFound)
+		return
+	}
+
+	DNSPusher.mutex.Lock()
+	status := DNSPusher.status
+	DNSPusher.mutex.Unlock()
+
+	if status == nil {
+		status = &DNSPushStatus{
+			Backend: DNSPusher.backendName(),
+			DryRun:  DNSPusher.DryRun,
+			Changes: make([]*DNSPushChange, 0),
+		}
+	}
+
+	(&Response{Data: status, Records: status.Changes}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called at the end of each DNSUpdate to request a push
+
+func DNSPushUpdate(zone *DNSZone) {
+
+	if DNSPusher == nil {
+		return
+	}
+
+	DNSPusher.mutex.Lock()
+	DNSPusher.pending = zone
+	DNSPusher.mutex.Unlock()
+
+	// signal the pusher, without blocking if a push is already queued
+	select {
+	case DNSPusher.trigger <- true:
+	default:
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// pusher main loop, failed pushes are retried periodically
+
+func (p *DNSZonePusher) run() {
+
+	ticker := time.NewTicker(DNS_PUSH_RETRY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.trigger:
+		case <-ticker.C:
+		}
+
+		p.mutex.Lock()
+		zone := p.pending
+		pushed := p.pushed
+		p.mutex.Unlock()
+
+		if zone == nil || zone == pushed {
+			continue
+		}
+
+		p.push(pushed, zone)
+	}
+}
+
+// push the changes between two zones
+func (p *DNSZonePusher) push(pushed *DNSZone, zone *DNSZone) {
+
+	var previous *DNSAuthZone
+	if pushed != nil {
+		previous = pushed.auth
+	}
+	changes := DNSZoneDelta(previous, zone.auth, p.ignored)
+
+	status := &DNSPushStatus{
+		Backend: p.backendName(),
+		DryRun:  p.DryRun,
+		Commit:  zone.Commit,
+		Time:    time.Now(),
+		Changes: make([]*DNSPushChange, 0, len(changes)),
+	}
+	for _, change := range changes {
+		status.Changes = append(status.Changes, change.report())
+	}
+
+	var err error
+	if len(changes) > 0 {
+		if p.DryRun || p.Backend == nil {
+			for _, change := range status.Changes {
+				log.WithFields(log.Fields{
+					"name":    change.Name,
+					"type":    change.Type,
+					"action":  change.Action,
+					"records": strings.Join(change.Records, ", "),
+				}).Info("DNS push (dry run)")
+			}
+		} else {
+			err = p.Backend.Push(zone.auth.Origin, changes)
+		}
+	}
+
+	if err != nil {
+		status.Error = err.Error()
+		log.WithFields(log.Fields{
+			"backend": p.backendName(),
+			"changes": len(changes),
+			"error":   err,
+		}).Error("DNS zone push failed")
+	} else {
+		log.WithFields(log.Fields{
+			"backend": p.backendName(),
+			"changes": len(changes),
+			"commit":  zone.Commit,
+			"dryrun":  p.DryRun,
+		}).Info("DNS zone pushed")
+	}
+
+	p.mutex.Lock()
+	p.status = status
+	if err == nil {
+		p.pushed = zone
+	}
+	p.mutex.Unlock()
+
+	if err == nil && p.StatePath != "" {
+		p.saveState(zone)
+	}
+}
+
+// return true if a name is on the ignore list, entries starting with
+// '*.' match any name below the entry
+func (p *DNSZonePusher) ignored(name string) bool {
+	for _, ignore := range p.Ignore {
+		if strings.HasPrefix(ignore, "*.") {
+			if name != DNSFQDN(ignore[2:]) &&
+				DNSIsSubdomain(name, DNSFQDN(ignore[2:])) {
+				return true
+			}
+		} else if name == DNSFQDN(ignore) {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////
+// state file, holding the last pushed zone
+
+func (p *DNSZonePusher) loadState() {
+
+	data, err := ioutil.ReadFile(p.StatePath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  p.StatePath,
+			"error": err,
+		}).Warn("Unable to read DNS push state, all RRsets will be pushed")
+		return
+	}
+
+	zone := &DNSZone{}
+	if err := json.Unmarshal(data, zone); err != nil {
+		log.WithFields(log.Fields{
+			"path":  p.StatePath,
+			"error": err,
+		}).Warn("Unable to parse DNS push state, all RRsets will be pushed")
+		return
+	}
+	zone.auth = zone.AuthZone(".", DNSConfig)
+
+	p.pushed = zone
+}
+
+func (p *DNSZonePusher) saveState(zone *DNSZone) {
+
+	data, err := json.Marshal(zone)
+	if err == nil {
+		err = writeFileAtomic(p.StatePath, data)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  p.StatePath,
+			"error": err,
+		}).Error("Unable to save DNS push state")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// calculate the RRset changes between two zones, the previous zone may
+// be nil in which case every RRset is replaced
+
+func DNSZoneDelta(previous *DNSAuthZone, next *DNSAuthZone,
+	ignored func(string) bool) []*DNSRRSetChange {
+
+	changes := make([]*DNSRRSetChange, 0)
+
+	for _, name := range next.Names() {
+		if name == next.Origin || ignored(name) {
+			continue
+		}
+		for _, t := range next.Types(name) {
+			rrset := next.RRSet(name, t)
+			if previous != nil && dnsRRSetEqual(previous.RRSet(name, t), rrset) {
+				continue
+			}
+			changes = append(changes, &DNSRRSetChange{
+				Name:    name,
+				Type:    t,
+				Records: rrset,
+			})
+		}
+	}
+
+	if previous != nil {
+		for _, name := range previous.Names() {
+			if name == previous.Origin || ignored(name) {
+				continue
+			}
+			for _, t := range previous.Types(name) {
+				if len(next.RRSet(name, t)) == 0 {
+					changes = append(changes, &DNSRRSetChange{
+						Name:   name,
+						Type:   t,
+						Delete: true,
+					})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Name != changes[j].Name {
+			return DNSCanonicalLess(changes[i].Name, changes[j].Name)
+		}
+		return changes[i].Type < changes[j].Type
+	})
+
+	return changes
+}
+
+// return true if two RRsets have the same TTL and records
+func dnsRRSetEqual(a []*DNSRR, b []*DNSRR) bool {
+
+	if len(a) != len(b) || len(a) == 0 || a[0].TTL != b[0].TTL {
+		return false
+	}
+
+	for _, ra := range a {
+		found := false
+		for _, rb := range b {
+			if bytes.Equal(ra.RData, rb.RData) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describe a change for the API and logs
+func (change *DNSRRSetChange) report() *DNSPushChange {
+
+	report := &DNSPushChange{
+		Name:   change.Name,
+		Type:   DNSTypeString(change.Type),
+		Action: "replace",
+	}
+	if change.Delete {
+		report.Action = "delete"
+	}
+	for _, rr := range change.Records {
+		report.Records = append(report.Records,
+			DNSRDataToText(rr.Type, rr.RData))
+	}
+
+	return report
+}
+
+//////////////////////////////////////////////////////////////////////////
+// PowerDNS HTTP API backend
+//
+// the URL is that of the server, e.g.
+// http://127.0.0.1:8081/api/v1/servers/localhost. The SOA-EDIT-API
+// setting for the zone controls how the serial is updated.
+
+type dnsPowerDNS struct {
+	URL    string
+	Key    string
+	client *http.Client
+}
+
+type pdnsRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type pdnsRRSet struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        uint32       `json:"ttl,omitempty"`
+	ChangeType string       `json:"changetype"`
+	Records    []pdnsRecord `json:"records"`
+}
+
+type pdnsPatch struct {
+	RRSets []*pdnsRRSet `json:"rrsets"`
+}
+
+func (pdns *dnsPowerDNS) String() string {
+	return "powerdns"
+}
+
+func (pdns *dnsPowerDNS) Push(origin string,
+	changes []*DNSRRSetChange) error {
+
+	patch := &pdnsPatch{RRSets: make([]*pdnsRRSet, 0, len(changes))}
+	for _, change := range changes {
+		rrset := &pdnsRRSet{
+			Name:       change.Name,
+			Type:       DNSTypeString(change.Type),
+			ChangeType: "REPLACE",
+			Records:    make([]pdnsRecord, 0, len(change.Records)),
+		}
+		if change.Delete {
+			rrset.ChangeType = "DELETE"
+		} else {
+			rrset.TTL = change.Records[0].TTL
+		}
+		for _, rr := range change.Records {
+			rrset.Records = append(rrset.Records, pdnsRecord{
+				Content: DNSRDataToText(rr.Type, rr.RData),
+			})
+		}
+		patch.RRSets = append(patch.RRSets, rrset)
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	// the zone id escapes the root zone
+	id := origin
+	if id == "." {
+		id = "=2E"
+	}
+	zoneURL := pdns.URL + "/zones/" + id
+
+	if err := pdns.request("PATCH", zoneURL, body); err != nil {
+		return err
+	}
+
+	// ask the server to notify its secondaries, this fails if the zone
+	// is not a primary zone so only a warning is logged
+	if err := pdns.request("PUT", zoneURL+"/notify", nil); err != nil {
+		log.WithFields(log.Fields{
+			"zone":  origin,
+			"error": err,
+		}).Warn("PowerDNS notify failed")
+	}
+
+	return nil
+}
+
+// make a request to the API, expecting a successful response
+func (pdns *dnsPowerDNS) request(method string, url string,
+	body []byte) error {
+
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-API-Key", pdns.Key)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := pdns.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, url, response.Status,
+			strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RFC 2136 dynamic update backend
+//
+// each RRset is deleted and then re-added, in batches sent over TCP.
+// The server is responsible for updating the serial and for notifying
+// its secondaries.
+
+type dnsRFC2136 struct {
+	Server string
+	Key    *DNSTSIGKey
+}
+
+func (u *dnsRFC2136) String() string {
+	return "rfc2136"
+}
+
+func (u *dnsRFC2136) Push(origin string, changes []*DNSRRSetChange) error {
+
+	update := make([]*DNSRR, 0)
+	for ix, change := range changes {
+
+		// delete the existing RRset
+		update = append(update, &DNSRR{
+			Name:  change.Name,
+			Type:  change.Type,
+			Class: DNS_CLASS_ANY,
+		})
+		update = append(update, change.Records...)
+
+		if len(update) >= DNS_PUSH_BATCH_SIZE || ix == len(changes)-1 {
+			if err := u.send(origin, update); err != nil {
+				return err
+			}
+			update = make([]*DNSRR, 0)
+		}
+	}
+
+	return nil
+}
+
+// send a single update message and check the response
+func (u *dnsRFC2136) send(origin string, update []*DNSRR) error {
+
+	request := &DNSMessage{
+		ID:     uint16(rand.Intn(0x10000)),
+		Opcode: DNS_OPCODE_UPDATE,
+		Question: []*DNSQuestion{{
+			Name:  origin,
+			Type:  DNS_TYPE_SOA,
+			Class: DNS_CLASS_IN,
+		}},
+		Authority: update,
+	}
+
+	msg := request.Pack()
+	var mac []byte
+	if u.Key != nil {
+		msg, mac = u.Key.Sign(msg, time.Now())
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Server, DNS_PUSH_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DNS_PUSH_TIMEOUT))
+
+	if err := dnsWriteTCP(conn, msg); err != nil {
+		return err
+	}
+	data, err := dnsReadTCP(conn)
+	if err != nil {
+		return err
+	}
+
+	response, err := UnpackDNSMessage(data)
+	if err != nil {
+		return err
+	}
+	if response.ID != request.ID || !response.Response ||
+		response.Opcode != DNS_OPCODE_UPDATE {
+		return errors.New("unexpected response to DNS update")
+	}
+	if response.RCode != DNS_RCODE_NOERROR {
+		return fmt.Errorf("DNS update failed: %s", dnsRCodeString(response.RCode))
+	}
+
+	if u.Key != nil {
+		if err := u.Key.Verify(data, mac, time.Now()); err != nil {
+			return fmt.Errorf("DNS update response: %s", err)
+		}
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
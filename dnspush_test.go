@@ -0,0 +1,574 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// build a root zone from records in the form 'name ttl type rdata'
+func testPushZone(t *testing.T, serial uint32,
+	records ...string) *DNSAuthZone {
+
+	zone := NewDNSAuthZone(".", serial, &DNSZoneConfig{
+		NameServers: []string{"a.root.dn42"},
+		Contact:     "hostmaster.dn42",
+		TTL:         3600,
+	})
+
+	for _, record := range records {
+		fields := strings.SplitN(record, " ", 4)
+		var ttl uint32
+		fmt.Sscan(fields[1], &ttl)
+		rtype, ok := DNSTypeFromString(fields[2])
+		if !ok {
+			t.Fatalf("unknown type in '%s'", record)
+		}
+		rdata, err := DNSRDataFromText(rtype, fields[3])
+		if err != nil {
+			t.Fatalf("%s: %s", record, err)
+		}
+		zone.Add(&DNSRR{
+			Name:  DNSFQDN(fields[0]),
+			Type:  rtype,
+			Class: DNS_CLASS_IN,
+			TTL:   ttl,
+			RData: rdata,
+		})
+	}
+
+	return zone
+}
+
+// describe a set of changes as 'action name type [records]'
+func testPushChanges(changes []*DNSRRSetChange) []string {
+	described := make([]string, 0, len(changes))
+	for _, change := range changes {
+		report := change.report()
+		described = append(described, strings.TrimSpace(report.Action+" "+
+			report.Name+" "+report.Type+" "+strings.Join(report.Records, ",")))
+	}
+	return described
+}
+
+var testPushPrevious = []string{
+	"dn42 3600 NS a.root.dn42.",
+	"dn42 3600 NS b.root.dn42.",
+	"a.root.dn42 3600 A 172.20.0.1",
+	"b.root.dn42 3600 A 172.20.0.2",
+	"old.dn42 3600 NS ns1.old.dn42.",
+	"local.dn42 3600 NS ns1.local.dn42.",
+	"x.local.dn42 3600 A 10.0.0.1",
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestDNSZoneDelta(t *testing.T) {
+
+	pusher := &DNSZonePusher{Ignore: []string{"*.local.dn42"}}
+
+	tests := []struct {
+		name     string
+		previous []string
+		next     []string
+		expected []string
+	}{
+		{
+			name:     "unchanged",
+			previous: testPushPrevious,
+			next:     testPushPrevious,
+			expected: []string{},
+		},
+		{
+			name:     "no previous zone",
+			previous: nil,
+			next:     []string{"dn42 3600 NS a.root.dn42."},
+			expected: []string{"replace dn42. NS a.root.dn42."},
+		},
+		{
+			name:     "records added, changed and removed",
+			previous: testPushPrevious,
+			next: []string{
+				"dn42 3600 NS a.root.dn42.",
+				"dn42 3600 NS b.root.dn42.",
+				"dn42 3600 DS 64441 10 2 6dadda00",
+				"a.root.dn42 3600 A 172.20.0.1",
+				"b.root.dn42 3600 A 172.20.0.3",
+				"b.root.dn42 3600 AAAA fd42::2",
+				"local.dn42 3600 NS ns1.local.dn42.",
+				"x.local.dn42 3600 A 10.0.0.1",
+			},
+			expected: []string{
+				"replace dn42. DS 64441 10 2 6DADDA00",
+				"delete old.dn42. NS",
+				"replace b.root.dn42. A 172.20.0.3",
+				"replace b.root.dn42. AAAA fd42::2",
+			},
+		},
+		{
+			name:     "TTL changed",
+			previous: testPushPrevious,
+			next: append([]string{"a.root.dn42 300 A 172.20.0.1"},
+				testPushPrevious...),
+			expected: []string{"replace a.root.dn42. A 172.20.0.1"},
+		},
+		{
+			// names below the ignored entry are not touched, but the
+			// entry itself is
+			name:     "ignored names",
+			previous: testPushPrevious,
+			next: []string{
+				"dn42 3600 NS a.root.dn42.",
+				"dn42 3600 NS b.root.dn42.",
+				"a.root.dn42 3600 A 172.20.0.1",
+				"b.root.dn42 3600 A 172.20.0.2",
+				"old.dn42 3600 NS ns1.old.dn42.",
+				"y.local.dn42 3600 A 10.0.0.2",
+			},
+			expected: []string{"delete local.dn42. NS"},
+		},
+	}
+
+	for _, test := range tests {
+		var previous *DNSAuthZone
+		if test.previous != nil {
+			previous = testPushZone(t, 1, test.previous...)
+		}
+		// a new serial, the apex is never pushed
+		next := testPushZone(t, 2, test.next...)
+
+		changes := testPushChanges(DNSZoneDelta(previous, next, pusher.ignored))
+		if strings.Join(changes, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.name,
+				strings.Join(changes, "\n"), strings.Join(test.expected, "\n"))
+		}
+	}
+}
+
+func TestDNSZonePusherIgnored(t *testing.T) {
+
+	pusher := &DNSZonePusher{Ignore: []string{"local.dn42", "*.test.dn42"}}
+
+	tests := []struct {
+		name    string
+		ignored bool
+	}{
+		{"local.dn42.", true},
+		{"x.local.dn42.", false},
+		{"test.dn42.", false},
+		{"x.test.dn42.", true},
+		{"y.x.test.dn42.", true},
+		{"dn42.", false},
+	}
+
+	for _, test := range tests {
+		if pusher.ignored(test.name) != test.ignored {
+			t.Errorf("%s: ignored %v, expected %v", test.name,
+				!test.ignored, test.ignored)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// PowerDNS
+
+func TestDNSPowerDNSPush(t *testing.T) {
+
+	type request struct {
+		method string
+		path   string
+		key    string
+		body   []byte
+	}
+	var mutex sync.Mutex
+	var requests []*request
+	status := http.StatusNoContent
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			requests = append(requests, &request{
+				method: r.Method,
+				path:   r.URL.EscapedPath(),
+				key:    r.Header.Get("X-API-Key"),
+				body:   body,
+			})
+			code := status
+			mutex.Unlock()
+			if r.Method == "PUT" {
+				// notify is not supported for secondary zones
+				code = http.StatusUnprocessableEntity
+			}
+			w.WriteHeader(code)
+		}))
+	defer server.Close()
+
+	pdns := &dnsPowerDNS{
+		URL:    server.URL + "/api/v1/servers/localhost",
+		Key:    "secret",
+		client: server.Client(),
+	}
+
+	previous := testPushZone(t, 1, testPushPrevious...)
+	next := testPushZone(t, 2, "dn42 3600 NS a.root.dn42.",
+		"dn42 3600 NS b.root.dn42.", "a.root.dn42 300 A 172.20.0.1",
+		"b.root.dn42 3600 A 172.20.0.2")
+	changes := DNSZoneDelta(previous, next, func(string) bool { return false })
+
+	// a failed notify is only a warning
+	if err := pdns.Push(".", changes); err != nil {
+		t.Fatalf("push failed: %s", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+
+	patch, notify := requests[0], requests[1]
+	zone := "/api/v1/servers/localhost/zones/=2E"
+	if patch.method != "PATCH" || patch.path != zone || patch.key != "secret" {
+		t.Errorf("unexpected request %s %s, key '%s'", patch.method,
+			patch.path, patch.key)
+	}
+	if notify.method != "PUT" || notify.path != zone+"/notify" {
+		t.Errorf("unexpected request %s %s", notify.method, notify.path)
+	}
+
+	body := &pdnsPatch{}
+	if err := json.Unmarshal(patch.body, body); err != nil {
+		t.Fatalf("unable to parse the PATCH body: %s", err)
+	}
+	described := make([]string, 0, len(body.RRSets))
+	for _, rrset := range body.RRSets {
+		records := make([]string, 0, len(rrset.Records))
+		for _, record := range rrset.Records {
+			records = append(records, record.Content)
+		}
+		described = append(described, fmt.Sprintf("%s %s %s %d %s",
+			rrset.ChangeType, rrset.Name, rrset.Type, rrset.TTL,
+			strings.Join(records, ",")))
+	}
+	expected := []string{
+		"DELETE local.dn42. NS 0 ",
+		"DELETE x.local.dn42. A 0 ",
+		"DELETE old.dn42. NS 0 ",
+		"REPLACE a.root.dn42. A 300 172.20.0.1",
+	}
+	if strings.Join(described, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected rrsets:\n%s", strings.Join(described, "\n"))
+	}
+
+	// errors from the API are returned
+	mutex.Lock()
+	status = http.StatusUnprocessableEntity
+	mutex.Unlock()
+	err := pdns.Push(".", changes)
+	if err == nil || !strings.Contains(err.Error(), "422") {
+		t.Errorf("expected an error with the status, got %v", err)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RFC 2136
+
+// sign a response to a request signed with the given MAC
+func testTSIGSignResponse(key *DNSTSIGKey, msg []byte, requestMAC []byte,
+	now time.Time) []byte {
+
+	signed := uint64(now.Unix())
+	mac := key.mac(requestMAC, msg, signed, DNS_TSIG_FUDGE, 0, nil)
+
+	rdata := dnsAppendName(nil, key.Algorithm)
+	rdata = dnsAppendUint16(rdata, uint16(signed>>32))
+	rdata = dnsAppendUint32(rdata, uint32(signed))
+	rdata = dnsAppendUint16(rdata, DNS_TSIG_FUDGE, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = dnsAppendUint16(rdata, binary.BigEndian.Uint16(msg[0:]), 0, 0)
+
+	out := append([]byte(nil), msg...)
+	out = dnsAppendName(out, key.Name)
+	out = dnsAppendUint16(out, DNS_TYPE_TSIG, DNS_CLASS_ANY)
+	out = dnsAppendUint32(out, 0)
+	out = dnsAppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1)
+
+	return out
+}
+
+// a DNS server stand-in that answers updates with the given rcode,
+// recording the updates it receives
+type testUpdateServer struct {
+	listener net.Listener
+	key      *DNSTSIGKey
+	rcode    uint8
+	sign     bool
+
+	mutex   sync.Mutex
+	updates []*DNSMessage
+	errors  []string
+}
+
+func newTestUpdateServer(t *testing.T, key *DNSTSIGKey) *testUpdateServer {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testUpdateServer{listener: listener, key: key, sign: true}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (server *testUpdateServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	data, err := dnsReadTCP(conn)
+	if err != nil {
+		return
+	}
+	request, err := UnpackDNSMessage(data)
+	if err != nil {
+		server.fail(err.Error())
+		return
+	}
+
+	// check and remove the request signature
+	var requestMAC []byte
+	if server.key != nil {
+		if err := server.key.Verify(data, nil, time.Now()); err != nil {
+			server.fail("request: " + err.Error())
+		}
+		tsig := request.Additional[len(request.Additional)-1]
+		_, offset, _ := dnsReadName(tsig.RData, 0)
+		size := int(binary.BigEndian.Uint16(tsig.RData[offset+8:]))
+		requestMAC = tsig.RData[offset+10 : offset+10+size]
+		request.Additional = request.Additional[:len(request.Additional)-1]
+	}
+
+	server.mutex.Lock()
+	server.updates = append(server.updates, request)
+	rcode := server.rcode
+	server.mutex.Unlock()
+
+	response := (&DNSMessage{
+		ID:       request.ID,
+		Response: true,
+		Opcode:   request.Opcode,
+		RCode:    rcode,
+		Question: request.Question,
+	}).Pack()
+	if server.key != nil && server.sign {
+		response = testTSIGSignResponse(server.key, response, requestMAC,
+			time.Now())
+	}
+	dnsWriteTCP(conn, response)
+}
+
+func (server *testUpdateServer) fail(message string) {
+	server.mutex.Lock()
+	server.errors = append(server.errors, message)
+	server.mutex.Unlock()
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestDNSRFC2136Push(t *testing.T) {
+
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key, err := ParseDNSTSIGKey("push.key:" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   *DNSTSIGKey
+		rcode uint8
+		sign  bool
+		err   string
+	}{
+		{name: "unsigned"},
+		{name: "signed", key: key, sign: true},
+		{name: "refused", rcode: DNS_RCODE_REFUSED, err: "REFUSED"},
+		{name: "response not signed", key: key, err: "not signed"},
+	}
+
+	previous := testPushZone(t, 1, testPushPrevious...)
+	next := testPushZone(t, 2, "dn42 3600 NS a.root.dn42.",
+		"dn42 3600 NS b.root.dn42.", "a.root.dn42 300 A 172.20.0.1",
+		"b.root.dn42 3600 A 172.20.0.2")
+	changes := DNSZoneDelta(previous, next, func(string) bool { return false })
+
+	for _, test := range tests {
+		server := newTestUpdateServer(t, test.key)
+		server.rcode = test.rcode
+		server.sign = test.sign
+
+		update := &dnsRFC2136{Server: server.listener.Addr().String(),
+			Key: test.key}
+		err := update.Push(".", changes)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error '%s', got %v", test.name,
+					test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: push failed: %s", test.name, err)
+			continue
+		}
+
+		server.mutex.Lock()
+		if len(server.errors) != 0 {
+			t.Errorf("%s: %v", test.name, server.errors)
+		}
+		if len(server.updates) != 1 {
+			t.Fatalf("%s: expected 1 update, got %d", test.name,
+				len(server.updates))
+		}
+		msg := server.updates[0]
+		server.mutex.Unlock()
+
+		if msg.Opcode != DNS_OPCODE_UPDATE || len(msg.Question) != 1 ||
+			msg.Question[0].Name != "." || msg.Question[0].Type != DNS_TYPE_SOA {
+			t.Errorf("%s: unexpected update header %+v", test.name, msg)
+		}
+
+		// each RRset is deleted and then re-added
+		described := make([]string, 0, len(msg.Authority))
+		for _, rr := range msg.Authority {
+			rdata := DNSRDataToText(rr.Type, rr.RData)
+			if len(rr.RData) == 0 {
+				rdata = "-"
+			}
+			described = append(described, fmt.Sprintf("%s %d %s %d %s",
+				rr.Name, rr.Class, DNSTypeString(rr.Type), rr.TTL, rdata))
+		}
+		expected := []string{
+			"local.dn42. 255 NS 0 -",
+			"x.local.dn42. 255 A 0 -",
+			"old.dn42. 255 NS 0 -",
+			"a.root.dn42. 255 A 0 -",
+			"a.root.dn42. 1 A 300 172.20.0.1",
+		}
+		if strings.Join(described, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: unexpected update:\n%s", test.name,
+				strings.Join(described, "\n"))
+		}
+	}
+}
+
+func TestDNSRFC2136PushBatches(t *testing.T) {
+
+	records := make([]string, 0, DNS_PUSH_BATCH_SIZE)
+	for ix := 0; ix < DNS_PUSH_BATCH_SIZE; ix++ {
+		records = append(records, fmt.Sprintf("n%d.dn42 3600 A 172.20.%d.%d",
+			ix, ix/256, ix%256))
+	}
+	changes := DNSZoneDelta(nil, testPushZone(t, 1, records...),
+		func(string) bool { return false })
+
+	server := newTestUpdateServer(t, nil)
+	update := &dnsRFC2136{Server: server.listener.Addr().String()}
+	if err := update.Push(".", changes); err != nil {
+		t.Fatalf("push failed: %s", err)
+	}
+
+	// each change adds a delete and a record to the update
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(server.updates))
+	}
+	total := 0
+	for _, msg := range server.updates {
+		if len(msg.Authority) > DNS_PUSH_BATCH_SIZE {
+			t.Errorf("update has %d records", len(msg.Authority))
+		}
+		total += len(msg.Authority)
+	}
+	if total != 2*DNS_PUSH_BATCH_SIZE {
+		t.Errorf("expected %d records, got %d", 2*DNS_PUSH_BATCH_SIZE, total)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// dry run and state
+
+func TestDNSZonePusherDryRun(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dnspush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	server := newTestUpdateServer(t, nil)
+	pusher := &DNSZonePusher{
+		Backend:   &dnsRFC2136{Server: server.listener.Addr().String()},
+		DryRun:    true,
+		StatePath: dir + "/state.json",
+	}
+
+	zone := &DNSZone{Zone: ".", Commit: testRegistryCommit}
+	zone.AddRecord("dn42", "NS", "a.root.dn42.", "")
+	zone.auth = zone.AuthZone(".", DNSConfig)
+
+	pusher.push(nil, zone)
+
+	server.mutex.Lock()
+	if len(server.updates) != 0 {
+		t.Errorf("dry run sent %d updates", len(server.updates))
+	}
+	server.mutex.Unlock()
+
+	if pusher.pushed != zone || pusher.status == nil ||
+		!pusher.status.DryRun || len(pusher.status.Changes) != 1 {
+		t.Fatalf("unexpected status %+v", pusher.status)
+	}
+
+	// the state is reloaded as the last pushed zone
+	reloaded := &DNSZonePusher{StatePath: pusher.StatePath}
+	reloaded.loadState()
+	if reloaded.pushed == nil ||
+		len(DNSZoneDelta(reloaded.pushed.auth, zone.auth,
+			reloaded.ignored)) != 0 {
+		t.Error("state was not reloaded")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,438 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// resolver configuration
+//
+// generates configuration for recursive resolvers to send queries for
+// each zone delegated in the DN42 root zone directly to the zone's name
+// servers. Zones with DS records are configured as trust anchors, and
+// those without are marked as insecure so that validating resolvers
+// don't treat them as bogus.
+//
+// dnsmasq, CoreDNS and Knot Resolver forwarding sends recursive queries
+// and doesn't follow referrals, so names delegated below the root zones
+// would never resolve. These are forwarded to DN42 recursive servers
+// instead of the authoritative name servers.
+
+type dnsResolverZone struct {
+	Name      string
+	Addresses []string
+	DS        []string
+	Missing   []string
+}
+
+type dnsResolverWriter func(io.Writer, []*dnsResolverZone)
+
+// the anycast addresses of recursive-servers.dn42
+var DNSRecursiveServers = []string{
+	"172.20.0.53",
+	"172.23.0.53",
+	"fd42:d42:d42:54::1",
+	"fd42:d42:d42:53::1",
+}
+
+var dnsResolverWriters = map[string]dnsResolverWriter{
+	"unbound":       dnsWriteUnbound,
+	"bind":          dnsWriteBIND,
+	"dnsmasq":       dnsWriteDnsmasq,
+	"knot-resolver": dnsWriteKnotResolver,
+	"pdns-recursor": dnsWritePDNSRecursor,
+	"coredns":       dnsWriteCoreDNS,
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitDNSResolverAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to set the recursive servers
+
+func InitialiseDNSResolver(servers []string) {
+
+	// keep the defaults if none were given
+	if len(servers) == 0 {
+		return
+	}
+
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			log.WithFields(log.Fields{
+				"server": server,
+			}).Fatal("DNS recursive server must be an IP address")
+		}
+	}
+	DNSRecursiveServers = servers
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitDNSResolverAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	s := router.
+		Methods("GET").
+		PathPrefix("/dns/resolver").
+		Subrouter()
+
+	s.HandleFunc("/{software}", dnsResolverHandler)
+
+	log.Info("DNS resolver API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// api handlers
+
+func dnsResolverHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	software := strings.ToLower(vars["software"])
+
+	writer := dnsResolverWriters[software]
+	if writer == nil {
+		supported := make([]string, 0, len(dnsResolverWriters))
+		for name := range dnsResolverWriters {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		http.Error(w, "Unknown resolver '"+software+"', supported: "+
+			strings.Join(supported, ", "), http.StatusNotFound)
+		return
+	}
+
+	zone := DNSRootZone
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", zone.Commit)
+
+	// the comment style depends on the software
+	comment := "#"
+	switch software {
+	case "bind":
+		comment = "//"
+	case "knot-resolver":
+		comment = "--"
+	}
+	fmt.Fprintf(w, "%s\n%s dn42regsrv %s Configuration Generator\n"+
+		"%s Commit: %s\n%s Generated: %s\n%s\n",
+		comment, comment, software, comment, zone.Commit,
+		comment, zone.Generated, comment)
+
+	writer(w, dnsResolverZones(zone))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// collect the zones delegated in the root zone, together with the
+// addresses of their name servers and any DS records
+
+func dnsResolverZones(zone *DNSZone) []*dnsResolverZone {
+
+	zones := make(map[string]*dnsResolverZone)
+	nservers := make(map[string][]string)
+	glue := make(map[string][]string)
+
+	for _, record := range zone.Records {
+		name := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+
+		switch record.Type {
+		case "NS":
+			if zones[name] == nil {
+				zones[name] = &dnsResolverZone{Name: name}
+			}
+			ns := strings.ToLower(strings.TrimSuffix(record.Content, "."))
+			nservers[name] = dnsAppendUnique(nservers[name], ns)
+
+		case "A", "AAAA":
+			glue[name] = dnsAppendUnique(glue[name], record.Content)
+		}
+	}
+
+	for _, record := range zone.Records {
+		name := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+		if record.Type == "DS" && zones[name] != nil {
+			zones[name].DS = dnsAppendUnique(zones[name].DS, record.Content)
+		}
+	}
+
+	list := make([]*dnsResolverZone, 0, len(zones))
+	for name, rzone := range zones {
+		for _, ns := range nservers[name] {
+			if len(glue[ns]) == 0 {
+				rzone.Missing = append(rzone.Missing, ns)
+			}
+			for _, address := range glue[ns] {
+				rzone.Addresses = dnsAppendUnique(rzone.Addresses, address)
+			}
+		}
+		list = append(list, rzone)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return DNSCanonicalLess(DNSFQDN(list[i].Name), DNSFQDN(list[j].Name))
+	})
+
+	return list
+}
+
+// append a string to a list if not already present
+func dnsAppendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// note name servers that have no address in the root zone, returns
+// false if the zone has no usable addresses at all
+func dnsResolverUsable(w io.Writer, comment string,
+	rzone *dnsResolverZone) bool {
+
+	for _, ns := range rzone.Missing {
+		fmt.Fprintf(w, "%s %s: no address for name server %s\n",
+			comment, rzone.Name, ns)
+	}
+	if len(rzone.Addresses) == 0 {
+		fmt.Fprintf(w, "%s %s: no name server addresses, skipped\n",
+			comment, rzone.Name)
+		return false
+	}
+	return true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Unbound
+
+func dnsWriteUnbound(w io.Writer, zones []*dnsResolverZone) {
+
+	fmt.Fprintf(w, "server:\n")
+	for _, rzone := range zones {
+		if strings.HasSuffix(rzone.Name, ".arpa") {
+			// remove any default local zone, e.g. for RFC 1918 space
+			fmt.Fprintf(w, "  local-zone: \"%s.\" nodefault\n", rzone.Name)
+		}
+		if len(rzone.DS) == 0 {
+			fmt.Fprintf(w, "  domain-insecure: \"%s\"\n", rzone.Name)
+		}
+		for _, ds := range rzone.DS {
+			fmt.Fprintf(w, "  trust-anchor: \"%s. DS %s\"\n", rzone.Name, ds)
+		}
+	}
+
+	for _, rzone := range zones {
+		fmt.Fprintf(w, "\n")
+		if !dnsResolverUsable(w, "#", rzone) {
+			continue
+		}
+		fmt.Fprintf(w, "stub-zone:\n  name: \"%s\"\n", rzone.Name)
+		for _, address := range rzone.Addresses {
+			fmt.Fprintf(w, "  stub-addr: %s\n", address)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// BIND
+
+func dnsWriteBIND(w io.Writer, zones []*dnsResolverZone) {
+
+	// validate-except must be placed in the options block
+	insecure := make([]string, 0)
+	for _, rzone := range zones {
+		if len(rzone.DS) == 0 {
+			insecure = append(insecure, "\""+rzone.Name+"\";")
+		}
+	}
+	if len(insecure) > 0 {
+		fmt.Fprintf(w, "// add the following to the options block:\n"+
+			"//   validate-except { %s };\n\n", strings.Join(insecure, " "))
+	}
+
+	anchors := make([]string, 0)
+	for _, rzone := range zones {
+		for _, ds := range rzone.DS {
+			fields := strings.Fields(ds)
+			if len(fields) < 4 {
+				continue
+			}
+			anchors = append(anchors, fmt.Sprintf(
+				"  \"%s.\" static-ds %s %s %s \"%s\";\n", rzone.Name,
+				fields[0], fields[1], fields[2], strings.Join(fields[3:], "")))
+		}
+	}
+	if len(anchors) > 0 {
+		fmt.Fprintf(w, "trust-anchors {\n%s};\n", strings.Join(anchors, ""))
+	}
+
+	for _, rzone := range zones {
+		fmt.Fprintf(w, "\n")
+		if !dnsResolverUsable(w, "//", rzone) {
+			continue
+		}
+		fmt.Fprintf(w, "zone \"%s\" {\n  type static-stub;\n"+
+			"  server-addresses {", rzone.Name)
+		for _, address := range rzone.Addresses {
+			fmt.Fprintf(w, " %s;", address)
+		}
+		fmt.Fprintf(w, " };\n};\n")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// dnsmasq
+
+func dnsWriteDnsmasq(w io.Writer, zones []*dnsResolverZone) {
+
+	fmt.Fprintf(w, "# dnsmasq doesn't follow referrals, "+
+		"so queries are sent to the DN42 recursive servers\n")
+
+	for _, rzone := range zones {
+		for _, ds := range rzone.DS {
+			fields := strings.Fields(ds)
+			if len(fields) < 4 {
+				continue
+			}
+			fmt.Fprintf(w, "trust-anchor=%s,%s,%s,%s,%s\n", rzone.Name,
+				fields[0], fields[1], fields[2], strings.Join(fields[3:], ""))
+		}
+	}
+
+	for _, rzone := range zones {
+		if len(rzone.DS) == 0 {
+			// dnsmasq has no option to mark a domain as insecure
+			fmt.Fprintf(w, "# %s is not signed, and will fail validation "+
+				"if dnssec is enabled\n", rzone.Name)
+		}
+		for _, address := range DNSRecursiveServers {
+			fmt.Fprintf(w, "server=/%s/%s\n", rzone.Name, address)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Knot Resolver
+
+func dnsWriteKnotResolver(w io.Writer, zones []*dnsResolverZone) {
+
+	insecure := make([]string, 0)
+	for _, rzone := range zones {
+		if len(rzone.DS) == 0 {
+			insecure = append(insecure, "'"+rzone.Name+".'")
+		}
+		for _, ds := range rzone.DS {
+			fmt.Fprintf(w, "trust_anchors.add('%s. DS %s')\n", rzone.Name, ds)
+		}
+	}
+	if len(insecure) > 0 {
+		fmt.Fprintf(w, "trust_anchors.set_insecure({ %s })\n",
+			strings.Join(insecure, ", "))
+	}
+
+	if len(zones) == 0 {
+		return
+	}
+
+	// policy.STUB doesn't validate or follow referrals, policy.FORWARD
+	// validates answers from the recursive servers using the anchors above
+	addresses := make([]string, len(DNSRecursiveServers))
+	for ix, address := range DNSRecursiveServers {
+		addresses[ix] = "'" + address + "'"
+	}
+	names := make([]string, len(zones))
+	for ix, rzone := range zones {
+		names[ix] = "todname('" + rzone.Name + ".')"
+	}
+	fmt.Fprintf(w, "\npolicy.add(policy.suffix(policy.FORWARD({ %s }), {\n  %s\n}))\n",
+		strings.Join(addresses, ", "), strings.Join(names, ",\n  "))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// PowerDNS Recursor (5.x YAML settings)
+
+func dnsWritePDNSRecursor(w io.Writer, zones []*dnsResolverZone) {
+
+	fmt.Fprintf(w, "recursor:\n  forward_zones:\n")
+	for _, rzone := range zones {
+		if !dnsResolverUsable(w, "  #", rzone) {
+			continue
+		}
+		fmt.Fprintf(w, "  - zone: %s\n    forwarders: [ '%s' ]\n",
+			rzone.Name, strings.Join(rzone.Addresses, "', '"))
+	}
+
+	var anchors, negative []*dnsResolverZone
+	for _, rzone := range zones {
+		if len(rzone.DS) == 0 {
+			negative = append(negative, rzone)
+		} else {
+			anchors = append(anchors, rzone)
+		}
+	}
+
+	fmt.Fprintf(w, "dnssec:\n")
+	if len(anchors) > 0 {
+		fmt.Fprintf(w, "  trustanchors:\n")
+		for _, rzone := range anchors {
+			fmt.Fprintf(w, "  - name: %s\n    dsrecords:\n", rzone.Name)
+			for _, ds := range rzone.DS {
+				fmt.Fprintf(w, "    - '%s'\n", ds)
+			}
+		}
+	}
+	if len(negative) > 0 {
+		fmt.Fprintf(w, "  negative_trustanchors:\n")
+		for _, rzone := range negative {
+			fmt.Fprintf(w, "  - name: %s\n    reason: 'not signed'\n",
+				rzone.Name)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// CoreDNS
+
+func dnsWriteCoreDNS(w io.Writer, zones []*dnsResolverZone) {
+
+	// the forward plugin does not validate, so there are no trust anchors
+	fmt.Fprintf(w, "# CoreDNS does not perform DNSSEC validation\n"+
+		"# the forward plugin doesn't follow referrals, "+
+		"so queries are sent to the DN42 recursive servers\n")
+
+	if len(zones) == 0 {
+		return
+	}
+
+	names := make([]string, len(zones))
+	for ix, rzone := range zones {
+		names[ix] = rzone.Name
+	}
+	fmt.Fprintf(w, "\n%s {\n  forward . %s\n}\n",
+		strings.Join(names, " "), strings.Join(DNSRecursiveServers, " "))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
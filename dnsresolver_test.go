@@ -0,0 +1,121 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+// a root zone with a signed and an unsigned delegation
+func testResolverZones() []*dnsResolverZone {
+
+	zone := &DNSZone{Zone: "."}
+	zone.AddRecord("dn42", "NS", "a.root.dn42.", "")
+	zone.AddRecord("a.root.dn42", "A", "172.20.0.1", "")
+	zone.AddRecord("a.root.dn42", "AAAA", "fd42::1", "")
+	zone.AddRecord("dn42", "DS", "64441 10 2 6dadda00", "")
+	zone.AddRecord("20.172.in-addr.arpa", "NS", "a.root.dn42.", "")
+	zone.AddRecord("20.172.in-addr.arpa", "NS", "ns1.missing.dn42.", "")
+
+	return dnsResolverZones(zone)
+}
+
+func TestDNSResolverZones(t *testing.T) {
+
+	zones := testResolverZones()
+	if len(zones) != 2 {
+		t.Fatalf("expected 2 zones, got %d", len(zones))
+	}
+
+	// in canonical order
+	arpa, dn42 := zones[0], zones[1]
+	if dn42.Name != "dn42" || arpa.Name != "20.172.in-addr.arpa" {
+		t.Fatalf("unexpected zones %s, %s", dn42.Name, arpa.Name)
+	}
+	if strings.Join(dn42.Addresses, " ") != "172.20.0.1 fd42::1" ||
+		len(dn42.DS) != 1 || len(dn42.Missing) != 0 {
+		t.Errorf("unexpected dn42 zone: %+v", dn42)
+	}
+	if len(arpa.DS) != 0 || strings.Join(arpa.Missing, " ") != "ns1.missing.dn42" {
+		t.Errorf("unexpected reverse zone: %+v", arpa)
+	}
+}
+
+func TestDNSResolverWriters(t *testing.T) {
+
+	zones := testResolverZones()
+
+	tests := []struct {
+		software string
+		present  []string
+		absent   []string
+	}{
+		// these follow referrals, so use the authoritative servers
+		{"unbound", []string{
+			"stub-zone:\n  name: \"dn42\"\n  stub-addr: 172.20.0.1\n  stub-addr: fd42::1",
+			"trust-anchor: \"dn42. DS 64441 10 2 6dadda00\"",
+			"domain-insecure: \"20.172.in-addr.arpa\"",
+			"local-zone: \"20.172.in-addr.arpa.\" nodefault",
+			"no address for name server ns1.missing.dn42",
+		}, []string{"172.20.0.53"}},
+		{"bind", []string{
+			"zone \"dn42\" {\n  type static-stub;\n  server-addresses { 172.20.0.1; fd42::1; };",
+			"\"dn42.\" static-ds 64441 10 2 \"6dadda00\";",
+			"validate-except { \"20.172.in-addr.arpa\"; }",
+		}, []string{"172.20.0.53"}},
+		{"pdns-recursor", []string{
+			"- zone: dn42\n    forwarders: [ '172.20.0.1', 'fd42::1' ]",
+			"- name: 20.172.in-addr.arpa\n    reason: 'not signed'",
+		}, []string{"172.20.0.53"}},
+		// these don't, so use the recursive servers
+		{"dnsmasq", []string{
+			"server=/dn42/172.20.0.53",
+			"server=/dn42/fd42:d42:d42:53::1",
+			"server=/20.172.in-addr.arpa/172.23.0.53",
+			"trust-anchor=dn42,64441,10,2,6dadda00",
+			"# 20.172.in-addr.arpa is not signed",
+		}, []string{"172.20.0.1", "fd42::1"}},
+		{"knot-resolver", []string{
+			"policy.FORWARD({ '172.20.0.53', '172.23.0.53', " +
+				"'fd42:d42:d42:54::1', 'fd42:d42:d42:53::1' })",
+			"todname('dn42.')",
+			"todname('20.172.in-addr.arpa.')",
+			"trust_anchors.add('dn42. DS 64441 10 2 6dadda00')",
+			"trust_anchors.set_insecure({ '20.172.in-addr.arpa.' })",
+		}, []string{"STUB", "172.20.0.1", "fd42::1"}},
+		{"coredns", []string{
+			"20.172.in-addr.arpa dn42 {\n  forward . 172.20.0.53 172.23.0.53 " +
+				"fd42:d42:d42:54::1 fd42:d42:d42:53::1\n}",
+		}, []string{"172.20.0.1", "fd42::1"}},
+	}
+
+	for _, test := range tests {
+		w := &bytes.Buffer{}
+		dnsResolverWriters[test.software](w, zones)
+		output := w.String()
+
+		for _, s := range test.present {
+			if !strings.Contains(output, s) {
+				t.Errorf("%s: output does not contain %q\n%s",
+					test.software, s, output)
+			}
+		}
+		for _, s := range test.absent {
+			if strings.Contains(output, s) {
+				t.Errorf("%s: output should not contain %q\n%s",
+					test.software, s, output)
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,678 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// DNSSEC signing of the root zone
+//
+// Keys are held in BIND format file pairs (K<zone>+<alg>+<tag>.key and
+// .private), so may be created with dnssec-keygen or with
+// --DNSSECGenerateKey. Keys with the SEP flag set (257) are used as KSKs
+// to sign the DNSKEY RRset, the remaining keys sign the rest of the zone.
+// Where an algorithm has only one type of key, it signs everything.
+
+const (
+	DNSSEC_ALG_ECDSAP256SHA256 = 13
+	DNSSEC_ALG_ED25519         = 15
+	DNSSEC_FLAG_ZONE           = 0x0100
+	DNSSEC_FLAG_SEP            = 0x0001
+	DNSSEC_INCEPTION_OFFSET    = time.Hour
+	DNSSEC_CHECK_INTERVAL      = time.Hour
+)
+
+var dnssecAlgorithmNames = map[uint8]string{
+	DNSSEC_ALG_ECDSAP256SHA256: "ECDSAP256SHA256",
+	DNSSEC_ALG_ED25519:         "ED25519",
+}
+
+type DNSSECKey struct {
+	Path      string
+	Owner     string
+	Flags     uint16
+	Algorithm uint8
+	Tag       uint16
+	Public    []byte
+
+	private crypto.Signer
+}
+
+type DNSSECSigner struct {
+	Keys     []*DNSSECKey
+	NSEC3    bool
+	Validity time.Duration
+
+	mutex       sync.RWMutex
+	unsigned    *DNSAuthZone
+	signed      *DNSAuthZone
+	fingerprint [sha256.Size]byte
+	inception   time.Time
+	expiration  time.Time
+}
+
+// the signer, nil if signing is not enabled
+var DNSSigner *DNSSECSigner
+
+// signing status returned by the API
+type DNSSECStatus struct {
+	Enabled    bool
+	Denial     string     `json:",omitempty"`
+	Serial     uint32     `json:",omitempty"`
+	Inception  *time.Time `json:",omitempty"`
+	Expiration *time.Time `json:",omitempty"`
+	Keys       []*DNSSECKeyStatus
+}
+
+type DNSSECKeyStatus struct {
+	Tag       uint16
+	Algorithm string
+	Role      string
+	DNSKEY    string
+	DS        string `json:",omitempty"`
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitDNSSECAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to load the keys and start re-signing
+
+func InitialiseDNSSEC(paths []string, denial string, validity time.Duration) {
+
+	// no keys disables signing
+	if len(paths) == 0 {
+		return
+	}
+
+	signer := &DNSSECSigner{
+		Validity: validity,
+	}
+
+	switch denial {
+	case "nsec":
+	case "nsec3":
+		signer.NSEC3 = true
+	default:
+		log.WithFields(log.Fields{
+			"denial": denial,
+		}).Fatal("DNSSEC denial of existence must be 'nsec' or 'nsec3'")
+	}
+
+	if validity < 4*DNSSEC_INCEPTION_OFFSET {
+		log.WithFields(log.Fields{
+			"validity": validity,
+		}).Fatal("DNSSEC signature validity is too short")
+	}
+
+	for _, path := range paths {
+		key, err := LoadDNSSECKey(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  path,
+			}).Fatal("Unable to load DNSSEC key")
+		}
+		if key.Owner != "." {
+			log.WithFields(log.Fields{
+				"path":  path,
+				"owner": key.Owner,
+			}).Fatal("DNSSEC key is not for the root zone")
+		}
+
+		log.WithFields(log.Fields{
+			"path":      key.Path,
+			"tag":       key.Tag,
+			"algorithm": key.Algorithm,
+			"role":      key.Role(),
+		}).Info("Loaded DNSSEC key")
+
+		signer.Keys = append(signer.Keys, key)
+	}
+
+	go signer.run()
+	DNSSigner = signer
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load a key from a BIND format key pair, the path may be of either
+// file or the common prefix
+
+func LoadDNSSECKey(path string) (*DNSSECKey, error) {
+
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+	key := &DNSSECKey{Path: base}
+
+	// the public key
+	data, err := ioutil.ReadFile(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	var rdata []byte
+	for _, line := range strings.Split(string(data), "\n") {
+		if ix := strings.IndexByte(line, ';'); ix != -1 {
+			line = line[:ix]
+		}
+		fields := strings.Fields(line)
+		for ix, field := range fields {
+			if strings.ToUpper(field) == "DNSKEY" && ix > 0 {
+				key.Owner = DNSFQDN(fields[0])
+				if rdata, err = DNSRDataFromText(DNS_TYPE_DNSKEY,
+					strings.Join(fields[ix+1:], " ")); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if rdata != nil {
+			break
+		}
+	}
+	if rdata == nil {
+		return nil, errors.New("no DNSKEY record found in " + base + ".key")
+	}
+
+	key.Flags = binary.BigEndian.Uint16(rdata)
+	key.Algorithm = rdata[3]
+	key.Public = rdata[4:]
+	key.Tag = DNSSECKeyTag(rdata)
+	if key.Flags&DNSSEC_FLAG_ZONE == 0 || rdata[2] != 3 {
+		return nil, errors.New("DNSKEY is not a zone key")
+	}
+
+	// and the private key
+	private, err := readDNSSECPrivate(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+
+	alg, _ := strconv.Atoi(strings.Fields(private["Algorithm"] + " 0")[0])
+	if alg != int(key.Algorithm) {
+		return nil, errors.New("private key algorithm does not match DNSKEY")
+	}
+	secret, err := base64.StdEncoding.DecodeString(private["PrivateKey"])
+	if err != nil {
+		return nil, errors.New("private key is not valid base64")
+	}
+
+	var public []byte
+	switch key.Algorithm {
+	case DNSSEC_ALG_ECDSAP256SHA256:
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(secret)
+		if len(secret) != 32 || d.Sign() == 0 ||
+			d.Cmp(curve.Params().N) >= 0 {
+			return nil, errors.New("invalid ECDSA private key")
+		}
+		ec := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve},
+			D:         d,
+		}
+		ec.PublicKey.X, ec.PublicKey.Y = curve.ScalarBaseMult(secret)
+		public = elliptic.Marshal(curve, ec.PublicKey.X, ec.PublicKey.Y)[1:]
+		key.private = ec
+
+	case DNSSEC_ALG_ED25519:
+		if len(secret) != ed25519.SeedSize {
+			return nil, errors.New("invalid ED25519 private key")
+		}
+		ed := ed25519.NewKeyFromSeed(secret)
+		public = ed.Public().(ed25519.PublicKey)
+		key.private = ed
+
+	default:
+		return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+	}
+
+	if string(public) != string(key.Public) {
+		return nil, errors.New("private key does not match DNSKEY")
+	}
+
+	return key, nil
+}
+
+// read the fields of a BIND private key file
+func readDNSSECPrivate(path string) (map[string]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if ix := strings.IndexByte(scanner.Text(), ':'); ix != -1 {
+			fields[scanner.Text()[:ix]] =
+				strings.TrimSpace(scanner.Text()[ix+1:])
+		}
+	}
+	return fields, scanner.Err()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// generate a new key pair for the root zone and exit
+//
+// the specification is dir:algorithm:role, where the role is one of
+// ksk or zsk
+
+func GenerateDNSSECKey(spec string) {
+
+	fields := strings.Split(spec, ":")
+	if len(fields) != 3 {
+		fmt.Fprintln(os.Stderr, "DNSSEC key must be given as dir:algorithm:ksk|zsk")
+		os.Exit(1)
+	}
+
+	key := &DNSSECKey{
+		Owner: ".",
+		Flags: DNSSEC_FLAG_ZONE,
+	}
+	switch strings.ToLower(fields[2]) {
+	case "ksk":
+		key.Flags |= DNSSEC_FLAG_SEP
+	case "zsk":
+	default:
+		fmt.Fprintf(os.Stderr, "DNSSEC key role must be ksk or zsk, not '%s'\n",
+			fields[2])
+		os.Exit(1)
+	}
+
+	var secret []byte
+	switch fields[1] {
+	case "13", "ecdsap256sha256", "ECDSAP256SHA256":
+		key.Algorithm = DNSSEC_ALG_ECDSAP256SHA256
+		ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to generate key: %s\n", err)
+			os.Exit(1)
+		}
+		// the public key is the uncompressed point without its prefix,
+		// and the private key is D padded to the size of the curve
+		key.Public = elliptic.Marshal(ec.Curve, ec.X, ec.Y)[1:]
+		secret = ec.D.FillBytes(make([]byte, 32))
+
+	case "15", "ed25519", "ED25519":
+		key.Algorithm = DNSSEC_ALG_ED25519
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to generate key: %s\n", err)
+			os.Exit(1)
+		}
+		key.Public = public
+		secret = private.Seed()
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported DNSSEC algorithm '%s', "+
+			"use 13 (ECDSAP256SHA256) or 15 (ED25519)\n", fields[1])
+		os.Exit(1)
+	}
+
+	key.Tag = DNSSECKeyTag(key.RData())
+	key.Path = filepath.Join(fields[0],
+		fmt.Sprintf("K.+%03d+%05d", key.Algorithm, key.Tag))
+	created := time.Now().UTC().Format("20060102150405")
+
+	public := fmt.Sprintf("; This is a %s, keyid %d, for .\n"+
+		"; Created: %s\n. IN DNSKEY %s\n", key.Role(), key.Tag,
+		created, DNSRDataToText(DNS_TYPE_DNSKEY, key.RData()))
+
+	private := fmt.Sprintf("Private-key-format: v1.3\n"+
+		"Algorithm: %d (%s)\nPrivateKey: %s\nCreated: %s\n",
+		key.Algorithm, dnssecAlgorithmNames[key.Algorithm],
+		base64.StdEncoding.EncodeToString(secret), created)
+
+	if err := ioutil.WriteFile(key.Path+".private",
+		[]byte(private), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write private key: %s\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(key.Path+".key",
+		[]byte(public), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write public key: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(key.Path)
+	if key.Flags&DNSSEC_FLAG_SEP != 0 {
+		fmt.Printf(".\tIN\tDS\t%s\n", DNSRDataToText(DNS_TYPE_DS, key.DS()))
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// key utility functions
+
+// return the DNSKEY rdata
+func (key *DNSSECKey) RData() []byte {
+	b := dnsAppendUint16(nil, key.Flags)
+	b = append(b, 3, key.Algorithm)
+	return append(b, key.Public...)
+}
+
+// return the DS rdata, using SHA-256 (RFC 4509)
+func (key *DNSSECKey) DS() []byte {
+	digest := sha256.Sum256(append(dnsAppendName(nil, key.Owner),
+		key.RData()...))
+	b := dnsAppendUint16(nil, key.Tag)
+	b = append(b, key.Algorithm, 2)
+	return append(b, digest[:]...)
+}
+
+// return a description of how the key is used
+func (key *DNSSECKey) Role() string {
+	if key.Flags&DNSSEC_FLAG_SEP != 0 {
+		return "key-signing key"
+	}
+	return "zone-signing key"
+}
+
+// sign data with the key
+func (key *DNSSECKey) Sign(data []byte) ([]byte, error) {
+
+	switch key.Algorithm {
+	case DNSSEC_ALG_ECDSAP256SHA256:
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, key.private.(*ecdsa.PrivateKey),
+			digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// the signature is r and s, each padded to 32 octets (RFC 6605)
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+
+	case DNSSEC_ALG_ED25519:
+		return ed25519.Sign(key.private.(ed25519.PrivateKey), data), nil
+	}
+
+	return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+}
+
+// calculate the key tag for DNSKEY rdata (RFC 4034 appendix B)
+func DNSSECKeyTag(rdata []byte) uint16 {
+	var ac uint32
+	for ix, b := range rdata {
+		if ix&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac & 0xFFFF)
+}
+
+// verify a signature using DNSKEY rdata
+func DNSSECVerifySignature(rdata []byte, data []byte,
+	signature []byte) error {
+
+	if len(rdata) < 4 {
+		return errors.New("invalid DNSKEY")
+	}
+
+	switch rdata[3] {
+	case DNSSEC_ALG_ECDSAP256SHA256:
+		x, y := elliptic.Unmarshal(elliptic.P256(),
+			append([]byte{4}, rdata[4:]...))
+		if x == nil {
+			return errors.New("invalid ECDSA public key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if len(signature) != 64 {
+			return errors.New("invalid ECDSA signature length")
+		}
+		digest := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("ECDSA signature does not verify")
+		}
+		return nil
+
+	case DNSSEC_ALG_ED25519:
+		if len(rdata[4:]) != ed25519.PublicKeySize {
+			return errors.New("invalid ED25519 public key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(rdata[4:]), data, signature) {
+			return errors.New("ED25519 signature does not verify")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported DNSSEC algorithm %d", rdata[3])
+}
+
+//////////////////////////////////////////////////////////////////////////
+// sign a new version of the zone
+//
+// if the zone content, other than the serial, is unchanged and the
+// current signatures are still fresh, the existing signed zone is kept.
+// The signed zone carries its own serial, taken from the signing time,
+// so that re-signing an unchanged zone is still picked up by secondaries.
+
+func (signer *DNSSECSigner) Sign(unsigned *DNSAuthZone) *DNSAuthZone {
+
+	fingerprint := dnssecFingerprint(unsigned)
+
+	signer.mutex.Lock()
+	defer signer.mutex.Unlock()
+
+	if signer.signed != nil && fingerprint == signer.fingerprint &&
+		!signer.due(time.Now()) {
+		current := *signer.signed
+		current.Commit = unsigned.Commit
+		signer.unsigned = unsigned
+		signer.signed = &current
+		return signer.signed
+	}
+
+	signer.unsigned = unsigned
+	signer.fingerprint = fingerprint
+	return signer.resign(time.Now())
+}
+
+// return the current signed zone
+func (signer *DNSSECSigner) Current() *DNSAuthZone {
+	signer.mutex.RLock()
+	defer signer.mutex.RUnlock()
+	return signer.signed
+}
+
+// signatures are renewed once half of their validity has passed
+func (signer *DNSSECSigner) due(now time.Time) bool {
+	return signer.expiration.Sub(now) < signer.Validity/2
+}
+
+// sign the unsigned zone, the mutex must be held
+func (signer *DNSSECSigner) resign(now time.Time) *DNSAuthZone {
+
+	serial := DNSConfig.Serial(now)
+	if signer.signed != nil && serial <= signer.signed.Serial {
+		serial = signer.signed.Serial + 1
+	}
+	if serial < signer.unsigned.Serial {
+		serial = signer.unsigned.Serial
+	}
+
+	inception := now.Add(-DNSSEC_INCEPTION_OFFSET)
+	expiration := now.Add(signer.Validity)
+
+	zone, err := DNSSECSignZone(signer.unsigned, serial, signer.Keys,
+		signer.NSEC3, inception, expiration)
+	if err == nil {
+		// never publish a zone that does not validate
+		if problems := DNSSECVerifyZone(zone, now); len(problems) > 0 {
+			err = problems[0]
+		}
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"serial": serial,
+		}).Error("Failed to sign DNS zone")
+		if signer.signed != nil {
+			return signer.signed
+		}
+		return signer.unsigned
+	}
+
+	signer.signed = zone
+	signer.inception = inception
+	signer.expiration = expiration
+
+	log.WithFields(log.Fields{
+		"zone":       zone.Origin,
+		"serial":     zone.Serial,
+		"expiration": expiration.UTC().Format(time.RFC3339),
+	}).Info("Signed DNS zone")
+
+	return zone
+}
+
+// periodically re-sign before the signatures expire
+func (signer *DNSSECSigner) run() {
+
+	interval := signer.Validity / 16
+	if interval > DNSSEC_CHECK_INTERVAL {
+		interval = DNSSEC_CHECK_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+
+		signer.mutex.Lock()
+		var zone *DNSAuthZone
+		if signer.unsigned != nil && signer.due(time.Now()) {
+			zone = signer.resign(time.Now())
+		}
+		signer.mutex.Unlock()
+
+		if zone != nil && DNSAuthServer != nil {
+			DNSAuthServer.Update(zone)
+		}
+	}
+}
+
+// return a hash of the zone content, ignoring the SOA serial
+func dnssecFingerprint(zone *DNSAuthZone) [sha256.Size]byte {
+	h := sha256.New()
+	for _, rr := range zone.Records() {
+		rdata := rr.RData
+		if rr.Type == DNS_TYPE_SOA && rr.Name == zone.Origin {
+			rdata = append([]byte(nil), rdata...)
+			binary.BigEndian.PutUint32(rdata[len(rdata)-20:], 0)
+		}
+		h.Write(dnsAppendName(nil, rr.Name))
+		h.Write(dnsAppendUint16(nil, rr.Type, uint16(len(rdata))))
+		h.Write(dnsAppendUint32(nil, rr.TTL))
+		h.Write(rdata)
+	}
+	var fingerprint [sha256.Size]byte
+	copy(fingerprint[:], h.Sum(nil))
+	return fingerprint
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the signed version of the root zone, if signing is enabled
+
+func DNSSECSigned(unsigned *DNSAuthZone) *DNSAuthZone {
+	if DNSSigner != nil {
+		if signed := DNSSigner.Current(); signed != nil {
+			return signed
+		}
+	}
+	return unsigned
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitDNSSECAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/dns/dnssec", dnssecStatusHandler).Methods("GET")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the signing status and keys
+
+func dnssecStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	status := &DNSSECStatus{
+		Keys: make([]*DNSSECKeyStatus, 0),
+	}
+
+	if DNSSigner != nil {
+		status.Enabled = true
+		status.Denial = "NSEC"
+		if DNSSigner.NSEC3 {
+			status.Denial = "NSEC3"
+		}
+
+		DNSSigner.mutex.RLock()
+		if DNSSigner.signed != nil {
+			status.Serial = DNSSigner.signed.Serial
+			inception, expiration := DNSSigner.inception, DNSSigner.expiration
+			status.Inception = &inception
+			status.Expiration = &expiration
+		}
+		DNSSigner.mutex.RUnlock()
+
+		for _, key := range DNSSigner.Keys {
+			ks := &DNSSECKeyStatus{
+				Tag:       key.Tag,
+				Algorithm: dnssecAlgorithmNames[key.Algorithm],
+				Role:      key.Role(),
+				DNSKEY:    DNSRDataToText(DNS_TYPE_DNSKEY, key.RData()),
+			}
+			if key.Flags&DNSSEC_FLAG_SEP != 0 {
+				ks.DS = DNSRDataToText(DNS_TYPE_DS, key.DS())
+			}
+			status.Keys = append(status.Keys, ks)
+		}
+	}
+
+	(&Response{Data: status, Records: status.Keys}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
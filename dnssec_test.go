@@ -0,0 +1,338 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// generate a key pair in a temporary directory and load it back
+func testDNSSECKey(t *testing.T, algorithm string, role string) *DNSSECKey {
+
+	dir, err := ioutil.TempDir("", "dnssec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// the key path and DS are printed on stdout
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	GenerateDNSSECKey(dir + ":" + algorithm + ":" + role)
+	os.Stdout.Close()
+	os.Stdout = stdout
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.private"))
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 private key, found %d", len(paths))
+	}
+	key, err := LoadDNSSECKey(paths[0])
+	if err != nil {
+		t.Fatalf("unable to load generated key: %s", err)
+	}
+	return key
+}
+
+// a root zone with delegations, glue and an empty non-terminal
+func testDNSSECZone(t *testing.T) *DNSAuthZone {
+	return testPushZone(t, 1,
+		"dn42 3600 NS a.root.dn42.",
+		"dn42 3600 DS 64441 13 2 6dadda00",
+		"a.root.dn42 3600 A 172.20.0.1",
+		"20.172.in-addr.arpa 3600 NS a.root.dn42.",
+		"d.f.ip6.arpa 3600 NS b.root.dn42.",
+		"b.root.dn42 3600 AAAA fd42::1",
+		"x.y.example 3600 TXT \"empty non-terminal above\"",
+	)
+}
+
+// join the problems found, for matching and reporting
+func testDNSSECProblems(problems []error) string {
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestLoadDNSSECKey(t *testing.T) {
+
+	// the ED25519 key is the example from RFC 8080, the ECDSA public key
+	// was derived from the private key with crypto/ecdh
+	tests := []struct {
+		private string
+		public  string
+		tag     uint16
+	}{
+		{
+			"Private-key-format: v1.2\nAlgorithm: 13 (ECDSAP256SHA256)\n" +
+				"PrivateKey: GU6SnQ/Ojn8mK3ArbK1sI8mYr9kM7z3+NeN2xOIVjEk=\n",
+			"example.net. 3600 IN DNSKEY 257 3 13 " +
+				"UNbiyJOszSeTsQqUaztuyGxrqJ4aWqp0WWvgpp7adE5T" +
+				"VZezY+nVQV9skfRfwGQqkFQi4//pCVtbm+3So2g4Ug==\n",
+			62212,
+		},
+		{
+			"Private-key-format: v1.2\nAlgorithm: 15 (ED25519)\n" +
+				"PrivateKey: ODIyNjAzODQ2MjgwODAxMjI2NDUxOTAyMDQxNDIyNjI=\n",
+			"example.com. 3600 IN DNSKEY 257 3 15 " +
+				"l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=\n",
+			3613,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "dnssec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		base := filepath.Join(dir, fmt.Sprintf("K%05d", test.tag))
+		ioutil.WriteFile(base+".private", []byte(test.private), 0600)
+		ioutil.WriteFile(base+".key", []byte(test.public), 0644)
+
+		key, err := LoadDNSSECKey(base + ".key")
+		if err != nil {
+			t.Errorf("%d: unable to load key: %s", test.tag, err)
+			continue
+		}
+		if key.Tag != test.tag || key.Flags&DNSSEC_FLAG_SEP == 0 {
+			t.Errorf("%d: loaded key with tag %d, flags %d", test.tag,
+				key.Tag, key.Flags)
+		}
+
+		// a private key that does not match the DNSKEY is rejected
+		mismatch := strings.Replace(test.public, " 13 U", " 13 V", 1)
+		mismatch = strings.Replace(mismatch, " 15 l", " 15 m", 1)
+		ioutil.WriteFile(base+".key", []byte(mismatch), 0644)
+		if _, err := LoadDNSSECKey(base + ".key"); err == nil {
+			t.Errorf("%d: mismatched key was loaded", test.tag)
+		}
+	}
+}
+
+func TestDNSSECKeySign(t *testing.T) {
+
+	for _, algorithm := range []string{"13", "15"} {
+		for _, role := range []string{"ksk", "zsk"} {
+			key := testDNSSECKey(t, algorithm, role)
+
+			if (key.Flags&DNSSEC_FLAG_SEP != 0) != (role == "ksk") ||
+				key.Tag != DNSSECKeyTag(key.RData()) {
+				t.Errorf("%s %s: unexpected key %+v", algorithm, role, key)
+			}
+
+			data := []byte("data to be signed")
+			signature, err := key.Sign(data)
+			if err != nil {
+				t.Fatalf("%s %s: sign failed: %s", algorithm, role, err)
+			}
+			if err := DNSSECVerifySignature(key.RData(), data,
+				signature); err != nil {
+				t.Errorf("%s %s: %s", algorithm, role, err)
+			}
+			data[0] ^= 1
+			if DNSSECVerifySignature(key.RData(), data, signature) == nil {
+				t.Errorf("%s %s: modified data verified", algorithm, role)
+			}
+		}
+	}
+}
+
+func TestDNSSECSignZone(t *testing.T) {
+
+	ecdsaKSK := testDNSSECKey(t, "13", "ksk")
+	ecdsaZSK := testDNSSECKey(t, "13", "zsk")
+	ed25519Key := testDNSSECKey(t, "15", "ksk")
+
+	tests := []struct {
+		name  string
+		keys  []*DNSSECKey
+		nsec3 bool
+	}{
+		{"ECDSA KSK and ZSK with NSEC", []*DNSSECKey{ecdsaKSK, ecdsaZSK}, false},
+		{"ECDSA KSK and ZSK with NSEC3", []*DNSSECKey{ecdsaKSK, ecdsaZSK}, true},
+		{"ED25519 single key with NSEC", []*DNSSECKey{ed25519Key}, false},
+		{"both algorithms with NSEC3", []*DNSSECKey{ecdsaKSK, ecdsaZSK,
+			ed25519Key}, true},
+	}
+
+	now := time.Now()
+	inception := now.Add(-time.Hour)
+	expiration := now.Add(24 * time.Hour)
+
+	for _, test := range tests {
+		unsigned := testDNSSECZone(t)
+		zone, err := DNSSECSignZone(unsigned, 42, test.keys, test.nsec3,
+			inception, expiration)
+		if err != nil {
+			t.Fatalf("%s: signing failed: %s", test.name, err)
+		}
+
+		if problems := DNSSECVerifyZone(zone, now); len(problems) != 0 {
+			t.Errorf("%s: signed zone does not verify:\n%s", test.name,
+				testDNSSECProblems(problems))
+		}
+		if zone.Serial != 42 || DNSSOASerial(zone.SOA().RData) != 42 {
+			t.Errorf("%s: serial was not updated", test.name)
+		}
+		if len(zone.RRSet(".", DNS_TYPE_DNSKEY)) != len(test.keys) {
+			t.Errorf("%s: expected %d DNSKEY records", test.name, len(test.keys))
+		}
+
+		// the denial of existence records
+		nsec := len(zone.RRSet("dn42.", DNS_TYPE_NSEC)) != 0
+		nsec3param := len(zone.RRSet(".", DNS_TYPE_NSEC3PARAM)) != 0
+		if nsec == test.nsec3 || nsec3param != test.nsec3 {
+			t.Errorf("%s: NSEC %v, NSEC3PARAM %v", test.name, nsec, nsec3param)
+		}
+
+		// only authoritative data is signed
+		signed := []struct {
+			name   string
+			t      uint16
+			signed bool
+		}{
+			{".", DNS_TYPE_SOA, true},
+			{".", DNS_TYPE_DNSKEY, true},
+			{"dn42.", DNS_TYPE_DS, true},
+			{"dn42.", DNS_TYPE_NS, false},
+			{"a.root.dn42.", DNS_TYPE_A, false},
+			{"x.y.example.", DNS_TYPE_TXT, true},
+		}
+		for _, s := range signed {
+			if (len(zone.dnssecRRSIGs(s.name, s.t)) != 0) != s.signed {
+				t.Errorf("%s: %s %s signed, expected %v", test.name, s.name,
+					DNSTypeString(s.t), s.signed)
+			}
+		}
+
+		// the DNSKEY RRset is only signed by the KSK, where there is one
+		if len(test.keys) == 2 {
+			if rrsigs := zone.dnssecRRSIGs(".", DNS_TYPE_DNSKEY); len(rrsigs) != 1 ||
+				binary.BigEndian.Uint16(rrsigs[0].RData[16:]) != ecdsaKSK.Tag {
+				t.Errorf("%s: DNSKEY RRset is not signed by the KSK", test.name)
+			}
+		}
+
+		// the unsigned zone is not changed
+		if len(unsigned.RRSet(".", DNS_TYPE_DNSKEY)) != 0 ||
+			unsigned.Serial != 1 {
+			t.Errorf("%s: unsigned zone was modified", test.name)
+		}
+	}
+}
+
+func TestDNSSECVerifyZone(t *testing.T) {
+
+	keys := []*DNSSECKey{testDNSSECKey(t, "13", "ksk"),
+		testDNSSECKey(t, "13", "zsk")}
+
+	now := time.Now()
+	inception := now.Add(-time.Hour)
+	expiration := now.Add(24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		nsec3    bool
+		now      time.Time
+		tamper   func(zone *DNSAuthZone)
+		expected string
+	}{
+		{
+			name:     "modified record",
+			tamper:   func(zone *DNSAuthZone) { zone.RRSet("dn42.", DNS_TYPE_DS)[0].RData[0] ^= 1 },
+			expected: "dn42. DS: RRSIG",
+		},
+		{
+			name: "missing signature",
+			tamper: func(zone *DNSAuthZone) {
+				delete(zone.rrsets["x.y.example."], DNS_TYPE_RRSIG)
+			},
+			expected: "x.y.example. TXT: no valid signature",
+		},
+		{
+			name:     "expired",
+			now:      expiration.Add(time.Minute),
+			expected: "expired",
+		},
+		{
+			name:     "not yet valid",
+			now:      inception.Add(-time.Minute),
+			expected: "not valid until",
+		},
+		{
+			name: "broken NSEC chain",
+			tamper: func(zone *DNSAuthZone) {
+				delete(zone.rrsets["dn42."], DNS_TYPE_NSEC)
+			},
+			expected: "dn42.: no NSEC record",
+		},
+		{
+			name:  "broken NSEC3 chain",
+			nsec3: true,
+			tamper: func(zone *DNSAuthZone) {
+				owner := dnssecNSEC3Owner(DNSSECHashName("dn42.", nil, 0), ".")
+				delete(zone.rrsets[owner], DNS_TYPE_NSEC3)
+			},
+			expected: "dn42.: no NSEC3 record",
+		},
+		{
+			name:  "NSEC3 with the wrong types",
+			nsec3: true,
+			tamper: func(zone *DNSAuthZone) {
+				zone.rrsets["x.y.example."][DNS_TYPE_A] = []*DNSRR{{
+					Name: "x.y.example.", Type: DNS_TYPE_A, Class: DNS_CLASS_IN,
+					TTL: 3600, RData: []byte{10, 0, 0, 1},
+				}}
+			},
+			expected: "types are",
+		},
+	}
+
+	for _, test := range tests {
+		zone, err := DNSSECSignZone(testDNSSECZone(t), 2, keys, test.nsec3,
+			inception, expiration)
+		if err != nil {
+			t.Fatalf("%s: signing failed: %s", test.name, err)
+		}
+		if test.tamper != nil {
+			test.tamper(zone)
+		}
+		if test.now.IsZero() {
+			test.now = now
+		}
+
+		problems := testDNSSECProblems(DNSSECVerifyZone(zone, test.now))
+		if !strings.Contains(problems, test.expected) {
+			t.Errorf("%s: expected a problem with '%s', got:\n%s", test.name,
+				test.expected, problems)
+		}
+	}
+
+	// an unsigned zone has no keys
+	problems := testDNSSECProblems(DNSSECVerifyZone(testDNSSECZone(t), now))
+	if !strings.Contains(problems, "no DNSKEY records") {
+		t.Errorf("unsigned zone: unexpected problems:\n%s", problems)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
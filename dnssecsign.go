@@ -0,0 +1,818 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// zone signing (RFC 4034, RFC 4035 and RFC 5155)
+//
+// only authoritative data is signed; NS records at a delegation and glue
+// below it are left unsigned, while DS records at the delegation are
+// signed. NSEC3 uses no salt or additional iterations and no opt-out,
+// as recommended in RFC 9276.
+
+const (
+	DNSSEC_NSEC3_SHA1       = 1
+	DNSSEC_NSEC3_ITERATIONS = 0
+	DNSSEC_AXFR_TIMEOUT     = 30 * time.Second
+)
+
+//////////////////////////////////////////////////////////////////////////
+// return a signed copy of a zone
+
+func DNSSECSignZone(unsigned *DNSAuthZone, serial uint32, keys []*DNSSECKey,
+	nsec3 bool, inception time.Time, expiration time.Time) (*DNSAuthZone,
+	error) {
+
+	zone := newDNSAuthZone(unsigned.Origin, serial)
+	zone.Commit = unsigned.Commit
+
+	// copy the records, replacing any existing DNSSEC data and
+	// updating the SOA serial
+	for _, rr := range unsigned.Records() {
+		switch rr.Type {
+		case DNS_TYPE_DNSKEY, DNS_TYPE_RRSIG, DNS_TYPE_NSEC,
+			DNS_TYPE_NSEC3, DNS_TYPE_NSEC3PARAM:
+			continue
+		}
+		record := *rr
+		if rr.Type == DNS_TYPE_SOA && rr.Name == zone.Origin {
+			record.RData = append([]byte(nil), rr.RData...)
+			binary.BigEndian.PutUint32(record.RData[len(record.RData)-20:],
+				serial)
+		}
+		zone.Add(&record)
+	}
+
+	soa := zone.SOA()
+	if soa == nil {
+		return nil, errors.New("zone has no SOA record")
+	}
+
+	for _, key := range keys {
+		zone.Add(&DNSRR{
+			Name:  zone.Origin,
+			Type:  DNS_TYPE_DNSKEY,
+			Class: DNS_CLASS_IN,
+			TTL:   soa.TTL,
+			RData: key.RData(),
+		})
+	}
+
+	// NSEC and NSEC3 records use the negative TTL (RFC 9077)
+	ttl := soa.TTL
+	if minimum := binary.BigEndian.Uint32(soa.RData[len(soa.RData)-4:]); minimum < ttl {
+		ttl = minimum
+	}
+
+	if nsec3 {
+		if err := zone.dnssecAddNSEC3(ttl); err != nil {
+			return nil, err
+		}
+	} else {
+		zone.dnssecAddNSEC(ttl)
+	}
+
+	// sign each authoritative RRset, with every algorithm
+	signatures := make([]*DNSRR, 0)
+	for _, name := range zone.Names() {
+		for _, t := range zone.Types(name) {
+			if !zone.dnssecSigned(name, t) {
+				continue
+			}
+			for _, key := range dnssecSigningKeys(keys, t == DNS_TYPE_DNSKEY) {
+				rrsig, err := dnssecSignRRSet(zone.rrsets[name][t], key,
+					zone.Origin, inception, expiration)
+				if err != nil {
+					return nil, err
+				}
+				signatures = append(signatures, rrsig)
+			}
+		}
+	}
+	for _, rrsig := range signatures {
+		zone.Add(rrsig)
+	}
+
+	return zone, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// authoritative data
+
+// return true if an RRset is authoritative, and so is signed
+func (zone *DNSAuthZone) dnssecSigned(name string, t uint16) bool {
+	if t == DNS_TYPE_RRSIG {
+		return false
+	}
+	switch zone.FindCut(name) {
+	case "":
+		return true
+	case name:
+		return t == DNS_TYPE_DS || t == DNS_TYPE_NSEC
+	}
+	return false
+}
+
+// return the names that form the NSEC or NSEC3 chain, in canonical
+// order. NSEC3 also covers empty non-terminals.
+func (zone *DNSAuthZone) dnssecChainNames(nsec3 bool) []string {
+
+	names := make([]string, 0, len(zone.names))
+	for name := range zone.names {
+		if zone.rrsets[name] == nil && !nsec3 {
+			continue
+		}
+		if len(zone.RRSet(name, DNS_TYPE_NSEC3)) > 0 {
+			continue
+		}
+		if cut := zone.FindCut(name); cut != "" && cut != name {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return DNSCanonicalLess(names[i], names[j])
+	})
+	return names
+}
+
+// return the types listed in the NSEC or NSEC3 bitmap for a name
+func (zone *DNSAuthZone) dnssecTypes(name string, nsec3 bool) []uint16 {
+
+	delegation := name != zone.Origin && zone.FindCut(name) == name
+	types := make([]uint16, 0)
+	signed := false
+
+	for _, t := range zone.Types(name) {
+		switch t {
+		case DNS_TYPE_RRSIG, DNS_TYPE_NSEC, DNS_TYPE_NSEC3:
+			continue
+		}
+		if delegation && t != DNS_TYPE_NS && t != DNS_TYPE_DS {
+			continue
+		}
+		types = append(types, t)
+		if !delegation || t == DNS_TYPE_DS {
+			signed = true
+		}
+	}
+
+	// the NSEC record is itself signed
+	if !nsec3 {
+		types = append(types, DNS_TYPE_NSEC)
+		signed = true
+	}
+	if signed {
+		types = append(types, DNS_TYPE_RRSIG)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+//////////////////////////////////////////////////////////////////////////
+// denial of existence
+
+// add the NSEC chain
+func (zone *DNSAuthZone) dnssecAddNSEC(ttl uint32) {
+
+	names := zone.dnssecChainNames(false)
+
+	records := make([]*DNSRR, len(names))
+	for ix, name := range names {
+		rdata := dnsAppendName(nil, names[(ix+1)%len(names)])
+		records[ix] = &DNSRR{
+			Name:  name,
+			Type:  DNS_TYPE_NSEC,
+			Class: DNS_CLASS_IN,
+			TTL:   ttl,
+			RData: append(rdata, dnsTypeBitmap(zone.dnssecTypes(name, false))...),
+		}
+	}
+
+	for _, rr := range records {
+		zone.Add(rr)
+	}
+	zone.chain = names
+}
+
+// add the NSEC3PARAM record and NSEC3 chain
+func (zone *DNSAuthZone) dnssecAddNSEC3(ttl uint32) error {
+
+	param := []byte{DNSSEC_NSEC3_SHA1, 0}
+	param = dnsAppendUint16(param, DNSSEC_NSEC3_ITERATIONS)
+	param = append(param, 0)
+
+	zone.Add(&DNSRR{
+		Name:  zone.Origin,
+		Type:  DNS_TYPE_NSEC3PARAM,
+		Class: DNS_CLASS_IN,
+		TTL:   0,
+		RData: param,
+	})
+
+	type hashed struct {
+		hash  []byte
+		types []uint16
+	}
+
+	names := zone.dnssecChainNames(true)
+	chain := make([]*hashed, len(names))
+	for ix, name := range names {
+		chain[ix] = &hashed{
+			hash:  DNSSECHashName(name, nil, DNSSEC_NSEC3_ITERATIONS),
+			types: zone.dnssecTypes(name, true),
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool {
+		return bytes.Compare(chain[i].hash, chain[j].hash) < 0
+	})
+
+	records := make([]*DNSRR, len(chain))
+	owners := make([]string, len(chain))
+	for ix, h := range chain {
+		next := chain[(ix+1)%len(chain)].hash
+		if len(chain) > 1 && bytes.Equal(h.hash, next) {
+			return errors.New("NSEC3 hash collision")
+		}
+
+		rdata := append([]byte(nil), param[:4]...)
+		rdata = append(rdata, 0, byte(len(next)))
+		rdata = append(rdata, next...)
+
+		owners[ix] = dnssecNSEC3Owner(h.hash, zone.Origin)
+		records[ix] = &DNSRR{
+			Name:  owners[ix],
+			Type:  DNS_TYPE_NSEC3,
+			Class: DNS_CLASS_IN,
+			TTL:   ttl,
+			RData: append(rdata, dnsTypeBitmap(h.types)...),
+		}
+	}
+
+	for _, rr := range records {
+		zone.Add(rr)
+	}
+	zone.chain = owners
+
+	return nil
+}
+
+// calculate the NSEC3 hash of a name
+func DNSSECHashName(name string, salt []byte, iterations uint16) []byte {
+	hash := sha1.Sum(append(dnsAppendName(nil, DNSFQDN(name)), salt...))
+	for ix := 0; ix < int(iterations); ix++ {
+		hash = sha1.Sum(append(hash[:], salt...))
+	}
+	return hash[:]
+}
+
+// return the owner name for an NSEC3 hash
+func dnssecNSEC3Owner(hash []byte, origin string) string {
+	if origin == "." {
+		return DNSBase32Hex(hash) + "."
+	}
+	return DNSBase32Hex(hash) + "." + origin
+}
+
+//////////////////////////////////////////////////////////////////////////
+// signatures
+
+// return the keys that sign an RRset. Each algorithm must sign every
+// RRset (RFC 4035 section 2.2), with KSKs used for the DNSKEY RRset and
+// ZSKs for everything else, falling back to any key of the algorithm.
+func dnssecSigningKeys(keys []*DNSSECKey, dnskey bool) []*DNSSECKey {
+
+	selected := make([]*DNSSECKey, 0)
+	done := make(map[uint8]bool)
+
+	for _, key := range keys {
+		if done[key.Algorithm] {
+			continue
+		}
+		done[key.Algorithm] = true
+
+		var preferred, all []*DNSSECKey
+		for _, k := range keys {
+			if k.Algorithm != key.Algorithm {
+				continue
+			}
+			all = append(all, k)
+			if (k.Flags&DNSSEC_FLAG_SEP != 0) == dnskey {
+				preferred = append(preferred, k)
+			}
+		}
+		if len(preferred) == 0 {
+			preferred = all
+		}
+		selected = append(selected, preferred...)
+	}
+
+	return selected
+}
+
+// sign an RRset
+func dnssecSignRRSet(rrset []*DNSRR, key *DNSSECKey, signer string,
+	inception time.Time, expiration time.Time) (*DNSRR, error) {
+
+	name := rrset[0].Name
+	labels := DNSLabelCount(name)
+	if strings.HasPrefix(name, "*.") {
+		labels--
+	}
+
+	rdata := dnsAppendUint16(nil, rrset[0].Type)
+	rdata = append(rdata, key.Algorithm, byte(labels))
+	rdata = dnsAppendUint32(rdata, rrset[0].TTL)
+	rdata = dnsAppendUint32(rdata, uint32(expiration.Unix()))
+	rdata = dnsAppendUint32(rdata, uint32(inception.Unix()))
+	rdata = dnsAppendUint16(rdata, key.Tag)
+	rdata = dnsAppendName(rdata, signer)
+
+	signature, err := key.Sign(dnssecSignedData(rdata, rrset))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DNSRR{
+		Name:  name,
+		Type:  DNS_TYPE_RRSIG,
+		Class: DNS_CLASS_IN,
+		TTL:   rrset[0].TTL,
+		RData: append(rdata, signature...),
+	}, nil
+}
+
+// return the data covered by a signature, the RRSIG RDATA without the
+// signature followed by the RRset in canonical form (RFC 4034 section 3.1.8.1)
+func dnssecSignedData(prefix []byte, rrset []*DNSRR) []byte {
+
+	sorted := append([]*DNSRR(nil), rrset...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].RData, sorted[j].RData) < 0
+	})
+
+	data := append([]byte(nil), prefix...)
+	for _, rr := range sorted {
+		data = dnsAppendName(data, DNSFQDN(rr.Name))
+		data = dnsAppendUint16(data, rr.Type, DNS_CLASS_IN)
+		data = append(data, prefix[4:8]...)
+		data = dnsAppendUint16(data, uint16(len(rr.RData)))
+		data = append(data, rr.RData...)
+	}
+	return data
+}
+
+// return the signatures covering an RRset
+func (zone *DNSAuthZone) dnssecRRSIGs(name string, t uint16) []*DNSRR {
+	rrsigs := make([]*DNSRR, 0)
+	for _, rrsig := range zone.RRSet(name, DNS_TYPE_RRSIG) {
+		if len(rrsig.RData) >= 2 && binary.BigEndian.Uint16(rrsig.RData) == t {
+			rrsigs = append(rrsigs, rrsig)
+		}
+	}
+	return rrsigs
+}
+
+//////////////////////////////////////////////////////////////////////////
+// responses to queries with the DO bit set
+
+// add the NSEC or NSEC3 records that prove a name, or type, does not
+// exist (RFC 4035 section 3.1.3 and RFC 5155 section 7.2)
+func (zone *DNSAuthZone) addDenial(response *DNSMessage, qname string,
+	nxdomain bool) {
+
+	if len(zone.chain) == 0 {
+		return
+	}
+
+	// find the closest existing ancestor of the name
+	encloser := qname
+	next := qname
+	for encloser != zone.Origin && !zone.names[encloser] {
+		next = encloser
+		encloser = DNSParent(encloser)
+	}
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+
+	owners := make([]string, 0, 3)
+	if param := zone.RRSet(zone.Origin, DNS_TYPE_NSEC3PARAM); len(param) > 0 {
+		rdata := param[0].RData
+		iterations := binary.BigEndian.Uint16(rdata[2:])
+		salt := rdata[5:]
+		hash := func(name string) string {
+			return zone.dnssecCover(dnssecNSEC3Owner(
+				DNSSECHashName(name, salt, iterations), zone.Origin))
+		}
+
+		if nxdomain {
+			// closest encloser proof, and no wildcard
+			owners = append(owners, hash(encloser), hash(next), hash(wildcard))
+		} else {
+			owners = append(owners, hash(qname))
+		}
+	} else {
+		owners = append(owners, zone.dnssecCover(qname))
+		if nxdomain {
+			owners = append(owners, zone.dnssecCover(wildcard))
+		}
+	}
+
+	added := make(map[string]bool)
+	for _, owner := range owners {
+		if added[owner] {
+			continue
+		}
+		added[owner] = true
+		for _, t := range []uint16{DNS_TYPE_NSEC, DNS_TYPE_NSEC3} {
+			if rrset := zone.RRSet(owner, t); len(rrset) > 0 {
+				response.Authority = append(response.Authority, rrset...)
+				response.Authority = append(response.Authority,
+					zone.dnssecRRSIGs(owner, t)...)
+			}
+		}
+	}
+}
+
+// return the chain owner that matches or covers a name
+func (zone *DNSAuthZone) dnssecCover(name string) string {
+	ix := sort.Search(len(zone.chain), func(i int) bool {
+		return DNSCanonicalLess(name, zone.chain[i])
+	})
+	if ix == 0 {
+		ix = len(zone.chain)
+	}
+	return zone.chain[ix-1]
+}
+
+//////////////////////////////////////////////////////////////////////////
+// verify a signed zone, returning any problems found
+//
+// checks that every authoritative RRset has a valid signature for each
+// algorithm in the DNSKEY RRset, and that the NSEC or NSEC3 chain is
+// complete
+
+func DNSSECVerifyZone(zone *DNSAuthZone, now time.Time) []error {
+
+	problems := make([]error, 0)
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	dnskeys := zone.RRSet(zone.Origin, DNS_TYPE_DNSKEY)
+	algorithms := make([]uint8, 0)
+	for _, dnskey := range dnskeys {
+		if len(dnskey.RData) < 4 {
+			fail("%s DNSKEY: invalid record", zone.Origin)
+			continue
+		}
+		known := false
+		for _, alg := range algorithms {
+			known = known || alg == dnskey.RData[3]
+		}
+		if !known {
+			algorithms = append(algorithms, dnskey.RData[3])
+		}
+	}
+	if len(algorithms) == 0 {
+		fail("%s: no DNSKEY records at the apex", zone.Origin)
+		return problems
+	}
+
+	for _, name := range zone.Names() {
+		for _, t := range zone.Types(name) {
+			if t == DNS_TYPE_RRSIG {
+				continue
+			}
+
+			signed := zone.dnssecSigned(name, t)
+			valid := make(map[uint8]bool)
+
+			for _, rrsig := range zone.dnssecRRSIGs(name, t) {
+				if !signed {
+					fail("%s %s: non-authoritative data is signed",
+						name, DNSTypeString(t))
+					break
+				}
+				if err := zone.dnssecVerifyRRSIG(rrsig, dnskeys, now); err != nil {
+					fail("%s %s: %s", name, DNSTypeString(t), err)
+					continue
+				}
+				valid[rrsig.RData[2]] = true
+			}
+
+			if signed {
+				for _, alg := range algorithms {
+					if !valid[alg] {
+						fail("%s %s: no valid signature for algorithm %d",
+							name, DNSTypeString(t), alg)
+					}
+				}
+			}
+		}
+	}
+
+	if len(zone.RRSet(zone.Origin, DNS_TYPE_NSEC3PARAM)) > 0 {
+		return append(problems, zone.dnssecVerifyNSEC3()...)
+	}
+	return append(problems, zone.dnssecVerifyNSEC()...)
+}
+
+// verify a single signature
+func (zone *DNSAuthZone) dnssecVerifyRRSIG(rrsig *DNSRR, dnskeys []*DNSRR,
+	now time.Time) error {
+
+	rdata := rrsig.RData
+	if len(rdata) < 18 {
+		return errors.New("invalid RRSIG")
+	}
+	signer, offset, err := dnsReadName(rdata, 18)
+	if err != nil {
+		return errors.New("invalid RRSIG signer name")
+	}
+	tag := binary.BigEndian.Uint16(rdata[16:])
+
+	if signer != zone.Origin {
+		return fmt.Errorf("RRSIG %d signer is %s", tag, signer)
+	}
+	labels := DNSLabelCount(rrsig.Name)
+	if strings.HasPrefix(rrsig.Name, "*.") {
+		labels--
+	}
+	if int(rdata[3]) != labels {
+		return fmt.Errorf("RRSIG %d has %d labels, expected %d",
+			tag, rdata[3], labels)
+	}
+
+	rrset := zone.RRSet(rrsig.Name, binary.BigEndian.Uint16(rdata))
+	if ttl := binary.BigEndian.Uint32(rdata[4:]); ttl != rrset[0].TTL ||
+		rrsig.TTL > ttl {
+		return fmt.Errorf("RRSIG %d TTL does not match the RRset", tag)
+	}
+
+	expiration := int64(binary.BigEndian.Uint32(rdata[8:]))
+	inception := int64(binary.BigEndian.Uint32(rdata[12:]))
+	if now.Unix() < inception {
+		return fmt.Errorf("RRSIG %d is not valid until %s",
+			tag, dnsTimeString(uint32(inception)))
+	}
+	if now.Unix() > expiration {
+		return fmt.Errorf("RRSIG %d expired at %s",
+			tag, dnsTimeString(uint32(expiration)))
+	}
+
+	data := dnssecSignedData(rdata[:offset], rrset)
+	err = fmt.Errorf("RRSIG %d has no matching DNSKEY", tag)
+	for _, dnskey := range dnskeys {
+		if len(dnskey.RData) < 4 || dnskey.RData[3] != rdata[2] ||
+			DNSSECKeyTag(dnskey.RData) != tag ||
+			binary.BigEndian.Uint16(dnskey.RData)&DNSSEC_FLAG_ZONE == 0 {
+			continue
+		}
+		if err = DNSSECVerifySignature(dnskey.RData, data,
+			rdata[offset:]); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("RRSIG %d: %s", tag, err)
+	}
+	return err
+}
+
+// check the NSEC chain
+func (zone *DNSAuthZone) dnssecVerifyNSEC() []error {
+
+	problems := make([]error, 0)
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	names := zone.dnssecChainNames(false)
+	chain := make(map[string]bool)
+
+	for ix, name := range names {
+		chain[name] = true
+
+		nsec := zone.RRSet(name, DNS_TYPE_NSEC)
+		if len(nsec) != 1 {
+			fail("%s: no NSEC record", name)
+			continue
+		}
+		next, offset, err := dnsReadName(nsec[0].RData, 0)
+		if err != nil {
+			fail("%s NSEC: invalid record", name)
+			continue
+		}
+		if expected := names[(ix+1)%len(names)]; next != expected {
+			fail("%s NSEC: next name is %s, expected %s", name, next, expected)
+		}
+		types, err := dnsReadTypeBitmap(nsec[0].RData[offset:])
+		if expected := zone.dnssecTypes(name, false); err != nil ||
+			dnsTypeList(types) != dnsTypeList(expected) {
+			fail("%s NSEC: types are '%s', expected '%s'", name,
+				dnsTypeList(types), dnsTypeList(expected))
+		}
+	}
+
+	for _, name := range zone.Names() {
+		if !chain[name] && len(zone.RRSet(name, DNS_TYPE_NSEC)) > 0 {
+			fail("%s NSEC: not part of the chain", name)
+		}
+	}
+
+	return problems
+}
+
+// check the NSEC3 chain
+func (zone *DNSAuthZone) dnssecVerifyNSEC3() []error {
+
+	problems := make([]error, 0)
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	param := zone.RRSet(zone.Origin, DNS_TYPE_NSEC3PARAM)[0].RData
+	if len(param) < 5 || len(param) != 5+int(param[4]) {
+		fail("%s NSEC3PARAM: invalid record", zone.Origin)
+		return problems
+	}
+	if param[0] != DNSSEC_NSEC3_SHA1 {
+		fail("%s NSEC3PARAM: unsupported hash algorithm %d",
+			zone.Origin, param[0])
+		return problems
+	}
+	iterations := binary.BigEndian.Uint16(param[2:])
+	salt := param[5:]
+
+	// the NSEC3 owner expected for each name in the zone
+	expected := make(map[string]string)
+	for _, name := range zone.dnssecChainNames(true) {
+		expected[dnssecNSEC3Owner(DNSSECHashName(name, salt, iterations),
+			zone.Origin)] = name
+	}
+
+	owners := make([]string, 0, len(expected))
+	for _, name := range zone.Names() {
+		if len(zone.RRSet(name, DNS_TYPE_NSEC3)) > 0 {
+			owners = append(owners, name)
+		}
+	}
+
+	for ix, owner := range owners {
+		rdata := zone.RRSet(owner, DNS_TYPE_NSEC3)[0].RData
+		if len(rdata) < len(param) || !bytes.Equal(rdata[4:len(param)], param[4:]) ||
+			rdata[0] != param[0] || binary.BigEndian.Uint16(rdata[2:]) != iterations {
+			fail("%s NSEC3: parameters do not match NSEC3PARAM", owner)
+			continue
+		}
+		offset := len(param)
+		if offset >= len(rdata) || offset+1+int(rdata[offset]) > len(rdata) {
+			fail("%s NSEC3: invalid record", owner)
+			continue
+		}
+		next := rdata[offset+1 : offset+1+int(rdata[offset])]
+		offset += 1 + len(next)
+
+		if n := dnssecNSEC3Owner(next, zone.Origin); n != owners[(ix+1)%len(owners)] {
+			fail("%s NSEC3: next hash is %s, expected %s", owner,
+				strings.ToUpper(DNSBase32Hex(next)),
+				strings.ToUpper(strings.Split(owners[(ix+1)%len(owners)], ".")[0]))
+		}
+
+		name, ok := expected[owner]
+		if !ok {
+			fail("%s NSEC3: does not match any name in the zone", owner)
+			continue
+		}
+		delete(expected, owner)
+
+		types, err := dnsReadTypeBitmap(rdata[offset:])
+		if want := zone.dnssecTypes(name, true); err != nil ||
+			dnsTypeList(types) != dnsTypeList(want) {
+			fail("%s NSEC3 (%s): types are '%s', expected '%s'", owner, name,
+				dnsTypeList(types), dnsTypeList(want))
+		}
+	}
+
+	for owner, name := range expected {
+		fail("%s: no NSEC3 record (%s)", name, owner)
+	}
+
+	return problems
+}
+
+//////////////////////////////////////////////////////////////////////////
+// transfer a zone from a server and verify it, then exit
+
+func VerifyDNSSECServer(server string) {
+
+	zone, err := dnssecTransfer(server, ".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DNSSEC verification FAILED: %s\n", err)
+		os.Exit(1)
+	}
+
+	problems := DNSSECVerifyZone(zone, time.Now())
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "DNSSEC verification FAILED: %d problems\n",
+			len(problems))
+		os.Exit(1)
+	}
+
+	fmt.Printf("DNSSEC zone OK: %s serial %d, %d records, %d signatures\n",
+		zone.Origin, zone.Serial, len(zone.Records()), zone.dnssecSignatures())
+}
+
+// return the number of signatures in the zone
+func (zone *DNSAuthZone) dnssecSignatures() int {
+	count := 0
+	for name := range zone.rrsets {
+		count += len(zone.RRSet(name, DNS_TYPE_RRSIG))
+	}
+	return count
+}
+
+// transfer a zone using AXFR
+func dnssecTransfer(server string, origin string) (*DNSAuthZone, error) {
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	conn, err := net.DialTimeout("tcp", server, DNSSEC_AXFR_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DNSSEC_AXFR_TIMEOUT))
+
+	request := &DNSMessage{
+		ID: uint16(time.Now().UnixNano()),
+		Question: []*DNSQuestion{{
+			Name:  origin,
+			Type:  DNS_TYPE_AXFR,
+			Class: DNS_CLASS_IN,
+		}},
+	}
+	if err := dnsWriteTCP(conn, request.Pack()); err != nil {
+		return nil, err
+	}
+
+	var zone *DNSAuthZone
+	for soas := 0; soas < 2; {
+		data, err := dnsReadTCP(conn)
+		if err != nil {
+			return nil, err
+		}
+		response, err := UnpackDNSMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		if response.RCode != DNS_RCODE_NOERROR {
+			return nil, fmt.Errorf("zone transfer failed with rcode %d",
+				response.RCode)
+		}
+
+		for _, rr := range response.Answer {
+			rr.Name = strings.ToLower(rr.Name)
+			if rr.Type == DNS_TYPE_SOA && rr.Name == origin {
+				if zone == nil {
+					zone = newDNSAuthZone(origin, DNSSOASerial(rr.RData))
+				}
+				soas++
+			}
+			if zone == nil {
+				return nil, errors.New("zone transfer did not start with a SOA")
+			}
+			zone.Add(rr)
+		}
+	}
+
+	return zone, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,493 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/binary"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// built-in authoritative DNS server
+//
+// Serves the generated zones over UDP and TCP. Zone transfers (AXFR)
+// are only permitted to the configured secondaries, and each secondary
+// is sent a NOTIFY (RFC 1996) when a zone's serial changes.
+
+const (
+	DNS_UDP_SIZE       = 1232
+	DNS_TCP_TIMEOUT    = 10 * time.Second
+	DNS_AXFR_MSG_SIZE  = 16384
+	DNS_NOTIFY_TIMEOUT = 2 * time.Second
+	DNS_NOTIFY_RETRIES = 3
+)
+
+type DNSServer struct {
+	Address     string
+	Secondaries []string
+
+	allowed map[string]bool
+	mutex   sync.RWMutex
+	zones   map[string]*DNSAuthZone
+}
+
+// the server, nil if not enabled
+var DNSAuthServer *DNSServer
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to start the server
+
+func InitialiseDNSServer(address string, secondaries []string) {
+
+	// an empty address disables the server
+	if address == "" {
+		return
+	}
+
+	server := &DNSServer{
+		Address: address,
+		allowed: make(map[string]bool),
+		zones:   make(map[string]*DNSAuthZone),
+	}
+
+	// secondaries may be given with or without a port
+	for _, secondary := range secondaries {
+		host, port, err := net.SplitHostPort(secondary)
+		if err != nil {
+			host, port = secondary, "53"
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			log.WithFields(log.Fields{
+				"secondary": secondary,
+			}).Fatal("DNS secondary must be an IP address")
+		}
+		server.allowed[ip.String()] = true
+		server.Secondaries = append(server.Secondaries,
+			net.JoinHostPort(ip.String(), port))
+	}
+
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": address,
+		}).Fatal("Unable to start DNS UDP listener")
+	}
+
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": address,
+		}).Fatal("Unable to start DNS TCP listener")
+	}
+
+	go server.serveUDP(udp)
+	go server.serveTCP(tcp)
+
+	DNSAuthServer = server
+
+	log.WithFields(log.Fields{
+		"address":     address,
+		"secondaries": server.Secondaries,
+	}).Info("DNS server started")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// swap in new zone data, and notify secondaries of changes
+
+func (server *DNSServer) Update(zones ...*DNSAuthZone) {
+
+	changed := make([]*DNSAuthZone, 0)
+
+	server.mutex.Lock()
+	for _, zone := range zones {
+		previous := server.zones[zone.Origin]
+		if previous == nil || previous.Serial != zone.Serial {
+			changed = append(changed, zone)
+		}
+		server.zones[zone.Origin] = zone
+	}
+	server.mutex.Unlock()
+
+	for _, zone := range changed {
+		log.WithFields(log.Fields{
+			"zone":   zone.Origin,
+			"serial": zone.Serial,
+		}).Debug("DNS zone updated")
+
+		for _, secondary := range server.Secondaries {
+			go server.notify(secondary, zone)
+		}
+	}
+}
+
+// return the zone that contains a name
+func (server *DNSServer) findZone(name string) *DNSAuthZone {
+
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	for {
+		if zone := server.zones[name]; zone != nil {
+			return zone
+		}
+		if name == "." {
+			return nil
+		}
+		name = DNSParent(name)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// listeners
+
+func (server *DNSServer) serveUDP(conn net.PacketConn) {
+
+	for {
+		buffer := make([]byte, 4096)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("DNS UDP read failed")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		go func() {
+			request, err := UnpackDNSMessage(buffer[:n])
+			if err != nil {
+				return
+			}
+
+			remote := addr.(*net.UDPAddr).IP
+			responses := server.handle(request, remote, false)
+			if len(responses) == 1 {
+				conn.WriteTo(server.truncate(request, responses[0]), addr)
+			}
+		}()
+	}
+}
+
+func (server *DNSServer) serveTCP(listener net.Listener) {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("DNS TCP accept failed")
+			time.Sleep(time.Second)
+			continue
+		}
+		go server.serveConn(conn)
+	}
+}
+
+// handle messages on a single TCP connection
+func (server *DNSServer) serveConn(conn net.Conn) {
+
+	defer conn.Close()
+	remote := conn.RemoteAddr().(*net.TCPAddr).IP
+
+	for {
+		conn.SetDeadline(time.Now().Add(DNS_TCP_TIMEOUT))
+
+		data, err := dnsReadTCP(conn)
+		if err != nil {
+			return
+		}
+
+		request, err := UnpackDNSMessage(data)
+		if err != nil {
+			return
+		}
+
+		for _, response := range server.handle(request, remote, true) {
+			if err := dnsWriteTCP(conn, response.Pack()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// read a length prefixed message
+func dnsReadTCP(conn io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err := io.ReadFull(conn, data)
+	return data, err
+}
+
+// write a length prefixed message
+func dnsWriteTCP(conn io.Writer, data []byte) error {
+	if len(data) > 0xFFFF {
+		return errors.New("DNS message too large")
+	}
+	_, err := conn.Write(append(dnsAppendUint16(nil, uint16(len(data))),
+		data...))
+	return err
+}
+
+// pack a UDP response, truncating if it exceeds the client's buffer
+func (server *DNSServer) truncate(request *DNSMessage,
+	response *DNSMessage) []byte {
+
+	limit := 512
+	if opt := request.OPT(); opt != nil && int(opt.Class) > limit {
+		limit = int(opt.Class)
+	}
+	if limit > DNS_UDP_SIZE {
+		limit = DNS_UDP_SIZE
+	}
+
+	data := response.Pack()
+	if len(data) <= limit {
+		return data
+	}
+
+	response.Truncated = true
+	response.Answer = nil
+	response.Authority = nil
+	if opt := response.OPT(); opt != nil {
+		response.Additional = []*DNSRR{opt}
+	} else {
+		response.Additional = nil
+	}
+	return response.Pack()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// handle a request, returning the responses to send
+
+func (server *DNSServer) handle(request *DNSMessage, remote net.IP,
+	tcp bool) []*DNSMessage {
+
+	// never respond to responses
+	if request.Response {
+		return nil
+	}
+
+	response := &DNSMessage{
+		ID:               request.ID,
+		Response:         true,
+		Opcode:           request.Opcode,
+		RecursionDesired: request.RecursionDesired,
+		Question:         request.Question,
+	}
+
+	// echo EDNS support
+	opt := request.OPT()
+	do := false
+	if opt != nil {
+		do = opt.TTL&(1<<15) != 0
+	}
+	finish := func() []*DNSMessage {
+		if opt != nil {
+			response.Additional = append(response.Additional,
+				NewDNSOPT(DNS_UDP_SIZE, do))
+		}
+		return []*DNSMessage{response}
+	}
+
+	if request.Opcode != DNS_OPCODE_QUERY {
+		response.RCode = DNS_RCODE_NOTIMP
+		return finish()
+	}
+
+	if len(request.Question) != 1 {
+		response.RCode = DNS_RCODE_FORMERR
+		return finish()
+	}
+
+	q := request.Question[0]
+	if q.Class != DNS_CLASS_IN && q.Class != DNS_CLASS_ANY {
+		response.RCode = DNS_RCODE_REFUSED
+		return finish()
+	}
+
+	// the question is echoed with the case it was sent in
+	qname := strings.ToLower(q.Name)
+
+	zone := server.findZone(qname)
+	if zone == nil {
+		response.RCode = DNS_RCODE_REFUSED
+		return finish()
+	}
+
+	if q.Type == DNS_TYPE_AXFR || q.Type == DNS_TYPE_IXFR {
+		if !tcp || !server.allowed[remote.String()] || qname != zone.Origin {
+			log.WithFields(log.Fields{
+				"remote": remote.String(),
+				"zone":   q.Name,
+			}).Warn("Refused DNS zone transfer")
+			response.RCode = DNS_RCODE_REFUSED
+			return finish()
+		}
+		return server.transfer(response, zone)
+	}
+
+	zone.Answer(response, qname, q.Type, do)
+
+	// answers owned by the query name also use its case
+	if q.Name != qname {
+		for ix, rr := range response.Answer {
+			if rr.Name == qname {
+				answer := *rr
+				answer.Name = q.Name
+				response.Answer[ix] = &answer
+			}
+		}
+	}
+
+	return finish()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the messages for a zone transfer, IXFR requests always
+// receive a full transfer (RFC 1995 section 4)
+
+func (server *DNSServer) transfer(template *DNSMessage,
+	zone *DNSAuthZone) []*DNSMessage {
+
+	records := append(zone.Records(), zone.SOA())
+
+	log.WithFields(log.Fields{
+		"zone":    zone.Origin,
+		"serial":  zone.Serial,
+		"records": len(records),
+	}).Info("DNS zone transfer")
+
+	messages := make([]*DNSMessage, 0)
+	var current *DNSMessage
+	size := 0
+
+	for _, rr := range records {
+		rrsize := len(rr.Name) + 12 + len(rr.RData)
+		if current == nil || size+rrsize > DNS_AXFR_MSG_SIZE {
+			current = &DNSMessage{
+				ID:            template.ID,
+				Response:      true,
+				Authoritative: true,
+				Question:      template.Question,
+			}
+			messages = append(messages, current)
+			size = 0
+		}
+		current.Answer = append(current.Answer, rr)
+		size += rrsize
+	}
+
+	return messages
+}
+
+//////////////////////////////////////////////////////////////////////////
+// send a NOTIFY to a secondary
+
+func (server *DNSServer) notify(secondary string, zone *DNSAuthZone) {
+
+	request := &DNSMessage{
+		ID:            uint16(rand.Intn(0x10000)),
+		Opcode:        DNS_OPCODE_NOTIFY,
+		Authoritative: true,
+		Question: []*DNSQuestion{{
+			Name:  zone.Origin,
+			Type:  DNS_TYPE_SOA,
+			Class: DNS_CLASS_IN,
+		}},
+		Answer: []*DNSRR{zone.SOA()},
+	}
+
+	err := dnsExchangeUDP(secondary, request, func(response *DNSMessage) error {
+		if response.Opcode != DNS_OPCODE_NOTIFY {
+			return errors.New("unexpected opcode in NOTIFY response")
+		}
+		if response.RCode != DNS_RCODE_NOERROR {
+			return errors.New("NOTIFY failed with rcode " +
+				dnsRCodeString(response.RCode))
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"secondary": secondary,
+			"zone":      zone.Origin,
+			"error":     err,
+		}).Warn("DNS NOTIFY failed")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"secondary": secondary,
+		"zone":      zone.Origin,
+		"serial":    zone.Serial,
+	}).Debug("DNS NOTIFY acknowledged")
+}
+
+// send a request over UDP, retrying until a matching response is checked
+func dnsExchangeUDP(address string, request *DNSMessage,
+	check func(*DNSMessage) error) error {
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	data := request.Pack()
+	buffer := make([]byte, 4096)
+
+	for attempt := 0; attempt < DNS_NOTIFY_RETRIES; attempt++ {
+
+		if _, err = conn.Write(data); err != nil {
+			continue
+		}
+
+		conn.SetReadDeadline(time.Now().Add(DNS_NOTIFY_TIMEOUT))
+		for {
+			var n int
+			if n, err = conn.Read(buffer); err != nil {
+				break
+			}
+			response, perr := UnpackDNSMessage(buffer[:n])
+			if perr != nil || !response.Response || response.ID != request.ID {
+				continue
+			}
+			return check(response)
+		}
+	}
+
+	return err
+}
+
+// return a name for an rcode
+func dnsRCodeString(rcode uint8) string {
+	names := []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN",
+		"NOTIMP", "REFUSED", "YXDOMAIN", "YXRRSET", "NXRRSET",
+		"NOTAUTH", "NOTZONE"}
+	if int(rcode) < len(names) {
+		return names[rcode]
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
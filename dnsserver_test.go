@@ -0,0 +1,88 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestDNSServerQueryCase(t *testing.T) {
+
+	server := &DNSServer{
+		allowed: make(map[string]bool),
+		zones:   make(map[string]*DNSAuthZone),
+	}
+	server.Update(testPushZone(t, 1,
+		"foo.dn42 3600 A 172.20.0.1",
+		"www.foo.dn42 3600 CNAME foo.dn42.",
+	))
+
+	tests := []struct {
+		qname  string
+		qtype  uint16
+		answer []string // owner names in the answer
+	}{
+		{"foo.dn42.", DNS_TYPE_A, []string{"foo.dn42."}},
+		// names are matched without case, and the case of the query is
+		// kept in the question and the answers it owns
+		{"FoO.dN42.", DNS_TYPE_A, []string{"FoO.dN42."}},
+		{"wWw.Foo.Dn42.", DNS_TYPE_A, []string{"wWw.Foo.Dn42.", "foo.dn42."}},
+		{"NONE.dn42.", DNS_TYPE_A, nil},
+	}
+
+	for _, test := range tests {
+		request := &DNSMessage{
+			ID: 1234,
+			Question: []*DNSQuestion{{
+				Name:  test.qname,
+				Type:  test.qtype,
+				Class: DNS_CLASS_IN,
+			}},
+		}
+
+		// the request and response go through the wire format
+		unpacked, err := UnpackDNSMessage(request.Pack())
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses := server.handle(unpacked, net.ParseIP("192.0.2.1"), false)
+		if len(responses) != 1 {
+			t.Fatalf("%s: %d responses", test.qname, len(responses))
+		}
+		response, err := UnpackDNSMessage(responses[0].Pack())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(response.Question) != 1 ||
+			response.Question[0].Name != test.qname {
+			t.Errorf("%s: unexpected question %+v", test.qname,
+				response.Question[0])
+		}
+
+		if len(response.Answer) != len(test.answer) {
+			t.Errorf("%s: %d answers, expected %d", test.qname,
+				len(response.Answer), len(test.answer))
+			continue
+		}
+		for ix, rr := range response.Answer {
+			if rr.Name != test.answer[ix] {
+				t.Errorf("%s: answer owned by %s, expected %s", test.qname,
+					rr.Name, test.answer[ix])
+			}
+		}
+		if test.answer == nil && response.RCode != DNS_RCODE_NXDOMAIN {
+			t.Errorf("%s: rcode %d", test.qname, response.RCode)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
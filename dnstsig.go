@@ -0,0 +1,209 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// TSIG (RFC 8945)
+//
+// signs requests and verifies responses using a shared secret. Keys are
+// given in the same form as 'dig -y', [algorithm:]name:secret, where the
+// secret is base64 encoded and the algorithm defaults to hmac-sha256.
+
+const (
+	DNS_TYPE_TSIG    = 250
+	DNS_TSIG_FUDGE   = 300
+	DNS_TSIG_BADSIG  = 16
+	DNS_TSIG_BADKEY  = 17
+	DNS_TSIG_BADTIME = 18
+)
+
+type DNSTSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+var dnsTSIGAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1.":   sha1.New,
+	"hmac-sha256.": sha256.New,
+	"hmac-sha512.": sha512.New,
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse a key
+
+func ParseDNSTSIGKey(s string) (*DNSTSIGKey, error) {
+
+	fields := strings.Split(s, ":")
+	if len(fields) == 2 {
+		fields = append([]string{"hmac-sha256"}, fields...)
+	}
+	if len(fields) != 3 {
+		return nil, errors.New("TSIG key must be [algorithm:]name:secret")
+	}
+
+	key := &DNSTSIGKey{
+		Name:      DNSFQDN(fields[1]),
+		Algorithm: DNSFQDN(fields[0]),
+	}
+	if dnsTSIGAlgorithms[key.Algorithm] == nil {
+		return nil, fmt.Errorf("unsupported TSIG algorithm '%s'", fields[0])
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("TSIG secret is not valid base64: %s", err)
+	}
+	key.Secret = secret
+
+	return key, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// calculate a MAC
+//
+// the MAC covers any request MAC, the message without the TSIG record
+// and the TSIG variables
+
+func (key *DNSTSIGKey) mac(requestMAC []byte, msg []byte, signed uint64,
+	fudge uint16, tsigError uint16, other []byte) []byte {
+
+	h := hmac.New(dnsTSIGAlgorithms[key.Algorithm], key.Secret)
+
+	if requestMAC != nil {
+		h.Write(dnsAppendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(msg)
+
+	vars := dnsAppendName(nil, key.Name)
+	vars = dnsAppendUint16(vars, DNS_CLASS_ANY)
+	vars = dnsAppendUint32(vars, 0)
+	vars = dnsAppendName(vars, key.Algorithm)
+	vars = dnsAppendUint16(vars, uint16(signed>>32))
+	vars = dnsAppendUint32(vars, uint32(signed))
+	vars = dnsAppendUint16(vars, fudge, tsigError, uint16(len(other)))
+	vars = append(vars, other...)
+	h.Write(vars)
+
+	return h.Sum(nil)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// sign a packed message, returning the signed message and the MAC
+
+func (key *DNSTSIGKey) Sign(msg []byte, now time.Time) ([]byte, []byte) {
+
+	signed := uint64(now.Unix())
+	mac := key.mac(nil, msg, signed, DNS_TSIG_FUDGE, 0, nil)
+
+	rdata := dnsAppendName(nil, key.Algorithm)
+	rdata = dnsAppendUint16(rdata, uint16(signed>>32))
+	rdata = dnsAppendUint32(rdata, uint32(signed))
+	rdata = dnsAppendUint16(rdata, DNS_TSIG_FUDGE, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = dnsAppendUint16(rdata, binary.BigEndian.Uint16(msg[0:]), 0, 0)
+
+	// the TSIG record is always last, and is never compressed
+	out := append([]byte(nil), msg...)
+	out = dnsAppendName(out, key.Name)
+	out = dnsAppendUint16(out, DNS_TYPE_TSIG, DNS_CLASS_ANY)
+	out = dnsAppendUint32(out, 0)
+	out = dnsAppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:],
+		binary.BigEndian.Uint16(out[10:])+1)
+
+	return out, mac
+}
+
+//////////////////////////////////////////////////////////////////////////
+// verify a packed response to a signed request
+
+func (key *DNSTSIGKey) Verify(msg []byte, requestMAC []byte,
+	now time.Time) error {
+
+	m, err := UnpackDNSMessage(msg)
+	if err != nil {
+		return err
+	}
+	if len(m.Additional) == 0 ||
+		m.Additional[len(m.Additional)-1].Type != DNS_TYPE_TSIG {
+		return errors.New("response is not signed")
+	}
+	tsig := m.Additional[len(m.Additional)-1]
+	if strings.ToLower(tsig.Name) != key.Name {
+		return fmt.Errorf("response signed with unknown key '%s'", tsig.Name)
+	}
+
+	// parse the TSIG RDATA
+	rdata := tsig.RData
+	algorithm, offset, err := dnsReadName(rdata, 0)
+	if err != nil || offset+10 > len(rdata) {
+		return errors.New("invalid TSIG record")
+	}
+	signed := uint64(binary.BigEndian.Uint16(rdata[offset:]))<<32 |
+		uint64(binary.BigEndian.Uint32(rdata[offset+2:]))
+	fudge := binary.BigEndian.Uint16(rdata[offset+6:])
+	size := int(binary.BigEndian.Uint16(rdata[offset+8:]))
+	offset += 10
+	if offset+size+6 > len(rdata) {
+		return errors.New("invalid TSIG record")
+	}
+	mac := rdata[offset : offset+size]
+	offset += size
+	originalID := binary.BigEndian.Uint16(rdata[offset:])
+	tsigError := binary.BigEndian.Uint16(rdata[offset+2:])
+	olen := int(binary.BigEndian.Uint16(rdata[offset+4:]))
+	if offset+6+olen > len(rdata) {
+		return errors.New("invalid TSIG record")
+	}
+	other := rdata[offset+6 : offset+6+olen]
+
+	if algorithm != key.Algorithm {
+		return fmt.Errorf("response signed with algorithm '%s'", algorithm)
+	}
+	if tsigError != 0 {
+		return fmt.Errorf("TSIG error %d", tsigError)
+	}
+
+	// remove the TSIG record, and restore the original ID
+	length := len(dnsAppendName(nil, tsig.Name)) + 10 + len(tsig.RData)
+	unsigned := append([]byte(nil), msg[:len(msg)-length]...)
+	binary.BigEndian.PutUint16(unsigned[0:], originalID)
+	binary.BigEndian.PutUint16(unsigned[10:],
+		binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	expected := key.mac(requestMAC, unsigned, signed, fudge, tsigError, other)
+	if !hmac.Equal(mac, expected) {
+		return errors.New("TSIG signature does not match")
+	}
+
+	delta := int64(signed) - now.Unix()
+	if delta < -int64(fudge) || delta > int64(fudge) {
+		return errors.New("TSIG time is outside the allowed fudge")
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,864 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// DNS wire format (RFC 1035)
+//
+// A minimal implementation of DNS messages, sufficient for an
+// authoritative server and for sending NOTIFY messages. Names are
+// always held fully qualified and lower case, and names within RDATA
+// are never compressed.
+
+const (
+	DNS_TYPE_A          = 1
+	DNS_TYPE_NS         = 2
+	DNS_TYPE_CNAME      = 5
+	DNS_TYPE_SOA        = 6
+	DNS_TYPE_PTR        = 12
+	DNS_TYPE_TXT        = 16
+	DNS_TYPE_AAAA       = 28
+	DNS_TYPE_OPT        = 41
+	DNS_TYPE_DS         = 43
+	DNS_TYPE_RRSIG      = 46
+	DNS_TYPE_NSEC       = 47
+	DNS_TYPE_DNSKEY     = 48
+	DNS_TYPE_NSEC3      = 50
+	DNS_TYPE_NSEC3PARAM = 51
+	DNS_TYPE_IXFR       = 251
+	DNS_TYPE_AXFR       = 252
+	DNS_TYPE_ANY        = 255
+	DNS_CLASS_IN        = 1
+	DNS_CLASS_NONE      = 254
+	DNS_CLASS_ANY       = 255
+	DNS_OPCODE_QUERY    = 0
+	DNS_OPCODE_NOTIFY   = 4
+	DNS_OPCODE_UPDATE   = 5
+	DNS_RCODE_NOERROR   = 0
+	DNS_RCODE_FORMERR   = 1
+	DNS_RCODE_SERVFAIL  = 2
+	DNS_RCODE_NXDOMAIN  = 3
+	DNS_RCODE_NOTIMP    = 4
+	DNS_RCODE_REFUSED   = 5
+	DNS_RCODE_NOTAUTH   = 9
+)
+
+// mnemonics for the supported types
+var DNSTypeNames = map[uint16]string{
+	DNS_TYPE_A:          "A",
+	DNS_TYPE_NS:         "NS",
+	DNS_TYPE_CNAME:      "CNAME",
+	DNS_TYPE_SOA:        "SOA",
+	DNS_TYPE_PTR:        "PTR",
+	DNS_TYPE_TXT:        "TXT",
+	DNS_TYPE_AAAA:       "AAAA",
+	DNS_TYPE_OPT:        "OPT",
+	DNS_TYPE_DS:         "DS",
+	DNS_TYPE_RRSIG:      "RRSIG",
+	DNS_TYPE_NSEC:       "NSEC",
+	DNS_TYPE_DNSKEY:     "DNSKEY",
+	DNS_TYPE_NSEC3:      "NSEC3",
+	DNS_TYPE_NSEC3PARAM: "NSEC3PARAM",
+	DNS_TYPE_IXFR:       "IXFR",
+	DNS_TYPE_AXFR:       "AXFR",
+	DNS_TYPE_ANY:        "ANY",
+}
+
+// return the mnemonic for a type
+func DNSTypeString(t uint16) string {
+	if name, ok := DNSTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// return the type for a mnemonic
+func DNSTypeFromString(s string) (uint16, bool) {
+	s = strings.ToUpper(s)
+	for t, name := range DNSTypeNames {
+		if name == s {
+			return t, true
+		}
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		if t, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return uint16(t), true
+		}
+	}
+	return 0, false
+}
+
+//////////////////////////////////////////////////////////////////////////
+// data model
+
+type DNSQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+type DNSRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	RData []byte
+}
+
+type DNSMessage struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	AuthenticData      bool
+	CheckingDisabled   bool
+	RCode              uint8
+	Question           []*DNSQuestion
+	Answer             []*DNSRR
+	Authority          []*DNSRR
+	Additional         []*DNSRR
+}
+
+//////////////////////////////////////////////////////////////////////////
+// name utility functions
+
+// return a name in canonical form, fully qualified and lower case
+func DNSFQDN(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// return true if name is equal to, or below, the parent
+func DNSIsSubdomain(name string, parent string) bool {
+	if parent == "." || name == parent {
+		return true
+	}
+	return strings.HasSuffix(name, "."+parent)
+}
+
+// return the parent of a name, the parent of the root is the root
+func DNSParent(name string) string {
+	if name == "." {
+		return name
+	}
+	ix := strings.IndexByte(name, '.')
+	if ix == len(name)-1 {
+		return "."
+	}
+	return name[ix+1:]
+}
+
+// return the number of labels in a name
+func DNSLabelCount(name string) int {
+	if name == "." {
+		return 0
+	}
+	return strings.Count(name, ".")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// packing
+
+// append an uncompressed name
+func dnsAppendName(b []byte, name string) []byte {
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// append a name, compressed using previously written names
+func dnsAppendCompressedName(b []byte, name string,
+	offsets map[string]int) []byte {
+
+	for name != "." {
+		if offset, ok := offsets[name]; ok {
+			return append(b, 0xC0|byte(offset>>8), byte(offset))
+		}
+		if len(b) < 0x3FFF {
+			offsets[name] = len(b)
+		}
+		ix := strings.IndexByte(name, '.')
+		b = append(b, byte(ix))
+		b = append(b, name[:ix]...)
+		name = DNSParent(name)
+	}
+	return append(b, 0)
+}
+
+// pack the message in to wire format
+func (m *DNSMessage) Pack() []byte {
+
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+
+	var flags uint16
+	if m.Response {
+		flags |= 1 << 15
+	}
+	flags |= uint16(m.Opcode&0x0F) << 11
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	if m.Truncated {
+		flags |= 1 << 9
+	}
+	if m.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if m.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	if m.AuthenticData {
+		flags |= 1 << 5
+	}
+	if m.CheckingDisabled {
+		flags |= 1 << 4
+	}
+	flags |= uint16(m.RCode & 0x0F)
+	binary.BigEndian.PutUint16(b[2:], flags)
+
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Question)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answer)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+
+	offsets := make(map[string]int)
+	for _, q := range m.Question {
+		b = dnsAppendCompressedName(b, q.Name, offsets)
+		b = dnsAppendUint16(b, q.Type, q.Class)
+	}
+
+	for _, section := range [][]*DNSRR{m.Answer, m.Authority, m.Additional} {
+		for _, rr := range section {
+			b = rr.appendTo(b, offsets)
+		}
+	}
+
+	return b
+}
+
+// append a resource record, compressing the owner name
+func (rr *DNSRR) appendTo(b []byte, offsets map[string]int) []byte {
+	b = dnsAppendCompressedName(b, rr.Name, offsets)
+	b = dnsAppendUint16(b, rr.Type, rr.Class)
+	b = dnsAppendUint32(b, rr.TTL)
+	b = dnsAppendUint16(b, uint16(len(rr.RData)))
+	return append(b, rr.RData...)
+}
+
+func dnsAppendUint16(b []byte, values ...uint16) []byte {
+	for _, v := range values {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func dnsAppendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// unpacking
+
+var errDNSShort = errors.New("DNS message is truncated")
+
+// read a possibly compressed name in lower case, returning the offset
+// after the name
+func dnsReadName(msg []byte, offset int) (string, int, error) {
+	name, offset, err := dnsReadWireName(msg, offset)
+	return strings.ToLower(name), offset, err
+}
+
+// read a possibly compressed name, keeping the case used on the wire so
+// that it can be echoed back to resolvers that randomise the case of
+// their queries (draft-vixie-dnsext-dns0x20)
+func dnsReadWireName(msg []byte, offset int) (string, int, error) {
+
+	var labels []string
+	end := -1
+	jumps := 0
+
+	for {
+		if offset >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		l := int(msg[offset])
+
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			if len(labels) == 0 {
+				return ".", end, nil
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+
+		case l&0xC0 == 0xC0:
+			if offset+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			jumps++
+			if jumps > 64 {
+				return "", 0, errors.New("DNS name compression loop")
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+
+		case l&0xC0 != 0:
+			return "", 0, errors.New("unsupported DNS label type")
+
+		default:
+			if offset+1+l > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+l]))
+			offset += 1 + l
+		}
+	}
+}
+
+// unpack a message from wire format
+func UnpackDNSMessage(msg []byte) (*DNSMessage, error) {
+
+	if len(msg) < 12 {
+		return nil, errDNSShort
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m := &DNSMessage{
+		ID:                 binary.BigEndian.Uint16(msg[0:]),
+		Response:           flags&(1<<15) != 0,
+		Opcode:             uint8(flags>>11) & 0x0F,
+		Authoritative:      flags&(1<<10) != 0,
+		Truncated:          flags&(1<<9) != 0,
+		RecursionDesired:   flags&(1<<8) != 0,
+		RecursionAvailable: flags&(1<<7) != 0,
+		AuthenticData:      flags&(1<<5) != 0,
+		CheckingDisabled:   flags&(1<<4) != 0,
+		RCode:              uint8(flags & 0x0F),
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(msg[6:])),
+		int(binary.BigEndian.Uint16(msg[8:])),
+		int(binary.BigEndian.Uint16(msg[10:])),
+	}
+
+	// question and owner names keep their case, lookups must use
+	// lower case names
+	offset := 12
+	for ix := 0; ix < qdcount; ix++ {
+		name, next, err := dnsReadWireName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errDNSShort
+		}
+		m.Question = append(m.Question, &DNSQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		offset = next + 4
+	}
+
+	sections := []*[]*DNSRR{&m.Answer, &m.Authority, &m.Additional}
+	for s, count := range counts {
+		for ix := 0; ix < count; ix++ {
+			name, next, err := dnsReadWireName(msg, offset)
+			if err != nil {
+				return nil, err
+			}
+			if next+10 > len(msg) {
+				return nil, errDNSShort
+			}
+			rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+			if next+10+rdlen > len(msg) {
+				return nil, errDNSShort
+			}
+			rr := &DNSRR{
+				Name:  name,
+				Type:  binary.BigEndian.Uint16(msg[next:]),
+				Class: binary.BigEndian.Uint16(msg[next+2:]),
+				TTL:   binary.BigEndian.Uint32(msg[next+4:]),
+			}
+			if rr.RData, err = dnsExpandRData(msg, next+10, rdlen,
+				rr.Type); err != nil {
+				return nil, err
+			}
+			*sections[s] = append(*sections[s], rr)
+			offset = next + 10 + rdlen
+		}
+	}
+
+	return m, nil
+}
+
+// copy RDATA from a message, expanding any compressed names in the
+// types that permit compression. The RDATA of records deleting an RRset
+// in a dynamic update is empty.
+func dnsExpandRData(msg []byte, offset int, rdlen int,
+	t uint16) ([]byte, error) {
+
+	if rdlen == 0 {
+		return []byte{}, nil
+	}
+
+	end := offset + rdlen
+	names := 0
+	switch t {
+	case DNS_TYPE_NS, DNS_TYPE_CNAME, DNS_TYPE_PTR:
+		names = 1
+	case DNS_TYPE_SOA:
+		names = 2
+	}
+
+	rdata := make([]byte, 0, rdlen)
+	for ; names > 0; names-- {
+		name, next, err := dnsReadName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next > end {
+			return nil, errors.New("DNS name overruns RDATA")
+		}
+		rdata = dnsAppendName(rdata, name)
+		offset = next
+	}
+
+	return append(rdata, msg[offset:end]...), nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// EDNS (RFC 6891)
+
+// return the OPT record from the additional section, if present
+func (m *DNSMessage) OPT() *DNSRR {
+	for _, rr := range m.Additional {
+		if rr.Type == DNS_TYPE_OPT {
+			return rr
+		}
+	}
+	return nil
+}
+
+// create an OPT record
+func NewDNSOPT(size uint16, do bool) *DNSRR {
+	var ttl uint32
+	if do {
+		ttl = 1 << 15
+	}
+	return &DNSRR{Name: ".", Type: DNS_TYPE_OPT, Class: size, TTL: ttl}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RDATA encoding
+
+// encode a SOA record
+func DNSRDataSOA(mname string, rname string, serial uint32,
+	refresh uint32, retry uint32, expire uint32, minimum uint32) []byte {
+	b := dnsAppendName(nil, mname)
+	b = dnsAppendName(b, rname)
+	for _, v := range []uint32{serial, refresh, retry, expire, minimum} {
+		b = dnsAppendUint32(b, v)
+	}
+	return b
+}
+
+// return the serial from SOA rdata
+func DNSSOASerial(rdata []byte) uint32 {
+	_, offset, err := dnsReadName(rdata, 0)
+	if err != nil {
+		return 0
+	}
+	if _, offset, err = dnsReadName(rdata, offset); err != nil ||
+		offset+4 > len(rdata) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(rdata[offset:])
+}
+
+// encode RDATA from its presentation format
+func DNSRDataFromText(t uint16, text string) ([]byte, error) {
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, errors.New("empty RDATA")
+	}
+
+	switch t {
+	case DNS_TYPE_A:
+		ip := net.ParseIP(fields[0]).To4()
+		if ip == nil || strings.Contains(fields[0], ":") {
+			return nil, errors.New("invalid IPv4 address: " + fields[0])
+		}
+		return []byte(ip), nil
+
+	case DNS_TYPE_AAAA:
+		ip := net.ParseIP(fields[0])
+		if ip == nil || !strings.Contains(fields[0], ":") {
+			return nil, errors.New("invalid IPv6 address: " + fields[0])
+		}
+		return []byte(ip.To16()), nil
+
+	case DNS_TYPE_NS, DNS_TYPE_CNAME, DNS_TYPE_PTR:
+		if !dnsValidName(fields[0]) {
+			return nil, errors.New("invalid name: " + fields[0])
+		}
+		return dnsAppendName(nil, DNSFQDN(fields[0])), nil
+
+	case DNS_TYPE_SOA:
+		if len(fields) != 7 {
+			return nil, errors.New("SOA requires 7 fields")
+		}
+		var values [5]uint32
+		for ix := range values {
+			v, err := strconv.ParseUint(fields[ix+2], 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			values[ix] = uint32(v)
+		}
+		return DNSRDataSOA(DNSFQDN(fields[0]), DNSFQDN(fields[1]),
+			values[0], values[1], values[2], values[3], values[4]), nil
+
+	case DNS_TYPE_DS:
+		return dnsRDataDS(fields)
+
+	case DNS_TYPE_DNSKEY:
+		return dnsRDataDNSKEY(fields)
+
+	case DNS_TYPE_TXT:
+		if len(text) > 255 {
+			return nil, errors.New("TXT string too long")
+		}
+		return append([]byte{byte(len(text))}, text...), nil
+	}
+
+	return nil, errors.New("unsupported record type " + DNSTypeString(t))
+}
+
+// encode DS rdata, the digest may be split over several fields
+func dnsRDataDS(fields []string) ([]byte, error) {
+
+	if len(fields) < 4 {
+		return nil, errors.New("DS requires key tag, algorithm, " +
+			"digest type and digest")
+	}
+
+	tag, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid DS key tag: " + fields[0])
+	}
+	alg, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return nil, errors.New("invalid DS algorithm: " + fields[1])
+	}
+	dtype, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil {
+		return nil, errors.New("invalid DS digest type: " + fields[2])
+	}
+	digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		return nil, errors.New("invalid DS digest")
+	}
+
+	b := dnsAppendUint16(nil, uint16(tag))
+	b = append(b, byte(alg), byte(dtype))
+	return append(b, digest...), nil
+}
+
+// encode DNSKEY rdata, the key may be split over several fields
+func dnsRDataDNSKEY(fields []string) ([]byte, error) {
+
+	if len(fields) < 4 {
+		return nil, errors.New("DNSKEY requires flags, protocol, " +
+			"algorithm and key")
+	}
+
+	flags, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid DNSKEY flags: " + fields[0])
+	}
+	protocol, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return nil, errors.New("invalid DNSKEY protocol: " + fields[1])
+	}
+	alg, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil {
+		return nil, errors.New("invalid DNSKEY algorithm: " + fields[2])
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil {
+		return nil, errors.New("invalid DNSKEY public key")
+	}
+
+	b := dnsAppendUint16(nil, uint16(flags))
+	b = append(b, byte(protocol), byte(alg))
+	return append(b, key...), nil
+}
+
+// return true if a name is syntactically valid as a host name
+func dnsValidName(name string) bool {
+
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+				c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// NSEC and NSEC3 type bitmaps (RFC 4034 section 4.1.2)
+
+// encode a set of types as a bitmap
+func dnsTypeBitmap(types []uint16) []byte {
+
+	sorted := append([]uint16(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	b := make([]byte, 0)
+	var window []byte
+	current := -1
+
+	flush := func() {
+		if window != nil {
+			b = append(b, byte(current), byte(len(window)))
+			b = append(b, window...)
+		}
+	}
+
+	for _, t := range sorted {
+		if int(t>>8) != current {
+			flush()
+			current = int(t >> 8)
+			window = make([]byte, 0, 32)
+		}
+		octet := int(t&0xFF) / 8
+		for len(window) <= octet {
+			window = append(window, 0)
+		}
+		window[octet] |= 0x80 >> (t & 7)
+	}
+	flush()
+
+	return b
+}
+
+// decode a type bitmap
+func dnsReadTypeBitmap(b []byte) ([]uint16, error) {
+
+	types := make([]uint16, 0)
+	for offset := 0; offset < len(b); {
+		if offset+2 > len(b) {
+			return nil, errors.New("truncated type bitmap")
+		}
+		window, length := int(b[offset]), int(b[offset+1])
+		if length == 0 || length > 32 || offset+2+length > len(b) {
+			return nil, errors.New("invalid type bitmap")
+		}
+		for ix, octet := range b[offset+2 : offset+2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(0x80>>bit) != 0 {
+					types = append(types, uint16(window<<8|ix*8+bit))
+				}
+			}
+		}
+		offset += 2 + length
+	}
+	return types, nil
+}
+
+// encode a hash in base32 with the extended hex alphabet (RFC 4648),
+// as used for NSEC3 owner names
+func DNSBase32Hex(b []byte) string {
+	return strings.ToLower(
+		base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RDATA decoding
+
+// return the presentation format of RDATA
+func DNSRDataToText(t uint16, rdata []byte) string {
+
+	switch t {
+	case DNS_TYPE_A, DNS_TYPE_AAAA:
+		return net.IP(rdata).String()
+
+	case DNS_TYPE_NS, DNS_TYPE_CNAME, DNS_TYPE_PTR:
+		if name, _, err := dnsReadName(rdata, 0); err == nil {
+			return name
+		}
+
+	case DNS_TYPE_SOA:
+		mname, offset, err := dnsReadName(rdata, 0)
+		if err != nil {
+			break
+		}
+		rname, offset, err := dnsReadName(rdata, offset)
+		if err != nil || offset+20 != len(rdata) {
+			break
+		}
+		return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname,
+			binary.BigEndian.Uint32(rdata[offset:]),
+			binary.BigEndian.Uint32(rdata[offset+4:]),
+			binary.BigEndian.Uint32(rdata[offset+8:]),
+			binary.BigEndian.Uint32(rdata[offset+12:]),
+			binary.BigEndian.Uint32(rdata[offset+16:]))
+
+	case DNS_TYPE_DS:
+		if len(rdata) < 5 {
+			break
+		}
+		return fmt.Sprintf("%d %d %d %s",
+			binary.BigEndian.Uint16(rdata), rdata[2], rdata[3],
+			strings.ToUpper(hex.EncodeToString(rdata[4:])))
+
+	case DNS_TYPE_DNSKEY:
+		if len(rdata) < 4 {
+			break
+		}
+		return fmt.Sprintf("%d %d %d %s",
+			binary.BigEndian.Uint16(rdata), rdata[2], rdata[3],
+			base64.StdEncoding.EncodeToString(rdata[4:]))
+
+	case DNS_TYPE_RRSIG:
+		if len(rdata) < 18 {
+			break
+		}
+		signer, offset, err := dnsReadName(rdata, 18)
+		if err != nil {
+			break
+		}
+		return fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+			DNSTypeString(binary.BigEndian.Uint16(rdata)), rdata[2], rdata[3],
+			binary.BigEndian.Uint32(rdata[4:]),
+			dnsTimeString(binary.BigEndian.Uint32(rdata[8:])),
+			dnsTimeString(binary.BigEndian.Uint32(rdata[12:])),
+			binary.BigEndian.Uint16(rdata[16:]), signer,
+			base64.StdEncoding.EncodeToString(rdata[offset:]))
+
+	case DNS_TYPE_NSEC:
+		next, offset, err := dnsReadName(rdata, 0)
+		if err != nil {
+			break
+		}
+		types, err := dnsReadTypeBitmap(rdata[offset:])
+		if err != nil {
+			break
+		}
+		return strings.TrimSpace(next + " " + dnsTypeList(types))
+
+	case DNS_TYPE_NSEC3, DNS_TYPE_NSEC3PARAM:
+		if len(rdata) < 5 || len(rdata) < 5+int(rdata[4]) {
+			break
+		}
+		offset := 5 + int(rdata[4])
+		text := fmt.Sprintf("%d %d %d %s", rdata[0], rdata[1],
+			binary.BigEndian.Uint16(rdata[2:]), dnsSaltString(rdata[5:offset]))
+		if t == DNS_TYPE_NSEC3PARAM {
+			return text
+		}
+		if offset >= len(rdata) || offset+1+int(rdata[offset]) > len(rdata) {
+			break
+		}
+		next := rdata[offset+1 : offset+1+int(rdata[offset])]
+		types, err := dnsReadTypeBitmap(rdata[offset+1+len(next):])
+		if err != nil {
+			break
+		}
+		return strings.TrimSpace(text + " " +
+			strings.ToUpper(DNSBase32Hex(next)) + " " + dnsTypeList(types))
+
+	case DNS_TYPE_TXT:
+		var parts []string
+		for offset := 0; offset < len(rdata); {
+			l := int(rdata[offset])
+			if offset+1+l > len(rdata) {
+				break
+			}
+			parts = append(parts, strconv.Quote(string(rdata[offset+1:offset+1+l])))
+			offset += 1 + l
+		}
+		return strings.Join(parts, " ")
+	}
+
+	// RFC 3597 generic format
+	return fmt.Sprintf("\\# %d %s", len(rdata), hex.EncodeToString(rdata))
+}
+
+// return a DNSSEC timestamp as YYYYMMDDHHmmSS
+func dnsTimeString(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+// return an NSEC3 salt as hex, or '-' if empty
+func dnsSaltString(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return strings.ToUpper(hex.EncodeToString(salt))
+}
+
+// return a list of type mnemonics
+func dnsTypeList(types []uint16) string {
+	names := make([]string, len(types))
+	for ix, t := range types {
+		names[ix] = DNSTypeString(t)
+	}
+	return strings.Join(names, " ")
+}
+
+// return a record in presentation format
+func (rr *DNSRR) String() string {
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", rr.Name, rr.TTL,
+		DNSTypeString(rr.Type), DNSRDataToText(rr.Type, rr.RData))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,584 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// authoritative zone set
+//
+// zones that are authoritative within DN42 come from three sources,
+// later sources replace earlier ones for the same zone:
+//
+// default  - the built-in forward and reverse zones, only used when
+//            no config file is given
+// registry - reverse zones derived from the top level inetnum and
+//            inet6num objects that have nserver attributes and are
+//            permitted by the filter rules
+// config   - a config file given with --DNSAuthZones, containing lines
+//            of 'zone object', e.g. 'dn42 domain/dn42'
+
+const (
+	DNS_ZONE_SOURCE_DEFAULT  = "default"
+	DNS_ZONE_SOURCE_REGISTRY = "registry"
+	DNS_ZONE_SOURCE_CONFIG   = "config"
+)
+
+type DNSAuthZoneSource struct {
+	Zone   string
+	Object string
+	Source string
+}
+
+var DNSDefaultAuthZones = map[string]string{
+	"dn42":                    "domain/dn42",
+	"recursive-servers.dn42":  "domain/recursive-servers.dn42",
+	"delegation-servers.dn42": "domain/delegation-servers.dn42",
+	"d.f.ip6.arpa":            "inet6num/fd00::_8",
+	"20.172.in-addr.arpa":     "inetnum/172.20.0.0_16",
+	"21.172.in-addr.arpa":     "inetnum/172.21.0.0_16",
+	"22.172.in-addr.arpa":     "inetnum/172.22.0.0_16",
+	"23.172.in-addr.arpa":     "inetnum/172.23.0.0_16",
+	"31.172.in-addr.arpa":     "inetnum/172.31.0.0_16",
+	"10.in-addr.arpa":         "inetnum/10.0.0.0_8",
+}
+
+var dnsAuthZoneFile string
+var dnsAuthZoneConfig []*DNSAuthZoneSource
+var dnsAuthZoneRegistry = true
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to set where authoritative zones come from
+
+func InitialiseDNSAuthZones(path string, fromRegistry bool) {
+
+	dnsAuthZoneRegistry = fromRegistry
+
+	if path == "" {
+		return
+	}
+
+	// the config file is read at startup to catch errors early, and
+	// then re-read on each registry update
+	config, err := loadDNSAuthZones(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Fatal("Unable to load DNS authoritative zones")
+	}
+
+	dnsAuthZoneFile = path
+	dnsAuthZoneConfig = config
+
+	log.WithFields(log.Fields{
+		"path":  path,
+		"zones": len(config),
+	}).Info("DNS authoritative zones loaded")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load zones from a config file
+
+func loadDNSAuthZones(path string) ([]*DNSAuthZoneSource, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zones := make([]*DNSAuthZoneSource, 0)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		line := scanner.Text()
+
+		// remove any comments
+		if ix := strings.IndexRune(line, '#'); ix != -1 {
+			line = line[:ix]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || strings.IndexRune(fields[1], '/') == -1 {
+			log.WithFields(log.Fields{
+				"path": path,
+				"line": line,
+			}).Warn("Invalid line in DNS authoritative zones")
+			continue
+		}
+
+		zones = append(zones, &DNSAuthZoneSource{
+			Zone:   strings.TrimSuffix(strings.ToLower(fields[0]), "."),
+			Object: fields[1],
+			Source: DNS_ZONE_SOURCE_CONFIG,
+		})
+	}
+
+	return zones, scanner.Err()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// compile the set of authoritative zones, sorted by zone name
+
+func DNSCompileAuthZones(registry *Registry, path string) []*DNSAuthZoneSource {
+
+	zones := make(map[string]*DNSAuthZoneSource)
+
+	if dnsAuthZoneFile == "" {
+		for zone, object := range DNSDefaultAuthZones {
+			zones[zone] = &DNSAuthZoneSource{
+				Zone:   zone,
+				Object: object,
+				Source: DNS_ZONE_SOURCE_DEFAULT,
+			}
+		}
+	}
+
+	if dnsAuthZoneRegistry {
+		for _, source := range dnsRegistryAuthZones(registry, path) {
+			zones[source.Zone] = source
+		}
+	}
+
+	if dnsAuthZoneFile != "" {
+		// keep the previous config if the file can no longer be read
+		config, err := loadDNSAuthZones(dnsAuthZoneFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  dnsAuthZoneFile,
+				"error": err,
+			}).Error("Unable to reload DNS authoritative zones")
+		} else {
+			dnsAuthZoneConfig = config
+		}
+
+		for _, source := range dnsAuthZoneConfig {
+			zones[source.Zone] = source
+		}
+	}
+
+	list := make([]*DNSAuthZoneSource, 0, len(zones))
+	for _, source := range zones {
+		list = append(list, source)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Zone < list[j].Zone
+	})
+
+	return list
+}
+
+//////////////////////////////////////////////////////////////////////////
+// derive reverse zones from the registry
+//
+// candidates are inetnum and inet6num objects with nserver attributes
+// that match a permit rule in the filter files. Objects on an octet
+// (IPv4) or nibble (IPv6) boundary are a single zone, whilst other
+// objects are expanded in to the zones at the next boundary, using the
+// object's name servers. Candidates below another candidate's zone are
+// delegated from that zone, so are not authoritative in the root, and
+// where candidates share a zone the most specific object is used.
+// Classless (RFC 2317) networks can only be delegated from their parent
+// zone, so are never candidates.
+
+func dnsRegistryAuthZones(registry *Registry,
+	path string) []*DNSAuthZoneSource {
+
+	filters := &ROA{}
+	if filters.loadFilter(path+"/filter.txt", 4) != nil ||
+		filters.loadFilter(path+"/filter6.txt", 6) != nil {
+		return nil
+	}
+
+	type candidate struct {
+		zone   string
+		object *RegObject
+		ones   int
+	}
+	candidates := make(map[string]*candidate)
+
+	for _, tname := range []string{"inetnum", "inet6num"} {
+
+		stype := registry.Schema[tname]
+		if stype == nil || stype.KeyIndex["cidr"] == nil ||
+			stype.KeyIndex["nserver"] == nil {
+			continue
+		}
+		nserverIX := stype.KeyIndex["nserver"]
+
+		for object, cidr := range stype.KeyIndex["cidr"].Objects {
+
+			if len(nserverIX.Objects[object]) == 0 {
+				continue
+			}
+
+			ip, network, err := net.ParseCIDR(cidr[0].RawValue)
+			if err != nil || !ip.Equal(network.IP) {
+				continue
+			}
+
+			filter := filters.MatchFilter(network.IP)
+			if filter == nil || filter.Action != "permit" {
+				continue
+			}
+
+			ones, _ := network.Mask.Size()
+			for _, delegation := range dnsReverseNetworkDelegations(network) {
+				if len(delegation.Aliases) != 0 {
+					continue
+				}
+
+				zone := DNSFQDN(delegation.Zone)
+				if c := candidates[zone]; c != nil && (c.ones > ones ||
+					(c.ones == ones && c.object.Ref < object.Ref)) {
+					continue
+				}
+				candidates[zone] = &candidate{
+					zone:   delegation.Zone,
+					object: object,
+					ones:   ones,
+				}
+			}
+		}
+	}
+
+	zones := make([]*DNSAuthZoneSource, 0)
+
+	for name, c := range candidates {
+
+		// skip candidates below another candidate's zone
+		below := false
+		for parent := DNSParent(name); parent != "."; parent = DNSParent(parent) {
+			if candidates[parent] != nil {
+				below = true
+				break
+			}
+		}
+		if below {
+			continue
+		}
+
+		zones = append(zones, &DNSAuthZoneSource{
+			Zone:   c.zone,
+			Object: c.object.Ref,
+			Source: DNS_ZONE_SOURCE_REGISTRY,
+		})
+	}
+
+	return zones
+}
+
+//////////////////////////////////////////////////////////////////////////
+// generate the delegation content for an authoritative zone
+//
+// the apex NS records and glue come from the zone object, and each
+// delegation adds NS, DS and glue records. Glue is only included where
+// it falls within both the zone and the delegation that supplied it.
+
+func DNSBuildZone(registry *Registry, source *DNSAuthZoneSource,
+	serial uint32) *DNSZone {
+
+	object := registry.GetObject(source.Object)
+	if object == nil {
+		log.WithFields(log.Fields{
+			"zone": source.Zone,
+			"path": source.Object,
+		}).Error("DNS: unable to find object in registry")
+		return nil
+	}
+
+	zone := &DNSZone{
+		Zone:      source.Zone,
+		Generated: time.Now(),
+		Commit:    registry.Commit,
+		Serial:    serial,
+	}
+
+	// the apex name servers replace those configured for the root zone
+	config := *DNSConfig
+	config.NameServers = nil
+	for _, ns := range object.GetKey("nserver") {
+		fields := strings.Split(ns.RawValue, " ")
+		config.NameServers = append(config.NameServers, fields[0])
+		if len(fields) == 2 {
+			zone.AddRecord(fields[0], dnsAddressType(fields[1]), fields[1],
+				"Apex Glue")
+		}
+	}
+
+	var delegations []*dnsDelegation
+	if strings.HasPrefix(source.Object, "domain/") {
+		delegations = dnsForwardDelegations(registry, source.Zone)
+	} else {
+		delegations = dnsReverseDelegations(registry, object, source.Zone)
+	}
+
+	for _, delegation := range delegations {
+		zone.AddRecords(registry, delegation.Zone, delegation.Object,
+			delegation.Object)
+
+		// RFC 2317 aliases point in to the classless sub-zone
+		for _, alias := range delegation.Aliases {
+			target := alias[:strings.IndexByte(alias, '.')]
+			zone.AddRecord(alias, "CNAME", target+"."+delegation.Zone+".",
+				delegation.Object)
+		}
+	}
+
+	// remove any out of zone glue
+	records := make([]*DNSRecord, 0, len(zone.Records))
+	for _, record := range zone.Records {
+		if DNSIsSubdomain(DNSFQDN(record.Name), DNSFQDN(source.Zone)) {
+			records = append(records, record)
+		}
+	}
+	zone.Records = records
+
+	zone.auth = zone.AuthZone(source.Zone, &config)
+	return zone
+}
+
+// a delegation from an authoritative zone, classless reverse delegations
+// also list the names that are aliased in to the delegated zone
+type dnsDelegation struct {
+	Zone    string
+	Object  string
+	Aliases []string
+}
+
+// remove delegations that are below another delegation in the list,
+// these are delegated by the zone above them
+func dnsTopDelegations(delegations []*dnsDelegation) []*dnsDelegation {
+
+	names := make(map[string]bool)
+	for _, delegation := range delegations {
+		names[DNSFQDN(delegation.Zone)] = true
+	}
+
+	top := make([]*dnsDelegation, 0, len(delegations))
+	for _, delegation := range delegations {
+		below := false
+		name := DNSFQDN(delegation.Zone)
+		for parent := DNSParent(name); parent != "."; parent = DNSParent(parent) {
+			if names[parent] {
+				below = true
+				break
+			}
+		}
+		if !below {
+			top = append(top, delegation)
+		}
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		return DNSCanonicalLess(DNSFQDN(top[i].Zone), DNSFQDN(top[j].Zone))
+	})
+	return top
+}
+
+// return domain objects with nserver attributes below a forward zone
+func dnsForwardDelegations(registry *Registry,
+	origin string) []*dnsDelegation {
+
+	delegations := make([]*dnsDelegation, 0)
+
+	rtype := registry.Types["domain"]
+	if rtype == nil {
+		return delegations
+	}
+
+	for name, object := range rtype.Objects {
+		name = strings.ToLower(name)
+		if !strings.HasSuffix(name, "."+origin) ||
+			len(object.GetKey("nserver")) == 0 {
+			continue
+		}
+		delegations = append(delegations, &dnsDelegation{
+			Zone:   name,
+			Object: object.Ref,
+		})
+	}
+
+	return dnsTopDelegations(delegations)
+}
+
+// return inetnum or inet6num objects with nserver attributes within the
+// network of a reverse zone object, and below the zone itself as objects
+// that are not on a boundary are expanded in to several zones
+func dnsReverseDelegations(registry *Registry,
+	zobject *RegObject, origin string) []*dnsDelegation {
+
+	delegations := make([]*dnsDelegation, 0)
+
+	cidr := zobject.GetKey("cidr")
+	if len(cidr) == 0 {
+		return delegations
+	}
+	_, znet, err := net.ParseCIDR(cidr[0].RawValue)
+	if err != nil {
+		return delegations
+	}
+	zones, _ := znet.Mask.Size()
+
+	tname := "inetnum"
+	if znet.IP.To4() == nil {
+		tname = "inet6num"
+	}
+	stype := registry.Schema[tname]
+	if stype == nil || stype.KeyIndex["cidr"] == nil ||
+		stype.KeyIndex["nserver"] == nil {
+		return delegations
+	}
+	nserverIX := stype.KeyIndex["nserver"]
+
+	for object, cidr := range stype.KeyIndex["cidr"].Objects {
+
+		if len(nserverIX.Objects[object]) == 0 {
+			continue
+		}
+
+		ip, network, err := net.ParseCIDR(cidr[0].RawValue)
+		if err != nil || !ip.Equal(network.IP) {
+			continue
+		}
+
+		ones, _ := network.Mask.Size()
+		if ones <= zones || !znet.Contains(network.IP) {
+			continue
+		}
+
+		for _, delegation := range dnsReverseNetworkDelegations(network) {
+			name := DNSFQDN(delegation.Zone)
+			if name == DNSFQDN(origin) ||
+				!DNSIsSubdomain(name, DNSFQDN(origin)) {
+				continue
+			}
+			delegation.Object = object.Ref
+			delegations = append(delegations, delegation)
+		}
+	}
+
+	return dnsTopDelegations(delegations)
+}
+
+// return the reverse delegations for a network
+//
+// IPv4 networks smaller than a /24 use RFC 2317 classless delegation,
+// with a sub-zone named <network>-<length> in the parent /24 and a CNAME
+// for each address. Other networks that are not on an octet or nibble
+// boundary are delegated as each of the networks at the next boundary.
+func dnsReverseNetworkDelegations(network *net.IPNet) []*dnsDelegation {
+
+	if zone, ok := DNSReverseZone(network); ok {
+		return []*dnsDelegation{&dnsDelegation{Zone: zone}}
+	}
+
+	ones, bits := network.Mask.Size()
+
+	if bits == 32 && ones > 24 {
+		ip := network.IP.To4()
+		parent, _ := DNSReverseZone(&net.IPNet{
+			IP:   ip.Mask(net.CIDRMask(24, 32)),
+			Mask: net.CIDRMask(24, 32),
+		})
+
+		delegation := &dnsDelegation{
+			Zone: fmt.Sprintf("%d-%d.%s", ip[3], ones, parent),
+		}
+		for ix := 0; ix < 1<<uint(32-ones); ix++ {
+			delegation.Aliases = append(delegation.Aliases,
+				fmt.Sprintf("%d.%s", int(ip[3])+ix, parent))
+		}
+		return []*dnsDelegation{delegation}
+	}
+
+	// round up to the next boundary
+	boundary := 8
+	if bits == 128 {
+		boundary = 4
+	}
+	length := (ones/boundary + 1) * boundary
+
+	delegations := make([]*dnsDelegation, 0, 1<<uint(length-ones))
+	ip := make(net.IP, len(network.IP))
+	copy(ip, network.IP)
+	for ix := 0; ix < 1<<uint(length-ones); ix++ {
+
+		// add ix to the network at the new prefix length
+		subnet := make(net.IP, len(ip))
+		copy(subnet, ip)
+		carry := uint(ix) << uint((bits-length)%8)
+		for bx := (length - 1) / 8; bx >= 0 && carry > 0; bx-- {
+			sum := uint(subnet[bx]) + carry
+			subnet[bx] = byte(sum)
+			carry = sum >> 8
+		}
+
+		zone, _ := DNSReverseZone(&net.IPNet{
+			IP:   subnet,
+			Mask: net.CIDRMask(length, bits),
+		})
+		delegations = append(delegations, &dnsDelegation{Zone: zone})
+	}
+
+	return delegations
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the reverse zone for a network, false if the prefix length
+// is not on an octet (IPv4) or nibble (IPv6) boundary
+
+func DNSReverseZone(network *net.IPNet) (string, bool) {
+
+	ones, bits := network.Mask.Size()
+	labels := make([]string, 0)
+
+	if bits == 32 {
+		if ones%8 != 0 {
+			return "", false
+		}
+		ip := network.IP.To4()
+		for ix := ones/8 - 1; ix >= 0; ix-- {
+			labels = append(labels, strconv.Itoa(int(ip[ix])))
+		}
+		labels = append(labels, "in-addr", "arpa")
+
+	} else {
+		if ones%4 != 0 {
+			return "", false
+		}
+		ip := network.IP.To16()
+		for ix := ones/4 - 1; ix >= 0; ix-- {
+			nibble := ip[ix/2] >> 4
+			if ix%2 == 1 {
+				nibble = ip[ix/2] & 0x0f
+			}
+			labels = append(labels, strconv.FormatUint(uint64(nibble), 16))
+		}
+		labels = append(labels, "ip6", "arpa")
+	}
+
+	return strings.Join(labels, "."), true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
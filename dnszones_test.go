@@ -0,0 +1,211 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestDNSRegistryAuthZones(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		exclude  []string
+		filter6  string   // replaces the IPv6 filter rules
+		expected []string // zone and object
+	}{
+		{
+			name: "unaligned top level expanded to the next boundary",
+			// the /16 is more specific than the expanded /14, and the
+			// /24 and /50 are delegated from the zones above them
+			expected: []string{
+				"20.172.in-addr.arpa inetnum/172.20.0.0_16",
+				"21.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"22.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"23.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"d.f.ip6.arpa inet6num/fd00::_8",
+			},
+		},
+		{
+			name:    "within an unaligned top level",
+			exclude: []string{"inetnum/172.20.0.0_16"},
+			expected: []string{
+				"20.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"21.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"22.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"23.172.in-addr.arpa inetnum/172.20.0.0_14",
+				"d.f.ip6.arpa inet6num/fd00::_8",
+			},
+		},
+		{
+			name:    "aligned top level",
+			exclude: []string{"inetnum/172.20.0.0_14"},
+			expected: []string{
+				"20.172.in-addr.arpa inetnum/172.20.0.0_16",
+				"d.f.ip6.arpa inet6num/fd00::_8",
+			},
+		},
+		{
+			name: "member network at the top level",
+			exclude: []string{"inetnum/172.20.0.0_14",
+				"inetnum/172.20.0.0_16"},
+			expected: []string{
+				"0.20.172.in-addr.arpa inetnum/172.20.0.0_24",
+				"d.f.ip6.arpa inet6num/fd00::_8",
+			},
+		},
+		{
+			name: "classless and unaligned IPv6 at the top level",
+			exclude: []string{"inetnum/172.20.0.0_14",
+				"inetnum/172.20.0.0_16", "inetnum/172.20.0.0_24",
+				"inet6num/fd00::_8"},
+			expected: []string{
+				"0.0.0.0.0.1.0.0.0.2.4.d.f.ip6.arpa inet6num/fd42:1::_50",
+				"1.0.0.0.0.1.0.0.0.2.4.d.f.ip6.arpa inet6num/fd42:1::_50",
+				"2.0.0.0.0.1.0.0.0.2.4.d.f.ip6.arpa inet6num/fd42:1::_50",
+				"3.0.0.0.0.1.0.0.0.2.4.d.f.ip6.arpa inet6num/fd42:1::_50",
+			},
+		},
+		{
+			name: "outside of the filters",
+			exclude: []string{"inetnum/172.20.0.0_14",
+				"inetnum/172.20.0.0_16", "inetnum/172.20.0.0_24",
+				"inet6num/fd00::_8"},
+			filter6:  "1 deny fd42::/16 128 128\n2 permit fd00::/8 44 64\n",
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		path := testCopyRegistry(t, test.exclude...)
+		if test.filter6 != "" {
+			err := ioutil.WriteFile(filepath.Join(path, "filter6.txt"),
+				[]byte(test.filter6), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		registry := LoadRegistry(path, testRegistryCommit)
+
+		zones := []string{}
+		for _, source := range dnsRegistryAuthZones(registry, path) {
+			if source.Source != DNS_ZONE_SOURCE_REGISTRY {
+				t.Errorf("%s: %s has source %s", test.name, source.Zone, source.Source)
+			}
+			zones = append(zones, source.Zone+" "+source.Object)
+		}
+		sort.Strings(zones)
+
+		if strings.Join(zones, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("%s: got %v, expected %v", test.name, zones, test.expected)
+		}
+	}
+}
+
+func TestDNSBuildZoneGlue(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+	zone := DNSBuildZone(registry, &DNSAuthZoneSource{
+		Zone:   "dn42",
+		Object: "domain/dn42",
+		Source: DNS_ZONE_SOURCE_DEFAULT,
+	}, 1)
+	if zone == nil {
+		t.Fatal("zone was not built")
+	}
+
+	records := make(map[string]bool)
+	for _, record := range zone.Records {
+		records[record.Name+" "+record.Type+" "+record.Content] = true
+	}
+
+	tests := []struct {
+		record    string
+		published bool
+	}{
+		{"a.root.dn42 A 172.20.0.1", true},
+		{"foo.dn42 NS ns1.foo.dn42.", true},
+		{"ns1.foo.dn42 A 172.20.0.53", true},
+		{"ns2.foo.dn42 A 172.20.0.54", true},
+		{"foo.dn42 DS 1 13 2 abc", true},
+		// bar.dn42 uses foo's name server, but can't supply its glue
+		{"bar.dn42 NS ns1.foo.dn42.", true},
+		{"ns1.foo.dn42 A 172.20.0.99", false},
+		// glue within delegation-servers.dn42 is for that delegation
+		{"delegation-servers.dn42 NS ns1.root.dn42.", true},
+		{"ns1.root.dn42 A 172.20.0.1", false},
+		// out of zone
+		{"ns1.hack A 10.1.2.3", false},
+	}
+
+	for _, test := range tests {
+		if records[test.record] != test.published {
+			t.Errorf("%s: published %v, expected %v", test.record,
+				records[test.record], test.published)
+		}
+	}
+}
+
+func TestDNSBuildZoneExpanded(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+
+	tests := []struct {
+		zone     string
+		expected []string
+		absent   []string
+	}{
+		{"20.172.in-addr.arpa",
+			[]string{"0.20.172.in-addr.arpa NS ns1.foo.dn42.",
+				"160-27.1.20.172.in-addr.arpa NS ns1.small.dn42."},
+			// the /16 is the zone itself, not a delegation from it
+			[]string{"20.172.in-addr.arpa NS a.root.dn42."}},
+		// delegations in the other expanded zones are out of zone
+		{"21.172.in-addr.arpa", nil,
+			[]string{"0.20.172.in-addr.arpa NS ns1.foo.dn42."}},
+	}
+
+	for _, test := range tests {
+		zone := DNSBuildZone(registry, &DNSAuthZoneSource{
+			Zone:   test.zone,
+			Object: "inetnum/172.20.0.0_14",
+			Source: DNS_ZONE_SOURCE_REGISTRY,
+		}, 1)
+		if zone == nil {
+			t.Fatalf("%s: zone was not built", test.zone)
+		}
+
+		records := make(map[string]bool)
+		for _, record := range zone.Records {
+			records[record.Name+" "+record.Type+" "+record.Content] = true
+		}
+		for _, record := range test.expected {
+			if !records[record] {
+				t.Errorf("%s: %s was not published", test.zone, record)
+			}
+		}
+		for _, record := range test.absent {
+			if records[record] {
+				t.Errorf("%s: %s was published", test.zone, record)
+			}
+		}
+		for _, record := range zone.Records {
+			if !DNSIsSubdomain(DNSFQDN(record.Name), DNSFQDN(test.zone)) {
+				t.Errorf("%s: out of zone record %s", test.zone, record.Name)
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
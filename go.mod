@@ -1,6 +1,6 @@
 module burble.dn42/dn42regsrv
 
-go 1.14
+go 1.19
 
 require (
 	github.com/gorilla/handlers v1.5.1
@@ -8,3 +8,9 @@ require (
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/pflag v1.0.5
 )
+
+require (
+	github.com/felixge/httpsnoop v1.0.1 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
+)
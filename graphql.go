@@ -0,0 +1,861 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// GraphQL query language
+//
+// A parser for the executable subset of the GraphQL language: queries
+// with variables, aliases, arguments, fragments and directives.
+// Type system definitions are not supported as the schema is generated
+// from the registry (see reggraphql.go).
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	Path      []interface{}     `json:"path,omitempty"`
+}
+
+func (e *GraphQLError) Error() string {
+	return e.Message
+}
+
+// create an error at a location in the query
+func gqlErrorf(pos GraphQLLocation, format string, args ...interface{}) *GraphQLError {
+	return &GraphQLError{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []GraphQLLocation{pos},
+	}
+}
+
+// the parsed document
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []*gqlVariableDef
+	directives []*gqlDirective
+	selections []*gqlSelection
+	pos        GraphQLLocation
+}
+
+type gqlFragment struct {
+	name       string
+	on         string
+	directives []*gqlDirective
+	selections []*gqlSelection
+	pos        GraphQLLocation
+}
+
+type gqlVariableDef struct {
+	name   string
+	vtype  *gqlTypeRef
+	defval *gqlValue
+	pos    GraphQLLocation
+}
+
+type gqlTypeRef struct {
+	name    string
+	list    *gqlTypeRef
+	nonNull bool
+}
+
+// selections are fields, fragment spreads or inline fragments
+const (
+	GQL_FIELD           = 'f'
+	GQL_FRAGMENT_SPREAD = 's'
+	GQL_INLINE_FRAGMENT = 'i'
+)
+
+type gqlSelection struct {
+	kind       byte
+	alias      string
+	name       string // field or fragment name
+	on         string // type condition for inline fragments
+	args       []*gqlArgument
+	directives []*gqlDirective
+	selections []*gqlSelection
+	pos        GraphQLLocation
+}
+
+type gqlDirective struct {
+	name string
+	args []*gqlArgument
+	pos  GraphQLLocation
+}
+
+type gqlArgument struct {
+	name  string
+	value *gqlValue
+}
+
+// value kinds
+const (
+	GQL_VARIABLE = '$'
+	GQL_INT      = 'i'
+	GQL_FLOAT    = 'f'
+	GQL_STRING   = 's'
+	GQL_BOOLEAN  = 'b'
+	GQL_NULL     = 'n'
+	GQL_ENUM     = 'e'
+	GQL_LIST     = 'l'
+	GQL_OBJECT   = 'o'
+)
+
+type gqlValue struct {
+	kind   byte
+	text   string
+	list   []*gqlValue
+	fields []*gqlArgument
+	pos    GraphQLLocation
+}
+
+//////////////////////////////////////////////////////////////////////////
+// type references
+
+func (t *gqlTypeRef) String() string {
+	s := t.name
+	if t.list != nil {
+		s = "[" + t.list.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+//////////////////////////////////////////////////////////////////////////
+// the response key for a field
+
+func (sel *gqlSelection) key() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+//////////////////////////////////////////////////////////////////////////
+// lexer
+
+const (
+	GQL_TOKEN_EOF    = 0
+	GQL_TOKEN_PUNCT  = 'p'
+	GQL_TOKEN_NAME   = 'n'
+	GQL_TOKEN_INT    = 'i'
+	GQL_TOKEN_FLOAT  = 'f'
+	GQL_TOKEN_STRING = 's'
+)
+
+type gqlToken struct {
+	kind byte
+	text string
+	pos  GraphQLLocation
+}
+
+type gqlLexer struct {
+	src  string
+	ix   int
+	line int
+	col  int
+}
+
+func (lex *gqlLexer) pos() GraphQLLocation {
+	return GraphQLLocation{Line: lex.line, Column: lex.col}
+}
+
+// advance over n bytes, tracking the line and column
+func (lex *gqlLexer) advance(n int) {
+	for ; n > 0 && lex.ix < len(lex.src); n-- {
+		if lex.src[lex.ix] == '\n' {
+			lex.line++
+			lex.col = 1
+		} else {
+			lex.col++
+		}
+		lex.ix++
+	}
+}
+
+func gqlNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func gqlNameChar(c byte) bool {
+	return gqlNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// return the next token
+func (lex *gqlLexer) next() (*gqlToken, error) {
+
+	// skip whitespace, commas and comments
+	for lex.ix < len(lex.src) {
+		c := lex.src[lex.ix]
+		if c == '#' {
+			for lex.ix < len(lex.src) && lex.src[lex.ix] != '\n' {
+				lex.advance(1)
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			lex.advance(1)
+		} else if strings.HasPrefix(lex.src[lex.ix:], "\ufeff") {
+			lex.advance(3)
+		} else {
+			break
+		}
+	}
+
+	pos := lex.pos()
+	if lex.ix >= len(lex.src) {
+		return &gqlToken{kind: GQL_TOKEN_EOF, pos: pos}, nil
+	}
+
+	c := lex.src[lex.ix]
+	start := lex.ix
+
+	switch {
+	case strings.HasPrefix(lex.src[lex.ix:], "..."):
+		lex.advance(3)
+		return &gqlToken{kind: GQL_TOKEN_PUNCT, text: "...", pos: pos}, nil
+
+	case strings.IndexByte("!$&():=@[]{}|", c) != -1:
+		lex.advance(1)
+		return &gqlToken{kind: GQL_TOKEN_PUNCT, text: string(c), pos: pos}, nil
+
+	case gqlNameStart(c):
+		for lex.ix < len(lex.src) && gqlNameChar(lex.src[lex.ix]) {
+			lex.advance(1)
+		}
+		return &gqlToken{kind: GQL_TOKEN_NAME, text: lex.src[start:lex.ix],
+			pos: pos}, nil
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		return lex.number(pos)
+
+	case c == '"':
+		if strings.HasPrefix(lex.src[lex.ix:], `"""`) {
+			return lex.blockString(pos)
+		}
+		return lex.string(pos)
+	}
+
+	r, _ := utf8.DecodeRuneInString(lex.src[lex.ix:])
+	return nil, gqlErrorf(pos, "Syntax Error: Unexpected character %q", r)
+}
+
+func (lex *gqlLexer) number(pos GraphQLLocation) (*gqlToken, error) {
+
+	start := lex.ix
+	kind := byte(GQL_TOKEN_INT)
+
+	digits := func() int {
+		n := 0
+		for lex.ix < len(lex.src) && lex.src[lex.ix] >= '0' && lex.src[lex.ix] <= '9' {
+			lex.advance(1)
+			n++
+		}
+		return n
+	}
+
+	if lex.src[lex.ix] == '-' {
+		lex.advance(1)
+	}
+	if digits() == 0 {
+		return nil, gqlErrorf(pos, "Syntax Error: Invalid number")
+	}
+	if lex.ix < len(lex.src) && lex.src[lex.ix] == '.' {
+		kind = GQL_TOKEN_FLOAT
+		lex.advance(1)
+		if digits() == 0 {
+			return nil, gqlErrorf(pos, "Syntax Error: Invalid number")
+		}
+	}
+	if lex.ix < len(lex.src) && (lex.src[lex.ix] == 'e' || lex.src[lex.ix] == 'E') {
+		kind = GQL_TOKEN_FLOAT
+		lex.advance(1)
+		if lex.ix < len(lex.src) && (lex.src[lex.ix] == '+' || lex.src[lex.ix] == '-') {
+			lex.advance(1)
+		}
+		if digits() == 0 {
+			return nil, gqlErrorf(pos, "Syntax Error: Invalid number")
+		}
+	}
+	if lex.ix < len(lex.src) && gqlNameStart(lex.src[lex.ix]) {
+		return nil, gqlErrorf(pos, "Syntax Error: Invalid number")
+	}
+
+	return &gqlToken{kind: kind, text: lex.src[start:lex.ix], pos: pos}, nil
+}
+
+func (lex *gqlLexer) string(pos GraphQLLocation) (*gqlToken, error) {
+
+	var b strings.Builder
+	lex.advance(1)
+
+	for {
+		if lex.ix >= len(lex.src) || lex.src[lex.ix] == '\n' {
+			return nil, gqlErrorf(pos, "Syntax Error: Unterminated string")
+		}
+
+		c := lex.src[lex.ix]
+		if c == '"' {
+			lex.advance(1)
+			break
+		}
+
+		if c != '\\' {
+			b.WriteByte(c)
+			lex.advance(1)
+			continue
+		}
+
+		if lex.ix+1 >= len(lex.src) {
+			return nil, gqlErrorf(pos, "Syntax Error: Unterminated string")
+		}
+		escape := lex.src[lex.ix+1]
+		lex.advance(2)
+
+		switch escape {
+		case '"', '\\', '/':
+			b.WriteByte(escape)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if lex.ix+4 > len(lex.src) {
+				return nil, gqlErrorf(pos, "Syntax Error: Invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(lex.src[lex.ix:lex.ix+4], 16, 32)
+			if err != nil {
+				return nil, gqlErrorf(pos, "Syntax Error: Invalid unicode escape")
+			}
+			b.WriteRune(rune(r))
+			lex.advance(4)
+		default:
+			return nil, gqlErrorf(pos, "Syntax Error: Invalid escape \\%c", escape)
+		}
+	}
+
+	return &gqlToken{kind: GQL_TOKEN_STRING, text: b.String(), pos: pos}, nil
+}
+
+// block strings are returned as is, without removing indentation
+func (lex *gqlLexer) blockString(pos GraphQLLocation) (*gqlToken, error) {
+
+	var b strings.Builder
+	lex.advance(3)
+
+	for {
+		if lex.ix >= len(lex.src) {
+			return nil, gqlErrorf(pos, "Syntax Error: Unterminated string")
+		}
+		if strings.HasPrefix(lex.src[lex.ix:], `"""`) {
+			lex.advance(3)
+			break
+		}
+		if strings.HasPrefix(lex.src[lex.ix:], `\"""`) {
+			b.WriteString(`"""`)
+			lex.advance(4)
+			continue
+		}
+		b.WriteByte(lex.src[lex.ix])
+		lex.advance(1)
+	}
+
+	return &gqlToken{kind: GQL_TOKEN_STRING,
+		text: strings.TrimSpace(b.String()), pos: pos}, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parser
+
+type gqlParser struct {
+	lex   *gqlLexer
+	token *gqlToken
+}
+
+// parse a GraphQL document
+func ParseGraphQL(src string) (*gqlDocument, error) {
+
+	parser := &gqlParser{
+		lex: &gqlLexer{src: src, line: 1, col: 1},
+	}
+	if err := parser.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &gqlDocument{
+		fragments: make(map[string]*gqlFragment),
+	}
+
+	for parser.token.kind != GQL_TOKEN_EOF {
+
+		if parser.peek(GQL_TOKEN_NAME, "fragment") {
+			fragment, err := parser.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[fragment.name] != nil {
+				return nil, gqlErrorf(fragment.pos,
+					"There can be only one fragment named \"%s\"", fragment.name)
+			}
+			doc.fragments[fragment.name] = fragment
+			continue
+		}
+
+		operation, err := parser.operation()
+		if err != nil {
+			return nil, err
+		}
+		doc.operations = append(doc.operations, operation)
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, &GraphQLError{Message: "Document does not contain an operation"}
+	}
+
+	return doc, nil
+}
+
+func (parser *gqlParser) advance() error {
+	token, err := parser.lex.next()
+	if err != nil {
+		return err
+	}
+	parser.token = token
+	return nil
+}
+
+// return true if the current token matches
+func (parser *gqlParser) peek(kind byte, text string) bool {
+	return parser.token.kind == kind && parser.token.text == text
+}
+
+// consume a token if it matches
+func (parser *gqlParser) skip(kind byte, text string) (bool, error) {
+	if parser.peek(kind, text) {
+		return true, parser.advance()
+	}
+	return false, nil
+}
+
+// consume a token that must match
+func (parser *gqlParser) expect(kind byte, text string) error {
+	if !parser.peek(kind, text) {
+		return parser.unexpected("\"" + text + "\"")
+	}
+	return parser.advance()
+}
+
+func (parser *gqlParser) unexpected(expected string) error {
+	found := "\"" + parser.token.text + "\""
+	if parser.token.kind == GQL_TOKEN_EOF {
+		found = "<EOF>"
+	}
+	return gqlErrorf(parser.token.pos, "Syntax Error: Expected %s, found %s",
+		expected, found)
+}
+
+func (parser *gqlParser) name() (string, error) {
+	if parser.token.kind != GQL_TOKEN_NAME {
+		return "", parser.unexpected("Name")
+	}
+	name := parser.token.text
+	return name, parser.advance()
+}
+
+func (parser *gqlParser) operation() (*gqlOperation, error) {
+
+	operation := &gqlOperation{kind: "query", pos: parser.token.pos}
+	var err error
+
+	// the query shorthand is just a selection set
+	if !parser.peek(GQL_TOKEN_PUNCT, "{") {
+		if parser.token.kind != GQL_TOKEN_NAME {
+			return nil, parser.unexpected("\"{\", \"query\" or \"fragment\"")
+		}
+		if operation.kind, err = parser.name(); err != nil {
+			return nil, err
+		}
+		switch operation.kind {
+		case "query", "mutation", "subscription":
+		default:
+			return nil, gqlErrorf(operation.pos,
+				"Syntax Error: Unexpected Name \"%s\"", operation.kind)
+		}
+
+		if parser.token.kind == GQL_TOKEN_NAME {
+			if operation.name, err = parser.name(); err != nil {
+				return nil, err
+			}
+		}
+		if operation.variables, err = parser.variableDefinitions(); err != nil {
+			return nil, err
+		}
+		if operation.directives, err = parser.directives(); err != nil {
+			return nil, err
+		}
+	}
+
+	if operation.selections, err = parser.selectionSet(); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+func (parser *gqlParser) fragment() (*gqlFragment, error) {
+
+	fragment := &gqlFragment{pos: parser.token.pos}
+	var err error
+
+	if err = parser.advance(); err != nil {
+		return nil, err
+	}
+	if fragment.name, err = parser.name(); err != nil {
+		return nil, err
+	}
+	if fragment.name == "on" {
+		return nil, gqlErrorf(fragment.pos, "Syntax Error: Unexpected Name \"on\"")
+	}
+	if err = parser.expect(GQL_TOKEN_NAME, "on"); err != nil {
+		return nil, err
+	}
+	if fragment.on, err = parser.name(); err != nil {
+		return nil, err
+	}
+	if fragment.directives, err = parser.directives(); err != nil {
+		return nil, err
+	}
+	if fragment.selections, err = parser.selectionSet(); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+func (parser *gqlParser) variableDefinitions() ([]*gqlVariableDef, error) {
+
+	if ok, err := parser.skip(GQL_TOKEN_PUNCT, "("); !ok || err != nil {
+		return nil, err
+	}
+
+	defs := make([]*gqlVariableDef, 0)
+	for {
+		if ok, err := parser.skip(GQL_TOKEN_PUNCT, ")"); ok || err != nil {
+			return defs, err
+		}
+
+		def := &gqlVariableDef{pos: parser.token.pos}
+		var err error
+
+		if err = parser.expect(GQL_TOKEN_PUNCT, "$"); err != nil {
+			return nil, err
+		}
+		if def.name, err = parser.name(); err != nil {
+			return nil, err
+		}
+		if err = parser.expect(GQL_TOKEN_PUNCT, ":"); err != nil {
+			return nil, err
+		}
+		if def.vtype, err = parser.typeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := parser.skip(GQL_TOKEN_PUNCT, "="); err != nil {
+			return nil, err
+		} else if ok {
+			if def.defval, err = parser.value(true); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = parser.directives(); err != nil {
+			return nil, err
+		}
+
+		defs = append(defs, def)
+	}
+}
+
+func (parser *gqlParser) typeRef() (*gqlTypeRef, error) {
+
+	t := &gqlTypeRef{}
+	var err error
+
+	if ok, err := parser.skip(GQL_TOKEN_PUNCT, "["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.list, err = parser.typeRef(); err != nil {
+			return nil, err
+		}
+		if err = parser.expect(GQL_TOKEN_PUNCT, "]"); err != nil {
+			return nil, err
+		}
+	} else if t.name, err = parser.name(); err != nil {
+		return nil, err
+	}
+
+	if t.nonNull, err = parser.skip(GQL_TOKEN_PUNCT, "!"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (parser *gqlParser) directives() ([]*gqlDirective, error) {
+
+	directives := make([]*gqlDirective, 0)
+	for parser.peek(GQL_TOKEN_PUNCT, "@") {
+		directive := &gqlDirective{pos: parser.token.pos}
+		var err error
+
+		if err = parser.advance(); err != nil {
+			return nil, err
+		}
+		if directive.name, err = parser.name(); err != nil {
+			return nil, err
+		}
+		if directive.args, err = parser.arguments(); err != nil {
+			return nil, err
+		}
+		directives = append(directives, directive)
+	}
+	return directives, nil
+}
+
+func (parser *gqlParser) arguments() ([]*gqlArgument, error) {
+
+	if ok, err := parser.skip(GQL_TOKEN_PUNCT, "("); !ok || err != nil {
+		return nil, err
+	}
+
+	args := make([]*gqlArgument, 0)
+	for {
+		if ok, err := parser.skip(GQL_TOKEN_PUNCT, ")"); ok || err != nil {
+			return args, err
+		}
+
+		arg := &gqlArgument{}
+		var err error
+
+		if arg.name, err = parser.name(); err != nil {
+			return nil, err
+		}
+		if err = parser.expect(GQL_TOKEN_PUNCT, ":"); err != nil {
+			return nil, err
+		}
+		if arg.value, err = parser.value(false); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+}
+
+func (parser *gqlParser) selectionSet() ([]*gqlSelection, error) {
+
+	if err := parser.expect(GQL_TOKEN_PUNCT, "{"); err != nil {
+		return nil, err
+	}
+
+	selections := make([]*gqlSelection, 0)
+	for {
+		if ok, err := parser.skip(GQL_TOKEN_PUNCT, "}"); ok || err != nil {
+			if ok && len(selections) == 0 {
+				return nil, gqlErrorf(parser.token.pos,
+					"Syntax Error: Expected Name, found \"}\"")
+			}
+			return selections, err
+		}
+
+		selection, err := parser.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+}
+
+func (parser *gqlParser) selection() (*gqlSelection, error) {
+
+	sel := &gqlSelection{pos: parser.token.pos}
+	var err error
+
+	if ok, err := parser.skip(GQL_TOKEN_PUNCT, "..."); err != nil {
+		return nil, err
+	} else if ok {
+
+		// a named fragment spread
+		if parser.token.kind == GQL_TOKEN_NAME && parser.token.text != "on" {
+			sel.kind = GQL_FRAGMENT_SPREAD
+			if sel.name, err = parser.name(); err != nil {
+				return nil, err
+			}
+			if sel.directives, err = parser.directives(); err != nil {
+				return nil, err
+			}
+			return sel, nil
+		}
+
+		// or an inline fragment, with an optional type condition
+		sel.kind = GQL_INLINE_FRAGMENT
+		if ok, err := parser.skip(GQL_TOKEN_NAME, "on"); err != nil {
+			return nil, err
+		} else if ok {
+			if sel.on, err = parser.name(); err != nil {
+				return nil, err
+			}
+		}
+		if sel.directives, err = parser.directives(); err != nil {
+			return nil, err
+		}
+		if sel.selections, err = parser.selectionSet(); err != nil {
+			return nil, err
+		}
+		return sel, nil
+	}
+
+	// must be a field
+	sel.kind = GQL_FIELD
+	if sel.name, err = parser.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := parser.skip(GQL_TOKEN_PUNCT, ":"); err != nil {
+		return nil, err
+	} else if ok {
+		sel.alias = sel.name
+		if sel.name, err = parser.name(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.args, err = parser.arguments(); err != nil {
+		return nil, err
+	}
+	if sel.directives, err = parser.directives(); err != nil {
+		return nil, err
+	}
+	if parser.peek(GQL_TOKEN_PUNCT, "{") {
+		if sel.selections, err = parser.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sel, nil
+}
+
+// parse a value, constant values may not contain variables
+func (parser *gqlParser) value(constant bool) (*gqlValue, error) {
+
+	token := parser.token
+	value := &gqlValue{text: token.text, pos: token.pos}
+
+	switch token.kind {
+	case GQL_TOKEN_INT:
+		value.kind = GQL_INT
+	case GQL_TOKEN_FLOAT:
+		value.kind = GQL_FLOAT
+	case GQL_TOKEN_STRING:
+		value.kind = GQL_STRING
+
+	case GQL_TOKEN_NAME:
+		switch token.text {
+		case "true", "false":
+			value.kind = GQL_BOOLEAN
+		case "null":
+			value.kind = GQL_NULL
+		default:
+			value.kind = GQL_ENUM
+		}
+
+	case GQL_TOKEN_PUNCT:
+		switch token.text {
+		case "$":
+			if constant {
+				return nil, parser.unexpected("constant value")
+			}
+			if err := parser.advance(); err != nil {
+				return nil, err
+			}
+			name, err := parser.name()
+			if err != nil {
+				return nil, err
+			}
+			value.kind = GQL_VARIABLE
+			value.text = name
+			return value, nil
+
+		case "[":
+			if err := parser.advance(); err != nil {
+				return nil, err
+			}
+			value.kind = GQL_LIST
+			value.list = make([]*gqlValue, 0)
+			for {
+				if ok, err := parser.skip(GQL_TOKEN_PUNCT, "]"); ok || err != nil {
+					return value, err
+				}
+				item, err := parser.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				value.list = append(value.list, item)
+			}
+
+		case "{":
+			if err := parser.advance(); err != nil {
+				return nil, err
+			}
+			value.kind = GQL_OBJECT
+			value.fields = make([]*gqlArgument, 0)
+			for {
+				if ok, err := parser.skip(GQL_TOKEN_PUNCT, "}"); ok || err != nil {
+					return value, err
+				}
+				field := &gqlArgument{}
+				var err error
+				if field.name, err = parser.name(); err != nil {
+					return nil, err
+				}
+				if err = parser.expect(GQL_TOKEN_PUNCT, ":"); err != nil {
+					return nil, err
+				}
+				if field.value, err = parser.value(constant); err != nil {
+					return nil, err
+				}
+				value.fields = append(value.fields, field)
+			}
+
+		default:
+			return nil, parser.unexpected("value")
+		}
+
+	default:
+		return nil, parser.unexpected("value")
+	}
+
+	return value, parser.advance()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
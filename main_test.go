@@ -0,0 +1,106 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a small registry, used by the tests
+const testRegistryPath = "testdata/registry/data"
+const testRegistryCommit = "0123456789abcdef0123456789abcdef01234567"
+
+//////////////////////////////////////////////////////////////////////////
+// shared test setup
+
+func TestMain(m *testing.M) {
+	// keep the test output readable
+	log.SetLevel(log.FatalLevel)
+	os.Exit(m.Run())
+}
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// load the test registry
+func testLoadRegistry(t *testing.T) *Registry {
+	registry := LoadRegistry(testRegistryPath, testRegistryCommit)
+	if len(registry.Types) == 0 {
+		t.Fatal("Failed to load the test registry")
+	}
+	return registry
+}
+
+// copy the test registry to a temporary directory, without the
+// listed objects, and return the path to its data directory
+func testCopyRegistry(t *testing.T, exclude ...string) string {
+
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	excluded := make(map[string]bool)
+	for _, path := range exclude {
+		excluded[filepath.FromSlash(path)] = true
+	}
+
+	data := filepath.Join(dir, "data")
+	err = filepath.Walk(testRegistryPath, func(path string,
+		info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(testRegistryPath, path)
+		if excluded[rel] {
+			return nil
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(data, rel), 0755)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(data, rel), content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// return ROA data with the filters from the test registry
+func testLoadFilters(t *testing.T) *ROA {
+	roa := &ROA{Commit: testRegistryCommit}
+	if err := roa.loadFilter(testRegistryPath+"/filter.txt", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := roa.loadFilter(testRegistryPath+"/filter6.txt", 6); err != nil {
+		t.Fatal(err)
+	}
+	return roa
+}
+
+// parse a CIDR, failing the test if it is invalid
+func testCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Invalid CIDR '%s': %v", cidr, err)
+	}
+	return network
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
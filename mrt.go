@@ -0,0 +1,319 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// parsers for BGP table dumps
+//
+// both formats are reduced to a list of prefix/origin pairs
+
+type BGPRoute struct {
+	Prefix string `json:"prefix"`
+	Origin uint32 `json:"origin"`
+}
+
+// MRT constants, see RFC 6396
+const (
+	MRT_TABLE_DUMP_V2               = 13
+	MRT_RIB_IPV4_UNICAST            = 2
+	MRT_RIB_IPV6_UNICAST            = 4
+	MRT_RIB_IPV4_UNICAST_ADDPATH    = 8
+	MRT_RIB_IPV6_UNICAST_ADDPATH    = 10
+	BGP_ATTR_AS_PATH                = 2
+	BGP_ATTR_FLAG_EXTENDED          = 0x10
+	BGP_AS_PATH_SEGMENT_AS_SET      = 1
+	BGP_AS_PATH_SEGMENT_AS_SEQUENCE = 2
+)
+
+//////////////////////////////////////////////////////////////////////////
+// parse a dump, automatically detecting the format
+
+func ParseBGPDump(data []byte) ([]*BGPRoute, error) {
+
+	// MRT records start with a 4 byte timestamp followed by the type
+	if len(data) >= 12 &&
+		binary.BigEndian.Uint16(data[4:6]) == MRT_TABLE_DUMP_V2 {
+		return ParseMRT(data)
+	}
+
+	return ParseBirdRoutes(bytes.NewReader(data))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse an MRT TABLE_DUMP_V2 file
+
+func ParseMRT(data []byte) ([]*BGPRoute, error) {
+
+	routes := make([]*BGPRoute, 0)
+	seen := make(map[BGPRoute]bool)
+
+	for len(data) > 0 {
+
+		if len(data) < 12 {
+			return nil, errors.New("truncated MRT header")
+		}
+
+		mtype := binary.BigEndian.Uint16(data[4:6])
+		subtype := binary.BigEndian.Uint16(data[6:8])
+		mlen := binary.BigEndian.Uint32(data[8:12])
+		data = data[12:]
+
+		if uint32(len(data)) < mlen {
+			return nil, errors.New("truncated MRT record")
+		}
+		record := data[:mlen]
+		data = data[mlen:]
+
+		if mtype != MRT_TABLE_DUMP_V2 {
+			continue
+		}
+
+		var iplen int
+		addpath := false
+		switch subtype {
+		case MRT_RIB_IPV4_UNICAST:
+			iplen = 4
+		case MRT_RIB_IPV6_UNICAST:
+			iplen = 16
+		case MRT_RIB_IPV4_UNICAST_ADDPATH:
+			iplen = 4
+			addpath = true
+		case MRT_RIB_IPV6_UNICAST_ADDPATH:
+			iplen = 16
+			addpath = true
+		default:
+			// peer index table and other subtypes are not needed
+			continue
+		}
+
+		entries, err := parseMRTRib(record, iplen, addpath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, route := range entries {
+			if !seen[*route] {
+				seen[*route] = true
+				routes = append(routes, route)
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse a single RIB record
+
+func parseMRTRib(record []byte, iplen int, addpath bool) ([]*BGPRoute, error) {
+
+	errTruncated := errors.New("truncated MRT RIB record")
+
+	// sequence number and prefix length
+	if len(record) < 5 {
+		return nil, errTruncated
+	}
+	plen := int(record[4])
+	if plen > iplen*8 {
+		return nil, errors.New("invalid prefix length in MRT RIB record")
+	}
+	record = record[5:]
+
+	// the prefix is truncated to the minimum number of bytes
+	pbytes := (plen + 7) / 8
+	if len(record) < pbytes+2 {
+		return nil, errTruncated
+	}
+	ip := make(net.IP, iplen)
+	copy(ip, record[:pbytes])
+	prefix := (&net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(plen, iplen*8),
+	}).String()
+	record = record[pbytes:]
+
+	count := int(binary.BigEndian.Uint16(record[:2]))
+	record = record[2:]
+
+	routes := make([]*BGPRoute, 0, count)
+	for ix := 0; ix < count; ix++ {
+
+		// peer index, originated time and optional path id
+		skip := 6
+		if addpath {
+			skip += 4
+		}
+		if len(record) < skip+2 {
+			return nil, errTruncated
+		}
+		record = record[skip:]
+
+		alen := int(binary.BigEndian.Uint16(record[:2]))
+		record = record[2:]
+		if len(record) < alen {
+			return nil, errTruncated
+		}
+
+		origin, err := parseBGPOrigin(record[:alen])
+		if err != nil {
+			return nil, err
+		}
+		record = record[alen:]
+
+		routes = append(routes, &BGPRoute{
+			Prefix: prefix,
+			Origin: origin,
+		})
+	}
+
+	return routes, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// find the origin AS from a set of BGP path attributes
+//
+// TABLE_DUMP_V2 always uses 4 byte AS numbers, an origin that is an AS_SET
+// (or an empty path) is returned as 0 and can never be valid
+
+func parseBGPOrigin(attrs []byte) (uint32, error) {
+
+	errTruncated := errors.New("truncated BGP path attributes")
+
+	for len(attrs) > 0 {
+
+		if len(attrs) < 3 {
+			return 0, errTruncated
+		}
+		flags := attrs[0]
+		atype := attrs[1]
+
+		var alen int
+		if flags&BGP_ATTR_FLAG_EXTENDED != 0 {
+			if len(attrs) < 4 {
+				return 0, errTruncated
+			}
+			alen = int(binary.BigEndian.Uint16(attrs[2:4]))
+			attrs = attrs[4:]
+		} else {
+			alen = int(attrs[2])
+			attrs = attrs[3:]
+		}
+
+		if len(attrs) < alen {
+			return 0, errTruncated
+		}
+		value := attrs[:alen]
+		attrs = attrs[alen:]
+
+		if atype != BGP_ATTR_AS_PATH {
+			continue
+		}
+
+		// walk the segments, remembering the last one
+		var origin uint32
+		for len(value) > 0 {
+			if len(value) < 2 {
+				return 0, errTruncated
+			}
+			stype := value[0]
+			count := int(value[1])
+			value = value[2:]
+			if len(value) < count*4 {
+				return 0, errTruncated
+			}
+
+			origin = 0
+			if stype == BGP_AS_PATH_SEGMENT_AS_SEQUENCE && count > 0 {
+				origin = binary.BigEndian.Uint32(value[(count-1)*4:])
+			}
+			value = value[count*4:]
+		}
+
+		return origin, nil
+	}
+
+	// no AS_PATH, route was originated locally
+	return 0, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse the output of bird 'show route'
+//
+// both bird 1 and bird 2 formats are supported, lines starting with a
+// prefix set the current prefix, and the origin is taken from the
+// [AS...] marker at the end of each route line
+
+var birdOriginRE = regexp.MustCompile(`\[AS(\d+)[ie?]\]\s*$`)
+
+func ParseBirdRoutes(r io.Reader) ([]*BGPRoute, error) {
+
+	routes := make([]*BGPRoute, 0)
+	seen := make(map[BGPRoute]bool)
+
+	var prefix string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		// a new prefix ?
+		if line[0] != ' ' && line[0] != '\t' {
+			fields := strings.Fields(line)
+			if _, network, err := net.ParseCIDR(fields[0]); err == nil {
+				prefix = network.String()
+			}
+		}
+
+		if prefix == "" {
+			continue
+		}
+
+		match := birdOriginRE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		origin, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		route := BGPRoute{
+			Prefix: prefix,
+			Origin: uint32(origin),
+		}
+		if !seen[route] {
+			seen[route] = true
+			routes = append(routes, &route)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,176 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// testdata/mrt/table.mrt is a TABLE_DUMP_V2 file containing:
+//
+//   a PEER_INDEX_TABLE
+//   172.20.0.0/24, with three entries (one a duplicate origin)
+//   172.20.0.128/25, with an extended length AS_PATH
+//   a BGP4MP message
+//   172.20.1.0/24, with an AS_SET at the end of the path
+//   172.20.0.0/30
+//   fd42:1::/48, as RIB_IPV6_UNICAST
+//   10.1.0.0/16, as RIB_IPV4_UNICAST_ADDPATH with two paths
+
+const testMRTPath = "testdata/mrt/table.mrt"
+
+// the routes in the test MRT file
+var testMRTRoutes = []string{
+	"172.20.0.0/24 4242420001",
+	"172.20.0.0/24 4242420002",
+	"172.20.0.128/25 4242420010",
+	"172.20.1.0/24 0",
+	"172.20.0.0/30 4242420001",
+	"fd42:1::/48 4242420001",
+	"10.1.0.0/16 64512",
+}
+
+// format routes for comparison
+func testRouteStrings(routes []*BGPRoute) []string {
+	s := make([]string, len(routes))
+	for ix, route := range routes {
+		s[ix] = fmt.Sprintf("%s %d", route.Prefix, route.Origin)
+	}
+	return s
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestParseMRT(t *testing.T) {
+
+	data, err := ioutil.ReadFile(testMRTPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the format is detected automatically
+	routes, err := ParseBGPDump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(testRouteStrings(routes), "\n")
+	if expected := strings.Join(testMRTRoutes, "\n"); got != expected {
+		t.Errorf("unexpected routes:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestParseMRTTruncated(t *testing.T) {
+
+	data, err := ioutil.ReadFile(testMRTPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every truncation of the file is an error, apart from those
+	// that end exactly on a record boundary
+	boundaries := make(map[int]bool)
+	for offset := 0; offset < len(data); {
+		boundaries[offset] = true
+		offset += 12 + int(binary.BigEndian.Uint32(data[offset+8:]))
+	}
+
+	for length := 1; length < len(data); length++ {
+		_, err := ParseMRT(data[:length])
+		if boundaries[length] {
+			if err != nil {
+				t.Errorf("%d bytes: unexpected error %s", length, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "truncated MRT") {
+			t.Errorf("%d bytes: unexpected error %v", length, err)
+		}
+	}
+}
+
+func TestParseMRTRecords(t *testing.T) {
+
+	// build a RIB record for 172.20.0.0/24 with the given attributes
+	rib := func(attrs ...byte) []byte {
+		record := []byte{0, 0, 0, 0, 24, 172, 20, 0, 0, 1,
+			0, 0, 0, 0, 0, 0, 0, byte(len(attrs))}
+		record = append(record, attrs...)
+		return append([]byte{0, 0, 0, 0, 0, MRT_TABLE_DUMP_V2,
+			0, MRT_RIB_IPV4_UNICAST, 0, 0, 0, byte(len(record))}, record...)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		result string
+	}{
+		{"no AS_PATH", rib(), "172.20.0.0/24 0"},
+		{"empty AS_PATH", rib(0x40, 2, 0), "172.20.0.0/24 0"},
+		{"empty sequence", rib(0x40, 2, 2, 2, 0), "172.20.0.0/24 0"},
+		{"AS4 origin", rib(0x40, 2, 6, 2, 1, 0xfc, 0xde, 0x31, 0x21),
+			"172.20.0.0/24 4242420001"},
+		{"truncated attribute header", rib(0x40, 2), "truncated BGP path attributes"},
+		{"truncated extended length", rib(0x50, 2, 0), "truncated BGP path attributes"},
+		{"truncated attribute", rib(0x40, 2, 6, 2, 1, 0xfc),
+			"truncated BGP path attributes"},
+		{"truncated segment", rib(0x40, 2, 4, 2, 2, 0xfc, 0xde),
+			"truncated BGP path attributes"},
+		{"invalid prefix length", []byte{0, 0, 0, 0, 0, MRT_TABLE_DUMP_V2,
+			0, MRT_RIB_IPV4_UNICAST, 0, 0, 0, 7, 0, 0, 0, 0, 33, 0, 0},
+			"invalid prefix length in MRT RIB record"},
+		{"truncated RIB entry", []byte{0, 0, 0, 0, 0, MRT_TABLE_DUMP_V2,
+			0, MRT_RIB_IPV4_UNICAST, 0, 0, 0, 10, 0, 0, 0, 0, 16, 172, 20, 0, 1, 0},
+			"truncated MRT RIB record"},
+		{"truncated header", []byte{0, 0, 0, 0, 0, MRT_TABLE_DUMP_V2},
+			"truncated MRT header"},
+	}
+
+	for _, test := range tests {
+		routes, err := ParseMRT(test.data)
+		result := ""
+		if err != nil {
+			result = err.Error()
+		} else {
+			result = strings.Join(testRouteStrings(routes), " ")
+		}
+		if result != test.result {
+			t.Errorf("%s: got '%s', expected '%s'", test.name, result, test.result)
+		}
+	}
+}
+
+func TestParseBirdRoutes(t *testing.T) {
+
+	dump := "" +
+		"BIRD 2.0.8 ready.\n" +
+		"Table master4:\n" +
+		"172.20.0.0/24        unicast [peer1 2024-01-01] * (100) [AS4242420001i]\n" +
+		"\tvia 172.20.0.1 on eth0\n" +
+		"                     unicast [peer2 2024-01-01] (100) [AS4242420002i]\n" +
+		"172.20.0.1/24        unicast [peer1 2024-01-01] * (100) [AS4242420001i]\n" +
+		"172.20.1.0/24        unicast [static1 2024-01-01] * (200)\n" +
+		"fd42:1::/48          unicast [peer1 2024-01-01] * (100) [AS4242420001?]\n" +
+		"not a route\n"
+
+	routes, err := ParseBGPDump([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "172.20.0.0/24 4242420001,172.20.0.0/24 4242420002," +
+		"fd42:1::/48 4242420001"
+	if got := strings.Join(testRouteStrings(routes), ","); got != expected {
+		t.Errorf("got '%s', expected '%s'", got, expected)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,146 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// utility functions for manipulating IP prefixes
+
+// return true if outer contains (or is equal to) inner
+func netContainsNet(outer *net.IPNet, inner *net.IPNet) bool {
+
+	olen, obits := outer.Mask.Size()
+	ilen, ibits := inner.Mask.Size()
+
+	if obits != ibits || ilen < olen {
+		return false
+	}
+
+	return outer.Contains(inner.IP)
+}
+
+// return true if the two networks overlap
+func netOverlaps(a *net.IPNet, b *net.IPNet) bool {
+	return netContainsNet(a, b) || netContainsNet(b, a)
+}
+
+// split a network in to its two halves
+func netSplit(n *net.IPNet) (*net.IPNet, *net.IPNet) {
+
+	plen, bits := n.Mask.Size()
+	mask := net.CIDRMask(plen+1, bits)
+
+	lo := make(net.IP, len(n.IP))
+	copy(lo, n.IP)
+
+	hi := make(net.IP, len(n.IP))
+	copy(hi, n.IP)
+	hi[plen/8] |= 0x80 >> uint(plen%8)
+
+	return &net.IPNet{IP: lo, Mask: mask}, &net.IPNet{IP: hi, Mask: mask}
+}
+
+// return the parent network, one bit shorter
+func netParent(n *net.IPNet) *net.IPNet {
+
+	plen, bits := n.Mask.Size()
+	mask := net.CIDRMask(plen-1, bits)
+
+	return &net.IPNet{IP: n.IP.Mask(mask), Mask: mask}
+}
+
+// return the sibling network that shares the same parent
+func netSibling(n *net.IPNet) *net.IPNet {
+
+	plen, _ := n.Mask.Size()
+
+	ip := make(net.IP, len(n.IP))
+	copy(ip, n.IP)
+	ip[(plen-1)/8] ^= 0x80 >> uint((plen-1)%8)
+
+	return &net.IPNet{IP: ip, Mask: n.Mask}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// address ranges
+
+// parse a prefix, an address or an inetnum style range ('a - b'),
+// returning the first and last addresses in 16 byte form
+func netParseRange(s string) (net.IP, net.IP, bool) {
+
+	s = strings.TrimSpace(s)
+
+	if _, ipnet, err := net.ParseCIDR(s); err == nil {
+		first := ipnet.IP.To16()
+		last := make(net.IP, len(ipnet.IP))
+		for ix := range ipnet.IP {
+			last[ix] = ipnet.IP[ix] | ^ipnet.Mask[ix]
+		}
+		return first, last.To16(), true
+	}
+
+	// addresses never contain a '-', so this must be a range
+	if ix := strings.IndexByte(s, '-'); ix != -1 {
+		first := net.ParseIP(strings.TrimSpace(s[:ix]))
+		last := net.ParseIP(strings.TrimSpace(s[ix+1:]))
+		if first == nil || last == nil ||
+			(first.To4() == nil) != (last.To4() == nil) ||
+			bytes.Compare(first.To16(), last.To16()) > 0 {
+			return nil, nil, false
+		}
+		return first.To16(), last.To16(), true
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16(), ip.To16(), true
+	}
+
+	return nil, nil, false
+}
+
+// return true if the range inner is within (or equal to) the range outer
+func netRangeWithin(ofirst net.IP, olast net.IP,
+	ifirst net.IP, ilast net.IP) bool {
+	return bytes.Compare(ifirst, ofirst) >= 0 && bytes.Compare(ilast, olast) <= 0
+}
+
+//////////////////////////////////////////////////////////////////////////
+// subtract a list of networks from a base network,
+// returning the minimal list of networks that remain
+
+func netSubtract(base *net.IPNet, remove []*net.IPNet) []*net.IPNet {
+
+	// find the networks that overlap with the base
+	overlaps := make([]*net.IPNet, 0, len(remove))
+	for _, r := range remove {
+		if netContainsNet(r, base) {
+			// the whole base network is removed
+			return nil
+		}
+		if netContainsNet(base, r) {
+			overlaps = append(overlaps, r)
+		}
+	}
+
+	// nothing to remove, the whole base remains
+	if len(overlaps) == 0 {
+		return []*net.IPNet{base}
+	}
+
+	// otherwise split the base in half and try again
+	lo, hi := netSplit(base)
+	result := netSubtract(lo, overlaps)
+	return append(result, netSubtract(hi, overlaps)...)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
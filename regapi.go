@@ -7,11 +7,19 @@ package main
 //////////////////////////////////////////////////////////////////////////
 
 import (
-	//	"fmt"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 	//	"time"
 )
 
@@ -22,6 +30,21 @@ type RegMetaReturn struct {
 	Commit string
 }
 
+// the maximum results per page, which is also the default, for
+// listing object names and for returning object data
+const (
+	REG_API_MAX_NAMES   = 10000
+	REG_API_MAX_OBJECTS = 1000
+)
+
+// pagination of results
+type regPage struct {
+	limit int
+	after string // the last reference on the previous page
+	total int
+	next  string // the last reference on this page, if there are more
+}
+
 //////////////////////////////////////////////////////////////////////////
 // register the api
 
@@ -69,7 +92,7 @@ func regMetaHandler(w http.ResponseWriter, r *http.Request) {
 
 	// don't cache
 	w.Header().Set("Cache-Control", "no-store")
-	ResponseJSON(w, rv)
+	(&Response{Data: rv}).Write(w, r)
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -272,9 +295,9 @@ func matchAttribute(attribute *RegAttribute,
 
 // return a map of objects and attribute values that match the filter
 func filterAttributes(ix []*RegKeyIndex, objects []*RegObject,
-	filter string, raw bool) map[string]map[string][]string {
+	filter string) map[string]map[string][]*RegAttribute {
 
-	result := make(map[string]map[string][]string)
+	result := make(map[string]map[string][]*RegAttribute)
 
 	// pre-calculate the search type
 	isExact := true
@@ -306,19 +329,12 @@ func filterAttributes(ix []*RegKeyIndex, objects []*RegObject,
 
 						objmap := result[object.Ref]
 						if objmap == nil {
-							objmap = make(map[string][]string)
+							objmap = make(map[string][]*RegAttribute)
 							result[object.Ref] = objmap
 						}
 
 						// append the result
-						var value *string
-						if raw {
-							value = &attribute.RawValue
-						} else {
-							value = &attribute.Value
-						}
-
-						objmap[keyix.Ref] = append(objmap[keyix.Ref], *value)
+						objmap[keyix.Ref] = append(objmap[keyix.Ref], attribute)
 					}
 				}
 			}
@@ -330,21 +346,310 @@ func filterAttributes(ix []*RegKeyIndex, objects []*RegObject,
 	return result
 }
 
+//////////////////////////////////////////////////////////////////////////
+// link styles
+//
+// raw:        undecorated values, as in the registry
+// markdown:   lookups decorated as markdown style links (the default)
+// structured: attributes as objects, with typed references for lookups
+// hal:        HAL hypermedia, for object queries only
+// jsonapi:    JSON:API hypermedia, for object queries only
+
+func regParseLinks(query url.Values, hypermedia bool) (string, error) {
+
+	links := query.Get("links")
+	switch links {
+	case "":
+		if query["raw"] != nil {
+			return "raw", nil
+		}
+		return "markdown", nil
+
+	case "raw", "markdown", "structured":
+		return links, nil
+
+	case "hal", "jsonapi":
+		if hypermedia {
+			return links, nil
+		}
+		return "", fmt.Errorf("links=%s is only supported for object queries", links)
+	}
+
+	return "", fmt.Errorf("Invalid links '%s', must be raw, markdown, "+
+		"structured, hal or jsonapi", links)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// pagination
+//
+// results are sorted by reference and the cursor for the next page is the
+// last reference on the current page, so that pages remain consistent
+// even if the registry is updated between requests
+
+// parse the limit and cursor parameters, the cursor must refer to an
+// object of one of the listed types, or to a type if rtypes is nil
+func regParsePage(query url.Values, max int,
+	rtypes []*RegType) (*regPage, error) {
+
+	page := &regPage{limit: max}
+
+	if l := query.Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 1 || v > max {
+			return nil, fmt.Errorf("Invalid limit '%s', must be 1 to %d", l, max)
+		}
+		page.limit = v
+	}
+
+	if c := query.Get("cursor"); c != "" {
+		ref, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil || !regValidCursor(string(ref), rtypes) {
+			return nil, fmt.Errorf("Invalid cursor '%s'", c)
+		}
+		page.after = string(ref)
+	}
+
+	return page, nil
+}
+
+// check that a decoded cursor is a reference that can appear in the list
+func regValidCursor(ref string, rtypes []*RegType) bool {
+
+	if ref == "" || !utf8.ValidString(ref) {
+		return false
+	}
+	for _, c := range ref {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+
+	// listing types
+	if rtypes == nil {
+		return !strings.Contains(ref, "/")
+	}
+
+	rtype, name := RegistrySplitPath(ref)
+	if name == "" {
+		return false
+	}
+	for _, t := range rtypes {
+		if t.Ref == rtype {
+			return true
+		}
+	}
+	return false
+}
+
+// return the bounds of the page within a list of sorted references
+func (page *regPage) bounds(refs []string) (int, int) {
+
+	page.total = len(refs)
+
+	start := 0
+	if page.after != "" {
+		after := newRegistrySortKey(page.after)
+		start = sort.Search(len(refs), func(i int) bool {
+			return after.less(newRegistrySortKey(refs[i]))
+		})
+	}
+
+	end := start + page.limit
+	if end < len(refs) {
+		page.next = refs[end-1]
+	} else {
+		end = len(refs)
+	}
+
+	return start, end
+}
+
+// add the total and a link to the next page to the response headers
+func (page *regPage) headers(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+	w.Header().Set("Access-Control-Expose-Headers",
+		"X-Total-Count, X-Next-Cursor, Link")
+
+	if page.next != "" {
+		w.Header().Set("X-Next-Cursor", page.cursor())
+		w.Header().Set("Link", "<"+page.nextURL(r)+">; rel=\"next\"")
+	}
+}
+
+// return a copy of a response with the total number of results added,
+// the original remains as the records for NDJSON
+func (page *regPage) body(response *OrderedJSON) *OrderedJSON {
+	body := NewOrderedJSON()
+	for _, key := range response.keys {
+		body.Set(key, response.values[key])
+	}
+	body.Set("total", page.total)
+	return body
+}
+
+// return the cursor for the next page
+func (page *regPage) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(page.next))
+}
+
+// return the URL of the next page, or an empty string if there isn't one
+func (page *regPage) nextURL(r *http.Request) string {
+	if page.next == "" {
+		return ""
+	}
+
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", page.cursor())
+	next.RawQuery = query.Encode()
+
+	return next.RequestURI()
+}
+
+// return the object references for a page of results from filterAttributes
+func pageAttributes(page *regPage,
+	amap map[string]map[string][]*RegAttribute) []string {
+
+	refs := make([]string, 0, len(amap))
+	for ref := range amap {
+		refs = append(refs, ref)
+	}
+	RegistrySortRefs(refs)
+	start, end := page.bounds(refs)
+
+	return refs[start:end]
+}
+
+// construct the response for a page of results from filterAttributes
+func regAttributesResponse(refs []string,
+	amap map[string]map[string][]*RegAttribute, links string) *OrderedJSON {
+
+	response := NewOrderedJSON()
+	for _, ref := range refs {
+		object := RegistryData.GetObject(ref)
+
+		values := make(map[string]interface{})
+		for key, attributes := range amap[ref] {
+			switch links {
+			case "structured":
+				linked := make([]*RegLinkedAttribute, len(attributes))
+				for ix, attribute := range attributes {
+					linked[ix] = RegistryData.LinkAttribute(object, attribute)
+				}
+				values[key] = linked
+
+			case "raw":
+				raw := make([]string, len(attributes))
+				for ix, attribute := range attributes {
+					raw[ix] = attribute.RawValue
+				}
+				values[key] = raw
+
+			default:
+				decorated := make([]string, len(attributes))
+				for ix, attribute := range attributes {
+					decorated[ix] = attribute.Value
+				}
+				values[key] = decorated
+			}
+		}
+
+		response.Set(ref, values)
+	}
+	return response
+}
+
+//////////////////////////////////////////////////////////////////////////
+// alternative response formats
+
+// write objects as RPSL, separated by blank lines
+func regObjectsRPSL(objects []*RegObject) func(io.Writer) {
+	return func(w io.Writer) {
+		for ix, object := range objects {
+			if ix > 0 {
+				io.WriteString(w, "\n")
+			}
+			WriteRPSL(w, object)
+		}
+	}
+}
+
+// write a page of results from filterAttributes as CSV, with a row per
+// value, values are only decorated for markdown links
+func regAttributesCSV(refs []string,
+	amap map[string]map[string][]*RegAttribute, links string) func(*csv.Writer) {
+	return func(w *csv.Writer) {
+		w.Write([]string{"object", "key", "value"})
+		for _, ref := range refs {
+			attributes := amap[ref]
+
+			keys := make([]string, 0, len(attributes))
+			for key := range attributes {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				for _, attribute := range attributes[key] {
+					value := attribute.RawValue
+					if links == "markdown" {
+						value = attribute.Value
+					}
+					w.Write([]string{ref, key, value})
+				}
+			}
+		}
+	}
+}
+
+// return the references for a list of objects, sorting the objects
+func regSortedRefs(objects []*RegObject) []string {
+	RegistrySortObjects(objects)
+	refs := make([]string, len(objects))
+	for ix, object := range objects {
+		refs[ix] = object.Ref
+	}
+	return refs
+}
+
 //////////////////////////////////////////////////////////////////////////
 // root handler, lists all types within the registry
 
 func regRootHandler(w http.ResponseWriter, r *http.Request) {
 
-	response := make(map[string]int)
+	query := r.URL.Query()
+
+	page, err := regParsePage(query, REG_API_MAX_NAMES, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// names are never decorated, but reject the hypermedia forms
+	if _, err := regParseLinks(query, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	refs := make([]string, 0, len(RegistryData.Types))
 	for _, rType := range RegistryData.Types {
-		response[rType.Ref] = len(rType.Objects)
+		refs = append(refs, rType.Ref)
 	}
+	RegistrySortRefs(refs)
+	start, end := page.bounds(refs)
+
+	response := NewOrderedJSON()
+	for _, ref := range refs[start:end] {
+		response.Set(ref, len(RegistryData.Types[ref].Objects))
+	}
+	page.headers(w, r)
 
 	// cache for up to a day, but set etag to commit to catch changes
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
 	w.Header().Set("ETag", RegistryData.Commit)
 
-	ResponseJSON(w, response)
+	(&Response{Data: page.body(response), Records: response}).Write(w, r)
 
 }
 
@@ -365,23 +670,62 @@ func regTypeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// construct the response
-	response := make(map[string][]string)
-	for _, rtype := range rtypes {
+	query := r.URL.Query()
 
-		objects := make([]string, 0, len(rtype.Objects))
-		for key := range rtype.Objects {
-			objects = append(objects, key)
+	page, err := regParsePage(query, REG_API_MAX_NAMES, rtypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// names are never decorated, but reject the hypermedia forms
+	if _, err := regParseLinks(query, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// page through the names of objects in all the matching types
+	refs := make([]string, 0)
+	for _, rtype := range rtypes {
+		for name := range rtype.Objects {
+			refs = append(refs, RegistryMakePath(rtype.Ref, name))
 		}
+	}
+	RegistrySortRefs(refs)
+	start, end := page.bounds(refs)
+
+	// construct the response
+	names := make(map[string][]string)
+	if len(rtypes) == 1 {
+		// always include an exact match, even if it is empty
+		names[rtypes[0].Ref] = make([]string, 0)
+	}
+	for _, ref := range refs[start:end] {
+		rtype, name := RegistrySplitPath(ref)
+		names[rtype] = append(names[rtype], name)
+	}
+
+	rtnames := make([]string, 0, len(names))
+	for rtype := range names {
+		rtnames = append(rtnames, rtype)
+	}
+	sort.Strings(rtnames)
 
-		response[rtype.Ref] = objects
+	response := NewOrderedJSON()
+	for _, rtype := range rtnames {
+		response.Set(rtype, names[rtype])
 	}
+	page.headers(w, r)
 
 	// cache for up to a day, but set etag to commit to catch changes
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
 	w.Header().Set("ETag", RegistryData.Commit)
 
-	ResponseJSON(w, response)
+	// stream individual references as NDJSON
+	(&Response{
+		Data:    page.body(response),
+		Records: refs[start:end],
+	}).Write(w, r)
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -401,7 +745,6 @@ func regObjectHandler(w http.ResponseWriter, r *http.Request) {
 
 	tFilter := vars["type"]   // type filter
 	oFilter := vars["object"] // object filter
-	raw := query["raw"]       // raw or decorated results
 
 	// select the type(s)
 	rtypes := filterTypes(tFilter)
@@ -419,10 +762,82 @@ func regObjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := regParsePage(query, REG_API_MAX_OBJECTS, rtypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	links, err := regParseLinks(query, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, end := page.bounds(regSortedRefs(objects))
+	objects = objects[start:end]
+	page.headers(w, r)
+
+	// cache for up to a day, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", RegistryData.Commit)
+
 	// collate the results in to the response data
-	if raw == nil {
+	switch links {
+	case "raw":
+		// provide a response with just the raw registry data
+		response := NewOrderedJSON()
+
+		// for each object in the results
+		for _, object := range objects {
+
+			attributes := make([][2]string, len(object.Data))
+			response.Set(object.Ref, attributes)
+
+			// copy the raw attributes
+			for ix, attribute := range object.Data {
+				attributes[ix] = [2]string{attribute.Key, attribute.RawValue}
+			}
+		}
+
+		(&Response{
+			Data:    page.body(response),
+			Records: response,
+			RPSL:    regObjectsRPSL(objects),
+		}).Write(w, r)
+
+	case "structured":
+		// attributes as objects with typed references
+		response := NewOrderedJSON()
+		for _, object := range objects {
+			response.Set(object.Ref, RegistryData.LinkObject(object))
+		}
+
+		(&Response{
+			Data:    page.body(response),
+			Records: response,
+			RPSL:    regObjectsRPSL(objects),
+		}).Write(w, r)
+
+	case "hal":
+		hal := RegistryData.HALCollection(r, page, objects)
+		(&Response{
+			Data:        hal,
+			Records:     hal.Embedded.Objects,
+			ContentType: "application/hal+json",
+		}).Write(w, r)
+
+	case "jsonapi":
+		doc := RegistryData.JSONAPIDocument(r, page, objects)
+		(&Response{
+			Data:        doc,
+			Records:     doc.Data,
+			ContentType: "application/vnd.api+json",
+		}).Write(w, r)
+
+	default:
 		// provide a decorated response
-		response := make(map[string]RegObjectResponse)
+		response := NewOrderedJSON()
 
 		// for each object in the results
 		for _, object := range objects {
@@ -440,39 +855,17 @@ func regObjectHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// add to the response
-			response[object.Ref] = RegObjectResponse{
+			response.Set(object.Ref, RegObjectResponse{
 				Attributes: attributes,
 				Backlinks:  backlinks,
-			}
-		}
-
-		// cache for up to a day, but set etag to commit to catch changes
-		w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
-		w.Header().Set("ETag", RegistryData.Commit)
-
-		ResponseJSON(w, response)
-
-	} else {
-		// provide a response with just the raw registry data
-		response := make(map[string][][2]string)
-
-		// for each object in the results
-		for _, object := range objects {
-
-			attributes := make([][2]string, len(object.Data))
-			response[object.Ref] = attributes
-
-			// copy the raw attributes
-			for ix, attribute := range object.Data {
-				attributes[ix] = [2]string{attribute.Key, attribute.RawValue}
-			}
+			})
 		}
 
-		// cache for up to a day, but set etag to commit to catch changes
-		w.Header().Set("Cache-Control", "public, max-age=7200 stale-if-error=86400")
-		w.Header().Set("ETag", RegistryData.Commit)
-
-		ResponseJSON(w, response)
+		(&Response{
+			Data:    page.body(response),
+			Records: response,
+			RPSL:    regObjectsRPSL(objects),
+		}).Write(w, r)
 	}
 
 }
@@ -489,7 +882,6 @@ func regKeyHandler(w http.ResponseWriter, r *http.Request) {
 	tFilter := vars["type"]   // type filter
 	oFilter := vars["object"] // object filter
 	kFilter := vars["key"]    // key filter
-	raw := query["raw"]       // raw or decorated results
 
 	// select the type(s)
 	rtypes := filterTypes(tFilter)
@@ -515,19 +907,39 @@ func regKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := regParsePage(query, REG_API_MAX_OBJECTS, rtypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	links, err := regParseLinks(query, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// select objects that match the keys
-	amap := filterAttributes(ix, objects, "*", (raw != nil))
+	amap := filterAttributes(ix, objects, "*")
 	if len(amap) == 0 {
 		http.Error(w, "No attributes matching '"+tFilter+"/"+
 			oFilter+"/"+kFilter+"' found", http.StatusNotFound)
 		return
 	}
 
+	refs := pageAttributes(page, amap)
+	page.headers(w, r)
+
 	// cache for up to a day, but set etag to commit to catch changes
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
 	w.Header().Set("ETag", RegistryData.Commit)
 
-	ResponseJSON(w, amap)
+	response := regAttributesResponse(refs, amap, links)
+	(&Response{
+		Data:    page.body(response),
+		Records: response,
+		CSV:     regAttributesCSV(refs, amap, links),
+	}).Write(w, r)
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -543,7 +955,6 @@ func regAttributeHandler(w http.ResponseWriter, r *http.Request) {
 	oFilter := vars["object"]    // object filter
 	kFilter := vars["key"]       // key filter
 	aFilter := vars["attribute"] // attribute filter
-	raw := query["raw"]          // raw or decorated results
 
 	// select the type(s)
 	rtypes := filterTypes(tFilter)
@@ -569,19 +980,39 @@ func regAttributeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := regParsePage(query, REG_API_MAX_OBJECTS, rtypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	links, err := regParseLinks(query, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// select objects that match the keys
-	amap := filterAttributes(ix, objects, aFilter, (raw != nil))
+	amap := filterAttributes(ix, objects, aFilter)
 	if len(amap) == 0 {
 		http.Error(w, "No attributes matching '"+tFilter+"/"+
 			oFilter+"/"+kFilter+"/"+aFilter+"' found", http.StatusNotFound)
 		return
 	}
 
+	refs := pageAttributes(page, amap)
+	page.headers(w, r)
+
 	// cache for up to a day, but set etag to commit to catch changes
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
 	w.Header().Set("ETag", RegistryData.Commit)
 
-	ResponseJSON(w, amap)
+	response := regAttributesResponse(refs, amap, links)
+	(&Response{
+		Data:    page.body(response),
+		Records: response,
+		CSV:     regAttributesCSV(refs, amap, links),
+	}).Write(w, r)
 
 }
 
@@ -0,0 +1,212 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// return a router with the registry API installed on the test registry
+func testRegistryAPI(t *testing.T) *mux.Router {
+
+	saved := RegistryData
+	RegistryData = testLoadRegistry(t)
+	t.Cleanup(func() { RegistryData = saved })
+
+	router := mux.NewRouter()
+	InitRegistryAPI(router)
+	return router
+}
+
+// make a request against the API
+func testAPIRequest(router *mux.Router, url string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// return the cursor for a reference
+func testCursor(ref string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(ref))
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestRegistryAPIPaging(t *testing.T) {
+
+	router := testRegistryAPI(t)
+
+	// follow the next links through every page, one result at a time
+	for _, url := range []string{
+		"/registry/?limit=1",
+		"/registry/route?limit=1",
+		"/registry/*net?limit=1",
+		"/registry/*/*foo?limit=1",
+		"/registry/inetnum/*/mnt-by?limit=1",
+	} {
+		seen := make(map[string]bool)
+		total, pages := -1, 0
+
+		for next := url; next != ""; pages++ {
+			w := testAPIRequest(router, next)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: status %d: %s", next, w.Code, w.Body.String())
+			}
+			if pages > len(RegistryData.Types)+10 {
+				t.Fatalf("%s: paging did not terminate", url)
+			}
+
+			total, _ = strconv.Atoi(w.Header().Get("X-Total-Count"))
+			next = w.Header().Get("Link")
+			if next != "" {
+				next = next[1 : len(next)-len(`>; rel="next"`)]
+			}
+
+			var page map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("%s: %s", next, err)
+			}
+
+			// the total is also returned in the body
+			if count, ok := page["total"].(float64); !ok || int(count) != total {
+				t.Errorf("%s: total %v, expected %d", next, page["total"], total)
+			}
+			delete(page, "total")
+			for key, value := range page {
+				// type listings are keyed by type with a list of names
+				refs := []string{key}
+				if names, ok := value.([]interface{}); ok {
+					refs = refs[:0]
+					for _, name := range names {
+						refs = append(refs, key+"/"+name.(string))
+					}
+				}
+				for _, ref := range refs {
+					if seen[ref] {
+						t.Errorf("%s: %s was returned twice", url, ref)
+					}
+					seen[ref] = true
+				}
+			}
+		}
+
+		if total < 1 || len(seen) != total || pages != total {
+			t.Errorf("%s: %d results in %d pages, expected %d",
+				url, len(seen), pages, total)
+		}
+	}
+
+	// the total is not streamed as a record
+	w := testAPIRequest(router, "/registry/route/*?format=ndjson")
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 ||
+		strings.Contains(w.Body.String(), "total") {
+		t.Errorf("unexpected NDJSON %s", w.Body.String())
+	}
+}
+
+func TestRegistryAPIInvalidCursor(t *testing.T) {
+
+	router := testRegistryAPI(t)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/registry/?cursor=" + testCursor("mntner"), http.StatusOK},
+		{"/registry/route?cursor=" + testCursor("route/172.20.0.0_24"), http.StatusOK},
+		{"/registry/*net?cursor=" + testCursor("inetnum/172.20.0.0_16"), http.StatusOK},
+		{"/registry/route/*?cursor=" + testCursor("route/172.20.0.0_24"), http.StatusOK},
+		// not base64url
+		{"/registry/?cursor=zzz", http.StatusBadRequest},
+		{"/registry/route?cursor=zzz", http.StatusBadRequest},
+		{"/registry/route?cursor=cm91dGU+", http.StatusBadRequest},
+		{"/registry/route/*?cursor=%21%21", http.StatusBadRequest},
+		// not a reference of the listed type
+		{"/registry/?cursor=" + testCursor("route/172.20.0.0_24"), http.StatusBadRequest},
+		{"/registry/route?cursor=" + testCursor("route"), http.StatusBadRequest},
+		{"/registry/route?cursor=" + testCursor("mntner/FOO-MNT"), http.StatusBadRequest},
+		{"/registry/*net?cursor=" + testCursor("route/172.20.0.0_24"), http.StatusBadRequest},
+		{"/registry/route/*?cursor=" + testCursor("route/a/b"), http.StatusBadRequest},
+		{"/registry/route/*/origin?cursor=" + testCursor("route/\x00"), http.StatusBadRequest},
+		{"/registry/route/*/origin/*?cursor=" + testCursor("route/\xff"), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := testAPIRequest(router, test.url)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d: %s", test.url, w.Code,
+				test.status, w.Body.String())
+		}
+	}
+}
+
+func TestRegistryAPILinks(t *testing.T) {
+
+	router := testRegistryAPI(t)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/registry/?links=raw", http.StatusOK},
+		{"/registry/aut-num?links=structured", http.StatusOK},
+		{"/registry/aut-num/AS4242420001?links=hal", http.StatusOK},
+		{"/registry/aut-num/AS4242420001?links=jsonapi", http.StatusOK},
+		// hypermedia is only available for object queries
+		{"/registry/?links=hal", http.StatusBadRequest},
+		{"/registry/?links=jsonapi", http.StatusBadRequest},
+		{"/registry/aut-num?links=hal", http.StatusBadRequest},
+		{"/registry/*?links=jsonapi", http.StatusBadRequest},
+		{"/registry/aut-num/*/mnt-by?links=hal", http.StatusBadRequest},
+		{"/registry/aut-num/*/mnt-by/*?links=jsonapi", http.StatusBadRequest},
+		{"/registry/?links=other", http.StatusBadRequest},
+		{"/registry/aut-num?links=other", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := testAPIRequest(router, test.url)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d: %s", test.url, w.Code,
+				test.status, w.Body.String())
+		}
+	}
+
+	// structured values are not decorated
+	w := testAPIRequest(router, "/registry/aut-num/AS4242420001?links=structured")
+	var response struct {
+		Object *RegLinkedObject `json:"aut-num/AS4242420001"`
+		Total  int              `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	object := response.Object
+	if object == nil || len(object.Attributes) != 5 || response.Total != 1 {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+
+	mntby := object.Attributes[3]
+	if mntby.Key != "mnt-by" || mntby.Value != "FOO-MNT" ||
+		mntby.Raw != "FOO-MNT" || mntby.Ref == nil ||
+		mntby.Ref.URL != "/api/registry/mntner/FOO-MNT" {
+		t.Errorf("unexpected attribute %+v", mntby)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,384 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// relationship graphs
+//
+// Starting from an object, the graph follows the links between objects
+// (attributes that look up other objects), outwards to the objects
+// referenced, inwards via the backlinks or both, to a given depth.
+// Edges always point from the referencing object to the referenced
+// object and are labelled with the attribute key.
+
+const (
+	REG_GRAPH_DEFAULT_DEPTH = 1
+	REG_GRAPH_MAX_DEPTH     = 6
+	REG_GRAPH_MAX_NODES     = 5000
+)
+
+type RegGraphNode struct {
+	Ref   string
+	Type  string
+	Name  string
+	Depth int
+}
+
+type RegGraphEdge struct {
+	From string
+	To   string
+	Key  string
+}
+
+type RegGraph struct {
+	Root      string
+	Commit    string
+	Depth     int
+	Direction string
+	Truncated bool
+	Nodes     []*RegGraphNode
+	Edges     []*RegGraphEdge
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitRegGraphAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitRegGraphAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/graph/{type}/{object}", regGraphHandler).Methods("GET")
+
+	log.Info("Registry graph API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// graph handler
+
+func regGraphHandler(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	depth := REG_GRAPH_DEFAULT_DEPTH
+	if d := query.Get("depth"); d != "" {
+		v, err := strconv.Atoi(d)
+		if err != nil || v < 1 || v > REG_GRAPH_MAX_DEPTH {
+			http.Error(w, fmt.Sprintf("Invalid depth '%s', must be 1 to %d",
+				d, REG_GRAPH_MAX_DEPTH), http.StatusBadRequest)
+			return
+		}
+		depth = v
+	}
+
+	direction := query.Get("direction")
+	switch direction {
+	case "":
+		direction = "out"
+	case "out", "in", "both":
+	default:
+		http.Error(w, "Invalid direction '"+direction+
+			"', must be out, in or both", http.StatusBadRequest)
+		return
+	}
+
+	registry := RegistryData
+	ref := RegistryMakePath(vars["type"], vars["object"])
+	object := registry.GetObject(ref)
+	if object == nil {
+		http.Error(w, "No object '"+ref+"' found", http.StatusNotFound)
+		return
+	}
+
+	graph := registry.Graph(object, depth, direction)
+
+	// cache for up to a day, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", registry.Commit)
+
+	response := &Response{
+		Data:    graph,
+		Records: graph.Nodes,
+		Formats: map[string]*ResponseFormat{
+			"dot": {
+				ContentType: "text/vnd.graphviz",
+				Write: func(w io.Writer) error {
+					_, err := io.WriteString(w, graph.DOT())
+					return err
+				},
+			},
+			"graphml": {
+				ContentType: "application/graphml+xml",
+				Write: func(w io.Writer) error {
+					data, err := graph.GraphML()
+					if err == nil {
+						_, err = w.Write(data)
+					}
+					return err
+				},
+			},
+		},
+	}
+	response.Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// traverse the registry from an object, returning the subgraph reachable
+// within depth links
+
+func (registry *Registry) Graph(root *RegObject, depth int,
+	direction string) *RegGraph {
+
+	graph := &RegGraph{
+		Root:      root.Ref,
+		Commit:    registry.Commit,
+		Depth:     depth,
+		Direction: direction,
+		Nodes:     make([]*RegGraphNode, 0),
+		Edges:     make([]*RegGraphEdge, 0),
+	}
+
+	nodes := make(map[*RegObject]*RegGraphNode)
+	edges := make(map[RegGraphEdge]bool)
+
+	// add a node, returning false if the graph is full
+	visit := func(object *RegObject, d int) bool {
+		if nodes[object] != nil {
+			return true
+		}
+		if len(nodes) >= REG_GRAPH_MAX_NODES {
+			graph.Truncated = true
+			return false
+		}
+		rtype, name := RegistrySplitPath(object.Ref)
+		node := &RegGraphNode{
+			Ref:   object.Ref,
+			Type:  rtype,
+			Name:  name,
+			Depth: d,
+		}
+		nodes[object] = node
+		graph.Nodes = append(graph.Nodes, node)
+		return true
+	}
+
+	edge := func(from *RegObject, to *RegObject, key string) {
+		e := RegGraphEdge{From: from.Ref, To: to.Ref, Key: key}
+		if !edges[e] {
+			edges[e] = true
+			graph.Edges = append(graph.Edges, &e)
+		}
+	}
+
+	// breadth first, so that nodes are found at their shortest distance
+	visit(root, 0)
+	frontier := []*RegObject{root}
+
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		next := make([]*RegObject, 0)
+		add := func(object *RegObject) bool {
+			if nodes[object] != nil {
+				return true
+			}
+			if !visit(object, d) {
+				return false
+			}
+			next = append(next, object)
+			return true
+		}
+
+		for _, object := range frontier {
+
+			if direction != "in" {
+				for _, attribute := range object.Data {
+					related := registry.GetRelation(object, attribute)
+					if related != nil && add(related) {
+						edge(object, related, attribute.Key)
+					}
+				}
+			}
+
+			if direction != "out" {
+				for _, backlink := range object.Backlinks {
+					for _, attribute := range backlink.Data {
+						if registry.GetRelation(backlink, attribute) == object &&
+							add(backlink) {
+							edge(backlink, object, attribute.Key)
+						}
+					}
+				}
+			}
+		}
+
+		frontier = next
+	}
+
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		if graph.Nodes[i].Depth != graph.Nodes[j].Depth {
+			return graph.Nodes[i].Depth < graph.Nodes[j].Depth
+		}
+		return graph.Nodes[i].Ref < graph.Nodes[j].Ref
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		ei, ej := graph.Edges[i], graph.Edges[j]
+		if ei.From != ej.From {
+			return ei.From < ej.From
+		}
+		if ei.To != ej.To {
+			return ei.To < ej.To
+		}
+		return ei.Key < ej.Key
+	})
+
+	return graph
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Graphviz DOT output
+
+// quote a DOT identifier
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func (graph *RegGraph) DOT() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "// dn42regsrv graph of %s, depth %d, direction %s\n",
+		graph.Root, graph.Depth, graph.Direction)
+	fmt.Fprintf(&b, "// Commit: %s\n", graph.Commit)
+	if graph.Truncated {
+		fmt.Fprintf(&b, "// Truncated at %d nodes\n", REG_GRAPH_MAX_NODES)
+	}
+
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(graph.Root))
+	b.WriteString("  node [shape=box];\n")
+
+	for _, node := range graph.Nodes {
+		attrs := ""
+		if node.Ref == graph.Root {
+			attrs = ", style=bold"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s%s];\n", dotQuote(node.Ref),
+			dotQuote(node.Type+"\n"+node.Name), attrs)
+	}
+
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", dotQuote(edge.From),
+			dotQuote(edge.To), dotQuote(edge.Key))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// GraphML output
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	Name     string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+func (graph *RegGraph) GraphML() ([]byte, error) {
+
+	doc := &graphMLDocument{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "type", For: "node", Name: "type", AttrType: "string"},
+			{ID: "name", For: "node", Name: "name", AttrType: "string"},
+			{ID: "depth", For: "node", Name: "depth", AttrType: "int"},
+			{ID: "key", For: "edge", Name: "key", AttrType: "string"},
+		},
+		Graph: graphMLGraph{
+			ID:          graph.Root,
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, 0, len(graph.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(graph.Edges)),
+		},
+	}
+
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: node.Ref,
+			Data: []graphMLData{
+				{Key: "type", Value: node.Type},
+				{Key: "name", Value: node.Name},
+				{Key: "depth", Value: strconv.Itoa(node.Depth)},
+			},
+		})
+	}
+
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.From,
+			Target: edge.To,
+			Data:   []graphMLData{{Key: "key", Value: edge.Key}},
+		})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,382 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// summarise the nodes and edges in a graph
+func testGraphStrings(graph *RegGraph) ([]string, []string) {
+
+	nodes := make([]string, len(graph.Nodes))
+	for ix, node := range graph.Nodes {
+		nodes[ix] = fmt.Sprintf("%d %s", node.Depth, node.Ref)
+	}
+	edges := make([]string, len(graph.Edges))
+	for ix, edge := range graph.Edges {
+		edges[ix] = edge.From + " -> " + edge.To + " " + edge.Key
+	}
+	return nodes, edges
+}
+
+// check that a graph is consistent
+func testGraphConsistent(t *testing.T, graph *RegGraph) {
+
+	nodes := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		if nodes[node.Ref] {
+			t.Errorf("%s: duplicate node %s", graph.Root, node.Ref)
+		}
+		nodes[node.Ref] = true
+		if node.Depth > graph.Depth {
+			t.Errorf("%s: node %s at depth %d", graph.Root, node.Ref, node.Depth)
+		}
+	}
+	for _, edge := range graph.Edges {
+		if !nodes[edge.From] || !nodes[edge.To] {
+			t.Errorf("%s: edge %+v is not between nodes", graph.Root, edge)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestRegGraph(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+
+	tests := []struct {
+		ref       string
+		depth     int
+		direction string
+		nodes     []string
+		edges     []string
+	}{
+		{"aut-num/AS4242420001", 1, "out",
+			[]string{
+				"0 aut-num/AS4242420001",
+				"1 mntner/FOO-MNT",
+				"1 person/FOO-DN42",
+			},
+			[]string{
+				"aut-num/AS4242420001 -> mntner/FOO-MNT mnt-by",
+				"aut-num/AS4242420001 -> person/FOO-DN42 admin-c",
+			}},
+		// links between nodes already found are included
+		{"aut-num/AS4242420001", 2, "out",
+			[]string{
+				"0 aut-num/AS4242420001",
+				"1 mntner/FOO-MNT",
+				"1 person/FOO-DN42",
+			},
+			[]string{
+				"aut-num/AS4242420001 -> mntner/FOO-MNT mnt-by",
+				"aut-num/AS4242420001 -> person/FOO-DN42 admin-c",
+				"mntner/FOO-MNT -> mntner/FOO-MNT mnt-by",
+				"mntner/FOO-MNT -> person/FOO-DN42 admin-c",
+				"person/FOO-DN42 -> mntner/FOO-MNT mnt-by",
+			}},
+		// inward edges still point to the referenced object
+		{"mntner/BAR-MNT", 1, "in",
+			[]string{
+				"0 mntner/BAR-MNT",
+				"1 domain/bar.dn42",
+				"1 inetnum/172.20.1.160_27",
+			},
+			[]string{
+				"domain/bar.dn42 -> mntner/BAR-MNT mnt-by",
+				"inetnum/172.20.1.160_27 -> mntner/BAR-MNT mnt-by",
+				"mntner/BAR-MNT -> mntner/BAR-MNT mnt-by",
+			}},
+		{"person/FOO-DN42", 1, "both",
+			[]string{
+				"0 person/FOO-DN42",
+				"1 aut-num/AS4242420001",
+				"1 aut-num/AS4242420010",
+				"1 mntner/FOO-MNT",
+			},
+			[]string{
+				"aut-num/AS4242420001 -> person/FOO-DN42 admin-c",
+				"aut-num/AS4242420010 -> person/FOO-DN42 admin-c",
+				"mntner/FOO-MNT -> person/FOO-DN42 admin-c",
+				"person/FOO-DN42 -> mntner/FOO-MNT mnt-by",
+			}},
+		// dangling lookups are not followed
+		{"person/BAR-DN42", 3, "out",
+			[]string{"0 person/BAR-DN42"},
+			[]string{}},
+	}
+
+	for _, test := range tests {
+		graph := registry.Graph(registry.GetObject(test.ref), test.depth,
+			test.direction)
+		testGraphConsistent(t, graph)
+
+		nodes, edges := testGraphStrings(graph)
+		if strings.Join(nodes, "\n") != strings.Join(test.nodes, "\n") {
+			t.Errorf("%s %d %s: nodes:\n%s", test.ref, test.depth,
+				test.direction, strings.Join(nodes, "\n"))
+		}
+		if strings.Join(edges, "\n") != strings.Join(test.edges, "\n") {
+			t.Errorf("%s %d %s: edges:\n%s", test.ref, test.depth,
+				test.direction, strings.Join(edges, "\n"))
+		}
+		if graph.Truncated || graph.Commit != testRegistryCommit {
+			t.Errorf("%s: unexpected graph %+v", test.ref, graph)
+		}
+	}
+
+	// extra levels find more of the registry, at the shortest distance,
+	// until everything that refers to the object has been found
+	for _, test := range []struct{ depth, count int }{{1, 4}, {2, 22}, {3, 22}} {
+		graph := registry.Graph(registry.GetObject("person/FOO-DN42"),
+			test.depth, "in")
+		testGraphConsistent(t, graph)
+		if len(graph.Nodes) != test.count {
+			t.Errorf("depth %d: %d nodes, expected %d", test.depth,
+				len(graph.Nodes), test.count)
+		}
+
+		for _, node := range graph.Nodes {
+			if node.Ref == "aut-num/AS4242420001" && node.Depth != 1 {
+				t.Errorf("depth %d: %s found at %d", test.depth, node.Ref,
+					node.Depth)
+			}
+		}
+	}
+}
+
+func TestRegGraphTruncated(t *testing.T) {
+
+	// add enough objects maintained by BAR-MNT to fill the graph
+	data := testCopyRegistry(t)
+	for ix := 0; ix < REG_GRAPH_MAX_NODES; ix++ {
+		name := fmt.Sprintf("TEST%d-DN42", ix)
+		content := fmt.Sprintf("person:             Test %d\n"+
+			"nic-hdl:            %s\n"+
+			"mnt-by:             BAR-MNT\n"+
+			"source:             DN42\n", ix, name)
+		err := ioutil.WriteFile(filepath.Join(data, "person", name),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry := LoadRegistry(data, testRegistryCommit)
+
+	graph := registry.Graph(registry.GetObject("mntner/BAR-MNT"), 1, "in")
+	testGraphConsistent(t, graph)
+	if !graph.Truncated || len(graph.Nodes) != REG_GRAPH_MAX_NODES ||
+		graph.Nodes[0].Ref != "mntner/BAR-MNT" {
+		t.Errorf("graph not truncated: %t, %d nodes", graph.Truncated,
+			len(graph.Nodes))
+	}
+	if !strings.Contains(graph.DOT(), fmt.Sprintf("// Truncated at %d nodes\n",
+		REG_GRAPH_MAX_NODES)) {
+		t.Error("DOT output does not show the truncation")
+	}
+
+	// the other direction is unaffected
+	graph = registry.Graph(registry.GetObject("mntner/BAR-MNT"), 1, "out")
+	if graph.Truncated || len(graph.Nodes) != 1 {
+		t.Errorf("unexpected graph: %t, %d nodes", graph.Truncated,
+			len(graph.Nodes))
+	}
+}
+
+func TestRegGraphDOT(t *testing.T) {
+
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"mntner/FOO-MNT", `"mntner/FOO-MNT"`},
+		{"", `""`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`back\slash`, `"back\\slash"`},
+		{"two\nlines", `"two\nlines"`},
+		{`\"` + "\n", `"\\\"\n"`},
+	}
+
+	for _, test := range tests {
+		if q := dotQuote(test.s); q != test.expected {
+			t.Errorf("%q: got %s, expected %s", test.s, q, test.expected)
+		}
+	}
+
+	registry := testLoadRegistry(t)
+	graph := registry.Graph(registry.GetObject("aut-num/AS4242420001"), 1, "out")
+
+	expected := "" +
+		"// dn42regsrv graph of aut-num/AS4242420001, depth 1, direction out\n" +
+		"// Commit: " + testRegistryCommit + "\n" +
+		"digraph \"aut-num/AS4242420001\" {\n" +
+		"  node [shape=box];\n" +
+		"  \"aut-num/AS4242420001\" [label=\"aut-num\\nAS4242420001\", style=bold];\n" +
+		"  \"mntner/FOO-MNT\" [label=\"mntner\\nFOO-MNT\"];\n" +
+		"  \"person/FOO-DN42\" [label=\"person\\nFOO-DN42\"];\n" +
+		"  \"aut-num/AS4242420001\" -> \"mntner/FOO-MNT\" [label=\"mnt-by\"];\n" +
+		"  \"aut-num/AS4242420001\" -> \"person/FOO-DN42\" [label=\"admin-c\"];\n" +
+		"}\n"
+
+	if dot := graph.DOT(); dot != expected {
+		t.Errorf("unexpected DOT:\n%s\nexpected:\n%s", dot, expected)
+	}
+}
+
+func TestRegGraphGraphML(t *testing.T) {
+
+	// names that need escaping in XML
+	graph := &RegGraph{
+		Root: `test/<a & "b">`,
+		Nodes: []*RegGraphNode{
+			{Ref: `test/<a & "b">`, Type: "test", Name: `<a & "b">`},
+			{Ref: "test/c'd", Type: "test", Name: "c'd", Depth: 1},
+		},
+		Edges: []*RegGraphEdge{
+			{From: `test/<a & "b">`, To: "test/c'd", Key: "x-<key>"},
+		},
+	}
+
+	data, err := graph.GraphML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(xml.Header+"<graphml ")) {
+		t.Errorf("unexpected header %s", data)
+	}
+
+	// the document is well formed
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s in %s", err, data)
+		}
+	}
+
+	// and decodes back to the same graph
+	var doc struct {
+		XMLName xml.Name `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+		Keys    []struct {
+			ID  string `xml:"id,attr"`
+			For string `xml:"for,attr"`
+		} `xml:"key"`
+		Graph struct {
+			ID          string `xml:"id,attr"`
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []struct {
+				ID   string        `xml:"id,attr"`
+				Data []graphMLData `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string        `xml:"source,attr"`
+				Target string        `xml:"target,attr"`
+				Data   []graphMLData `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Keys) != 4 || doc.Graph.ID != graph.Root ||
+		doc.Graph.EdgeDefault != "directed" || len(doc.Graph.Nodes) != 2 ||
+		len(doc.Graph.Edges) != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+
+	node := doc.Graph.Nodes[0]
+	if node.ID != `test/<a & "b">` || len(node.Data) != 3 ||
+		node.Data[1].Key != "name" || node.Data[1].Value != `<a & "b">` {
+		t.Errorf("unexpected node %+v", node)
+	}
+	if node := doc.Graph.Nodes[1]; node.Data[2].Key != "depth" ||
+		node.Data[2].Value != "1" {
+		t.Errorf("unexpected node %+v", node)
+	}
+
+	edge := doc.Graph.Edges[0]
+	if edge.Source != `test/<a & "b">` || edge.Target != "test/c'd" ||
+		len(edge.Data) != 1 || edge.Data[0].Value != "x-<key>" {
+		t.Errorf("unexpected edge %+v", edge)
+	}
+}
+
+func TestRegGraphAPI(t *testing.T) {
+
+	saved := RegistryData
+	RegistryData = testLoadRegistry(t)
+	t.Cleanup(func() { RegistryData = saved })
+
+	router := mux.NewRouter()
+	InitRegGraphAPI(router)
+
+	tests := []struct {
+		url    string
+		accept string
+		status int
+		ctype  string
+	}{
+		{"/graph/aut-num/AS4242420001", "", http.StatusOK, "application/json"},
+		{"/graph/aut-num/AS4242420001?format=dot", "", http.StatusOK,
+			"text/vnd.graphviz"},
+		{"/graph/aut-num/AS4242420001?format=graphml", "", http.StatusOK,
+			"application/graphml+xml"},
+		{"/graph/aut-num/AS4242420001", "application/graphml+xml", http.StatusOK,
+			"application/graphml+xml"},
+		{"/graph/aut-num/AS4242420001?format=yaml", "", http.StatusOK,
+			"application/yaml"},
+		{"/graph/aut-num/AS4242420001?format=ndjson", "", http.StatusOK,
+			"application/x-ndjson"},
+		{"/graph/aut-num/AS4242420001?format=csv", "", http.StatusBadRequest, ""},
+		{"/graph/aut-num/AS4242420001?depth=0", "", http.StatusBadRequest, ""},
+		{"/graph/aut-num/AS4242420001?depth=7", "", http.StatusBadRequest, ""},
+		{"/graph/aut-num/AS4242420001?direction=up", "", http.StatusBadRequest, ""},
+		{"/graph/aut-num/AS4242429999", "", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != test.status || (test.ctype != "" &&
+			w.Header().Get("Content-Type") != test.ctype) {
+			t.Errorf("%s: %d %s", test.url, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// the nodes are streamed as NDJSON
+	w := testAPIRequest(router, "/graph/aut-num/AS4242420001?format=ndjson")
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 {
+		t.Errorf("unexpected NDJSON %s", w.Body.String())
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,1436 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// GraphQL API
+//
+// The GraphQL schema is generated from the registry schema each time the
+// registry is reloaded. Each registry type becomes an object type with
+// a field for each key. Keys that look up other objects become object
+// typed fields, and the reverse of each lookup becomes a '<type>_via_<key>'
+// field on the referenced type, built from the object backlinks.
+// All types implement the Object interface, which has generic fields for
+// the object reference, attributes and backlinks.
+//
+// Fragment type conditions are checked against the type of the field
+// they are used in before the query is executed, so that a fragment that
+// can never apply is an error rather than being silently ignored.
+
+const (
+	GQL_OBJECT_INTERFACE  = "Object"
+	GQL_ATTRIBUTE_TYPE    = "Attribute"
+	GQL_QUERY_TYPE        = "Query"
+	GQL_MAX_REQUEST       = 64 * 1024
+	GQL_MAX_LIST_LIMIT    = 1000
+	GQL_DEFAULT_LIST_SIZE = 100
+)
+
+// limits on queries
+var GraphQLMaxDepth = 10
+var GraphQLMaxCost = 20000
+
+type GraphQLSchema struct {
+	registry  *Registry
+	types     map[string]*gqlType // by GraphQL name
+	byRegType map[string]*gqlType // by registry type
+	query     *gqlType
+	object    *gqlType
+	attribute *gqlType
+	meta      map[string]*gqlField // introspection fields on the query root
+}
+
+var RegGraphQLSchema *GraphQLSchema
+
+type gqlResolver func(ex *gqlExec, parent interface{},
+	args map[string]interface{}) (interface{}, error)
+
+type gqlType struct {
+	name       string
+	kind       string // 'type' or 'interface'
+	implements string
+	fields     []*gqlField
+	index      map[string]*gqlField
+	values     []string // for enums
+}
+
+type gqlField struct {
+	name    string
+	args    []*gqlArgDef
+	ftype   string   // the field type, as written in SDL
+	target  *gqlType // the type of object fields, nil for scalars
+	resolve gqlResolver
+}
+
+type gqlArgDef struct {
+	name   string
+	atype  string
+	defval interface{}
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type GraphQLResponse struct {
+	Data   *OrderedJSON    `json:"data,omitempty"`
+	Errors []*GraphQLError `json:"errors,omitempty"`
+}
+
+// the state of executing a query
+type gqlExec struct {
+	schema    *GraphQLSchema
+	doc       *gqlDocument
+	variables map[string]interface{}
+	cost      int
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitGraphQLAPI)
+	EventBus.Listen("RegistryUpdate", GraphQLUpdate)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to set the query limits
+
+func InitialiseGraphQL(maxDepth int, maxCost int) {
+
+	GraphQLMaxDepth = maxDepth
+	GraphQLMaxCost = maxCost
+
+	log.WithFields(log.Fields{
+		"depth": maxDepth,
+		"cost":  maxCost,
+	}).Debug("GraphQL limits set")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitGraphQLAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/graphql", graphqlHandler).
+		Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/graphql/schema", graphqlSchemaHandler).
+		Methods("GET")
+
+	log.Info("GraphQL API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// regenerate the schema when the registry is updated
+
+func GraphQLUpdate(params ...interface{}) {
+
+	registry := params[0].(*Registry)
+	schema := BuildGraphQLSchema(registry)
+
+	log.WithFields(log.Fields{
+		"commit": registry.Commit,
+		"types":  len(schema.types),
+	}).Info("GraphQL schema updated")
+
+	RegGraphQLSchema = schema
+}
+
+//////////////////////////////////////////////////////////////////////////
+// api handlers
+
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+
+	// allow cross origin POST requests
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	request := &GraphQLRequest{}
+
+	if r.Method == "GET" {
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &request.Variables); err != nil {
+				http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, GQL_MAX_REQUEST))
+		if err != nil {
+			http.Error(w, "Unable to read request: "+err.Error(),
+				http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			request.Query = string(body)
+		} else if err := json.Unmarshal(body, request); err != nil {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if strings.TrimSpace(request.Query) == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+
+	schema := RegGraphQLSchema
+	response := schema.Execute(request)
+
+	if len(response.Errors) > 0 {
+		log.WithFields(log.Fields{
+			"error": response.Errors[0].Message,
+		}).Debug("GraphQL query failed")
+	}
+
+	if r.Method == "GET" {
+		// cache for up to a day, but set etag to commit to catch changes
+		w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+		w.Header().Set("ETag", schema.registry.Commit)
+	}
+
+	ResponseJSON(w, response)
+}
+
+// return the schema in SDL format
+func graphqlSchemaHandler(w http.ResponseWriter, r *http.Request) {
+
+	schema := RegGraphQLSchema
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", schema.registry.Commit)
+
+	w.Write([]byte(schema.SDL()))
+}
+
+//////////////////////////////////////////////////////////////////////////
+// schema generation
+
+// convert a registry type to a GraphQL type name, e.g. aut-num -> AutNum
+func gqlTypeName(ref string) string {
+
+	var b strings.Builder
+	upper := true
+	for _, c := range ref {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		b.WriteRune(c)
+	}
+
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "T" + name
+	}
+
+	// avoid the built in types
+	switch name {
+	case GQL_QUERY_TYPE, GQL_OBJECT_INTERFACE, GQL_ATTRIBUTE_TYPE,
+		"String", "Int", "Float", "Boolean", "ID":
+		name += "Type"
+	}
+	return name
+}
+
+// convert a registry key to a GraphQL field name, e.g. mnt-by -> mnt_by
+func gqlFieldName(key string) string {
+	name := strings.Map(func(c rune) rune {
+		if c < unicode.MaxASCII && (c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return c
+		}
+		return '_'
+	}, key)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+func newGQLType(name string, kind string) *gqlType {
+	return &gqlType{
+		name:   name,
+		kind:   kind,
+		fields: make([]*gqlField, 0),
+		index:  make(map[string]*gqlField),
+	}
+}
+
+// add a field to a type, ignoring duplicates
+func (t *gqlType) add(field *gqlField) {
+	if t.index[field.name] != nil {
+		log.WithFields(log.Fields{
+			"type":  t.name,
+			"field": field.name,
+		}).Debug("Ignoring duplicate GraphQL field")
+		return
+	}
+	t.fields = append(t.fields, field)
+	t.index[field.name] = field
+}
+
+// generate a GraphQL schema from the registry
+func BuildGraphQLSchema(registry *Registry) *GraphQLSchema {
+
+	schema := &GraphQLSchema{
+		registry:  registry,
+		types:     make(map[string]*gqlType),
+		byRegType: make(map[string]*gqlType),
+		query:     newGQLType(GQL_QUERY_TYPE, "type"),
+		object:    newGQLType(GQL_OBJECT_INTERFACE, "interface"),
+		attribute: newGQLType(GQL_ATTRIBUTE_TYPE, "type"),
+	}
+	schema.types[GQL_QUERY_TYPE] = schema.query
+	schema.types[GQL_OBJECT_INTERFACE] = schema.object
+	schema.types[GQL_ATTRIBUTE_TYPE] = schema.attribute
+
+	schema.attribute.add(&gqlField{name: "key", ftype: "String!",
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return parent.(*RegAttribute).Key, nil
+		}})
+	schema.attribute.add(&gqlField{name: "value", ftype: "String!",
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return parent.(*RegAttribute).RawValue, nil
+		}})
+
+	for _, field := range schema.commonFields() {
+		schema.object.add(field)
+	}
+
+	// create the types first, so that relations can refer to them
+	typeNames := make([]string, 0, len(registry.Types))
+	for typeName := range registry.Types {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		t := newGQLType(gqlTypeName(typeName), "type")
+		t.implements = GQL_OBJECT_INTERFACE
+		if schema.types[t.name] != nil {
+			log.WithFields(log.Fields{
+				"type": typeName,
+				"name": t.name,
+			}).Error("GraphQL type name clash, ignoring registry type")
+			continue
+		}
+		schema.types[t.name] = t
+		schema.byRegType[typeName] = t
+	}
+
+	// then the fields for each type
+	for _, typeName := range typeNames {
+		t := schema.byRegType[typeName]
+		if t == nil {
+			continue
+		}
+		for _, field := range schema.commonFields() {
+			t.add(field)
+		}
+		if rtschema := registry.Schema[typeName]; rtschema != nil {
+			schema.addKeyFields(t, rtschema)
+		}
+	}
+
+	// and the reverse fields, in a separate pass so they follow the keys
+	for _, typeName := range typeNames {
+		if rtschema := registry.Schema[typeName]; rtschema != nil {
+			schema.addReverseFields(typeName, rtschema)
+		}
+	}
+
+	schema.addQueryFields(typeNames)
+	schema.addIntrospection()
+
+	return schema
+}
+
+// fields that are available on every object
+func (schema *GraphQLSchema) commonFields() []*gqlField {
+	return []*gqlField{
+		&gqlField{name: "_ref", ftype: "String!",
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				return parent.(*RegObject).Ref, nil
+			}},
+		&gqlField{name: "_type", ftype: "String!",
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				t, _ := RegistrySplitPath(parent.(*RegObject).Ref)
+				return t, nil
+			}},
+		&gqlField{name: "_name", ftype: "String!",
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				_, name := RegistrySplitPath(parent.(*RegObject).Ref)
+				return name, nil
+			}},
+		&gqlField{name: "_attributes", ftype: "[Attribute!]!",
+			target: schema.attribute,
+			args:   []*gqlArgDef{&gqlArgDef{name: "keys", atype: "[String!]"}},
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				keys, _ := args["keys"].([]interface{})
+				attributes := make([]*RegAttribute, 0)
+				for _, attribute := range parent.(*RegObject).Data {
+					if keys == nil || gqlContains(keys, attribute.Key) {
+						attributes = append(attributes, attribute)
+					}
+				}
+				return attributes, nil
+			}},
+		&gqlField{name: "_values", ftype: "[String!]!",
+			args: []*gqlArgDef{&gqlArgDef{name: "key", atype: "String!"}},
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				return gqlValues(parent.(*RegObject), args["key"].(string)), nil
+			}},
+		&gqlField{name: "_backlinks", ftype: "[Object!]!",
+			target: schema.object,
+			args:   []*gqlArgDef{&gqlArgDef{name: "type", atype: "String"}},
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				rtype, _ := args["type"].(string)
+				objects := make([]*RegObject, 0)
+				for _, object := range parent.(*RegObject).Backlinks {
+					if t, _ := RegistrySplitPath(object.Ref); rtype == "" || t == rtype {
+						objects = append(objects, object)
+					}
+				}
+				gqlSortObjects(objects)
+				return objects, nil
+			}},
+	}
+}
+
+// add fields for each of the keys in a registry type
+func (schema *GraphQLSchema) addKeyFields(t *gqlType, rtschema *RegTypeSchema) {
+
+	keys := make([]string, 0, len(rtschema.Attributes))
+	for key := range rtschema.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		key := key
+		attribute := rtschema.Attributes[key]
+		relations := attribute.Relations
+		single := false
+		for _, f := range attribute.Fields {
+			if f == "single" {
+				single = true
+			}
+		}
+
+		field := &gqlField{name: gqlFieldName(key)}
+
+		// lookups return the related objects
+		if len(relations) > 0 {
+			field.target = schema.object
+			if len(relations) == 1 && schema.byRegType[relations[0].Ref] != nil {
+				field.target = schema.byRegType[relations[0].Ref]
+			}
+
+			if single {
+				field.ftype = field.target.name
+				field.resolve = func(ex *gqlExec, parent interface{},
+					args map[string]interface{}) (interface{}, error) {
+					objects := gqlRelated(parent.(*RegObject), key, relations)
+					if len(objects) == 0 {
+						return (*RegObject)(nil), nil
+					}
+					return objects[0], nil
+				}
+			} else {
+				field.ftype = "[" + field.target.name + "!]!"
+				field.resolve = func(ex *gqlExec, parent interface{},
+					args map[string]interface{}) (interface{}, error) {
+					return gqlRelated(parent.(*RegObject), key, relations), nil
+				}
+			}
+
+		} else if single {
+			field.ftype = "String"
+			field.resolve = func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				values := gqlValues(parent.(*RegObject), key)
+				if len(values) == 0 {
+					return nil, nil
+				}
+				return values[0], nil
+			}
+
+		} else {
+			field.ftype = "[String!]!"
+			field.resolve = func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				return gqlValues(parent.(*RegObject), key), nil
+			}
+		}
+
+		t.add(field)
+	}
+}
+
+// add the reverse of each lookup in a registry type to the related types
+func (schema *GraphQLSchema) addReverseFields(typeName string,
+	rtschema *RegTypeSchema) {
+
+	source := schema.byRegType[typeName]
+	if source == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(rtschema.Attributes))
+	for key := range rtschema.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		key := key
+		relations := rtschema.Attributes[key].Relations
+
+		for _, relation := range relations {
+			t := schema.byRegType[relation.Ref]
+			if t == nil {
+				continue
+			}
+
+			t.add(&gqlField{
+				name:   gqlFieldName(typeName) + "_via_" + gqlFieldName(key),
+				ftype:  "[" + source.name + "!]!",
+				target: source,
+				resolve: func(ex *gqlExec, parent interface{},
+					args map[string]interface{}) (interface{}, error) {
+					target := parent.(*RegObject)
+					objects := make([]*RegObject, 0)
+					for _, object := range target.Backlinks {
+						if t, _ := RegistrySplitPath(object.Ref); t != typeName {
+							continue
+						}
+						for _, related := range gqlRelated(object, key, relations) {
+							if related == target {
+								objects = append(objects, object)
+								break
+							}
+						}
+					}
+					gqlSortObjects(objects)
+					return objects, nil
+				},
+			})
+		}
+	}
+}
+
+// add the root query fields
+func (schema *GraphQLSchema) addQueryFields(typeNames []string) {
+
+	registry := schema.registry
+	paging := func(limit int) []*gqlArgDef {
+		return []*gqlArgDef{
+			&gqlArgDef{name: "offset", atype: "Int", defval: 0},
+			&gqlArgDef{name: "limit", atype: "Int", defval: limit},
+		}
+	}
+
+	for _, typeName := range typeNames {
+		rtype := registry.Types[typeName]
+		t := schema.byRegType[typeName]
+		if t == nil {
+			continue
+		}
+
+		// a single object by name
+		schema.query.add(&gqlField{
+			name:   gqlFieldName(typeName),
+			ftype:  t.name,
+			target: t,
+			args:   []*gqlArgDef{&gqlArgDef{name: "name", atype: "String!"}},
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				// allow prefixes to be given with a '/'
+				name := strings.Replace(args["name"].(string), "/", "_", 1)
+				return rtype.Objects[name], nil
+			},
+		})
+
+		// and a list of objects, optionally matching a name
+		schema.query.add(&gqlField{
+			name:   gqlFieldName(typeName) + "_list",
+			ftype:  "[" + t.name + "!]!",
+			target: t,
+			args: append([]*gqlArgDef{&gqlArgDef{name: "match", atype: "String"}},
+				paging(GQL_DEFAULT_LIST_SIZE)...),
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				match, _ := args["match"].(string)
+				match = strings.ToLower(match)
+				objects := make([]*RegObject, 0)
+				for name, object := range rtype.Objects {
+					if strings.Contains(strings.ToLower(name), match) {
+						objects = append(objects, object)
+					}
+				}
+				gqlSortObjects(objects)
+				return gqlPage(objects, args)
+			},
+		})
+	}
+
+	schema.query.add(&gqlField{
+		name:   "object",
+		ftype:  GQL_OBJECT_INTERFACE,
+		target: schema.object,
+		args:   []*gqlArgDef{&gqlArgDef{name: "ref", atype: "String!"}},
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return registry.GetObject(args["ref"].(string)), nil
+		},
+	})
+
+	// structured search
+	schema.query.add(&gqlField{
+		name:   "search",
+		ftype:  "[Object!]!",
+		target: schema.object,
+		args: append([]*gqlArgDef{&gqlArgDef{name: "query", atype: "String!"}},
+			paging(GQL_DEFAULT_LIST_SIZE)...),
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			node, err := ParseRegQuery(args["query"].(string))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid search query: %s", err)
+			}
+			return gqlPage(RegSearch(registry, node), args)
+		},
+	})
+
+	// full text search
+	schema.query.add(&gqlField{
+		name:   "text",
+		ftype:  "[Object!]!",
+		target: schema.object,
+		args: append([]*gqlArgDef{&gqlArgDef{name: "query", atype: "String!"}},
+			paging(REG_TEXT_DEFAULT_LIMIT)...),
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			objects := make([]*RegObject, 0)
+			if index := RegTextIndexData; index != nil {
+				for _, result := range index.Search(args["query"].(string)) {
+					if object := registry.GetObject(result.Ref); object != nil {
+						objects = append(objects, object)
+					}
+				}
+			}
+			return gqlPage(objects, args)
+		},
+	})
+
+	schema.query.add(&gqlField{
+		name:  "types",
+		ftype: "[String!]!",
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return typeNames, nil
+		},
+	})
+
+	schema.query.add(&gqlField{
+		name:  "commit",
+		ftype: "String!",
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return registry.Commit, nil
+		},
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// resolver helpers
+
+// return the raw values for a key
+func gqlValues(object *RegObject, key string) []string {
+	values := make([]string, 0)
+	for _, attribute := range object.GetKey(key) {
+		values = append(values, attribute.RawValue)
+	}
+	return values
+}
+
+// return the objects that a key refers to, matching the same way as
+// attributes are decorated
+func gqlRelated(object *RegObject, key string, relations []*RegType) []*RegObject {
+	objects := make([]*RegObject, 0)
+	for _, attribute := range object.GetKey(key) {
+		for _, relation := range relations {
+			if related := relation.Objects[attribute.RawValue]; related != nil {
+				objects = append(objects, related)
+				break
+			}
+		}
+	}
+	return objects
+}
+
+func gqlContains(list []interface{}, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func gqlSortObjects(objects []*RegObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Ref < objects[j].Ref
+	})
+}
+
+// apply the offset and limit arguments to a list of objects
+func gqlPage(objects []*RegObject, args map[string]interface{}) (interface{}, error) {
+
+	offset, limit := args["offset"].(int), args["limit"].(int)
+	if offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative")
+	}
+	if limit < 1 || limit > GQL_MAX_LIST_LIMIT {
+		return nil, fmt.Errorf("limit must be between 1 and %d", GQL_MAX_LIST_LIMIT)
+	}
+
+	if offset >= len(objects) {
+		return make([]*RegObject, 0), nil
+	}
+	objects = objects[offset:]
+	if len(objects) > limit {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// execution
+
+// execute a request against the schema
+func (schema *GraphQLSchema) Execute(request *GraphQLRequest) *GraphQLResponse {
+
+	response := &GraphQLResponse{}
+	fail := func(err error) *GraphQLResponse {
+		gerr, ok := err.(*GraphQLError)
+		if !ok {
+			gerr = &GraphQLError{Message: err.Error()}
+		}
+		response.Errors = append(response.Errors, gerr)
+		return response
+	}
+
+	doc, err := ParseGraphQL(request.Query)
+	if err != nil {
+		return fail(err)
+	}
+
+	// find the operation to execute
+	var operation *gqlOperation
+	for _, op := range doc.operations {
+		if request.OperationName == "" || op.name == request.OperationName {
+			if operation != nil {
+				return fail(&GraphQLError{Message: "Must provide operation " +
+					"name if query contains multiple operations"})
+			}
+			operation = op
+		}
+	}
+	if operation == nil {
+		return fail(&GraphQLError{Message: "Unknown operation named \"" +
+			request.OperationName + "\""})
+	}
+	if operation.kind != "query" {
+		return fail(gqlErrorf(operation.pos, "Only query operations are supported"))
+	}
+
+	ex := &gqlExec{
+		schema: schema,
+		doc:    doc,
+	}
+
+	// check the query depth before doing any work
+	depth, err := ex.depth(operation.selections, make(map[string]bool))
+	if err != nil {
+		return fail(err)
+	}
+	if depth > GraphQLMaxDepth {
+		return fail(gqlErrorf(operation.pos, "Query depth of %d exceeds "+
+			"the maximum of %d", depth, GraphQLMaxDepth))
+	}
+
+	if err = ex.validate(schema.query, operation.selections,
+		make(map[string]bool)); err != nil {
+		return fail(err)
+	}
+
+	if ex.variables, err = ex.coerceVariables(operation, request.Variables); err != nil {
+		return fail(err)
+	}
+
+	if response.Data, err = ex.selectObject(schema.query, nil,
+		operation.selections, nil); err != nil {
+		response.Data = nil
+		return fail(err)
+	}
+
+	return response
+}
+
+// return the depth of a selection set
+func (ex *gqlExec) depth(selections []*gqlSelection,
+	visiting map[string]bool) (int, error) {
+
+	max := 0
+	for _, sel := range selections {
+		d := 0
+		var err error
+
+		switch sel.kind {
+		case GQL_FIELD:
+			if len(sel.selections) > 0 {
+				d, err = ex.depth(sel.selections, visiting)
+			}
+			d++
+			// introspection is limited by the size of the schema
+			if sel.name == "__schema" || sel.name == "__type" {
+				d = 1
+			}
+
+		case GQL_INLINE_FRAGMENT:
+			d, err = ex.depth(sel.selections, visiting)
+
+		case GQL_FRAGMENT_SPREAD:
+			fragment := ex.doc.fragments[sel.name]
+			if fragment == nil {
+				return 0, gqlErrorf(sel.pos, "Unknown fragment \"%s\"", sel.name)
+			}
+			if visiting[sel.name] {
+				return 0, gqlErrorf(sel.pos, "Cannot spread fragment \"%s\" "+
+					"within itself", sel.name)
+			}
+			visiting[sel.name] = true
+			d, err = ex.depth(fragment.selections, visiting)
+			delete(visiting, sel.name)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+		if d > max {
+			max = d
+		}
+	}
+
+	return max, nil
+}
+
+// return the field selected on a type
+func (ex *gqlExec) field(t *gqlType, name string) *gqlField {
+	field := t.index[name]
+	if field == nil && t == ex.schema.query {
+		field = ex.schema.meta[name]
+	}
+	return field
+}
+
+// check that the fragments in a selection set can apply to the type of
+// the field that they are used in. Fields are checked when they are
+// resolved, as fields of the concrete type may be selected on an
+// interface.
+func (ex *gqlExec) validate(t *gqlType, selections []*gqlSelection,
+	visiting map[string]bool) error {
+
+	for _, sel := range selections {
+		switch sel.kind {
+		case GQL_FIELD:
+			if field := ex.field(t, sel.name); field != nil && field.target != nil {
+				if err := ex.validate(field.target, sel.selections,
+					visiting); err != nil {
+					return err
+				}
+			}
+
+		case GQL_INLINE_FRAGMENT:
+			on := t
+			if sel.on != "" {
+				if on = ex.schema.types[sel.on]; on == nil {
+					return gqlErrorf(sel.pos, "Unknown type \"%s\"", sel.on)
+				}
+				if !gqlPossible(t, on) {
+					return gqlErrorf(sel.pos, "Fragment cannot be spread here "+
+						"as objects of type \"%s\" can never be of type \"%s\"",
+						t.name, on.name)
+				}
+			}
+			if err := ex.validate(on, sel.selections, visiting); err != nil {
+				return err
+			}
+
+		case GQL_FRAGMENT_SPREAD:
+			// fragments are known to exist and not to spread within
+			// themselves, having been checked by depth()
+			if visiting[sel.name] {
+				continue
+			}
+			fragment := ex.doc.fragments[sel.name]
+			on := ex.schema.types[fragment.on]
+			if on == nil {
+				return gqlErrorf(fragment.pos, "Unknown type \"%s\"", fragment.on)
+			}
+			if !gqlPossible(t, on) {
+				return gqlErrorf(sel.pos, "Fragment \"%s\" cannot be spread "+
+					"here as objects of type \"%s\" can never be of type \"%s\"",
+					sel.name, t.name, on.name)
+			}
+			visiting[sel.name] = true
+			err := ex.validate(on, fragment.selections, visiting)
+			delete(visiting, sel.name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// return true if an object could be of both types
+func gqlPossible(a *gqlType, b *gqlType) bool {
+	return a == b || a.name == b.implements || b.name == a.implements
+}
+
+// coerce the request variables to the types defined by the operation
+func (ex *gqlExec) coerceVariables(operation *gqlOperation,
+	provided map[string]interface{}) (map[string]interface{}, error) {
+
+	variables := make(map[string]interface{})
+	for _, def := range operation.variables {
+		value, ok := provided[def.name]
+		if !ok && def.defval != nil {
+			var err error
+			if value, err = ex.value(def.defval); err != nil {
+				return nil, err
+			}
+		}
+
+		coerced, err := gqlCoerce(value, def.vtype.String())
+		if err != nil {
+			return nil, gqlErrorf(def.pos, "Variable \"$%s\": %s", def.name, err)
+		}
+		variables[def.name] = coerced
+	}
+
+	return variables, nil
+}
+
+// convert a parsed value to a go value
+func (ex *gqlExec) value(v *gqlValue) (interface{}, error) {
+
+	switch v.kind {
+	case GQL_VARIABLE:
+		value, ok := ex.variables[v.text]
+		if !ok {
+			return nil, gqlErrorf(v.pos, "Variable \"$%s\" is not defined", v.text)
+		}
+		return value, nil
+
+	case GQL_INT:
+		var i int
+		if _, err := fmt.Sscan(v.text, &i); err != nil {
+			return nil, gqlErrorf(v.pos, "Invalid integer %s", v.text)
+		}
+		return i, nil
+
+	case GQL_FLOAT:
+		var f float64
+		if _, err := fmt.Sscan(v.text, &f); err != nil {
+			return nil, gqlErrorf(v.pos, "Invalid float %s", v.text)
+		}
+		return f, nil
+
+	case GQL_BOOLEAN:
+		return v.text == "true", nil
+
+	case GQL_NULL:
+		return nil, nil
+
+	case GQL_LIST:
+		list := make([]interface{}, 0, len(v.list))
+		for _, item := range v.list {
+			value, err := ex.value(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case GQL_OBJECT:
+		object := make(map[string]interface{})
+		for _, field := range v.fields {
+			value, err := ex.value(field.value)
+			if err != nil {
+				return nil, err
+			}
+			object[field.name] = value
+		}
+		return object, nil
+	}
+
+	// strings and enums
+	return v.text, nil
+}
+
+// coerce a value to a type
+func gqlCoerce(value interface{}, t string) (interface{}, error) {
+
+	nonNull := strings.HasSuffix(t, "!")
+	t = strings.TrimSuffix(t, "!")
+
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("Expected non-null value of type %s!", t)
+		}
+		return nil, nil
+	}
+
+	// lists, where a single value is treated as a list of one
+	if strings.HasPrefix(t, "[") {
+		inner := t[1 : len(t)-1]
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			coerced, err := gqlCoerce(item, inner)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	switch t {
+	case "String", "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			// JSON variables are decoded as floats
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		}
+
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown type %s", t)
+	}
+
+	return nil, fmt.Errorf("Expected type %s, found %v", t, value)
+}
+
+// evaluate the skip and include directives
+func (ex *gqlExec) included(directives []*gqlDirective) (bool, error) {
+
+	for _, directive := range directives {
+		if directive.name != "skip" && directive.name != "include" {
+			return false, gqlErrorf(directive.pos, "Unknown directive \"@%s\"",
+				directive.name)
+		}
+
+		var condition interface{}
+		for _, arg := range directive.args {
+			if arg.name == "if" {
+				value, err := ex.value(arg.value)
+				if err != nil {
+					return false, err
+				}
+				condition = value
+			}
+		}
+		b, ok := condition.(bool)
+		if !ok {
+			return false, gqlErrorf(directive.pos, "Directive \"@%s\" requires "+
+				"a Boolean argument \"if\"", directive.name)
+		}
+
+		if b == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// return true if a fragment type condition applies to a type
+func (ex *gqlExec) applies(t *gqlType, on string, pos GraphQLLocation) (bool, error) {
+	if ex.schema.types[on] == nil {
+		return false, gqlErrorf(pos, "Unknown type \"%s\"", on)
+	}
+	return on == t.name || on == t.implements, nil
+}
+
+// collect the fields in a selection set, expanding fragments
+func (ex *gqlExec) collect(t *gqlType, selections []*gqlSelection,
+	result *OrderedJSON, fields map[string][]*gqlSelection,
+	visited map[string]bool) error {
+
+	for _, sel := range selections {
+
+		if ok, err := ex.included(sel.directives); !ok || err != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch sel.kind {
+		case GQL_FIELD:
+			key := sel.key()
+			if fields[key] == nil {
+				// reserve the position of the field in the result
+				result.Set(key, nil)
+			}
+			fields[key] = append(fields[key], sel)
+
+		case GQL_INLINE_FRAGMENT:
+			if sel.on != "" {
+				if ok, err := ex.applies(t, sel.on, sel.pos); !ok || err != nil {
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := ex.collect(t, sel.selections, result, fields, visited); err != nil {
+				return err
+			}
+
+		case GQL_FRAGMENT_SPREAD:
+			if visited[sel.name] {
+				continue
+			}
+			visited[sel.name] = true
+
+			// fragments are known to exist, having been checked by depth()
+			fragment := ex.doc.fragments[sel.name]
+			if ok, err := ex.applies(t, fragment.on, fragment.pos); !ok || err != nil {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := ex.collect(t, fragment.selections, result, fields, visited); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// execute a selection set against an object
+func (ex *gqlExec) selectObject(t *gqlType, parent interface{},
+	selections []*gqlSelection, path []interface{}) (*OrderedJSON, error) {
+
+	result := NewOrderedJSON()
+	fields := make(map[string][]*gqlSelection)
+
+	if err := ex.collect(t, selections, result, fields,
+		make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	for _, key := range result.keys {
+		sel := fields[key][0]
+		fpath := append(path[:len(path):len(path)], key)
+
+		fail := func(err error) error {
+			gerr, ok := err.(*GraphQLError)
+			if !ok {
+				gerr = gqlErrorf(sel.pos, "%s", err)
+			}
+			if gerr.Path == nil {
+				gerr.Path = fpath
+			}
+			return gerr
+		}
+
+		ex.cost++
+		if ex.cost > GraphQLMaxCost {
+			return nil, fail(fmt.Errorf("Query cost exceeds the maximum of %d",
+				GraphQLMaxCost))
+		}
+
+		if sel.name == "__typename" {
+			result.Set(key, t.name)
+			continue
+		}
+
+		field := ex.field(t, sel.name)
+		if field == nil {
+			return nil, fail(fmt.Errorf("Cannot query field \"%s\" on type \"%s\"",
+				sel.name, t.name))
+		}
+
+		args, err := ex.arguments(field, sel)
+		if err != nil {
+			return nil, fail(err)
+		}
+
+		value, err := field.resolve(ex, parent, args)
+		if err != nil {
+			return nil, fail(err)
+		}
+
+		// merge the sub selections of fields with the same key
+		subs := make([]*gqlSelection, 0)
+		for _, fsel := range fields[key] {
+			subs = append(subs, fsel.selections...)
+		}
+
+		if field.target == nil {
+			if len(subs) > 0 {
+				return nil, fail(fmt.Errorf("Field \"%s\" must not have a "+
+					"selection since type \"%s\" has no subfields",
+					sel.name, field.ftype))
+			}
+			result.Set(key, value)
+			continue
+		}
+
+		if len(subs) == 0 {
+			return nil, fail(fmt.Errorf("Field \"%s\" of type \"%s\" must "+
+				"have a selection of subfields", sel.name, field.ftype))
+		}
+
+		completed, err := ex.complete(field.target, value, subs, fpath)
+		if err != nil {
+			return nil, err
+		}
+		result.Set(key, completed)
+	}
+
+	return result, nil
+}
+
+// complete the value of an object field
+func (ex *gqlExec) complete(t *gqlType, value interface{},
+	selections []*gqlSelection, path []interface{}) (interface{}, error) {
+
+	switch v := value.(type) {
+	case *RegObject:
+		if v == nil {
+			return nil, nil
+		}
+		return ex.selectObject(ex.runtimeType(t, v), v, selections, path)
+
+	case []*RegObject:
+		list := make([]interface{}, 0, len(v))
+		for ix, object := range v {
+			r, err := ex.selectObject(ex.runtimeType(t, object), object,
+				selections, append(path[:len(path):len(path)], ix))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, r)
+		}
+		return list, nil
+
+	case []*RegAttribute:
+		list := make([]interface{}, 0, len(v))
+		for ix, attribute := range v {
+			r, err := ex.selectObject(t, attribute, selections,
+				append(path[:len(path):len(path)], ix))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, r)
+		}
+		return list, nil
+
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for ix, item := range v {
+			r, err := ex.complete(t, item, selections,
+				append(path[:len(path):len(path)], ix))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, r)
+		}
+		return list, nil
+
+	case nil:
+		return nil, nil
+	}
+
+	// introspection values
+	return ex.selectObject(t, value, selections, path)
+}
+
+// return the concrete type for an object of an interface type
+func (ex *gqlExec) runtimeType(t *gqlType, object *RegObject) *gqlType {
+	if t.kind == "interface" {
+		rtype, _ := RegistrySplitPath(object.Ref)
+		if concrete := ex.schema.byRegType[rtype]; concrete != nil {
+			return concrete
+		}
+	}
+	return t
+}
+
+// coerce the arguments for a field
+func (ex *gqlExec) arguments(field *gqlField,
+	sel *gqlSelection) (map[string]interface{}, error) {
+
+	args := make(map[string]interface{})
+
+	for _, arg := range sel.args {
+		var def *gqlArgDef
+		for _, d := range field.args {
+			if d.name == arg.name {
+				def = d
+			}
+		}
+		if def == nil {
+			return nil, gqlErrorf(arg.value.pos, "Unknown argument \"%s\" "+
+				"on field \"%s\"", arg.name, field.name)
+		}
+
+		value, err := ex.value(arg.value)
+		if err != nil {
+			return nil, err
+		}
+		if args[def.name], err = gqlCoerce(value, def.atype); err != nil {
+			return nil, gqlErrorf(arg.value.pos, "Argument \"%s\": %s",
+				arg.name, err)
+		}
+	}
+
+	// add defaults and check for missing arguments
+	for _, def := range field.args {
+		if _, ok := args[def.name]; ok {
+			continue
+		}
+		if strings.HasSuffix(def.atype, "!") {
+			return nil, gqlErrorf(sel.pos, "Field \"%s\" argument \"%s\" "+
+				"of type \"%s\" is required", field.name, def.name, def.atype)
+		}
+		args[def.name] = def.defval
+	}
+
+	return args, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the schema in the GraphQL schema definition language
+
+func (schema *GraphQLSchema) SDL() string {
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# generated from registry commit %s\n", schema.registry.Commit)
+
+	names := make([]string, 0, len(schema.types))
+	for name := range schema.types {
+		switch {
+		case name == GQL_QUERY_TYPE, name == GQL_OBJECT_INTERFACE,
+			name == GQL_ATTRIBUTE_TYPE, strings.HasPrefix(name, "__"):
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{GQL_OBJECT_INTERFACE, GQL_ATTRIBUTE_TYPE},
+		append(names, GQL_QUERY_TYPE)...)
+
+	for _, name := range names {
+		t := schema.types[name]
+
+		fmt.Fprintf(&b, "\n%s %s", t.kind, t.name)
+		if t.implements != "" {
+			fmt.Fprintf(&b, " implements %s", t.implements)
+		}
+		b.WriteString(" {\n")
+
+		for _, field := range t.fields {
+			fmt.Fprintf(&b, "  %s", field.name)
+			if len(field.args) > 0 {
+				args := make([]string, 0, len(field.args))
+				for _, arg := range field.args {
+					s := arg.name + ": " + arg.atype
+					if arg.defval != nil {
+						s += fmt.Sprintf(" = %v", arg.defval)
+					}
+					args = append(args, s)
+				}
+				fmt.Fprintf(&b, "(%s)", strings.Join(args, ", "))
+			}
+			fmt.Fprintf(&b, ": %s\n", field.ftype)
+		}
+
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,232 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// execute a query, returning the data as JSON and the first error
+func testGraphQL(t *testing.T, schema *GraphQLSchema, query string) (string, string) {
+
+	response := schema.Execute(&GraphQLRequest{Query: query})
+	if len(response.Errors) > 0 {
+		return "", response.Errors[0].Message
+	}
+
+	data, err := json.Marshal(response.Data)
+	if err != nil {
+		t.Fatalf("unable to marshal response: %s", err)
+	}
+	return string(data), ""
+}
+
+// the query used by GraphiQL and other tools to fetch the schema
+const testIntrospectionQuery = `
+query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind name description
+  fields(includeDeprecated: true) {
+    name description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) {
+    name description isDeprecated deprecationReason
+  }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue {
+  name description type { ...TypeRef } defaultValue
+}
+fragment TypeRef on __Type {
+  kind name
+  ofType { kind name ofType { kind name ofType { kind name ofType {
+    kind name ofType { kind name ofType { kind name ofType { kind name }
+  } } } } } }
+}`
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestGraphQLRelations(t *testing.T) {
+
+	schema := BuildGraphQLSchema(testLoadRegistry(t))
+
+	data, err := testGraphQL(t, schema, `{
+		aut_num(name: "AS4242420001") {
+			as_name
+			mnt_by { _name }
+			admin_c { nic_hdl }
+			route_via_origin { route }
+		}
+	}`)
+	if err != "" {
+		t.Fatalf("query failed: %s", err)
+	}
+
+	expected := `{"aut_num":{"as_name":"AS4242420001",` +
+		`"mnt_by":[{"_name":"FOO-MNT"}],"admin_c":[{"nic_hdl":"FOO-DN42"}],` +
+		`"route_via_origin":[{"route":"172.20.0.0/24"}]}}`
+	if data != expected {
+		t.Errorf("got %s, expected %s", data, expected)
+	}
+}
+
+func TestGraphQLFragmentTypeConditions(t *testing.T) {
+
+	schema := BuildGraphQLSchema(testLoadRegistry(t))
+
+	tests := []struct {
+		query string
+		err   string
+	}{
+		// possible type conditions
+		{`{ mntner(name: "FOO-MNT") { mnt_by { ... on Mntner { _name } } } }`, ""},
+		{`{ mntner(name: "FOO-MNT") { mnt_by { ... on Object { _name } } } }`, ""},
+		{`{ object(ref: "mntner/FOO-MNT") { ... on Mntner { _name } } }`, ""},
+		{`{ object(ref: "mntner/FOO-MNT") { ...M } } fragment M on Mntner { _name }`, ""},
+		// a list with no objects is still checked
+		{`{ mntner(name: "NONE") { mnt_by { ... on Person { nic_hdl } } } }`,
+			`objects of type "Mntner" can never be of type "Person"`},
+		{`{ mntner(name: "FOO-MNT") { mnt_by { ... on Person { nic_hdl } } } }`,
+			`objects of type "Mntner" can never be of type "Person"`},
+		{`{ mntner(name: "FOO-MNT") { mnt_by { ...P } } } fragment P on Person { nic_hdl }`,
+			`Fragment "P" cannot be spread here`},
+		{`{ object(ref: "mntner/FOO-MNT") { ... on Attribute { key } } }`,
+			`objects of type "Object" can never be of type "Attribute"`},
+		// within a fragment of a narrower type
+		{`{ object(ref: "mntner/FOO-MNT") { ... on Mntner { admin_c { ... on Mntner { _name } } } } }`,
+			`objects of type "Person" can never be of type "Mntner"`},
+		{`{ object(ref: "mntner/FOO-MNT") { ... on Nothing { _name } } }`,
+			`Unknown type "Nothing"`},
+	}
+
+	for _, test := range tests {
+		_, err := testGraphQL(t, schema, test.query)
+		if test.err == "" && err != "" {
+			t.Errorf("%s: unexpected error: %s", test.query, err)
+		}
+		if test.err != "" && !strings.Contains(err, test.err) {
+			t.Errorf("%s: expected error '%s', got '%s'", test.query, test.err, err)
+		}
+	}
+}
+
+func TestGraphQLIntrospection(t *testing.T) {
+
+	schema := BuildGraphQLSchema(testLoadRegistry(t))
+
+	// the full introspection query works within the default limits
+	data, err := testGraphQL(t, schema, testIntrospectionQuery)
+	if err != "" {
+		t.Fatalf("introspection query failed: %s", err)
+	}
+
+	var result struct {
+		Schema struct {
+			QueryType struct{ Name string }
+			Types     []struct {
+				Kind          string
+				Name          string
+				Fields        []struct{ Name string }
+				Interfaces    []struct{ Name string }
+				PossibleTypes []struct{ Name string }
+				EnumValues    []struct{ Name string }
+			}
+			Directives []struct{ Name string }
+		} `json:"__schema"`
+	}
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Schema.QueryType.Name != GQL_QUERY_TYPE ||
+		len(result.Schema.Directives) != 2 {
+		t.Errorf("unexpected schema %+v", result.Schema)
+	}
+
+	kinds := make(map[string]string)
+	for _, ty := range result.Schema.Types {
+		kinds[ty.Name] = ty.Kind
+		switch ty.Name {
+		case "AutNum":
+			if len(ty.Interfaces) != 1 || ty.Interfaces[0].Name != "Object" {
+				t.Errorf("AutNum: unexpected interfaces %+v", ty.Interfaces)
+			}
+		case "Object":
+			if len(ty.PossibleTypes) != len(schema.byRegType) {
+				t.Errorf("Object: unexpected possible types %+v", ty.PossibleTypes)
+			}
+		case "__TypeKind":
+			if len(ty.EnumValues) != 8 {
+				t.Errorf("__TypeKind: unexpected values %+v", ty.EnumValues)
+			}
+		case GQL_QUERY_TYPE:
+			for _, field := range ty.Fields {
+				if strings.HasPrefix(field.Name, "__") {
+					t.Errorf("Query: lists %s", field.Name)
+				}
+			}
+		}
+	}
+	for name, kind := range map[string]string{"AutNum": "OBJECT",
+		"Object": "INTERFACE", "String": "SCALAR", "__Type": "OBJECT",
+		"__DirectiveLocation": "ENUM"} {
+		if kinds[name] != kind {
+			t.Errorf("%s: kind %s, expected %s", name, kinds[name], kind)
+		}
+	}
+
+	// type references are unwrapped with ofType
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`{ __type(name: "Mntner") { fields { name type { kind ofType {
+			kind ofType { kind ofType { name } } } } } } }`,
+			`{"name":"mnt_by","type":{"kind":"NON_NULL","ofType":{"kind":"LIST",` +
+				`"ofType":{"kind":"NON_NULL","ofType":{"name":"Mntner"}}}}}`},
+		{`{ __type(name: "Query") { fields { name args { name defaultValue } } } }`,
+			`{"name":"limit","defaultValue":"100"}`},
+		{`{ __type(name: "Nothing") { name } }`, `{"__type":null}`},
+		{`{ __typename __type(name: "String") { kind name fields { name } } }`,
+			`{"__typename":"Query","__type":{"kind":"SCALAR","name":"String","fields":null}}`},
+	}
+	for _, test := range tests {
+		data, err := testGraphQL(t, schema, test.query)
+		if err != "" {
+			t.Errorf("%s: %s", test.query, err)
+			continue
+		}
+		if !strings.Contains(data, test.expected) {
+			t.Errorf("%s: got %s, expected %s", test.query, data, test.expected)
+		}
+	}
+
+	// introspection types are not part of the SDL
+	if strings.Contains(schema.SDL(), "__") {
+		t.Error("SDL contains introspection types")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,350 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// GraphQL introspection
+//
+// The __schema and __type fields on the query root describe the
+// generated schema, so that tools such as GraphiQL can be used with the
+// API. The introspection types are resolved from the schema's own type
+// and field definitions, and type references are passed between the
+// resolvers in their SDL form (e.g. '[Mntner!]!').
+//
+// Type references are nested deeply in introspection queries, so the
+// introspection fields are not included in the query depth, but are
+// still included in the query cost.
+
+// the scalar types used by the schema
+var gqlScalarTypes = []string{"Boolean", "Int", "String"}
+
+// the directives supported when executing queries
+type gqlDirectiveDef struct {
+	name        string
+	description string
+	locations   []string
+	args        []*gqlArgDef
+}
+
+var gqlDirectiveDefs = []*gqlDirectiveDef{
+	&gqlDirectiveDef{
+		name:        "include",
+		description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:        []*gqlArgDef{&gqlArgDef{name: "if", atype: "Boolean!"}},
+	},
+	&gqlDirectiveDef{
+		name:        "skip",
+		description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:        []*gqlArgDef{&gqlArgDef{name: "if", atype: "Boolean!"}},
+	},
+}
+
+// introspection kinds for each kind of type
+var gqlTypeKinds = map[string]string{
+	"type":      "OBJECT",
+	"interface": "INTERFACE",
+	"enum":      "ENUM",
+}
+
+//////////////////////////////////////////////////////////////////////////
+// create the introspection types and the query root fields
+
+func (schema *GraphQLSchema) addIntrospection() {
+
+	add := func(name string, kind string) *gqlType {
+		t := newGQLType(name, kind)
+		schema.types[name] = t
+		return t
+	}
+
+	schemaType := add("__Schema", "type")
+	typeType := add("__Type", "type")
+	fieldType := add("__Field", "type")
+	inputType := add("__InputValue", "type")
+	enumType := add("__EnumValue", "type")
+	directiveType := add("__Directive", "type")
+
+	add("__TypeKind", "enum").values = []string{"SCALAR", "OBJECT",
+		"INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL"}
+	add("__DirectiveLocation", "enum").values = []string{"QUERY", "MUTATION",
+		"SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD",
+		"INLINE_FRAGMENT", "VARIABLE_DEFINITION", "SCHEMA", "SCALAR",
+		"OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE",
+		"UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION"}
+
+	deprecated := []*gqlArgDef{
+		&gqlArgDef{name: "includeDeprecated", atype: "Boolean", defval: false},
+	}
+	none := func(schema *GraphQLSchema, parent interface{}) interface{} {
+		return nil
+	}
+	no := func(schema *GraphQLSchema, parent interface{}) interface{} {
+		return false
+	}
+
+	// __Schema
+	schemaType.add(gqlMeta("description", "String", nil, none))
+	schemaType.add(gqlMeta("types", "[__Type!]!", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			names := append([]string{}, gqlScalarTypes...)
+			for name := range schema.types {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return gqlList(names)
+		}))
+	schemaType.add(gqlMeta("queryType", "__Type!", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return GQL_QUERY_TYPE
+		}))
+	schemaType.add(gqlMeta("mutationType", "__Type", typeType, none))
+	schemaType.add(gqlMeta("subscriptionType", "__Type", typeType, none))
+	schemaType.add(gqlMeta("directives", "[__Directive!]!", directiveType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			list := make([]interface{}, 0, len(gqlDirectiveDefs))
+			for _, directive := range gqlDirectiveDefs {
+				list = append(list, directive)
+			}
+			return list
+		}))
+
+	// __Type, the parent is a type reference
+	typeType.add(gqlMeta("kind", "__TypeKind!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return schema.typeKind(parent.(string))
+		}))
+	typeType.add(gqlMeta("name", "String", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			if ref := parent.(string); !gqlWrapped(ref) {
+				return ref
+			}
+			return nil
+		}))
+	typeType.add(gqlMeta("description", "String", nil, none))
+	typeType.add(gqlMeta("specifiedByURL", "String", nil, none))
+	typeType.add(gqlMeta("fields", "[__Field!]", fieldType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			t := schema.types[parent.(string)]
+			if t == nil || t.kind == "enum" {
+				return nil
+			}
+			list := make([]interface{}, 0, len(t.fields))
+			for _, field := range t.fields {
+				list = append(list, field)
+			}
+			return list
+		}))
+	typeType.index["fields"].args = deprecated
+	typeType.add(gqlMeta("interfaces", "[__Type!]", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			t := schema.types[parent.(string)]
+			if t == nil || t.kind == "enum" {
+				return nil
+			}
+			if t.implements != "" {
+				return gqlList([]string{t.implements})
+			}
+			return gqlList(nil)
+		}))
+	typeType.add(gqlMeta("possibleTypes", "[__Type!]", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			t := schema.types[parent.(string)]
+			if t == nil || t.kind != "interface" {
+				return nil
+			}
+			names := make([]string, 0)
+			for name, possible := range schema.types {
+				if possible.implements == t.name {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			return gqlList(names)
+		}))
+	typeType.add(gqlMeta("enumValues", "[__EnumValue!]", enumType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			t := schema.types[parent.(string)]
+			if t == nil || t.kind != "enum" {
+				return nil
+			}
+			return gqlList(t.values)
+		}))
+	typeType.index["enumValues"].args = deprecated
+	typeType.add(gqlMeta("inputFields", "[__InputValue!]", inputType, none))
+	typeType.add(gqlMeta("ofType", "__Type", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			ref := parent.(string)
+			switch {
+			case strings.HasSuffix(ref, "!"):
+				return strings.TrimSuffix(ref, "!")
+			case strings.HasPrefix(ref, "["):
+				return ref[1 : len(ref)-1]
+			}
+			return nil
+		}))
+
+	// __Field
+	fieldType.add(gqlMeta("name", "String!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlField).name
+		}))
+	fieldType.add(gqlMeta("description", "String", nil, none))
+	fieldType.add(gqlMeta("args", "[__InputValue!]!", inputType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return gqlArgList(parent.(*gqlField).args)
+		}))
+	fieldType.add(gqlMeta("type", "__Type!", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlField).ftype
+		}))
+	fieldType.add(gqlMeta("isDeprecated", "Boolean!", nil, no))
+	fieldType.add(gqlMeta("deprecationReason", "String", nil, none))
+
+	// __InputValue
+	inputType.add(gqlMeta("name", "String!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlArgDef).name
+		}))
+	inputType.add(gqlMeta("description", "String", nil, none))
+	inputType.add(gqlMeta("type", "__Type!", typeType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlArgDef).atype
+		}))
+	inputType.add(gqlMeta("defaultValue", "String", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			switch v := parent.(*gqlArgDef).defval.(type) {
+			case nil:
+				return nil
+			case string:
+				return strconv.Quote(v)
+			default:
+				return fmt.Sprint(v)
+			}
+		}))
+	inputType.add(gqlMeta("isDeprecated", "Boolean!", nil, no))
+	inputType.add(gqlMeta("deprecationReason", "String", nil, none))
+
+	// __EnumValue, the parent is the value
+	enumType.add(gqlMeta("name", "String!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(string)
+		}))
+	enumType.add(gqlMeta("description", "String", nil, none))
+	enumType.add(gqlMeta("isDeprecated", "Boolean!", nil, no))
+	enumType.add(gqlMeta("deprecationReason", "String", nil, none))
+
+	// __Directive
+	directiveType.add(gqlMeta("name", "String!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlDirectiveDef).name
+		}))
+	directiveType.add(gqlMeta("description", "String", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlDirectiveDef).description
+		}))
+	directiveType.add(gqlMeta("locations", "[__DirectiveLocation!]!", nil,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return parent.(*gqlDirectiveDef).locations
+		}))
+	directiveType.add(gqlMeta("args", "[__InputValue!]!", inputType,
+		func(schema *GraphQLSchema, parent interface{}) interface{} {
+			return gqlArgList(parent.(*gqlDirectiveDef).args)
+		}))
+	directiveType.add(gqlMeta("isRepeatable", "Boolean!", nil, no))
+
+	// the query root fields, which are not listed in the Query type
+	schema.meta = map[string]*gqlField{
+		"__schema": gqlMeta("__schema", "__Schema!", schemaType,
+			func(schema *GraphQLSchema, parent interface{}) interface{} {
+				return schema
+			}),
+		"__type": &gqlField{
+			name:   "__type",
+			ftype:  "__Type",
+			target: typeType,
+			args:   []*gqlArgDef{&gqlArgDef{name: "name", atype: "String!"}},
+			resolve: func(ex *gqlExec, parent interface{},
+				args map[string]interface{}) (interface{}, error) {
+				name := args["name"].(string)
+				if ex.schema.typeKind(name) == "" || gqlWrapped(name) {
+					return nil, nil
+				}
+				return name, nil
+			},
+		},
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// an introspection field, resolved from the schema and its parent
+func gqlMeta(name string, ftype string, target *gqlType,
+	resolve func(schema *GraphQLSchema, parent interface{}) interface{}) *gqlField {
+	return &gqlField{
+		name:   name,
+		ftype:  ftype,
+		target: target,
+		resolve: func(ex *gqlExec, parent interface{},
+			args map[string]interface{}) (interface{}, error) {
+			return resolve(ex.schema, parent), nil
+		},
+	}
+}
+
+// return true if a type reference is a list or non-null type
+func gqlWrapped(ref string) bool {
+	return strings.HasSuffix(ref, "!") || strings.HasPrefix(ref, "[")
+}
+
+// return the introspection kind of a type reference, or an empty
+// string if the type is unknown
+func (schema *GraphQLSchema) typeKind(ref string) string {
+	switch {
+	case strings.HasSuffix(ref, "!"):
+		return "NON_NULL"
+	case strings.HasPrefix(ref, "["):
+		return "LIST"
+	}
+	for _, scalar := range gqlScalarTypes {
+		if ref == scalar {
+			return "SCALAR"
+		}
+	}
+	if t := schema.types[ref]; t != nil {
+		return gqlTypeKinds[t.kind]
+	}
+	return ""
+}
+
+func gqlList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
+
+func gqlArgList(args []*gqlArgDef) []interface{} {
+	list := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		list = append(list, arg)
+	}
+	return list
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
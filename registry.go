@@ -8,12 +8,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	//	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -63,9 +67,10 @@ type RegTypeSchema struct {
 // the registry itself
 
 type Registry struct {
-	Commit string
-	Schema map[string]*RegTypeSchema
-	Types  map[string]*RegType
+	Commit     string
+	CommitTime time.Time
+	Schema     map[string]*RegTypeSchema
+	Types      map[string]*RegType
 }
 
 // and a variable for the actual data
@@ -78,6 +83,9 @@ var previousCommit string
 var AuthorisationToken string
 var RegistryRefresh chan bool
 
+// and the path to git
+var GitPath string
+
 //////////////////////////////////////////////////////////////////////////
 // utility and manipulation functions
 
@@ -95,6 +103,142 @@ func RegistrySplitPath(p string) (string, string) {
 	return tmp[0], tmp[1]
 }
 
+// references sort by type and then by name, prefixes and addresses sort
+// by address and then length, and other names sort with numbers compared
+// by value so that AS2 comes before AS10
+type registrySortKey struct {
+	rtype string
+	name  string
+	ip    net.IP
+	plen  int
+}
+
+func newRegistrySortKey(ref string) *registrySortKey {
+	key := &registrySortKey{}
+	key.rtype, key.name = RegistrySplitPath(ref)
+	if key.rtype == "" {
+		// not a type/name reference, treat as a type
+		key.rtype = ref
+	}
+	key.ip, key.plen = registryNameAddress(key.name)
+	return key
+}
+
+func (a *registrySortKey) less(b *registrySortKey) bool {
+
+	if a.rtype != b.rtype {
+		return a.rtype < b.rtype
+	}
+
+	if a.ip != nil && b.ip != nil {
+		if len(a.ip) != len(b.ip) {
+			return len(a.ip) < len(b.ip)
+		}
+		if c := bytes.Compare(a.ip, b.ip); c != 0 {
+			return c < 0
+		}
+		if a.plen != b.plen {
+			return a.plen < b.plen
+		}
+		return a.name < b.name
+	}
+	if (a.ip != nil) != (b.ip != nil) {
+		return a.ip != nil
+	}
+
+	// compare runs of digits by value and other text without case
+	for x, y := a.name, b.name; x != "" && y != ""; {
+		xc, yc := registryNameChunk(x), registryNameChunk(y)
+		x, y = x[len(xc):], y[len(yc):]
+		if xc == yc {
+			continue
+		}
+
+		if registryIsDigit(xc[0]) && registryIsDigit(yc[0]) {
+			xn, yn := strings.TrimLeft(xc, "0"), strings.TrimLeft(yc, "0")
+			if len(xn) != len(yn) {
+				return len(xn) < len(yn)
+			}
+			if xn != yn {
+				return xn < yn
+			}
+			continue
+		}
+
+		xl, yl := strings.ToLower(xc), strings.ToLower(yc)
+		if xl != yl {
+			return xl < yl
+		}
+	}
+
+	// equal in value, or one is a prefix of the other
+	if len(a.name) != len(b.name) {
+		return len(a.name) < len(b.name)
+	}
+	return a.name < b.name
+}
+
+// compare two references
+func RegistryRefLess(a string, b string) bool {
+	return newRegistrySortKey(a).less(newRegistrySortKey(b))
+}
+
+// sort a list of references
+func RegistrySortRefs(refs []string) {
+	keys := make(map[string]*registrySortKey, len(refs))
+	for _, ref := range refs {
+		keys[ref] = newRegistrySortKey(ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return keys[refs[i]].less(keys[refs[j]])
+	})
+}
+
+// sort a list of objects by reference
+func RegistrySortObjects(objects []*RegObject) {
+	keys := make(map[*RegObject]*registrySortKey, len(objects))
+	for _, object := range objects {
+		keys[object] = newRegistrySortKey(object.Ref)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return keys[objects[i]].less(keys[objects[j]])
+	})
+}
+
+func registryIsDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// return the leading run of digits, or of other characters
+func registryNameChunk(s string) string {
+	digits := registryIsDigit(s[0])
+	ix := 1
+	for ix < len(s) && registryIsDigit(s[ix]) == digits {
+		ix++
+	}
+	return s[:ix]
+}
+
+// parse an object name that is a prefix or address, returning
+// the address and prefix length
+func registryNameAddress(name string) (net.IP, int) {
+	name = strings.Replace(name, "_", "/", 1)
+	if ip, ipnet, err := net.ParseCIDR(name); err == nil {
+		ones, _ := ipnet.Mask.Size()
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, ones
+		}
+		return ip, ones
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, 32
+		}
+		return ip, 128
+	}
+	return nil, 0
+}
+
 func (registry *Registry) GetObject(path string) *RegObject {
 	rtname, objname := RegistrySplitPath(path)
 	rtype := registry.Types[rtname]
@@ -139,6 +283,39 @@ func (object *RegObject) GetSingleKey(key string) *RegAttribute {
 	return attributes[0]
 }
 
+// return the types that an attribute may refer to, or nil if the
+// attribute is not a lookup
+func (registry *Registry) GetLookupTypes(object *RegObject,
+	attribute *RegAttribute) []*RegType {
+
+	rtname, _ := RegistrySplitPath(object.Ref)
+	schema := registry.Schema[rtname]
+	if schema == nil {
+		return nil
+	}
+
+	attribSchema := schema.Attributes[attribute.Key]
+	if attribSchema == nil || len(attribSchema.Relations) == 0 {
+		return nil
+	}
+
+	return attribSchema.Relations
+}
+
+// return the object that an attribute refers to, or nil if the attribute
+// is not a lookup or the related object does not exist
+func (registry *Registry) GetRelation(object *RegObject,
+	attribute *RegAttribute) *RegObject {
+
+	// match in the same way as decorate()
+	for _, relation := range registry.GetLookupTypes(object, attribute) {
+		if related := relation.Objects[attribute.RawValue]; related != nil {
+			return related
+		}
+	}
+	return nil
+}
+
 // schema functions
 
 // validate a set of attributes against a schema
@@ -213,6 +390,23 @@ func reloadRegistry(path string, commit string) {
 
 	log.Debug("Reloading registry")
 
+	// load the new registry data
+	registry := LoadRegistry(path, commit)
+	registry.CommitTime = getCommitTime(strings.TrimSuffix(path, "/data"),
+		commit)
+
+	// trigger updates in any other modules
+	EventBus.Fire("RegistryUpdate", registry, path)
+
+	// swap in the new registry data
+	RegistryData = registry
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load and parse a registry from a data directory
+
+func LoadRegistry(path string, commit string) *Registry {
+
 	// r will become the new registry data
 	registry := &Registry{
 		Commit: commit,
@@ -238,11 +432,7 @@ func reloadRegistry(path string, commit string) {
 	// mark relationships
 	registry.decorate()
 
-	// trigger updates in any other modules
-	EventBus.Fire("RegistryUpdate", registry, path)
-
-	// swap in the new registry data
-	RegistryData = registry
+	return registry
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -604,6 +794,62 @@ func getCommitHash(regDir string, gitPath string) string {
 	return strings.TrimSpace(string(out))
 }
 
+//////////////////////////////////////////////////////////////////////////
+// fetch the time of a commit, or the current time if unavailable
+
+func getCommitTime(regDir string, commit string) time.Time {
+
+	cmd := exec.Command(GitPath, "log", "-1", "--format=%ct", commit)
+	cmd.Dir = regDir
+	// execute
+	out, err := cmd.Output()
+	if err == nil {
+		var ctime int64
+		if ctime, err = strconv.ParseInt(
+			strings.TrimSpace(string(out)), 10, 64); err == nil {
+			return time.Unix(ctime, 0)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"error":  err,
+		"regDir": regDir,
+		"commit": commit,
+	}).Warn("Failed to find commit time")
+
+	return time.Now()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// find the most recent commit between two commits that changed an object
+
+func getObjectCommit(regDir string, from string, to string,
+	ref string) string {
+
+	// map the object ref to its path within the registry
+	rtname, objname := RegistrySplitPath(ref)
+	if rtname == "domain" {
+		rtname = "dns"
+	}
+	path := "data/" + rtname + "/" + objname
+
+	cmd := exec.Command(GitPath, "log", "-1", "--format=%H",
+		from+".."+to, "--", path)
+	cmd.Dir = regDir
+	// execute
+	out, err := cmd.Output()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"regDir": regDir,
+			"path":   path,
+		}).Warn("Failed to execute git log for object")
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
 //////////////////////////////////////////////////////////////////////////
 // refresh the registry
 
@@ -646,6 +892,7 @@ func InitialiseRegistryData(regDir string, refresh time.Duration,
 	gitPath string, autoPull bool, branch string, token string) {
 
 	AuthorisationToken = token
+	GitPath = gitPath
 
 	// validate that the regDir/data path exists
 	dataPath := regDir + "/data"
@@ -0,0 +1,330 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"net/http"
+	"net/url"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// structured links
+//
+// The default registry responses decorate lookup attributes as markdown
+// style links, e.g. [BURBLE-MNT](mntner/BURBLE-MNT). These structures
+// provide the same information as typed references instead, with
+// lookups that don't match any object flagged as dangling.
+
+const REG_API_PATH = "/api/registry/"
+
+// a reference to a registry object
+type RegLink struct {
+	Type     string   `json:"type,omitempty"`
+	Name     string   `json:"name"`
+	URL      string   `json:"url,omitempty"`
+	Dangling bool     `json:"dangling,omitempty"`
+	Types    []string `json:"types,omitempty"` // the possible types of a dangling link
+}
+
+type RegLinkedAttribute struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value"` // the undecorated value
+	Raw   string   `json:"raw"`
+	Ref   *RegLink `json:"ref,omitempty"`
+}
+
+type RegLinkedObject struct {
+	Attributes []*RegLinkedAttribute
+	Backlinks  []*RegLink
+}
+
+// return the API URL for an object
+func RegistryObjectURL(ref string) string {
+	rtype, name := RegistrySplitPath(ref)
+	return REG_API_PATH + url.PathEscape(rtype) + "/" + url.PathEscape(name)
+}
+
+// return a link to an object
+func NewRegLink(object *RegObject) *RegLink {
+	rtype, name := RegistrySplitPath(object.Ref)
+	return &RegLink{
+		Type: rtype,
+		Name: name,
+		URL:  RegistryObjectURL(object.Ref),
+	}
+}
+
+// return an attribute with its link, if the attribute is a lookup
+func (registry *Registry) LinkAttribute(object *RegObject,
+	attribute *RegAttribute) *RegLinkedAttribute {
+
+	linked := &RegLinkedAttribute{
+		Key:   attribute.Key,
+		Value: attribute.RawValue,
+		Raw:   attribute.RawValue,
+	}
+
+	types := registry.GetLookupTypes(object, attribute)
+	if types == nil || attribute.RawValue == "" {
+		return linked
+	}
+
+	if related := registry.GetRelation(object, attribute); related != nil {
+		linked.Ref = NewRegLink(related)
+		return linked
+	}
+
+	// the lookup didn't match anything
+	linked.Ref = &RegLink{
+		Name:     attribute.RawValue,
+		Dangling: true,
+		Types:    make([]string, len(types)),
+	}
+	for ix, rtype := range types {
+		linked.Ref.Types[ix] = rtype.Ref
+	}
+	return linked
+}
+
+// return an object with structured links
+func (registry *Registry) LinkObject(object *RegObject) *RegLinkedObject {
+
+	linked := &RegLinkedObject{
+		Attributes: make([]*RegLinkedAttribute, len(object.Data)),
+		Backlinks:  make([]*RegLink, len(object.Backlinks)),
+	}
+
+	for ix, attribute := range object.Data {
+		linked.Attributes[ix] = registry.LinkAttribute(object, attribute)
+	}
+	for ix, backlink := range object.Backlinks {
+		linked.Backlinks[ix] = NewRegLink(backlink)
+	}
+
+	return linked
+}
+
+//////////////////////////////////////////////////////////////////////////
+// HAL (application/hal+json)
+//
+// objects are embedded in the response, with links for each lookup
+// attribute key and the backlinks. Dangling lookups have no link but
+// remain flagged within the attributes.
+
+type RegHALLink struct {
+	Href string `json:"href"`
+	Name string `json:"name,omitempty"`
+}
+
+type RegHALObject struct {
+	Links      *OrderedJSON          `json:"_links"`
+	Type       string                `json:"type"`
+	Name       string                `json:"name"`
+	Attributes []*RegLinkedAttribute `json:"attributes"`
+}
+
+type RegHALCollection struct {
+	Links    map[string]*RegHALLink `json:"_links"`
+	Total    int                    `json:"total"`
+	Commit   string                 `json:"commit"`
+	Embedded struct {
+		Objects []*RegHALObject `json:"objects"`
+	} `json:"_embedded"`
+}
+
+// return a link within a hypermedia response, in the same format
+func regHypermediaURL(ref string, links string) string {
+	return RegistryObjectURL(ref) + "?links=" + links
+}
+
+func (registry *Registry) HALObject(object *RegObject) *RegHALObject {
+
+	rtype, name := RegistrySplitPath(object.Ref)
+	linked := registry.LinkObject(object)
+
+	hal := &RegHALObject{
+		Links:      NewOrderedJSON(),
+		Type:       rtype,
+		Name:       name,
+		Attributes: linked.Attributes,
+	}
+	hal.Links.Set("self", &RegHALLink{Href: regHypermediaURL(object.Ref, "hal")})
+
+	// links are grouped by attribute key, in the order of the attributes
+	keys := make(map[string][]*RegHALLink)
+	for _, attribute := range linked.Attributes {
+		if attribute.Ref != nil && !attribute.Ref.Dangling {
+			if keys[attribute.Key] == nil {
+				hal.Links.Set(attribute.Key, nil)
+			}
+			keys[attribute.Key] = append(keys[attribute.Key], &RegHALLink{
+				Href: regHypermediaURL(
+					RegistryMakePath(attribute.Ref.Type, attribute.Ref.Name), "hal"),
+				Name: attribute.Ref.Name,
+			})
+		}
+	}
+	for key, links := range keys {
+		hal.Links.Set(key, links)
+	}
+
+	backlinks := make([]*RegHALLink, len(object.Backlinks))
+	for ix, backlink := range object.Backlinks {
+		backlinks[ix] = &RegHALLink{
+			Href: regHypermediaURL(backlink.Ref, "hal"),
+			Name: backlink.Ref,
+		}
+	}
+	hal.Links.Set("backlinks", backlinks)
+
+	return hal
+}
+
+func (registry *Registry) HALCollection(r *http.Request, page *regPage,
+	objects []*RegObject) *RegHALCollection {
+
+	hal := &RegHALCollection{
+		Links: map[string]*RegHALLink{
+			"self": {Href: r.URL.RequestURI()},
+		},
+		Total:  page.total,
+		Commit: registry.Commit,
+	}
+	if next := page.nextURL(r); next != "" {
+		hal.Links["next"] = &RegHALLink{Href: next}
+	}
+
+	hal.Embedded.Objects = make([]*RegHALObject, len(objects))
+	for ix, object := range objects {
+		hal.Embedded.Objects[ix] = registry.HALObject(object)
+	}
+
+	return hal
+}
+
+//////////////////////////////////////////////////////////////////////////
+// JSON:API (application/vnd.api+json)
+//
+// each object is a resource, identified by type and name, with its
+// attributes as a list and a relationship for each lookup attribute key
+// and the backlinks. Dangling lookups are listed in the relationship meta.
+
+type RegJSONAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type RegJSONAPIRelationshipMeta struct {
+	Dangling []string `json:"dangling"`
+}
+
+type RegJSONAPIRelationship struct {
+	Data []*RegJSONAPIIdentifier     `json:"data"`
+	Meta *RegJSONAPIRelationshipMeta `json:"meta,omitempty"`
+}
+
+type RegJSONAPIResource struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		Entries []*RegLinkedAttribute `json:"entries"`
+	} `json:"attributes"`
+	Relationships *OrderedJSON      `json:"relationships"`
+	Links         map[string]string `json:"links"`
+}
+
+type RegJSONAPIDocument struct {
+	Data []*RegJSONAPIResource `json:"data"`
+	Meta struct {
+		Total  int    `json:"total"`
+		Commit string `json:"commit"`
+	} `json:"meta"`
+	Links map[string]string `json:"links"`
+}
+
+func (registry *Registry) JSONAPIResource(object *RegObject) *RegJSONAPIResource {
+
+	rtype, name := RegistrySplitPath(object.Ref)
+	linked := registry.LinkObject(object)
+
+	resource := &RegJSONAPIResource{
+		Type:          rtype,
+		ID:            name,
+		Relationships: NewOrderedJSON(),
+		Links: map[string]string{
+			"self": regHypermediaURL(object.Ref, "jsonapi"),
+		},
+	}
+	resource.Attributes.Entries = linked.Attributes
+
+	// relationships are grouped by attribute key, in attribute order
+	relationships := make(map[string]*RegJSONAPIRelationship)
+	for _, attribute := range linked.Attributes {
+		if attribute.Ref == nil {
+			continue
+		}
+
+		relationship := relationships[attribute.Key]
+		if relationship == nil {
+			relationship = &RegJSONAPIRelationship{
+				Data: make([]*RegJSONAPIIdentifier, 0),
+			}
+			relationships[attribute.Key] = relationship
+			resource.Relationships.Set(attribute.Key, relationship)
+		}
+
+		if attribute.Ref.Dangling {
+			if relationship.Meta == nil {
+				relationship.Meta = &RegJSONAPIRelationshipMeta{}
+			}
+			relationship.Meta.Dangling =
+				append(relationship.Meta.Dangling, attribute.Ref.Name)
+		} else {
+			relationship.Data = append(relationship.Data,
+				&RegJSONAPIIdentifier{
+					Type: attribute.Ref.Type,
+					ID:   attribute.Ref.Name,
+				})
+		}
+	}
+
+	backlinks := &RegJSONAPIRelationship{
+		Data: make([]*RegJSONAPIIdentifier, len(object.Backlinks)),
+	}
+	for ix, backlink := range object.Backlinks {
+		btype, bname := RegistrySplitPath(backlink.Ref)
+		backlinks.Data[ix] = &RegJSONAPIIdentifier{Type: btype, ID: bname}
+	}
+	resource.Relationships.Set("backlinks", backlinks)
+
+	return resource
+}
+
+func (registry *Registry) JSONAPIDocument(r *http.Request, page *regPage,
+	objects []*RegObject) *RegJSONAPIDocument {
+
+	doc := &RegJSONAPIDocument{
+		Data: make([]*RegJSONAPIResource, len(objects)),
+		Links: map[string]string{
+			"self": r.URL.RequestURI(),
+		},
+	}
+	doc.Meta.Total = page.total
+	doc.Meta.Commit = registry.Commit
+	if next := page.nextURL(r); next != "" {
+		doc.Links["next"] = next
+	}
+
+	for ix, object := range objects {
+		doc.Data[ix] = registry.JSONAPIResource(object)
+	}
+
+	return doc
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,671 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// structured attribute search
+//
+// A query is a boolean expression of terms, each comparing the values
+// of a key against a value:
+//
+//   type = aut-num AND as-name ~ /^BURBLE/
+//   inetnum within 172.20.0.0/16 AND NOT mnt-by = DN42-MNT
+//   aut-num >= AS4242420000 OR (descr ^= burble AND tech-c exists)
+//
+// terms next to each other are implicitly joined by AND. The pseudo keys
+// 'type' and 'name' match the object type and name, and '*' matches any
+// key. Terms are evaluated against the key indices to produce sets of
+// objects, which are then combined.
+
+const (
+	REG_SEARCH_MAX_QUERY     = 1024
+	REG_SEARCH_DEFAULT_LIMIT = 100
+	REG_SEARCH_MAX_LIMIT     = 1000
+)
+
+type RegSearchResponse struct {
+	Query      string
+	Total      int
+	Offset     int
+	Limit      int
+	Results    []string
+	Attributes map[string]map[string][]string `json:",omitempty"`
+}
+
+// a set of objects
+type regObjectSet map[*RegObject]bool
+
+// nodes in the parsed query
+type regQueryNode interface {
+	eval(search *regSearch) regObjectSet
+}
+
+type regQueryAnd struct {
+	left, right regQueryNode
+}
+
+type regQueryOr struct {
+	left, right regQueryNode
+}
+
+type regQueryNot struct {
+	node regQueryNode
+}
+
+type regQueryTerm struct {
+	key   string
+	op    string
+	value string
+
+	regex  *regexp.Regexp
+	number uint64
+	first  net.IP
+	last   net.IP
+}
+
+// state for executing a query
+type regSearch struct {
+	registry *Registry
+	all      regObjectSet
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitRegSearchAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitRegSearchAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/search", regSearchHandler).Methods("GET")
+
+	log.Info("Registry search API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// search handler
+
+func regSearchHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	raw := query["raw"]
+
+	if q == "" {
+		http.Error(w, "Missing query, use ?q=", http.StatusBadRequest)
+		return
+	}
+	if len(q) > REG_SEARCH_MAX_QUERY {
+		http.Error(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+
+	offset, limit, err := regSearchPage(query.Get("offset"), query.Get("limit"),
+		REG_SEARCH_DEFAULT_LIMIT, REG_SEARCH_MAX_LIMIT)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	node, err := ParseRegQuery(q)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registry := RegistryData
+	objects := RegSearch(registry, node)
+
+	response := &RegSearchResponse{
+		Query:   q,
+		Total:   len(objects),
+		Offset:  offset,
+		Limit:   limit,
+		Results: make([]string, 0),
+	}
+
+	if offset < len(objects) {
+		end := offset + limit
+		if end > len(objects) {
+			end = len(objects)
+		}
+		objects = objects[offset:end]
+	} else {
+		objects = nil
+	}
+
+	for _, object := range objects {
+		response.Results = append(response.Results, object.Ref)
+	}
+
+	// include the values of any requested keys
+	if keys := query.Get("keys"); keys != "" && len(objects) > 0 {
+		response.Attributes = make(map[string]map[string][]string)
+		for _, object := range objects {
+			values := make(map[string][]string)
+			for _, key := range strings.Split(keys, ",") {
+				for _, attribute := range object.GetKey(strings.TrimSpace(key)) {
+					value := attribute.Value
+					if raw != nil {
+						value = attribute.RawValue
+					}
+					values[attribute.Key] = append(values[attribute.Key], value)
+				}
+			}
+			response.Attributes[object.Ref] = values
+		}
+	}
+
+	// cache for up to a day, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", registry.Commit)
+
+	(&Response{Data: response, Records: response.Results}).Write(w, r)
+}
+
+// parse the pagination parameters
+func regSearchPage(offsetStr string, limitStr string,
+	defaultLimit int, maxLimit int) (int, int, error) {
+
+	offset, limit := 0, defaultLimit
+
+	if offsetStr != "" {
+		v, err := strconv.Atoi(offsetStr)
+		if err != nil || v < 0 {
+			return 0, 0, fmt.Errorf("Invalid offset '%s'", offsetStr)
+		}
+		offset = v
+	}
+
+	if limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v < 1 || v > maxLimit {
+			return 0, 0, fmt.Errorf("Invalid limit '%s', must be 1 to %d",
+				limitStr, maxLimit)
+		}
+		limit = v
+	}
+
+	return offset, limit, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// execute a query, returning the matching objects sorted by reference
+
+func RegSearch(registry *Registry, node regQueryNode) []*RegObject {
+
+	search := &regSearch{registry: registry}
+	set := node.eval(search)
+
+	objects := make([]*RegObject, 0, len(set))
+	for object := range set {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Ref < objects[j].Ref
+	})
+
+	return objects
+}
+
+// return every object in the registry, used for negation
+func (search *regSearch) universe() regObjectSet {
+	if search.all == nil {
+		search.all = make(regObjectSet)
+		for _, rtype := range search.registry.Types {
+			for _, object := range rtype.Objects {
+				search.all[object] = true
+			}
+		}
+	}
+	return search.all
+}
+
+func (node *regQueryAnd) eval(search *regSearch) regObjectSet {
+	left := node.left.eval(search)
+	if len(left) == 0 {
+		return left
+	}
+	right := node.right.eval(search)
+	result := make(regObjectSet)
+	for object := range left {
+		if right[object] {
+			result[object] = true
+		}
+	}
+	return result
+}
+
+func (node *regQueryOr) eval(search *regSearch) regObjectSet {
+	result := node.left.eval(search)
+	for object := range node.right.eval(search) {
+		result[object] = true
+	}
+	return result
+}
+
+func (node *regQueryNot) eval(search *regSearch) regObjectSet {
+	exclude := node.node.eval(search)
+	result := make(regObjectSet)
+	for object := range search.universe() {
+		if !exclude[object] {
+			result[object] = true
+		}
+	}
+	return result
+}
+
+func (term *regQueryTerm) eval(search *regSearch) regObjectSet {
+
+	result := make(regObjectSet)
+
+	switch term.key {
+	case "type":
+		for _, rtype := range search.registry.Types {
+			if term.match(rtype.Ref) {
+				for _, object := range rtype.Objects {
+					result[object] = true
+				}
+			}
+		}
+
+	case "name":
+		for _, rtype := range search.registry.Types {
+			for name, object := range rtype.Objects {
+				if term.match(name) {
+					result[object] = true
+				}
+			}
+		}
+
+	case "*":
+		// scan every attribute of every object, rather than the key
+		// indices, so that any key matches whether it was indexed or not
+		for _, rtype := range search.registry.Types {
+			for _, object := range rtype.Objects {
+				for _, attribute := range object.Data {
+					if term.match(attribute.RawValue) {
+						result[object] = true
+						break
+					}
+				}
+			}
+		}
+
+	default:
+		for _, schema := range search.registry.Schema {
+			if keyix := schema.KeyIndex[term.key]; keyix != nil {
+				for object, attributes := range keyix.Objects {
+					if result[object] {
+						continue
+					}
+					for _, attribute := range attributes {
+						if term.match(attribute.RawValue) {
+							result[object] = true
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// return true if a value matches the term
+func (term *regQueryTerm) match(value string) bool {
+
+	switch term.op {
+	case "exists":
+		return true
+
+	case "=":
+		return strings.EqualFold(value, term.value)
+
+	case "^=":
+		return len(value) >= len(term.value) &&
+			strings.EqualFold(value[:len(term.value)], term.value)
+
+	case "~":
+		return term.regex.MatchString(value)
+
+	case "<", "<=", ">", ">=":
+		number, ok := regQueryNumber(value)
+		if !ok {
+			return false
+		}
+		switch term.op {
+		case "<":
+			return number < term.number
+		case "<=":
+			return number <= term.number
+		case ">":
+			return number > term.number
+		}
+		return number >= term.number
+
+	case "within":
+		first, last, ok := netParseRange(value)
+		return ok && netRangeWithin(term.first, term.last, first, last)
+
+	case "contains":
+		first, last, ok := netParseRange(value)
+		return ok && netRangeWithin(first, last, term.first, term.last)
+	}
+
+	return false
+}
+
+// parse a number, allowing an AS prefix
+func regQueryNumber(value string) (uint64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > 2 && strings.EqualFold(value[:2], "AS") {
+		value = value[2:]
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	return number, err == nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// query parsing
+//
+//   query   = and { OR and }
+//   and     = not { [AND] not }
+//   not     = NOT not | primary
+//   primary = '(' query ')' | key operator value | key exists
+
+type regQueryToken struct {
+	kind byte // 'w' word, 's' quoted string, 'r' regex, 'o' operator, '(' or ')'
+	text string
+	pos  int
+}
+
+type regQueryParser struct {
+	tokens []*regQueryToken
+	ix     int
+}
+
+// parse a query string
+func ParseRegQuery(q string) (regQueryNode, error) {
+
+	tokens, err := regQueryTokenise(q)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &regQueryParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token != nil {
+		return nil, fmt.Errorf("unexpected '%s' at position %d",
+			token.text, token.pos)
+	}
+
+	return node, nil
+}
+
+// split a query in to tokens
+func regQueryTokenise(q string) ([]*regQueryToken, error) {
+
+	tokens := make([]*regQueryToken, 0)
+	runes := []rune(q)
+
+	for ix := 0; ix < len(runes); {
+		c := runes[ix]
+		start := ix
+
+		switch {
+		case unicode.IsSpace(c):
+			ix++
+			continue
+
+		case c == '(' || c == ')':
+			tokens = append(tokens, &regQueryToken{kind: byte(c),
+				text: string(c), pos: start})
+			ix++
+
+		case c == '"' || c == '/':
+			// quoted strings and regular expressions, with \ escapes
+			var text strings.Builder
+			for ix++; ix < len(runes) && runes[ix] != c; ix++ {
+				if runes[ix] == '\\' && ix+1 < len(runes) {
+					// keep escapes other than the delimiter for regexes
+					if c == '/' && runes[ix+1] != '/' {
+						text.WriteRune('\\')
+					}
+					ix++
+				}
+				text.WriteRune(runes[ix])
+			}
+			if ix >= len(runes) {
+				return nil, fmt.Errorf("unterminated %c at position %d", c, start)
+			}
+			ix++
+			kind := byte('s')
+			if c == '/' {
+				kind = 'r'
+			}
+			tokens = append(tokens, &regQueryToken{kind: kind,
+				text: text.String(), pos: start})
+
+		case strings.ContainsRune("=<>~^", c):
+			op := string(c)
+			if ix+1 < len(runes) && runes[ix+1] == '=' && c != '=' && c != '~' {
+				op += "="
+			}
+			if op == "^" {
+				return nil, fmt.Errorf("unknown operator '^' at position %d, "+
+					"use '^='", start)
+			}
+			ix += len(op)
+			tokens = append(tokens, &regQueryToken{kind: 'o', text: op,
+				pos: start})
+
+		default:
+			for ix < len(runes) && !unicode.IsSpace(runes[ix]) &&
+				!strings.ContainsRune("()\"=<>~^", runes[ix]) {
+				ix++
+			}
+			tokens = append(tokens, &regQueryToken{kind: 'w',
+				text: string(runes[start:ix]), pos: start})
+		}
+	}
+
+	return tokens, nil
+}
+
+// return the next token without consuming it
+func (parser *regQueryParser) peek() *regQueryToken {
+	if parser.ix < len(parser.tokens) {
+		return parser.tokens[parser.ix]
+	}
+	return nil
+}
+
+// return true, and consume the token, if the next token is a keyword
+func (parser *regQueryParser) keyword(word string) bool {
+	token := parser.peek()
+	if token != nil && token.kind == 'w' && strings.EqualFold(token.text, word) {
+		parser.ix++
+		return true
+	}
+	return false
+}
+
+func (parser *regQueryParser) parseOr() (regQueryNode, error) {
+
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.keyword("OR") {
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &regQueryOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (parser *regQueryParser) parseAnd() (regQueryNode, error) {
+
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token := parser.peek()
+		if token == nil || token.kind == ')' ||
+			(token.kind == 'w' && strings.EqualFold(token.text, "OR")) {
+			return left, nil
+		}
+		parser.keyword("AND")
+
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &regQueryAnd{left: left, right: right}
+	}
+}
+
+func (parser *regQueryParser) parseNot() (regQueryNode, error) {
+
+	if parser.keyword("NOT") {
+		node, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &regQueryNot{node: node}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *regQueryParser) parsePrimary() (regQueryNode, error) {
+
+	token := parser.peek()
+	if token == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	// sub expression
+	if token.kind == '(' {
+		parser.ix++
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := parser.peek(); next == nil || next.kind != ')' {
+			return nil, fmt.Errorf("missing ')' for '(' at position %d",
+				token.pos)
+		}
+		parser.ix++
+		return node, nil
+	}
+
+	if token.kind != 'w' {
+		return nil, fmt.Errorf("expected a key at position %d, found '%s'",
+			token.pos, token.text)
+	}
+	parser.ix++
+
+	term := &regQueryTerm{key: strings.ToLower(token.text)}
+
+	// the operator
+	op := parser.peek()
+	switch {
+	case op == nil:
+		return nil, fmt.Errorf("expected an operator after '%s'", token.text)
+	case op.kind == 'o':
+		term.op = op.text
+	case op.kind == 'w' && (strings.EqualFold(op.text, "within") ||
+		strings.EqualFold(op.text, "contains") ||
+		strings.EqualFold(op.text, "exists")):
+		term.op = strings.ToLower(op.text)
+	default:
+		return nil, fmt.Errorf("expected an operator at position %d, found '%s'",
+			op.pos, op.text)
+	}
+	parser.ix++
+
+	if term.op == "exists" {
+		return term, nil
+	}
+
+	// and the value
+	value := parser.peek()
+	if value == nil || (value.kind != 'w' && value.kind != 's' &&
+		value.kind != 'r') {
+		return nil, fmt.Errorf("expected a value after '%s %s'",
+			token.text, term.op)
+	}
+	parser.ix++
+	term.value = value.text
+
+	if value.kind == 'r' && term.op != "~" {
+		return nil, fmt.Errorf("regular expression at position %d "+
+			"can only be used with '~'", value.pos)
+	}
+
+	switch term.op {
+	case "~":
+		regex, err := regexp.Compile(term.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %s",
+				value.pos, err)
+		}
+		term.regex = regex
+
+	case "<", "<=", ">", ">=":
+		number, ok := regQueryNumber(term.value)
+		if !ok {
+			return nil, fmt.Errorf("'%s' at position %d is not a number",
+				term.value, value.pos)
+		}
+		term.number = number
+
+	case "within", "contains":
+		first, last, ok := netParseRange(term.value)
+		if !ok {
+			return nil, fmt.Errorf("'%s' at position %d is not a prefix, "+
+				"address or range", term.value, value.pos)
+		}
+		term.first, term.last = first, last
+	}
+
+	return term, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,240 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// run a query against a registry, returning the matching references
+func testRegSearch(t *testing.T, registry *Registry, q string) string {
+
+	node, err := ParseRegQuery(q)
+	if err != nil {
+		t.Fatalf("%s: %s", q, err)
+	}
+
+	refs := make([]string, 0)
+	for _, object := range RegSearch(registry, node) {
+		refs = append(refs, object.Ref)
+	}
+	return strings.Join(refs, " ")
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestRegSearch(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		// OR binds less tightly than AND
+		{"mnt-by = BAR-MNT OR netname = FOO AND type = route",
+			"domain/bar.dn42 inetnum/172.20.1.160_27 mntner/BAR-MNT"},
+		{"(mnt-by = BAR-MNT OR netname = FOO) AND type = inetnum",
+			"inetnum/172.20.0.0_24 inetnum/172.20.1.160_27"},
+		{"type = person OR type = mntner AND mnt-by = BAR-MNT",
+			"mntner/BAR-MNT person/BAR-DN42 person/FOO-DN42"},
+		// terms next to each other are joined by AND
+		{"type = route origin = AS4242420001", "route/172.20.0.0_24"},
+		{"type = route and origin = AS4242420001", "route/172.20.0.0_24"},
+		// NOT binds more tightly than AND
+		{"type = route NOT origin = AS4242420001",
+			"route/172.20.0.128_25 route/172.20.1.0_24"},
+		{"NOT NOT type = person", "person/BAR-DN42 person/FOO-DN42"},
+		{"type = inetnum NOT (netname = ROOT OR netname = ROOT16)",
+			"inetnum/172.20.0.0_24 inetnum/172.20.1.160_27"},
+		// pseudo keys
+		{"name = AS-FOO", "as-set/AS-FOO"},
+		{"type = as-set name ^= as-b", "as-set/AS-BAR"},
+		// prefixes and ranges
+		{"inetnum within 172.20.0.0/16",
+			"inetnum/172.20.0.0_16 inetnum/172.20.0.0_24 inetnum/172.20.1.160_27"},
+		{"route within 172.20.0.0/24",
+			"route/172.20.0.0_24 route/172.20.0.128_25"},
+		{"cidr contains 172.20.1.170",
+			"inetnum/172.20.0.0_14 inetnum/172.20.0.0_16 inetnum/172.20.1.160_27"},
+		{"cidr within fd42::/16", "inet6num/fd42:1::_50"},
+		// numbers, with or without an AS prefix
+		{"aut-num >= AS4242420000",
+			"aut-num/AS4242420001 aut-num/AS4242420002 aut-num/AS4242420010"},
+		{"aut-num > AS4242420002", "aut-num/AS4242420010"},
+		{"aut-num <= 4242420002", "aut-num/AS4242420001 aut-num/AS4242420002"},
+		{"aut-num < as4242420001", ""},
+		// matching is not case sensitive, except for regexes
+		{"netname ^= root",
+			"inet6num/fd00::_8 inetnum/172.20.0.0_14 inetnum/172.20.0.0_16"},
+		{"netname = small", "inetnum/172.20.1.160_27"},
+		{"netname ~ /^root/", ""},
+		{"netname ~ /(?i)^root$/", "inetnum/172.20.0.0_14"},
+		// regex and string escaping
+		{`as-name ~ /^AS424242001/`, "aut-num/AS4242420010"},
+		{`cidr ~ /\/27$/`, "inetnum/172.20.1.160_27"},
+		{`cidr ~ /^172\.20\.1\./`, "inetnum/172.20.1.160_27"},
+		{`cidr ~ /^172.20.0.0\/1[46]$/`,
+			"inetnum/172.20.0.0_14 inetnum/172.20.0.0_16"},
+		{`person = "Missing link"`, "person/BAR-DN42"},
+		{`person = "missing \link"`, "person/BAR-DN42"},
+		{`person = "Missing"`, ""},
+		// keys
+		{"type = inetnum ds-rdata exists", "inetnum/172.20.1.160_27"},
+		{"x-aspa-provider exists", "aut-num/AS4242420002"},
+		{"* = AS4242420010", "aut-num/AS4242420002 aut-num/AS4242420010 " +
+			"route/172.20.0.128_25"},
+		{"* ~ /^Missing/", "person/BAR-DN42"},
+		{"no-such-key exists", ""},
+	}
+
+	for _, test := range tests {
+		if refs := testRegSearch(t, registry, test.query); refs != test.expected {
+			t.Errorf("%s: got '%s', expected '%s'", test.query, refs,
+				test.expected)
+		}
+	}
+}
+
+func TestRegQueryTokenise(t *testing.T) {
+
+	tokens, err := regQueryTokenise(`descr = "say \"hi\"" AND x ~ /a\/b\.c/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"w:descr", "o:=", `s:say "hi"`, "w:AND", "w:x",
+		"o:~", `r:a/b\.c`}
+	if len(tokens) != len(expected) {
+		t.Fatalf("%d tokens, expected %d", len(tokens), len(expected))
+	}
+	for ix, token := range tokens {
+		if text := string(token.kind) + ":" + token.text; text != expected[ix] {
+			t.Errorf("token %d is '%s', expected '%s'", ix, text, expected[ix])
+		}
+	}
+}
+
+func TestRegQueryErrors(t *testing.T) {
+
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"as-name ~ /abc", "unterminated / at position 10"},
+		{`person = "abc`, `unterminated " at position 9`},
+		{`person = "abc\"`, `unterminated " at position 9`},
+		{"netname ^ foo", "unknown operator '^' at position 8"},
+		{"netname ^", "unknown operator '^' at position 8"},
+		{"(type = route", "missing ')' for '(' at position 0"},
+		{"type = route)", "unexpected ')' at position 12"},
+		{"type = route OR", "unexpected end of query"},
+		{"NOT", "unexpected end of query"},
+		{"type", "expected an operator after 'type'"},
+		{"type foo", "expected an operator at position 5, found 'foo'"},
+		{"type =", "expected a value after 'type ='"},
+		{"= route", "expected a key at position 0, found '='"},
+		{"netname = /foo/", "regular expression at position 10 " +
+			"can only be used with '~'"},
+		{"as-name ~ /[/", "invalid regular expression at position 10"},
+		{"aut-num > foo", "'foo' at position 10 is not a number"},
+		{"cidr within nowhere", "'nowhere' at position 12 is not a prefix"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseRegQuery(test.query)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: error '%v', expected '%s'", test.query, err, test.err)
+		}
+	}
+}
+
+func TestRegSearchAPI(t *testing.T) {
+
+	saved := RegistryData
+	RegistryData = testLoadRegistry(t)
+	t.Cleanup(func() { RegistryData = saved })
+
+	router := mux.NewRouter()
+	InitRegSearchAPI(router)
+
+	search := func(q string, params string) *RegSearchResponse {
+		w := testAPIRequest(router, "/search?q="+url.QueryEscape(q)+params)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s%s: status %d: %s", q, params, w.Code, w.Body.String())
+		}
+		response := &RegSearchResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// pages of results
+	tests := []struct {
+		params   string
+		offset   int
+		limit    int
+		expected string
+	}{
+		{"", 0, REG_SEARCH_DEFAULT_LIMIT,
+			"route/172.20.0.0_24 route/172.20.0.128_25 route/172.20.1.0_24"},
+		{"&limit=1", 0, 1, "route/172.20.0.0_24"},
+		{"&offset=1&limit=1", 1, 1, "route/172.20.0.128_25"},
+		{"&offset=2&limit=5", 2, 5, "route/172.20.1.0_24"},
+		{"&offset=3", 3, REG_SEARCH_DEFAULT_LIMIT, ""},
+		{"&offset=100", 100, REG_SEARCH_DEFAULT_LIMIT, ""},
+		{"&limit=1000", 0, REG_SEARCH_MAX_LIMIT,
+			"route/172.20.0.0_24 route/172.20.0.128_25 route/172.20.1.0_24"},
+	}
+
+	for _, test := range tests {
+		response := search("type = route", test.params)
+		results := strings.Join(response.Results, " ")
+		if response.Total != 3 || response.Offset != test.offset ||
+			response.Limit != test.limit || results != test.expected {
+			t.Errorf("%s: unexpected response %+v", test.params, response)
+		}
+	}
+
+	// requested keys
+	response := search("type = route", "&limit=1&keys=origin,max-length")
+	values := response.Attributes["route/172.20.0.0_24"]
+	if len(response.Attributes) != 1 || len(values["origin"]) != 1 ||
+		len(values["max-length"]) != 1 || values["max-length"][0] != "29" {
+		t.Errorf("unexpected attributes %+v", response.Attributes)
+	}
+
+	// invalid requests
+	for _, request := range []string{
+		"/search",
+		"/search?q=",
+		"/search?q=" + strings.Repeat("x", REG_SEARCH_MAX_QUERY+1),
+		"/search?q=type+%3D+route&limit=0",
+		"/search?q=type+%3D+route&limit=1001",
+		"/search?q=type+%3D+route&limit=x",
+		"/search?q=type+%3D+route&offset=-1",
+		"/search?q=type+%3D+route&offset=x",
+		"/search?q=type+%3D",
+	} {
+		if w := testAPIRequest(router, request); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, expected %d", request, w.Code,
+				http.StatusBadRequest)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
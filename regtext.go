@@ -0,0 +1,559 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// full text search
+//
+// An inverted index is built from object names and attribute values each
+// time the registry is reloaded. Values are split in to words, and words
+// that look like nic-hdls, ASNs or prefixes are also indexed by their
+// parts so that, for example, 'burble' finds BURBLE-MNT, '4242422601'
+// finds AS4242422601 and '172.20.0.0' finds 172.20.0.0/16.
+//
+// Addresses and prefixes in a query match the prefixes that contain them,
+// rather than terms that happen to start with the same characters, so
+// '172.20.0.1' finds 172.20.0.0/24 but not 172.20.0.128/25.
+
+const (
+	REG_TEXT_MAX_QUERY     = 256
+	REG_TEXT_MAX_WORDS     = 8
+	REG_TEXT_DEFAULT_LIMIT = 20
+	REG_TEXT_MAX_LIMIT     = 100
+
+	REG_SUGGEST_DEFAULT_LIMIT = 10
+	REG_SUGGEST_MAX_LIMIT     = 50
+	REG_SUGGEST_MAX_OBJECTS   = 10
+
+	// limit the number of terms examined when expanding a prefix
+	REG_TEXT_MAX_EXPANSION = 2000
+
+	// relative weights of where a word was found
+	REG_TEXT_WEIGHT_NAME      = 10.0
+	REG_TEXT_WEIGHT_ATTRIBUTE = 2.0
+	REG_TEXT_WEIGHT_REFERENCE = 1.0
+	REG_TEXT_WEIGHT_PART      = 0.5
+	REG_TEXT_WEIGHT_PREFIX    = 0.5
+	REG_TEXT_WEIGHT_CONTAINS  = 0.5
+)
+
+type regTextPosting struct {
+	object *RegObject
+	weight float64
+}
+
+type RegTextIndex struct {
+	Commit   string
+	Objects  int
+	postings map[string][]*regTextPosting // objects containing each term
+	names    map[string][]*RegObject      // objects named by each term
+	terms    []string                     // all terms, sorted
+}
+
+var RegTextIndexData *RegTextIndex
+
+type RegTextResult struct {
+	Ref   string
+	Score float64
+}
+
+type RegTextResponse struct {
+	Query   string
+	Total   int
+	Offset  int
+	Limit   int
+	Results []*RegTextResult
+}
+
+type RegTextSuggestion struct {
+	Text    string
+	Count   int      // the number of objects containing the term
+	Objects []string `json:",omitempty"` // objects named by the term
+}
+
+type RegTextSuggestResponse struct {
+	Prefix      string
+	Suggestions []*RegTextSuggestion
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitRegTextAPI)
+	EventBus.Listen("RegistryUpdate", RegTextUpdate)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitRegTextAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	s := router.
+		Methods("GET").
+		PathPrefix("/search").
+		Subrouter()
+
+	s.HandleFunc("/text", regTextHandler)
+	s.HandleFunc("/suggest", regSuggestHandler)
+
+	log.Info("Registry text search API installed")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// rebuild the index when the registry is updated
+
+func RegTextUpdate(params ...interface{}) {
+
+	registry := params[0].(*Registry)
+
+	start := time.Now()
+	index := BuildRegTextIndex(registry)
+
+	log.WithFields(log.Fields{
+		"commit":  index.Commit,
+		"objects": index.Objects,
+		"terms":   len(index.terms),
+		"elapsed": time.Since(start),
+	}).Info("Text search index updated")
+
+	RegTextIndexData = index
+}
+
+//////////////////////////////////////////////////////////////////////////
+// api handlers
+
+// ranked full text search
+func regTextHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+
+	if q == "" {
+		http.Error(w, "Missing query, use ?q=", http.StatusBadRequest)
+		return
+	}
+	if len(q) > REG_TEXT_MAX_QUERY {
+		http.Error(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+
+	offset, limit, err := regSearchPage(query.Get("offset"), query.Get("limit"),
+		REG_TEXT_DEFAULT_LIMIT, REG_TEXT_MAX_LIMIT)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index := RegTextIndexData
+	results := index.Search(q)
+
+	response := &RegTextResponse{
+		Query:   q,
+		Total:   len(results),
+		Offset:  offset,
+		Limit:   limit,
+		Results: make([]*RegTextResult, 0),
+	}
+
+	if offset < len(results) {
+		end := offset + limit
+		if end > len(results) {
+			end = len(results)
+		}
+		response.Results = results[offset:end]
+	}
+
+	// cache for up to a day, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", index.Commit)
+
+	(&Response{Data: response, Records: response.Results}).Write(w, r)
+}
+
+// as-you-type completion
+func regSuggestHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	prefix := regTextNormalise(strings.ToLower(strings.TrimSpace(query.Get("prefix"))))
+
+	if prefix == "" {
+		http.Error(w, "Missing prefix, use ?prefix=", http.StatusBadRequest)
+		return
+	}
+	if len(prefix) > REG_TEXT_MAX_QUERY {
+		http.Error(w, "Prefix is too long", http.StatusBadRequest)
+		return
+	}
+
+	_, limit, err := regSearchPage("", query.Get("limit"),
+		REG_SUGGEST_DEFAULT_LIMIT, REG_SUGGEST_MAX_LIMIT)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index := RegTextIndexData
+
+	response := &RegTextSuggestResponse{
+		Prefix:      prefix,
+		Suggestions: index.Suggest(prefix, limit),
+	}
+
+	// cache for up to a day, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=86400")
+	w.Header().Set("ETag", index.Commit)
+
+	(&Response{Data: response, Records: response.Suggestions}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// build the index from the registry
+
+func BuildRegTextIndex(registry *Registry) *RegTextIndex {
+
+	index := &RegTextIndex{
+		Commit:   registry.Commit,
+		postings: make(map[string][]*regTextPosting),
+		names:    make(map[string][]*RegObject),
+	}
+
+	// the highest weight for each term in each object
+	weights := make(map[string]map[*RegObject]float64)
+	add := func(object *RegObject, weight float64) func(string, bool) {
+		return func(term string, whole bool) {
+			w := weight
+			if !whole {
+				w *= REG_TEXT_WEIGHT_PART
+			}
+			objects := weights[term]
+			if objects == nil {
+				objects = make(map[*RegObject]float64)
+				weights[term] = objects
+			}
+			if w > objects[object] {
+				objects[object] = w
+			}
+		}
+	}
+
+	for typeName, rType := range registry.Types {
+		schema := registry.Schema[typeName]
+
+		for name, object := range rType.Objects {
+			index.Objects++
+
+			regTextTerms(name, add(object, REG_TEXT_WEIGHT_NAME))
+			if term := regTextNormalise(strings.ToLower(name)); term != "" {
+				index.names[term] = append(index.names[term], object)
+			}
+
+			for _, attribute := range object.Data {
+
+				// references to other objects are less interesting
+				// than values that belong to this object
+				weight := REG_TEXT_WEIGHT_ATTRIBUTE
+				if schema != nil {
+					as := schema.Attributes[attribute.Key]
+					if as != nil && len(as.Relations) > 0 {
+						weight = REG_TEXT_WEIGHT_REFERENCE
+					}
+				}
+
+				regTextTerms(attribute.RawValue, add(object, weight))
+			}
+		}
+	}
+
+	// convert to postings lists
+	index.terms = make([]string, 0, len(weights))
+	for term, objects := range weights {
+		postings := make([]*regTextPosting, 0, len(objects))
+		for object, weight := range objects {
+			postings = append(postings, &regTextPosting{
+				object: object,
+				weight: weight,
+			})
+		}
+		index.postings[term] = postings
+		index.terms = append(index.terms, term)
+	}
+	sort.Strings(index.terms)
+
+	for _, objects := range index.names {
+		sort.Slice(objects, func(i, j int) bool {
+			return objects[i].Ref < objects[j].Ref
+		})
+	}
+
+	return index
+}
+
+//////////////////////////////////////////////////////////////////////////
+// tokenising
+
+// return true for characters that separate words
+func regTextSeparator(c rune) bool {
+	return unicode.IsSpace(c) || strings.ContainsRune(",;()[]{}<>\"'|`", c)
+}
+
+// normalise a lower case word, returning an empty string if the word
+// is too short to be useful
+func regTextNormalise(word string) string {
+
+	// prefixes in object names use '_' in place of '/'
+	if strings.ContainsRune(word, '_') {
+		if _, _, err := net.ParseCIDR(strings.Replace(word, "_", "/", 1)); err == nil {
+			return strings.Replace(word, "_", "/", 1)
+		}
+	}
+
+	// strip punctuation, taking care not to damage IPv6 addresses
+	if net.ParseIP(word) == nil {
+		word = strings.Trim(word, ".:!?")
+	}
+
+	if len(word) < 2 {
+		return ""
+	}
+	return word
+}
+
+// call fn with each term in some text, whole words are followed by
+// any parts that they contain
+func regTextTerms(text string, fn func(string, bool)) {
+
+	for _, field := range strings.FieldsFunc(strings.ToLower(text), regTextSeparator) {
+		word := regTextNormalise(field)
+		if word == "" {
+			continue
+		}
+		fn(word, true)
+
+		for _, part := range regTextParts(word) {
+			fn(part, false)
+		}
+	}
+}
+
+// split a word in to its component parts
+func regTextParts(word string) []string {
+
+	// AS numbers are also indexed by number
+	if strings.HasPrefix(word, "as") && len(word) > 2 &&
+		strings.IndexFunc(word[2:], func(c rune) bool {
+			return c < '0' || c > '9'
+		}) == -1 {
+		return []string{word[2:]}
+	}
+
+	// prefixes by address and in their canonical form, so that they
+	// are found however they were written, and addresses not at all
+	if ip, network, err := net.ParseCIDR(word); err == nil {
+		if network.String() != word {
+			return []string{ip.String(), network.String()}
+		}
+		return []string{ip.String()}
+	}
+	if net.ParseIP(word) != nil {
+		return nil
+	}
+
+	// anything else is split on punctuation, so that nic-hdls
+	// and e-mail addresses can be found by their components
+	parts := strings.FieldsFunc(word, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	if len(parts) < 2 {
+		return nil
+	}
+
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if len(part) >= 2 {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+//////////////////////////////////////////////////////////////////////////
+// querying
+
+// return the terms starting with prefix
+func (index *RegTextIndex) expand(prefix string) []string {
+	start := sort.SearchStrings(index.terms, prefix)
+	end := start
+	for end < len(index.terms) && end-start < REG_TEXT_MAX_EXPANSION &&
+		strings.HasPrefix(index.terms[end], prefix) {
+		end++
+	}
+	return index.terms[start:end]
+}
+
+// inverse document frequency, rarer terms score more highly
+func (index *RegTextIndex) idf(term string) float64 {
+	return math.Log(1 + float64(index.Objects)/float64(len(index.postings[term])))
+}
+
+// search the index, every word in the query must match and the last
+// word may also be a prefix
+func (index *RegTextIndex) Search(q string) []*RegTextResult {
+
+	words := make([]string, 0)
+	for _, field := range strings.FieldsFunc(strings.ToLower(q), regTextSeparator) {
+		if word := regTextNormalise(field); word != "" {
+			words = append(words, word)
+		}
+	}
+	if len(words) > REG_TEXT_MAX_WORDS {
+		words = words[:REG_TEXT_MAX_WORDS]
+	}
+
+	var scores map[*RegObject]float64
+	for ix, word := range words {
+
+		matches := make(map[*RegObject]float64)
+		score := func(term string, factor float64) {
+			idf := index.idf(term) * factor
+			for _, posting := range index.postings[term] {
+				if s := posting.weight * idf; s > matches[posting.object] {
+					matches[posting.object] = s
+				}
+			}
+		}
+
+		score(word, 1)
+		if network := regTextNetwork(word); network != nil {
+			// walk the shorter masks, so that more specific prefixes
+			// score more highly
+			plen, bits := network.Mask.Size()
+			for l := plen; l >= 0; l-- {
+				mask := net.CIDRMask(l, bits)
+				term := (&net.IPNet{IP: network.IP.Mask(mask), Mask: mask}).String()
+				if term != word {
+					score(term, REG_TEXT_WEIGHT_CONTAINS*float64(l+1)/float64(bits+1))
+				}
+			}
+		} else if ix == len(words)-1 {
+			for _, term := range index.expand(word) {
+				if term != word {
+					score(term, REG_TEXT_WEIGHT_PREFIX)
+				}
+			}
+		}
+
+		// combine with the previous words
+		if scores == nil {
+			scores = matches
+		} else {
+			for object, s := range scores {
+				if m, ok := matches[object]; ok {
+					scores[object] = s + m
+				} else {
+					delete(scores, object)
+				}
+			}
+		}
+
+		if len(scores) == 0 {
+			break
+		}
+	}
+
+	results := make([]*RegTextResult, 0, len(scores))
+	for object, s := range scores {
+		results = append(results, &RegTextResult{
+			Ref:   object.Ref,
+			Score: math.Round(s*1000) / 1000,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Ref < results[j].Ref
+	})
+
+	return results
+}
+
+// return the network for a word that is an address or a prefix, or nil
+func regTextNetwork(word string) *net.IPNet {
+
+	if _, network, err := net.ParseCIDR(word); err == nil {
+		return network
+	}
+
+	ip := net.ParseIP(word)
+	if ip == nil {
+		return nil
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// return completions for a prefix, object names are preferred and then
+// the most frequently used terms
+func (index *RegTextIndex) Suggest(prefix string, limit int) []*RegTextSuggestion {
+
+	terms := index.expand(prefix)
+	suggestions := make([]*RegTextSuggestion, 0, len(terms))
+
+	for _, term := range terms {
+		suggestion := &RegTextSuggestion{
+			Text:  term,
+			Count: len(index.postings[term]),
+		}
+		for _, object := range index.names[term] {
+			if len(suggestion.Objects) == REG_SUGGEST_MAX_OBJECTS {
+				break
+			}
+			suggestion.Objects = append(suggestion.Objects, object.Ref)
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		si, sj := suggestions[i], suggestions[j]
+		if (len(si.Objects) > 0) != (len(sj.Objects) > 0) {
+			return len(si.Objects) > 0
+		}
+		if si.Count != sj.Count {
+			return si.Count > sj.Count
+		}
+		if len(si.Text) != len(sj.Text) {
+			return len(si.Text) < len(sj.Text)
+		}
+		return si.Text < sj.Text
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,103 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestRegTextSearch(t *testing.T) {
+
+	index := BuildRegTextIndex(testLoadRegistry(t))
+
+	tests := []struct {
+		q        string
+		expected []string // refs that must be found, in this order
+		absent   []string
+	}{
+		// nic-hdls, ASNs and prefixes are found by their parts
+		{"foo", []string{"mntner/FOO-MNT"}, nil},
+		{"4242420001", []string{"aut-num/AS4242420001"}, nil},
+		{"172.20.1.160", []string{"inetnum/172.20.1.160_27"}, nil},
+		// the last word may be a prefix of a longer term
+		{"as424242000", []string{"aut-num/AS4242420001"}, nil},
+		{"as424242000", []string{"aut-num/AS4242420002"}, nil},
+		// addresses find the prefixes that contain them, most specific
+		// first, and not those that only share a string prefix
+		{"172.20.0.1", []string{"inetnum/172.20.0.0_24",
+			"inetnum/172.20.0.0_16", "inetnum/172.20.0.0_14"},
+			[]string{"route/172.20.0.128_25", "route/172.20.1.0_24"}},
+		{"172.20.1.170", []string{"inetnum/172.20.1.160_27",
+			"route/172.20.1.0_24", "inetnum/172.20.0.0_16",
+			"inetnum/172.20.0.0_14"},
+			[]string{"route/172.20.0.0_24", "inetnum/172.20.0.0_24"}},
+		{"fd42:1::1", []string{"inet6num/fd42:1::_50", "route6/fd42:1::_48",
+			"inet6num/fd00::_8"}, nil},
+		// as do prefixes
+		{"172.20.0.128/25", []string{"route/172.20.0.128_25",
+			"route/172.20.0.0_24", "inetnum/172.20.0.0_16"},
+			[]string{"route/172.20.1.0_24"}},
+		// combined with other words
+		{"172.20.0.1 as4242420001", []string{"route/172.20.0.0_24"},
+			[]string{"inetnum/172.20.0.0_24", "route/172.20.0.128_25"}},
+	}
+
+	for _, test := range tests {
+		position := make(map[string]int)
+		for ix, result := range index.Search(test.q) {
+			position[result.Ref] = ix + 1
+		}
+
+		last := 0
+		for _, ref := range test.expected {
+			p := position[ref]
+			if p == 0 {
+				t.Errorf("%s: %s was not found", test.q, ref)
+				continue
+			}
+			if p < last {
+				t.Errorf("%s: %s is ranked too highly", test.q, ref)
+			}
+			last = p
+		}
+		for _, ref := range test.absent {
+			if position[ref] != 0 {
+				t.Errorf("%s: %s should not be found", test.q, ref)
+			}
+		}
+	}
+}
+
+func TestRegTextSuggest(t *testing.T) {
+
+	index := BuildRegTextIndex(testLoadRegistry(t))
+
+	suggestions := index.Suggest("foo", 10)
+	if len(suggestions) == 0 {
+		t.Fatal("no suggestions for 'foo'")
+	}
+
+	// object names are suggested first
+	texts := make([]string, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		texts = append(texts, suggestion.Text)
+	}
+	if len(suggestions[0].Objects) == 0 ||
+		!strings.HasPrefix(suggestions[0].Text, "foo") {
+		t.Errorf("unexpected suggestions %v", texts)
+	}
+
+	if len(index.Suggest("foo", 1)) != 1 {
+		t.Error("suggestions were not limited")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,533 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// response rendering
+//
+// API handlers construct a Response and the format is chosen by the
+// 'format' query parameter or, if that isn't given, the Accept header.
+// JSON, YAML and NDJSON are rendered from the response data, whilst
+// CSV and RPSL must be provided by the handler as they only make sense
+// for some responses. Handlers may also add formats of their own.
+
+// the number of NDJSON lines to write between flushes
+const RESPONSE_NDJSON_FLUSH = 100
+
+type Response struct {
+	// the response data, rendered as JSON and YAML
+	Data interface{}
+	// the collection to stream as NDJSON, defaults to Data
+	Records interface{}
+	// write the response as RPSL, nil if not supported
+	RPSL func(w io.Writer)
+	// write the response as CSV, nil if not supported
+	CSV func(w *csv.Writer)
+	// the content type for JSON, if not application/json
+	ContentType string
+	// other formats supported by the response, keyed by name
+	Formats map[string]*ResponseFormat
+}
+
+// a format provided by a handler
+type ResponseFormat struct {
+	ContentType string
+	Write       func(w io.Writer) error
+}
+
+// response formats, and their content types
+var responseContentTypes = map[string]string{
+	"json":   "application/json",
+	"yaml":   "application/yaml",
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv; charset=utf-8",
+	"rpsl":   "text/plain; charset=utf-8",
+}
+
+// map media types in the Accept header to formats
+var responseMediaTypes = map[string]string{
+	"application/json":     "json",
+	"application/yaml":     "yaml",
+	"application/x-yaml":   "yaml",
+	"text/yaml":            "yaml",
+	"text/x-yaml":          "yaml",
+	"application/x-ndjson": "ndjson",
+	"application/ndjson":   "ndjson",
+	"application/jsonl":    "ndjson",
+	"text/csv":             "csv",
+	"application/rpsl":     "rpsl",
+	"text/x-rpsl":          "rpsl",
+	"text/plain":           "rpsl",
+}
+
+//////////////////////////////////////////////////////////////////////////
+// format negotiation
+
+// returns true if the response can be rendered in a format
+func (response *Response) supports(format string) bool {
+	switch format {
+	case "json", "yaml", "ndjson":
+		return true
+	case "csv":
+		return response.CSV != nil
+	case "rpsl":
+		return response.RPSL != nil
+	}
+	return response.Formats[format] != nil
+}
+
+// returns the list of formats supported by the response
+func (response *Response) formats() []string {
+	formats := make([]string, 0, len(responseContentTypes))
+	for _, format := range []string{"json", "yaml", "ndjson", "csv", "rpsl"} {
+		if response.supports(format) {
+			formats = append(formats, format)
+		}
+	}
+
+	other := make([]string, 0, len(response.Formats))
+	for format := range response.Formats {
+		other = append(other, format)
+	}
+	sort.Strings(other)
+
+	return append(formats, other...)
+}
+
+// choose the format for a request, an explicit format parameter must
+// be supported whilst an unmatched Accept header falls back to JSON
+func (response *Response) Format(r *http.Request) (string, bool) {
+
+	if format := r.URL.Query().Get("format"); format != "" {
+		format = strings.ToLower(format)
+		return format, response.supports(format)
+	}
+
+	best, bestq := "json", 0.0
+	for _, mrange := range strings.Split(r.Header.Get("Accept"), ",") {
+
+		params := strings.Split(mrange, ";")
+		media := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		format := responseMediaTypes[media]
+		if media == "*/*" || media == "application/*" {
+			format = "json"
+		}
+		for name, other := range response.Formats {
+			if media == other.ContentType {
+				format = name
+			}
+		}
+
+		// earlier entries win when the quality is the same
+		if format != "" && q > bestq && response.supports(format) {
+			best, bestq = format, q
+		}
+	}
+
+	return best, true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// write the response in the negotiated format
+
+func (response *Response) Write(w http.ResponseWriter, r *http.Request) {
+
+	format, ok := response.Format(r)
+	if !ok {
+		http.Error(w, "Invalid format '"+format+"', must be one of: "+
+			strings.Join(response.formats(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	other := response.Formats[format]
+	switch {
+	case other != nil:
+		w.Header().Set("Content-Type", other.ContentType)
+	case format == "json" && response.ContentType != "":
+		w.Header().Set("Content-Type", response.ContentType)
+	default:
+		w.Header().Set("Content-Type", responseContentTypes[format])
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Vary", "Accept")
+
+	var err error
+	switch {
+	case other != nil:
+		err = other.Write(w)
+
+	case format == "json":
+		err = json.NewEncoder(w).Encode(response.Data)
+
+	case format == "yaml":
+		err = responseYAML(w, response.Data)
+
+	case format == "ndjson":
+		records := response.Records
+		if records == nil {
+			records = response.Data
+		}
+		err = responseNDJSON(w, records)
+
+	case format == "csv":
+		cw := csv.NewWriter(w)
+		response.CSV(cw)
+		cw.Flush()
+		err = cw.Error()
+
+	case format == "rpsl":
+		bw := bufio.NewWriter(w)
+		response.RPSL(bw)
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"format": format,
+			"url":    r.URL.String(),
+		}).Error("Failed to write response")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// NDJSON output
+//
+// objects are written as one line per key, and arrays as one line per
+// element, flushing as the lines are written
+
+func responseNDJSON(w http.ResponseWriter, v interface{}) error {
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	flusher, _ := w.(http.Flusher)
+
+	count := 0
+	line := func(v interface{}) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		count++
+		if count%RESPONSE_NDJSON_FLUSH == 0 && flusher != nil {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	var err error
+	if ordered, ok := v.(*OrderedJSON); ok {
+		for _, key := range ordered.keys {
+			err = line(map[string]interface{}{key: ordered.values[key]})
+			if err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for ix := 0; ix < value.Len() && err == nil; ix++ {
+			err = line(value.Index(ix).Interface())
+		}
+
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			err = line(map[string]interface{}{
+				key.String(): value.MapIndex(key).Interface(),
+			})
+			if err != nil {
+				break
+			}
+		}
+
+	default:
+		err = line(v)
+	}
+
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// YAML output
+//
+// the data is first marshalled to JSON, so that the same field names
+// and ordering are used, then decoded in to a tree and written as YAML
+
+func responseYAML(w io.Writer, v interface{}) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := yamlTree(dec)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("---\n")
+	switch node := tree.(type) {
+	case *OrderedJSON:
+		if len(node.keys) == 0 {
+			bw.WriteString("{}\n")
+		} else {
+			yamlMap(bw, node, 0, false)
+		}
+	case []interface{}:
+		if len(node) == 0 {
+			bw.WriteString("[]\n")
+		} else {
+			yamlList(bw, node, 0, false)
+		}
+	default:
+		bw.WriteString(yamlScalar(node) + "\n")
+	}
+	return bw.Flush()
+}
+
+// decode JSON in to a tree, keeping the order of object keys
+func yamlTree(dec *json.Decoder) (interface{}, error) {
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		node := NewOrderedJSON()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := yamlTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Set(key.(string), value)
+		}
+		_, err = dec.Token()
+		return node, err
+
+	case json.Delim('['):
+		node := make([]interface{}, 0)
+		for dec.More() {
+			value, err := yamlTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, value)
+		}
+		_, err = dec.Token()
+		return node, err
+	}
+
+	return token, nil
+}
+
+// write the entries of a map, the first entry may follow a list marker
+func yamlMap(bw *bufio.Writer, node *OrderedJSON, indent int, inline bool) {
+	for ix, key := range node.keys {
+		if ix > 0 || !inline {
+			bw.WriteString(strings.Repeat(" ", indent))
+		}
+		bw.WriteString(yamlScalar(key) + ":")
+		yamlValue(bw, node.values[key], indent+2)
+	}
+}
+
+// write the items in a list, the first item may follow a list marker
+func yamlList(bw *bufio.Writer, node []interface{}, indent int, inline bool) {
+	for ix, item := range node {
+		if ix > 0 || !inline {
+			bw.WriteString(strings.Repeat(" ", indent))
+		}
+		bw.WriteString("-")
+
+		// nested collections start on the same line as the marker
+		switch v := item.(type) {
+		case *OrderedJSON:
+			if len(v.keys) > 0 {
+				bw.WriteString(" ")
+				yamlMap(bw, v, indent+2, true)
+				continue
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				bw.WriteString(" ")
+				yamlList(bw, v, indent+2, true)
+				continue
+			}
+		}
+		yamlValue(bw, item, indent+2)
+	}
+}
+
+// write a value following a key or list marker
+func yamlValue(bw *bufio.Writer, value interface{}, indent int) {
+	switch node := value.(type) {
+	case *OrderedJSON:
+		if len(node.keys) == 0 {
+			bw.WriteString(" {}\n")
+		} else {
+			bw.WriteString("\n")
+			yamlMap(bw, node, indent, false)
+		}
+	case []interface{}:
+		if len(node) == 0 {
+			bw.WriteString(" []\n")
+		} else {
+			bw.WriteString("\n")
+			yamlList(bw, node, indent, false)
+		}
+	default:
+		bw.WriteString(" " + yamlScalar(node) + "\n")
+	}
+}
+
+// return a scalar, quoting strings that would otherwise be misread
+func yamlScalar(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlPlain(v) {
+			return v
+		}
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+
+	return ""
+}
+
+// returns true if a string can be written without quotes
+func yamlPlain(s string) bool {
+
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return false
+	}
+
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.HasSuffix(s, ":") {
+		return false
+	}
+
+	for _, c := range s {
+		if c < ' ' || c == 0x7f {
+			return false
+		}
+	}
+
+	// values that would be read as something other than a string
+	switch strings.ToLower(s) {
+	case "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return false
+	}
+
+	// dates, timestamps and sexagesimal numbers
+	if strings.Trim(s, "0123456789-:._+ ") == "" ||
+		(s[0] >= '0' && s[0] <= '9' && strings.ContainsAny(s, "-:")) {
+		return false
+	}
+
+	return true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RPSL output
+
+// write a registry object in the original file layout, with keys padded
+// to 20 columns and '+' marking empty lines within a value
+func WriteRPSL(w io.Writer, object *RegObject) {
+	for _, attribute := range object.Data {
+		lines := strings.Split(attribute.RawValue, "\n")
+
+		if lines[0] == "" {
+			io.WriteString(w, attribute.Key+":\n")
+		} else {
+			io.WriteString(w, padRPSLKey(attribute.Key+":")+lines[0]+"\n")
+		}
+
+		for _, line := range lines[1:] {
+			if line == "" {
+				io.WriteString(w, "+\n")
+			} else {
+				io.WriteString(w, strings.Repeat(" ", 20)+line+"\n")
+			}
+		}
+	}
+}
+
+func padRPSLKey(key string) string {
+	if len(key) < 20 {
+		return key + strings.Repeat(" ", 20-len(key))
+	}
+	return key + " "
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,266 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// write a response for a request with a query and Accept header
+func testResponse(response *Response, query string,
+	accept string) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest("GET", "/test"+query, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	response.Write(w, r)
+	return w
+}
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestResponseFormat(t *testing.T) {
+
+	full := &Response{
+		Data: "data",
+		RPSL: func(w io.Writer) {},
+		CSV:  func(w *csv.Writer) {},
+		Formats: map[string]*ResponseFormat{
+			"dot": {ContentType: "text/vnd.graphviz"},
+		},
+	}
+	plain := &Response{Data: "data"}
+
+	tests := []struct {
+		response *Response
+		query    string
+		accept   string
+		format   string
+		ok       bool
+	}{
+		// defaults
+		{plain, "", "", "json", true},
+		{plain, "", "*/*", "json", true},
+		{plain, "", "application/*", "json", true},
+		// the format parameter overrides the Accept header
+		{full, "?format=yaml", "text/csv", "yaml", true},
+		{full, "?format=CSV", "", "csv", true},
+		{full, "?format=dot", "", "dot", true},
+		// explicit formats must be supported
+		{plain, "?format=csv", "", "csv", false},
+		{plain, "?format=rpsl", "", "rpsl", false},
+		{plain, "?format=dot", "", "dot", false},
+		{full, "?format=xml", "", "xml", false},
+		// the highest quality supported media type wins
+		{full, "", "application/yaml;q=0.5, text/csv;q=0.9", "csv", true},
+		{full, "", "text/csv;q=0.1, application/x-ndjson", "ndjson", true},
+		{full, "", "text/x-rpsl; q=0.8, application/json; q=0.7", "rpsl", true},
+		{full, "", "text/vnd.graphviz, application/json;q=0.5", "dot", true},
+		// earlier entries win on a tie
+		{full, "", "text/yaml, text/csv", "yaml", true},
+		// unsupported and unknown media types fall back to JSON
+		{plain, "", "text/csv", "json", true},
+		{plain, "", "text/csv;q=1, application/yaml;q=0.2", "yaml", true},
+		{plain, "", "image/png", "json", true},
+		{full, "", "application/yaml;q=0", "json", true},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/test"+test.query, nil)
+		r.Header.Set("Accept", test.accept)
+
+		format, ok := test.response.Format(r)
+		if format != test.format || ok != test.ok {
+			t.Errorf("%q Accept %q: got %s/%t, expected %s/%t", test.query,
+				test.accept, format, ok, test.format, test.ok)
+		}
+	}
+}
+
+func TestResponseWrite(t *testing.T) {
+
+	response := &Response{
+		Data:    map[string]interface{}{"a": []int{1, 2}},
+		Records: []int{1, 2},
+		Formats: map[string]*ResponseFormat{
+			"dot": {
+				ContentType: "text/vnd.graphviz",
+				Write: func(w io.Writer) error {
+					_, err := io.WriteString(w, "digraph {}\n")
+					return err
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		query  string
+		accept string
+		status int
+		ctype  string
+		body   string
+	}{
+		{"", "", http.StatusOK, "application/json", "{\"a\":[1,2]}\n"},
+		{"?format=yaml", "", http.StatusOK, "application/yaml",
+			"---\na:\n  - 1\n  - 2\n"},
+		{"", "application/x-ndjson", http.StatusOK, "application/x-ndjson",
+			"1\n2\n"},
+		{"?format=dot", "", http.StatusOK, "text/vnd.graphviz", "digraph {}\n"},
+		{"", "text/vnd.graphviz", http.StatusOK, "text/vnd.graphviz",
+			"digraph {}\n"},
+		{"?format=csv", "", http.StatusBadRequest, "text/plain; charset=utf-8",
+			"Invalid format 'csv', must be one of: json, yaml, ndjson, dot\n"},
+	}
+
+	for _, test := range tests {
+		w := testResponse(response, test.query, test.accept)
+		if w.Code != test.status || w.Header().Get("Content-Type") != test.ctype ||
+			w.Body.String() != test.body {
+			t.Errorf("%q Accept %q: got %d %s %q", test.query, test.accept,
+				w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	// a JSON content type set by the handler only applies to JSON
+	response.ContentType = "application/vnd.api+json"
+	if w := testResponse(response, "", ""); w.Header().Get("Content-Type") !=
+		"application/vnd.api+json" {
+		t.Errorf("unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	if w := testResponse(response, "?format=yaml", ""); w.Header().Get("Content-Type") !=
+		"application/yaml" {
+		t.Errorf("unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestResponseCSV(t *testing.T) {
+
+	response := &Response{
+		Data: nil,
+		CSV: func(w *csv.Writer) {
+			w.Write([]string{"key", "value"})
+			w.Write([]string{"plain", "FOO-MNT"})
+			w.Write([]string{"comma", "a, b"})
+			w.Write([]string{"quote", `say "hello"`})
+			w.Write([]string{"newline", "one\ntwo"})
+			w.Write([]string{"space", " leading"})
+			w.Write([]string{"empty", ""})
+		},
+	}
+
+	w := testResponse(response, "?format=csv", "")
+	expected := "key,value\n" +
+		"plain,FOO-MNT\n" +
+		"comma,\"a, b\"\n" +
+		"quote,\"say \"\"hello\"\"\"\n" +
+		"newline,\"one\ntwo\"\n" +
+		"space,\" leading\"\n" +
+		"empty,\n"
+
+	if w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("unexpected CSV %d %q", w.Code, w.Body.String())
+	}
+	if ctype := w.Header().Get("Content-Type"); ctype != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %s", ctype)
+	}
+
+	// and it reads back the same
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil || len(records) != 7 || records[3][1] != `say "hello"` ||
+		records[4][1] != "one\ntwo" {
+		t.Errorf("unexpected records %q: %v", records, err)
+	}
+}
+
+func TestWriteRPSL(t *testing.T) {
+
+	object := &RegObject{
+		Ref: "mntner/FOO-MNT",
+		Data: []*RegAttribute{
+			{Key: "mntner", RawValue: "FOO-MNT"},
+			{Key: "descr", RawValue: "first line\nsecond line\n\nafter a gap"},
+			{Key: "remarks", RawValue: "\nstarts on the next line"},
+			{Key: "a-very-long-attribute", RawValue: "value"},
+			{Key: "exactly-nineteen-ch", RawValue: "value"},
+			{Key: "source", RawValue: "DN42"},
+		},
+	}
+
+	var b bytes.Buffer
+	WriteRPSL(&b, object)
+
+	expected := "" +
+		"mntner:             FOO-MNT\n" +
+		"descr:              first line\n" +
+		"                    second line\n" +
+		"+\n" +
+		"                    after a gap\n" +
+		"remarks:\n" +
+		"                    starts on the next line\n" +
+		"a-very-long-attribute: value\n" +
+		"exactly-nineteen-ch: value\n" +
+		"source:             DN42\n"
+
+	if b.String() != expected {
+		t.Errorf("unexpected RPSL:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+	// values start in column 21 whenever the key fits
+	for _, line := range strings.Split(expected, "\n")[:5] {
+		if len(line) > 20 && line[19] != ' ' {
+			t.Errorf("misaligned line %q", line)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"FOO-MNT", "FOO-MNT"},
+		{"172.20.0.0/24", "172.20.0.0/24"},
+		{"AS4242420000", "AS4242420000"},
+		{"", `""`},
+		{"yes", `"yes"`},
+		{"Null", `"Null"`},
+		{"4242420000", `"4242420000"`},
+		{"0x10", `"0x10"`},
+		{"1.5e3", `"1.5e3"`},
+		{"2020-01-01", `"2020-01-01"`},
+		{"12:30", `"12:30"`},
+		{"- item", `"- item"`},
+		{"*ref", `"*ref"`},
+		{"key: value", `"key: value"`},
+		{"trailing:", `"trailing:"`},
+		{"a #comment", `"a #comment"`},
+		{" padded", `" padded"`},
+		{"tab\there", `"tab\there"`},
+		{"<html> & co", "<html> & co"},
+	}
+
+	for _, test := range tests {
+		if v := yamlScalar(test.value); v != test.expected {
+			t.Errorf("%q: got %s, expected %s", test.value, v, test.expected)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
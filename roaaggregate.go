@@ -0,0 +1,160 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ROA aggregation
+//
+// Two passes are made over the ROAs for each origin, and repeated until
+// nothing changes:
+//
+// - a ROA is removed if a covering ROA (itself, or a parent) exists for
+//   the same origin with a max-length that is at least as long
+// - two sibling ROAs with the same origin and max-length are merged in to
+//   their parent, if a ROA for the parent already exists with a shorter
+//   max-length. The parent route was already valid, so the validation
+//   outcome for every route stays the same.
+
+type ROAAggregation struct {
+	Before uint `json:"before"`
+	After  uint `json:"after"`
+}
+
+// working data for a single ROA
+type roaAggEntry struct {
+	network *net.IPNet
+	asn     string
+	source  string
+	maxlen  uint8
+	deleted bool
+}
+
+//////////////////////////////////////////////////////////////////////////
+// aggregate a list of ROAs, returning a new list
+
+func AggregateROA(roas []*PrefixROA) []*PrefixROA {
+
+	// entries are indexed by origin and prefix, and also kept in
+	// order so that the output follows the input
+	index := make(map[string]*roaAggEntry)
+	order := make([]*roaAggEntry, 0, len(roas))
+
+	for _, roa := range roas {
+		_, network, err := net.ParseCIDR(roa.Prefix)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix": roa.Prefix,
+				"error":  err,
+			}).Warn("Unable to parse prefix during ROA aggregation")
+			continue
+		}
+
+		key := roa.ASN + " " + network.String()
+		if entry := index[key]; entry != nil {
+			// duplicate prefix, just keep the longest max-length
+			if roa.MaxLen > entry.maxlen {
+				entry.maxlen = roa.MaxLen
+			}
+			continue
+		}
+
+		entry := &roaAggEntry{
+			network: network,
+			asn:     roa.ASN,
+			source:  roa.Source,
+			maxlen:  roa.MaxLen,
+		}
+		index[key] = entry
+		order = append(order, entry)
+	}
+
+	// helper closure to look up a live entry
+	lookup := func(asn string, network *net.IPNet) *roaAggEntry {
+		entry := index[asn+" "+network.String()]
+		if entry == nil || entry.deleted {
+			return nil
+		}
+		return entry
+	}
+
+	// helper closure to remove an entry
+	remove := func(entry *roaAggEntry) {
+		entry.deleted = true
+		delete(index, entry.asn+" "+entry.network.String())
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		// remove ROAs that are covered by a parent
+		for _, entry := range order {
+			if entry.deleted {
+				continue
+			}
+
+			parent := entry.network
+			for plen, _ := parent.Mask.Size(); plen > 0; plen-- {
+				parent = netParent(parent)
+				if p := lookup(entry.asn, parent); p != nil &&
+					p.maxlen >= entry.maxlen {
+					remove(entry)
+					changed = true
+					break
+				}
+			}
+		}
+
+		// merge siblings in to their parent
+		for _, entry := range order {
+			if entry.deleted {
+				continue
+			}
+
+			if plen, _ := entry.network.Mask.Size(); plen == 0 {
+				continue
+			}
+
+			sibling := lookup(entry.asn, netSibling(entry.network))
+			if sibling == nil || sibling.maxlen != entry.maxlen {
+				continue
+			}
+
+			parent := lookup(entry.asn, netParent(entry.network))
+			if parent == nil || parent.maxlen >= entry.maxlen {
+				continue
+			}
+
+			parent.maxlen = entry.maxlen
+			remove(entry)
+			remove(sibling)
+			changed = true
+		}
+	}
+
+	// finally build the result
+	result := make([]*PrefixROA, 0, len(index))
+	for _, entry := range order {
+		if !entry.deleted {
+			result = append(result, &PrefixROA{
+				Prefix: entry.network.String(),
+				MaxLen: entry.maxlen,
+				ASN:    entry.asn,
+				Source: entry.source,
+			})
+		}
+	}
+
+	return result
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,189 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+// return true if a route is valid for a set of ROAs (RFC 6811)
+func testROAValid(t *testing.T, roas []*PrefixROA, route *net.IPNet,
+	origin string) bool {
+
+	plen, _ := route.Mask.Size()
+	for _, roa := range roas {
+		if roa.ASN == origin && plen <= int(roa.MaxLen) &&
+			netContainsNet(testCIDR(t, roa.Prefix), route) {
+			return true
+		}
+	}
+	return false
+}
+
+// return every prefix within a network, down to a maximum length
+func testSubPrefixes(network *net.IPNet, maxlen int) []*net.IPNet {
+	result := []*net.IPNet{network}
+	if plen, _ := network.Mask.Size(); plen < maxlen {
+		lower, upper := netSplit(network)
+		result = append(result, testSubPrefixes(lower, maxlen)...)
+		result = append(result, testSubPrefixes(upper, maxlen)...)
+	}
+	return result
+}
+
+// format a list of ROAs for comparison
+func testROAStrings(roas []*PrefixROA) []string {
+	result := make([]string, len(roas))
+	for ix, roa := range roas {
+		result[ix] = fmt.Sprintf("%s %s %d", roa.ASN, roa.Prefix, roa.MaxLen)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestAggregateROA(t *testing.T) {
+
+	roa := func(prefix string, maxlen uint8, asn string) *PrefixROA {
+		return &PrefixROA{Prefix: prefix, MaxLen: maxlen, ASN: asn, Source: "dn42"}
+	}
+
+	tests := []struct {
+		name     string
+		input    []*PrefixROA
+		expected []*PrefixROA
+	}{
+		{
+			name: "covered by parent",
+			input: []*PrefixROA{
+				roa("10.0.0.0/22", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+				roa("10.0.2.0/24", 24, "AS1"),
+			},
+			expected: []*PrefixROA{roa("10.0.0.0/22", 26, "AS1")},
+		},
+		{
+			name: "longer max-length is kept",
+			input: []*PrefixROA{
+				roa("10.0.0.0/22", 24, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+			},
+			expected: []*PrefixROA{
+				roa("10.0.0.0/22", 24, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+			},
+		},
+		{
+			name: "different origins are not merged",
+			input: []*PrefixROA{
+				roa("10.0.0.0/22", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS2"),
+			},
+			expected: []*PrefixROA{
+				roa("10.0.0.0/22", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS2"),
+			},
+		},
+		{
+			name: "siblings merged in to an existing parent",
+			input: []*PrefixROA{
+				roa("10.0.0.0/23", 23, "AS1"),
+				roa("10.0.0.0/24", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+			},
+			expected: []*PrefixROA{roa("10.0.0.0/23", 26, "AS1")},
+		},
+		{
+			name: "siblings without a parent are kept",
+			input: []*PrefixROA{
+				roa("10.0.0.0/24", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+			},
+			expected: []*PrefixROA{
+				roa("10.0.0.0/24", 26, "AS1"),
+				roa("10.0.1.0/24", 26, "AS1"),
+			},
+		},
+		{
+			name: "duplicates keep the longest max-length",
+			input: []*PrefixROA{
+				roa("10.0.0.0/24", 24, "AS1"),
+				roa("10.0.0.0/24", 26, "AS1"),
+			},
+			expected: []*PrefixROA{roa("10.0.0.0/24", 26, "AS1")},
+		},
+		{
+			name: "ipv6",
+			input: []*PrefixROA{
+				roa("fd42:1::/48", 64, "AS1"),
+				roa("fd42:1:0:1000::/52", 56, "AS1"),
+			},
+			expected: []*PrefixROA{roa("fd42:1::/48", 64, "AS1")},
+		},
+		{
+			name:     "invalid prefixes are dropped",
+			input:    []*PrefixROA{roa("not a prefix", 24, "AS1")},
+			expected: []*PrefixROA{},
+		},
+	}
+
+	for _, test := range tests {
+		result := AggregateROA(test.input)
+
+		got := strings.Join(testROAStrings(result), ", ")
+		expected := strings.Join(testROAStrings(test.expected), ", ")
+		if got != expected {
+			t.Errorf("%s: got [%s], expected [%s]", test.name, got, expected)
+		}
+	}
+}
+
+// aggregation must never change the validation outcome for any route
+func TestAggregateROAPreservesValidity(t *testing.T) {
+
+	roa := func(prefix string, maxlen uint8, asn string) *PrefixROA {
+		return &PrefixROA{Prefix: prefix, MaxLen: maxlen, ASN: asn}
+	}
+
+	input := []*PrefixROA{
+		roa("10.0.0.0/20", 21, "AS1"),
+		roa("10.0.0.0/21", 24, "AS1"),
+		roa("10.0.8.0/21", 24, "AS1"),
+		roa("10.0.0.0/22", 26, "AS1"),
+		roa("10.0.4.0/22", 24, "AS1"),
+		roa("10.0.4.0/23", 25, "AS1"),
+		roa("10.0.6.0/23", 25, "AS1"),
+		roa("10.0.12.0/22", 22, "AS2"),
+		roa("10.0.12.0/23", 24, "AS2"),
+		roa("10.0.14.0/23", 24, "AS2"),
+		roa("10.0.1.0/24", 24, "AS2"),
+	}
+
+	result := AggregateROA(input)
+	if len(result) >= len(input) {
+		t.Errorf("expected fewer ROAs, got %d from %d", len(result), len(input))
+	}
+
+	for _, route := range testSubPrefixes(testCIDR(t, "10.0.0.0/20"), 27) {
+		for _, origin := range []string{"AS1", "AS2", "AS3"} {
+			before := testROAValid(t, input, route, origin)
+			after := testROAValid(t, result, route, origin)
+			if before != after {
+				t.Errorf("%s from %s: valid %v before aggregation, %v after",
+					route, origin, before, after)
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
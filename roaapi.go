@@ -7,6 +7,7 @@ package main
 //////////////////////////////////////////////////////////////////////////
 
 import (
+	"encoding/csv"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -36,6 +37,8 @@ type PrefixROA struct {
 	Prefix string `json:"prefix"`
 	MaxLen uint8  `json:"maxLength"`
 	ASN    string `json:"asn"`
+	Source string `json:"ta"` // the source of this ROA
+	Object string `json:"-"`  // the registry object that created this ROA
 }
 
 type ROAFilter struct {
@@ -54,6 +57,9 @@ type ROA struct {
 	Filters []*ROAFilter
 	IPv4    []*PrefixROA
 	IPv6    []*PrefixROA
+	AS0IPv4 []*PrefixROA
+	AS0IPv6 []*PrefixROA
+	Sources []*ROASourceStatus
 }
 
 var ROAData *ROA
@@ -65,11 +71,12 @@ var ROAData *ROA
 const ROA_JSON_VALIDITY_PERIOD = (7 * 24)
 
 type ROAMetaData struct {
-	Counts        uint   `json:"counts"`
-	Generated     uint32 `json:"generated"`
-	Valid         uint32 `json:"valid"`
-	Signature     string `json:"signature,omitempty"`
-	SignatureDate string `json:"signatureDate,omitempty"`
+	Counts        uint            `json:"counts"`
+	Generated     uint32          `json:"generated"`
+	Valid         uint32          `json:"valid"`
+	Signature     string          `json:"signature,omitempty"`
+	SignatureDate string          `json:"signatureDate,omitempty"`
+	Aggregation   *ROAAggregation `json:"aggregation,omitempty"`
 }
 
 type ROAJSON struct {
@@ -135,7 +142,18 @@ func roaFilterHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=604800")
 	w.Header().Set("ETag", ROAData.Commit)
 
-	ResponseJSON(w, filters)
+	(&Response{
+		Data: filters,
+		CSV: func(w *csv.Writer) {
+			w.Write([]string{"nr", "action", "prefix", "minlen", "maxlen"})
+			for _, f := range filters {
+				w.Write([]string{
+					strconv.Itoa(int(f.Number)), f.Action, f.Prefix,
+					strconv.Itoa(int(f.MinLen)), strconv.Itoa(int(f.MaxLen)),
+				})
+			}
+		},
+	}).Write(w, r)
 }
 
 // return JSON formatted ROA data suitable for use with GoRTR
@@ -156,7 +174,47 @@ func roaJSONHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=604800")
 	w.Header().Set("ETag", ROAData.Commit)
 
-	ResponseJSON(w, ROAJSONResponse)
+	// use the pre-computed response, unless a selection was requested
+	query := r.URL.Query()
+	query.Del("format")
+	if len(query) == 0 {
+		roaResponse(ROAJSONResponse).Write(w, r)
+		return
+	}
+
+	response := &ROAJSON{
+		MetaData: ROAMetaData{
+			Generated: ROAJSONResponse.MetaData.Generated,
+			Valid:     ROAJSONResponse.MetaData.Valid,
+		},
+	}
+
+	var err error
+	response.Roas, response.MetaData.Aggregation, err = roaSelect(r, "46")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response.MetaData.Counts = uint(len(response.Roas))
+	response.sign()
+
+	roaResponse(response).Write(w, r)
+}
+
+// render ROA JSON data, with CSV and NDJSON providing just the ROAs
+func roaResponse(roa *ROAJSON) *Response {
+	return &Response{
+		Data:    roa,
+		Records: roa.Roas,
+		CSV: func(w *csv.Writer) {
+			w.Write([]string{"prefix", "maxLength", "asn", "ta"})
+			for _, r := range roa.Roas {
+				w.Write([]string{
+					r.Prefix, strconv.Itoa(int(r.MaxLen)), r.ASN, r.Source,
+				})
+			}
+		},
+	}
 }
 
 // return the roa in bird format
@@ -172,12 +230,10 @@ func roaBirdHandler(w http.ResponseWriter, r *http.Request) {
 		birdf = "route %s max %d as %s;\n"
 	}
 
-	var roa []*PrefixROA
-	if strings.ContainsRune(ipv, '4') {
-		roa = append(roa, ROAData.IPv4...)
-	}
-	if strings.ContainsRune(ipv, '6') {
-		roa = append(roa, ROAData.IPv6...)
+	roa, agg, err := roaSelect(r, ipv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
@@ -188,7 +244,11 @@ func roaBirdHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("ETag", ROAData.Commit)
 
 	fmt.Fprintf(w, "#\n# dn42regsrv ROA Generator\n# Last Updated: %s\n"+
-		"# Commit: %s\n#\n", ROAData.CTime.String(), ROAData.Commit)
+		"# Commit: %s\n", ROAData.CTime.String(), ROAData.Commit)
+	if agg != nil {
+		fmt.Fprintf(w, "# Aggregated: %d -> %d\n", agg.Before, agg.After)
+	}
+	fmt.Fprintf(w, "#\n")
 
 	for _, r := range roa {
 		fmt.Fprintf(w, birdf, r.Prefix, r.MaxLen, r.ASN[2:])
@@ -203,12 +263,10 @@ func roaOBGPdHandler(w http.ResponseWriter, r *http.Request) {
 	ipv := vars["ipv"]
 
 	// select ROA to emit
-	var roa []*PrefixROA
-	if strings.ContainsRune(ipv, '4') {
-		roa = append(roa, ROAData.IPv4...)
-	}
-	if strings.ContainsRune(ipv, '6') {
-		roa = append(roa, ROAData.IPv6...)
+	roa, agg, err := roaSelect(r, ipv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
@@ -220,7 +278,11 @@ func roaOBGPdHandler(w http.ResponseWriter, r *http.Request) {
 
 	// add header
 	fmt.Fprintf(w, "#\n# dn42regsrv ROA Generator\n# Last Updated: %s\n"+
-		"# Commit: %s\n#\nroa-set {\n", ROAData.CTime.String(), ROAData.Commit)
+		"# Commit: %s\n", ROAData.CTime.String(), ROAData.Commit)
+	if agg != nil {
+		fmt.Fprintf(w, "# Aggregated: %d -> %d\n", agg.Before, agg.After)
+	}
+	fmt.Fprintf(w, "#\nroa-set {\n")
 
 	// output the ROA
 	format := "  %s maxlen %d source-as %s\n"
@@ -233,6 +295,60 @@ func roaOBGPdHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//////////////////////////////////////////////////////////////////////////
+// select the ROA to return based on the IP family and query parameters
+//
+// the as0 parameter selects the AS0 family,
+// either in addition to (as0=1) or instead of (as0=only) the normal ROAs
+//
+// the ROAs may then be filtered by the query parameters in roaquery.go
+//
+// if the aggregate parameter is set, the selected ROAs are aggregated
+// and the before and after counts are returned
+
+func roaSelect(r *http.Request,
+	ipv string) ([]*PrefixROA, *ROAAggregation, error) {
+
+	as0 := r.URL.Query().Get("as0")
+
+	var roa []*PrefixROA
+	if as0 != "only" {
+		if strings.ContainsRune(ipv, '4') {
+			roa = append(roa, ROAData.IPv4...)
+		}
+		if strings.ContainsRune(ipv, '6') {
+			roa = append(roa, ROAData.IPv6...)
+		}
+	}
+
+	if as0 != "" {
+		if strings.ContainsRune(ipv, '4') {
+			roa = append(roa, ROAData.AS0IPv4...)
+		}
+		if strings.ContainsRune(ipv, '6') {
+			roa = append(roa, ROAData.AS0IPv6...)
+		}
+	}
+
+	query, err := parseROAQuery(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if query != nil {
+		roa = query.Filter(roa)
+	}
+
+	if agg := r.URL.Query().Get("aggregate"); agg == "" || agg == "0" {
+		return roa, nil, nil
+	}
+
+	aggregated := AggregateROA(roa)
+	return aggregated, &ROAAggregation{
+		Before: uint(len(roa)),
+		After:  uint(len(aggregated)),
+	}, nil
+}
+
 //////////////////////////////////////////////////////////////////////////
 // called whenever the registry is updated
 
@@ -259,15 +375,32 @@ func ROAUpdate(params ...interface{}) {
 	}
 
 	// compile ROA prefixes
-	roa.IPv4 = roa.CompileROA(registry, "route")
-	roa.IPv6 = roa.CompileROA(registry, "route6")
+	roa.IPv4 = tagROA(roa.CompileROA(registry, "route"), ROA_SOURCE_DN42)
+	roa.IPv6 = tagROA(roa.CompileROA(registry, "route6"), ROA_SOURCE_DN42)
+
+	// and optionally the AS0 ROAs
+	if ROAAS0Unallocated {
+		roa.AS0IPv4 = roa.CompileAS0Unallocated(registry, "inetnum", 4)
+		roa.AS0IPv6 = roa.CompileAS0Unallocated(registry, "inet6num", 6)
+	}
+	if ROAAS0Deny {
+		roa.AS0IPv4 = append(roa.AS0IPv4, roa.CompileAS0Deny(4)...)
+		roa.AS0IPv6 = append(roa.AS0IPv6, roa.CompileAS0Deny(6)...)
+	}
+	tagROA(roa.AS0IPv4, ROA_SOURCE_DN42)
+	tagROA(roa.AS0IPv6, ROA_SOURCE_DN42)
 
-	// swap in the new data
+	// merge in any additional sources
+	roa.MergeSources()
+
+	// record what changed, then swap in the new data
+	ROARecordChanges(ROAData, roa, path)
 	ROAData = roa
 
 	log.WithFields(log.Fields{
 		"ipv4": len(roa.IPv4),
 		"ipv6": len(roa.IPv6),
+		"as0":  len(roa.AS0IPv4) + len(roa.AS0IPv6),
 	}).Debug("ROA data updated")
 
 	// pre-compute the JSON return struct
@@ -284,7 +417,33 @@ func ROAUpdate(params ...interface{}) {
 	response.Roas = append(roa.IPv4, roa.IPv6...)
 	response.MetaData.Counts = uint(len(response.Roas))
 
+	response.sign()
+
 	ROAJSONResponse = response
+
+	// and publish the RPKI objects, if enabled
+	RPKIUpdate(roa)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// sign a JSON response, if a key was provided
+
+func (response *ROAJSON) sign() {
+
+	if ROASigningKey == nil {
+		return
+	}
+
+	sig, sdate, err := SignROA(ROASigningKey, response.Roas)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to sign ROA data")
+		return
+	}
+
+	response.MetaData.Signature = sig
+	response.MetaData.SignatureDate = sdate
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -412,9 +571,17 @@ func (roa *ROA) CompileROA(registry *Registry,
 
 	// prepare indices to the route object keys
 	stype := registry.Schema[tname]
+	if stype == nil || stype.KeyIndex[tname] == nil ||
+		stype.KeyIndex["origin"] == nil {
+		// nothing to compile, may happen for additional sources
+		return make([]*PrefixROA, 0)
+	}
 	routeIX := stype.KeyIndex[tname]
 	originIX := stype.KeyIndex["origin"]
 	mlenIX := stype.KeyIndex["max-length"]
+	if mlenIX == nil {
+		mlenIX = &RegKeyIndex{}
+	}
 
 	roalist := make([]*PrefixROA, 0, len(routeIX.Objects))
 
@@ -517,6 +684,7 @@ func (roa *ROA) CompileROA(registry *Registry,
 					Prefix: prefNet.String(),
 					MaxLen: mlen,
 					ASN:    oattrib.RawValue,
+					Object: object.Ref,
 				})
 
 			}
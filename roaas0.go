@@ -0,0 +1,155 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// AS0 ROA generation
+//
+// AS0 ROAs (RFC 6483) make any route within the covered space invalid,
+// they are used here to mark the parts of the dn42 address space that
+// have not been allocated, or that are denied by the filter rules.
+
+// options, set from the command line
+var ROAAS0Unallocated bool
+var ROAAS0Deny bool
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to set the AS0 options
+
+func InitialiseROAAS0(unallocated bool, deny bool) {
+
+	ROAAS0Unallocated = unallocated
+	ROAAS0Deny = deny
+
+	log.WithFields(log.Fields{
+		"unallocated": unallocated,
+		"deny":        deny,
+	}).Debug("ROA AS0 options set")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the part of a filter network that is not covered by
+// an earlier filter, filter.txt rules are first match
+
+func (roa *ROA) effectiveFilter(filter *ROAFilter) []*net.IPNet {
+
+	earlier := make([]*net.IPNet, 0)
+	for _, f := range roa.Filters {
+		if f == filter {
+			break
+		}
+		if f.IPType == filter.IPType {
+			earlier = append(earlier, f.Network)
+		}
+	}
+
+	return netSubtract(filter.Network, earlier)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// compile AS0 ROAs for the unallocated parts of permitted ranges
+//
+// inetnum/inet6num objects with a prefix length shorter than the
+// filter MinLen are treated as structural blocks rather than allocations
+
+func (roa *ROA) CompileAS0Unallocated(registry *Registry,
+	tname string, iptype uint8) []*PrefixROA {
+
+	// parse the allocated networks
+	allocated := make([]*net.IPNet, 0)
+
+	schema := registry.Schema[tname]
+	if schema != nil && schema.KeyIndex["cidr"] != nil {
+		for object, attribs := range schema.KeyIndex["cidr"].Objects {
+			_, network, err := net.ParseCIDR(attribs[0].RawValue)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"object": object.Ref,
+					"cidr":   attribs[0].RawValue,
+					"error":  err,
+				}).Warn("Unable to parse CIDR for AS0 ROA")
+				continue
+			}
+			allocated = append(allocated, network)
+		}
+	}
+
+	roalist := make([]*PrefixROA, 0)
+
+	for _, filter := range roa.Filters {
+		if filter.IPType != iptype || filter.Action != "permit" {
+			continue
+		}
+
+		// select allocations that are relevant to this filter
+		fallocs := make([]*net.IPNet, 0)
+		for _, network := range allocated {
+			plen, _ := network.Mask.Size()
+			if plen >= int(filter.MinLen) &&
+				netContainsNet(filter.Network, network) {
+				fallocs = append(fallocs, network)
+			}
+		}
+
+		// and emit AS0 for what's left over
+		for _, base := range roa.effectiveFilter(filter) {
+			roalist = appendAS0(roalist, netSubtract(base, fallocs))
+		}
+	}
+
+	return roalist
+}
+
+//////////////////////////////////////////////////////////////////////////
+// compile AS0 ROAs for ranges denied by the filter rules
+//
+// rules that cover the default route are skipped, as these would
+// invalidate everything outside of the dn42 ranges
+
+func (roa *ROA) CompileAS0Deny(iptype uint8) []*PrefixROA {
+
+	roalist := make([]*PrefixROA, 0)
+
+	for _, filter := range roa.Filters {
+		if filter.IPType != iptype || filter.Action != "deny" {
+			continue
+		}
+
+		if plen, _ := filter.Network.Mask.Size(); plen == 0 {
+			continue
+		}
+
+		roalist = appendAS0(roalist, roa.effectiveFilter(filter))
+	}
+
+	return roalist
+}
+
+//////////////////////////////////////////////////////////////////////////
+// helper func to add AS0 ROAs for a list of networks
+
+func appendAS0(roalist []*PrefixROA, networks []*net.IPNet) []*PrefixROA {
+
+	for _, network := range networks {
+		_, bits := network.Mask.Size()
+		roalist = append(roalist, &PrefixROA{
+			Prefix: network.String(),
+			MaxLen: uint8(bits),
+			ASN:    "AS0",
+		})
+	}
+
+	return roalist
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,166 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"math/big"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+// return the number of addresses in a list of ROAs
+func testROASize(t *testing.T, roas []*PrefixROA) *big.Int {
+	total := new(big.Int)
+	for _, roa := range roas {
+		plen, bits := testCIDR(t, roa.Prefix).Mask.Size()
+		total.Add(total, new(big.Int).Lsh(big.NewInt(1), uint(bits-plen)))
+	}
+	return total
+}
+
+// return the number of addresses in a prefix
+func testPrefixSize(t *testing.T, prefix string) *big.Int {
+	return testROASize(t, []*PrefixROA{{Prefix: prefix}})
+}
+
+func TestCompileAS0Unallocated(t *testing.T) {
+
+	registry := testLoadRegistry(t)
+	roa := testLoadFilters(t)
+
+	tests := []struct {
+		name      string
+		tname     string
+		iptype    uint8
+		permitted []string // permitted filter ranges
+		allocated []string // allocations at or beyond the filter MinLen
+	}{
+		{
+			name:      "ipv4",
+			tname:     "inetnum",
+			iptype:    4,
+			permitted: []string{"172.20.0.0/14", "10.0.0.0/8"},
+			// 172.20.0.0/16 is shorter than MinLen, so isn't an allocation
+			allocated: []string{"172.20.0.0/24", "172.20.1.160/27"},
+		},
+		{
+			name:      "ipv6",
+			tname:     "inet6num",
+			iptype:    6,
+			permitted: []string{"fd00::/8"},
+			allocated: []string{"fd42:1::/50"},
+		},
+	}
+
+	for _, test := range tests {
+		as0 := roa.CompileAS0Unallocated(registry, test.tname, test.iptype)
+		if len(as0) == 0 {
+			t.Errorf("%s: no AS0 ROAs generated", test.name)
+			continue
+		}
+
+		for _, r := range as0 {
+			network := testCIDR(t, r.Prefix)
+			_, bits := network.Mask.Size()
+
+			if r.ASN != "AS0" || int(r.MaxLen) != bits {
+				t.Errorf("%s: %s has ASN %s max-length %d",
+					test.name, r.Prefix, r.ASN, r.MaxLen)
+			}
+
+			// must be within a permitted range
+			within := false
+			for _, p := range test.permitted {
+				within = within || netContainsNet(testCIDR(t, p), network)
+			}
+			if !within {
+				t.Errorf("%s: %s is outside the permitted ranges", test.name, r.Prefix)
+			}
+
+			// and must not overlap an allocation
+			for _, a := range test.allocated {
+				if netOverlaps(testCIDR(t, a), network) {
+					t.Errorf("%s: %s overlaps allocation %s", test.name, r.Prefix, a)
+				}
+			}
+		}
+
+		// the AS0 ROAs cover exactly the permitted space, less allocations
+		expected := new(big.Int)
+		for _, p := range test.permitted {
+			expected.Add(expected, testPrefixSize(t, p))
+		}
+		for _, a := range test.allocated {
+			expected.Sub(expected, testPrefixSize(t, a))
+		}
+		if size := testROASize(t, as0); size.Cmp(expected) != 0 {
+			t.Errorf("%s: AS0 ROAs cover %s addresses, expected %s",
+				test.name, size, expected)
+		}
+	}
+}
+
+func TestCompileAS0Deny(t *testing.T) {
+
+	roa := testLoadFilters(t)
+
+	tests := []struct {
+		iptype   uint8
+		expected []string
+	}{
+		// the default route deny rules are skipped
+		{4, []string{"192.168.0.0/16"}},
+		{6, []string{}},
+	}
+
+	for _, test := range tests {
+		as0 := roa.CompileAS0Deny(test.iptype)
+		if len(as0) != len(test.expected) {
+			t.Errorf("ipv%d: got %d AS0 ROAs, expected %d",
+				test.iptype, len(as0), len(test.expected))
+			continue
+		}
+		for ix, r := range as0 {
+			if r.Prefix != test.expected[ix] || r.ASN != "AS0" {
+				t.Errorf("ipv%d: got %s %s, expected AS0 %s",
+					test.iptype, r.ASN, r.Prefix, test.expected[ix])
+			}
+		}
+	}
+}
+
+func TestAS0EffectiveFilter(t *testing.T) {
+
+	// a later permit rule only covers space not matched by earlier rules
+	roa := &ROA{
+		Filters: []*ROAFilter{
+			{Number: 1, Action: "deny", Network: testCIDR(t, "10.1.0.0/16"),
+				IPType: 4, MinLen: 32, MaxLen: 32},
+			{Number: 2, Action: "permit", Network: testCIDR(t, "10.0.0.0/8"),
+				IPType: 4, MinLen: 15, MaxLen: 24},
+		},
+	}
+
+	registry := testLoadRegistry(t)
+	as0 := roa.CompileAS0Unallocated(registry, "inetnum", 4)
+
+	for _, r := range as0 {
+		if netOverlaps(testCIDR(t, r.Prefix), testCIDR(t, "10.1.0.0/16")) {
+			t.Errorf("%s overlaps the earlier deny rule", r.Prefix)
+		}
+	}
+
+	expected := new(big.Int).Sub(testPrefixSize(t, "10.0.0.0/8"),
+		testPrefixSize(t, "10.1.0.0/16"))
+	if size := testROASize(t, as0); size.Cmp(expected) != 0 {
+		t.Errorf("AS0 ROAs cover %s addresses, expected %s", size, expected)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,310 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ROA change log
+//
+// each time the ROA data is updated, the VRPs are compared with the
+// previous set and the differences are recorded, together with the
+// registry objects that were responsible for each change
+
+type ROAChange struct {
+	Prefix  string   `json:"prefix"`
+	MaxLen  uint8    `json:"maxLength"`
+	ASN     string   `json:"asn"`
+	Objects []string `json:"objects,omitempty"`
+	Commits []string `json:"commits,omitempty"`
+}
+
+type ROADelta struct {
+	Serial         uint32       `json:"serial"`
+	Commit         string       `json:"commit"`
+	PreviousCommit string       `json:"previousCommit"`
+	Generated      uint32       `json:"generated"`
+	Announced      []*ROAChange `json:"announced"`
+	Withdrawn      []*ROAChange `json:"withdrawn"`
+}
+
+type ROAChangesResponse struct {
+	Serial  uint32      `json:"serial"`
+	Commit  string      `json:"commit"`
+	Changes []*ROADelta `json:"changes"`
+}
+
+// the number of deltas to keep
+const ROA_CHANGE_HISTORY = 256
+
+var ROAHistory []*ROADelta
+var ROASerial uint32
+var roaHistoryMux sync.RWMutex
+
+// the serial at each registry commit, so that commits which didn't
+// change any VRPs can still be used as a starting point
+var roaCommitSerials = make(map[string]uint32)
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitROAChangesAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitROAChangesAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/roa/changes", roaChangesHandler).Methods("GET")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the ROA changes since a commit or serial
+
+func roaChangesHandler(w http.ResponseWriter, r *http.Request) {
+
+	since := r.URL.Query().Get("since")
+
+	roaHistoryMux.RLock()
+	defer roaHistoryMux.RUnlock()
+
+	response := &ROAChangesResponse{
+		Serial:  ROASerial,
+		Commit:  ROAData.Commit,
+		Changes: make([]*ROADelta, 0),
+	}
+
+	// find where to start from in the history
+	start := 0
+	if since != "" {
+
+		serial, status, err := roaSinceSerial(since)
+		if err != "" {
+			http.Error(w, err, status)
+			return
+		}
+
+		// changes that are no longer in the history can't be returned
+		if len(ROAHistory) != 0 && serial < ROAHistory[0].Serial-1 {
+			http.Error(w, "ROA history since '"+since+
+				"' is no longer available", http.StatusGone)
+			return
+		}
+
+		start = len(ROAHistory)
+		for ix, delta := range ROAHistory {
+			if delta.Serial > serial {
+				start = ix
+				break
+			}
+		}
+	}
+
+	response.Changes = append(response.Changes, ROAHistory[start:]...)
+
+	// don't cache
+	w.Header().Set("Cache-Control", "no-store")
+	(&Response{Data: response, Records: response.Changes}).Write(w, r)
+}
+
+// convert the since parameter to a serial number, returning an HTTP
+// status and error message if it isn't known
+//
+// commits are matched first, so that an abbreviated commit that
+// happens to be all digits isn't mistaken for a serial
+
+func roaSinceSerial(since string) (uint32, int, string) {
+
+	if len(since) >= 7 {
+		var commit string
+		for c := range roaCommitSerials {
+			if strings.HasPrefix(c, strings.ToLower(since)) {
+				if commit != "" {
+					return 0, http.StatusBadRequest,
+						"Ambiguous commit '" + since + "'"
+				}
+				commit = c
+			}
+		}
+		if commit != "" {
+			return roaCommitSerials[commit], 0, ""
+		}
+	}
+
+	if serial, err := strconv.ParseUint(since, 10, 32); err == nil &&
+		uint32(serial) <= ROASerial {
+		return uint32(serial), 0, ""
+	}
+
+	return 0, http.StatusNotFound, "No ROA history found since '" + since + "'"
+}
+
+//////////////////////////////////////////////////////////////////////////
+// compare old and new ROA data and record the differences
+
+func ROARecordChanges(previous *ROA, current *ROA, path string) {
+
+	// nothing to compare on the initial load
+	if previous == nil {
+		roaHistoryMux.Lock()
+		roaCommitSerials[current.Commit] = ROASerial
+		roaHistoryMux.Unlock()
+		return
+	}
+
+	delta := &ROADelta{
+		Commit:         current.Commit,
+		PreviousCommit: previous.Commit,
+		Generated:      uint32(current.CTime.Unix()),
+	}
+
+	pmap := roaChangeMap(previous)
+	cmap := roaChangeMap(current)
+
+	for key, change := range cmap {
+		if pmap[key] == nil {
+			delta.Announced = append(delta.Announced, change)
+		}
+	}
+
+	for key, change := range pmap {
+		if cmap[key] == nil {
+			delta.Withdrawn = append(delta.Withdrawn, change)
+		}
+	}
+
+	// the VRPs are unchanged, there is nothing to record
+	if len(delta.Announced) == 0 && len(delta.Withdrawn) == 0 {
+		roaHistoryMux.Lock()
+		roaCommitSerials[current.Commit] = ROASerial
+		roaHistoryMux.Unlock()
+		return
+	}
+
+	sortROAChanges(delta.Announced)
+	sortROAChanges(delta.Withdrawn)
+
+	// try to find the commits that changed the responsible objects
+	if previous.Commit != current.Commit {
+		regDir := filepath.Dir(path)
+		cache := make(map[string]string)
+		for _, changes := range [][]*ROAChange{delta.Announced, delta.Withdrawn} {
+			for _, change := range changes {
+				change.findCommits(regDir, previous.Commit, current.Commit, cache)
+			}
+		}
+	}
+
+	roaHistoryMux.Lock()
+	defer roaHistoryMux.Unlock()
+
+	ROASerial += 1
+	delta.Serial = ROASerial
+
+	ROAHistory = append(ROAHistory, delta)
+	if len(ROAHistory) > ROA_CHANGE_HISTORY {
+		ROAHistory = ROAHistory[len(ROAHistory)-ROA_CHANGE_HISTORY:]
+	}
+
+	// forget commits from before the start of the history
+	roaCommitSerials[current.Commit] = ROASerial
+	oldest := ROAHistory[0].Serial - 1
+	for commit, serial := range roaCommitSerials {
+		if serial < oldest {
+			delete(roaCommitSerials, commit)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"serial":    delta.Serial,
+		"announced": len(delta.Announced),
+		"withdrawn": len(delta.Withdrawn),
+	}).Debug("ROA changes recorded")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// create a map of all the VRPs in the ROA data
+
+func roaChangeMap(roa *ROA) map[string]*ROAChange {
+
+	result := make(map[string]*ROAChange)
+
+	for _, list := range [][]*PrefixROA{roa.IPv4, roa.IPv6,
+		roa.AS0IPv4, roa.AS0IPv6} {
+		for _, vrp := range list {
+
+			key := vrp.ASN + " " + vrp.Prefix + " " +
+				strconv.Itoa(int(vrp.MaxLen))
+
+			change := result[key]
+			if change == nil {
+				change = &ROAChange{
+					Prefix: vrp.Prefix,
+					MaxLen: vrp.MaxLen,
+					ASN:    vrp.ASN,
+				}
+				result[key] = change
+			}
+
+			if vrp.Object != "" {
+				change.Objects = append(change.Objects, vrp.Object)
+			}
+		}
+	}
+
+	return result
+}
+
+// sort changes to give a stable output
+func sortROAChanges(changes []*ROAChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Prefix != changes[j].Prefix {
+			return changes[i].Prefix < changes[j].Prefix
+		}
+		if changes[i].ASN != changes[j].ASN {
+			return changes[i].ASN < changes[j].ASN
+		}
+		return changes[i].MaxLen < changes[j].MaxLen
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// find the commits between two commits that changed the objects for a VRP
+
+func (change *ROAChange) findCommits(regDir string, from string, to string,
+	cache map[string]string) {
+
+	for _, object := range change.Objects {
+
+		commit, ok := cache[object]
+		if !ok {
+			commit = getObjectCommit(regDir, from, to, object)
+			cache[object] = commit
+		}
+
+		if commit != "" {
+			change.Commits = append(change.Commits, commit)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
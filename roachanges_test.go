@@ -0,0 +1,194 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+// reset the change history, restoring it when the test completes
+func testResetROAHistory(t *testing.T) {
+
+	history, serial, commits, data := ROAHistory, ROASerial, roaCommitSerials, ROAData
+	t.Cleanup(func() {
+		ROAHistory, ROASerial, roaCommitSerials, ROAData = history, serial, commits, data
+	})
+
+	ROAHistory = nil
+	ROASerial = 0
+	roaCommitSerials = make(map[string]uint32)
+}
+
+// return ROA data for a commit, with a route for each of the prefixes
+func testROACommit(commit string, prefixes ...string) *ROA {
+	roa := &ROA{CTime: time.Now(), Commit: commit}
+	for _, prefix := range prefixes {
+		roa.IPv4 = append(roa.IPv4, &PrefixROA{
+			Prefix: prefix,
+			MaxLen: 28,
+			ASN:    "AS4242420001",
+			Object: "route/" + strings.Replace(prefix, "/", "_", 1),
+		})
+	}
+	return roa
+}
+
+// load a sequence of ROA data, recording the changes
+func testROAUpdates(roas ...*ROA) {
+	for _, roa := range roas {
+		// the path is not a git repository, so no commits are found
+		ROARecordChanges(ROAData, roa, "testdata/nogit/data")
+		ROAData = roa
+	}
+}
+
+func TestROARecordChanges(t *testing.T) {
+	testResetROAHistory(t)
+
+	testROAUpdates(
+		testROACommit("aaaaaaa1", "172.20.0.0/24"),
+		testROACommit("bbbbbbb2", "172.20.0.0/24", "172.20.1.0/24"),
+		// no VRPs changed
+		testROACommit("ccccccc3", "172.20.0.0/24", "172.20.1.0/24"),
+		testROACommit("ddddddd4", "172.20.1.0/24"),
+	)
+
+	if ROASerial != 2 || len(ROAHistory) != 2 {
+		t.Fatalf("expected 2 deltas, got serial %d with %d deltas",
+			ROASerial, len(ROAHistory))
+	}
+
+	first, second := ROAHistory[0], ROAHistory[1]
+	if first.Commit != "bbbbbbb2" || first.PreviousCommit != "aaaaaaa1" ||
+		len(first.Announced) != 1 || len(first.Withdrawn) != 0 ||
+		first.Announced[0].Prefix != "172.20.1.0/24" {
+		t.Errorf("unexpected first delta: %+v", first)
+	}
+	if second.Commit != "ddddddd4" || second.PreviousCommit != "ccccccc3" ||
+		len(second.Announced) != 0 || len(second.Withdrawn) != 1 ||
+		second.Withdrawn[0].Prefix != "172.20.0.0/24" ||
+		second.Withdrawn[0].Objects[0] != "route/172.20.0.0_24" {
+		t.Errorf("unexpected second delta: %+v", second)
+	}
+}
+
+func TestROAChangesHandler(t *testing.T) {
+	testResetROAHistory(t)
+
+	testROAUpdates(
+		testROACommit("1234567aaaa", "172.20.0.0/24"),
+		testROACommit("bbbbbbb2", "172.20.0.0/24", "172.20.1.0/24"),
+		testROACommit("ccccccc3", "172.20.0.0/24", "172.20.1.0/24"),
+		testROACommit("ddddddd4", "172.20.1.0/24"),
+	)
+
+	tests := []struct {
+		since   string
+		status  int
+		serials []uint32
+	}{
+		{"", http.StatusOK, []uint32{1, 2}},
+		{"0", http.StatusOK, []uint32{1, 2}},
+		{"1", http.StatusOK, []uint32{2}},
+		{"2", http.StatusOK, []uint32{}},
+		{"3", http.StatusNotFound, nil},
+		// the commit the history started from, all digits
+		{"1234567", http.StatusOK, []uint32{1, 2}},
+		{"bbbbbbb2", http.StatusOK, []uint32{2}},
+		// a commit that didn't change any VRPs
+		{"ccccccc", http.StatusOK, []uint32{2}},
+		// the current commit
+		{"ddddddd4", http.StatusOK, []uint32{}},
+		{"eeeeeee", http.StatusNotFound, nil},
+		{"xyz", http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/roa/changes?since="+test.since, nil)
+		w := httptest.NewRecorder()
+		roaChangesHandler(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("since=%s: got status %d, expected %d",
+				test.since, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		response := &ROAChangesResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Errorf("since=%s: %v", test.since, err)
+			continue
+		}
+
+		serials := make([]uint32, len(response.Changes))
+		for ix, delta := range response.Changes {
+			serials[ix] = delta.Serial
+		}
+		if fmt.Sprint(serials) != fmt.Sprint(test.serials) {
+			t.Errorf("since=%s: got serials %v, expected %v",
+				test.since, serials, test.serials)
+		}
+	}
+}
+
+func TestROAChangesTruncated(t *testing.T) {
+	testResetROAHistory(t)
+
+	// alternate between two sets of VRPs, overflowing the history
+	roas := []*ROA{testROACommit("c000000", "172.20.0.0/24")}
+	for ix := 1; ix <= ROA_CHANGE_HISTORY+10; ix++ {
+		prefixes := []string{"172.20.0.0/24"}
+		if ix%2 == 1 {
+			prefixes = append(prefixes, "172.20.1.0/24")
+		}
+		roas = append(roas, testROACommit(fmt.Sprintf("c%06d", ix), prefixes...))
+	}
+	testROAUpdates(roas...)
+
+	if len(ROAHistory) != ROA_CHANGE_HISTORY {
+		t.Fatalf("expected %d deltas, got %d", ROA_CHANGE_HISTORY, len(ROAHistory))
+	}
+	oldest := ROAHistory[0].Serial
+
+	tests := []struct {
+		since  string
+		status int
+	}{
+		{"0", http.StatusGone},
+		{fmt.Sprint(oldest - 2), http.StatusGone},
+		{fmt.Sprint(oldest - 1), http.StatusOK},
+		{fmt.Sprintf("c%06d", oldest-1), http.StatusOK},
+		// commits from before the history are forgotten
+		{"c000000", http.StatusNotFound},
+		{fmt.Sprintf("c%06d", oldest-2), http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/roa/changes?since="+test.since, nil)
+		w := httptest.NewRecorder()
+		roaChangesHandler(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("since=%s: got status %d, expected %d",
+				test.since, w.Code, test.status)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
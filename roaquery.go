@@ -0,0 +1,256 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ROA query parameters
+//
+// asn=       origin ASN, a comma separated list or an as-set, members of
+//            an as-set that are not ASNs or as-sets are skipped
+// within=    ROAs for prefixes within (covered by) the given prefix
+// covers=    ROAs for prefixes that cover the given prefix
+// maxlen=    ROAs with exactly this max-length
+// maxlen-ge= ROAs with a max-length greater than or equal to
+// maxlen-le= ROAs with a max-length less than or equal to
+// source=    ROAs from a specific source
+//
+// parameters may be repeated to match any of the values, whilst
+// different parameters must all match
+
+type ROAQuery struct {
+	ASNs     map[string]bool
+	Within   []*net.IPNet
+	Covers   []*net.IPNet
+	MaxLen   []uint8
+	MaxLenGE []uint8
+	MaxLenLE []uint8
+	Sources  map[string]bool
+
+	// as-set members that were skipped
+	Unresolved []string
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse the query parameters from a request
+//
+// returns nil if no query parameters were given
+
+func parseROAQuery(r *http.Request) (*ROAQuery, error) {
+
+	query := r.URL.Query()
+	q := &ROAQuery{}
+	active := false
+
+	// origin ASNs, including as-sets
+	for _, param := range query["asn"] {
+		for _, asn := range strings.Split(param, ",") {
+			asn = strings.TrimSpace(asn)
+			if asn == "" {
+				continue
+			}
+			if q.ASNs == nil {
+				q.ASNs = make(map[string]bool)
+			}
+			if !q.addASN(asn, make(map[string]bool)) {
+				return nil, errors.New("invalid ASN or as-set: " + asn)
+			}
+			active = true
+		}
+	}
+
+	// prefixes
+	parsePrefixes := func(name string) ([]*net.IPNet, error) {
+		var networks []*net.IPNet
+		for _, param := range query[name] {
+			_, network, err := net.ParseCIDR(param)
+			if err != nil {
+				return nil, errors.New("invalid prefix for " + name +
+					": " + param)
+			}
+			networks = append(networks, network)
+			active = true
+		}
+		return networks, nil
+	}
+
+	var err error
+	if q.Within, err = parsePrefixes("within"); err != nil {
+		return nil, err
+	}
+	if q.Covers, err = parsePrefixes("covers"); err != nil {
+		return nil, err
+	}
+
+	// max-length conditions
+	parseLengths := func(name string) ([]uint8, error) {
+		var lengths []uint8
+		for _, param := range query[name] {
+			l, err := strconv.ParseUint(param, 10, 8)
+			if err != nil || l > 128 {
+				return nil, errors.New("invalid length for " + name +
+					": " + param)
+			}
+			lengths = append(lengths, uint8(l))
+			active = true
+		}
+		return lengths, nil
+	}
+
+	if q.MaxLen, err = parseLengths("maxlen"); err != nil {
+		return nil, err
+	}
+	if q.MaxLenGE, err = parseLengths("maxlen-ge"); err != nil {
+		return nil, err
+	}
+	if q.MaxLenLE, err = parseLengths("maxlen-le"); err != nil {
+		return nil, err
+	}
+
+	// sources
+	for _, param := range query["source"] {
+		if q.Sources == nil {
+			q.Sources = make(map[string]bool)
+		}
+		q.Sources[param] = true
+		active = true
+	}
+
+	if !active {
+		return nil, nil
+	}
+	return q, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// add an ASN, or recursively expand an as-set, to the query
+//
+// returns false if the name is neither, as-set members that can't be
+// resolved are skipped rather than failing the whole query
+
+func (q *ROAQuery) addASN(name string, visited map[string]bool) bool {
+
+	// is this an as-set ?
+	if asset := RegistryData.GetObject(
+		RegistryMakePath("as-set", strings.ToUpper(name))); asset != nil {
+
+		// protect against loops
+		if visited[asset.Ref] {
+			return true
+		}
+		visited[asset.Ref] = true
+
+		for _, member := range asset.GetKey("members") {
+			if !q.addASN(member.RawValue, visited) {
+				log.WithFields(log.Fields{
+					"as-set": asset.Ref,
+					"member": member.RawValue,
+				}).Debug("Skipping unresolved as-set member")
+				q.Unresolved = append(q.Unresolved, member.RawValue)
+			}
+		}
+		return true
+	}
+
+	// otherwise must be an ASN, with or without the AS prefix
+	number := strings.TrimPrefix(strings.ToUpper(name), "AS")
+	if _, err := strconv.ParseUint(number, 10, 32); err != nil {
+		return false
+	}
+
+	q.ASNs["AS"+number] = true
+	return true
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return true if a ROA matches the query
+
+func (q *ROAQuery) Match(roa *PrefixROA) bool {
+
+	if q.ASNs != nil && !q.ASNs[roa.ASN] {
+		return false
+	}
+
+	if q.Sources != nil && !q.Sources[roa.Source] {
+		return false
+	}
+
+	if len(q.Within) != 0 || len(q.Covers) != 0 {
+		_, network, err := net.ParseCIDR(roa.Prefix)
+		if err != nil {
+			return false
+		}
+		if !roaQueryAny(len(q.Within), func(ix int) bool {
+			return netContainsNet(q.Within[ix], network)
+		}) {
+			return false
+		}
+		if !roaQueryAny(len(q.Covers), func(ix int) bool {
+			return netContainsNet(network, q.Covers[ix])
+		}) {
+			return false
+		}
+	}
+
+	if !roaQueryAny(len(q.MaxLen), func(ix int) bool {
+		return roa.MaxLen == q.MaxLen[ix]
+	}) {
+		return false
+	}
+	if !roaQueryAny(len(q.MaxLenGE), func(ix int) bool {
+		return roa.MaxLen >= q.MaxLenGE[ix]
+	}) {
+		return false
+	}
+	if !roaQueryAny(len(q.MaxLenLE), func(ix int) bool {
+		return roa.MaxLen <= q.MaxLenLE[ix]
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// returns true if any of the values of a repeated parameter match,
+// or if the parameter wasn't given
+func roaQueryAny(count int, match func(ix int) bool) bool {
+	if count == 0 {
+		return true
+	}
+	for ix := 0; ix < count; ix++ {
+		if match(ix) {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the ROAs that match the query
+
+func (q *ROAQuery) Filter(roas []*PrefixROA) []*PrefixROA {
+
+	result := make([]*PrefixROA, 0)
+	for _, roa := range roas {
+		if q.Match(roa) {
+			result = append(result, roa)
+		}
+	}
+
+	return result
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
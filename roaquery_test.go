@@ -0,0 +1,175 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestROAQuery(t *testing.T) {
+
+	// as-sets are expanded from the global registry
+	saved := RegistryData
+	RegistryData = testLoadRegistry(t)
+	defer func() { RegistryData = saved }()
+
+	roa := func(prefix string, maxlen uint8, asn string,
+		source string) *PrefixROA {
+		return &PrefixROA{Prefix: prefix, MaxLen: maxlen, ASN: asn, Source: source}
+	}
+
+	roas := []*PrefixROA{
+		roa("172.20.0.0/24", 29, "AS4242420001", "dn42"),
+		roa("172.20.1.0/24", 24, "AS4242420002", "dn42"),
+		roa("172.20.0.128/25", 28, "AS4242420010", "dn42"),
+		roa("10.0.0.0/16", 24, "AS64512", "other"),
+		roa("fd42:1::/48", 64, "AS4242420001", "dn42"),
+	}
+
+	tests := []struct {
+		query    string
+		expected []string // matching prefixes, in order
+	}{
+		{"asn=AS4242420001", []string{"172.20.0.0/24", "fd42:1::/48"}},
+		{"asn=4242420002", []string{"172.20.1.0/24"}},
+		{"asn=AS4242420002,AS64512", []string{"172.20.1.0/24", "10.0.0.0/16"}},
+		{"asn=AS4242420002&asn=AS64512", []string{"172.20.1.0/24", "10.0.0.0/16"}},
+		// as-sets that include each other
+		{"asn=AS-FOO", []string{"172.20.0.0/24", "172.20.1.0/24", "fd42:1::/48"}},
+		{"asn=as-bar", []string{"172.20.0.0/24", "172.20.1.0/24", "fd42:1::/48"}},
+		{"within=172.20.0.0/16",
+			[]string{"172.20.0.0/24", "172.20.1.0/24", "172.20.0.128/25"}},
+		{"within=172.20.0.0/24", []string{"172.20.0.0/24", "172.20.0.128/25"}},
+		{"covers=172.20.0.130/32", []string{"172.20.0.0/24", "172.20.0.128/25"}},
+		{"covers=fd42:1:0:1::/64", []string{"fd42:1::/48"}},
+		{"within=172.20.0.0/16&covers=172.20.1.0/24", []string{"172.20.1.0/24"}},
+		// repeated parameters match any of the values
+		{"within=172.20.1.0/24&within=fd42::/16",
+			[]string{"172.20.1.0/24", "fd42:1::/48"}},
+		{"covers=172.20.1.1/32&covers=172.20.0.1/32",
+			[]string{"172.20.0.0/24", "172.20.1.0/24"}},
+		{"maxlen=24&maxlen=64", []string{"172.20.1.0/24", "10.0.0.0/16", "fd42:1::/48"}},
+		{"source=other&source=dn42&maxlen=28",
+			[]string{"172.20.0.128/25"}},
+		{"maxlen=24", []string{"172.20.1.0/24", "10.0.0.0/16"}},
+		{"maxlen-ge=29", []string{"172.20.0.0/24", "fd42:1::/48"}},
+		{"maxlen-le=28", []string{"172.20.1.0/24", "172.20.0.128/25", "10.0.0.0/16"}},
+		{"maxlen-ge=25&maxlen-le=28", []string{"172.20.0.128/25"}},
+		{"source=other", []string{"10.0.0.0/16"}},
+		{"source=dn42&asn=AS64512", []string{}},
+		{"asn=AS-FOO&within=172.20.0.0/14&maxlen-ge=29", []string{"172.20.0.0/24"}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/roa/json?"+test.query, nil)
+		q, err := parseROAQuery(r)
+		if err != nil || q == nil {
+			t.Errorf("%s: unexpected result %v, %v", test.query, q, err)
+			continue
+		}
+
+		result := q.Filter(roas)
+		got := make([]string, len(result))
+		for ix, roa := range result {
+			got[ix] = roa.Prefix
+		}
+		if strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("%s: got %v, expected %v", test.query, got, test.expected)
+		}
+	}
+}
+
+func TestROAQueryUnresolved(t *testing.T) {
+
+	// add as-set members that can't be resolved
+	data := testCopyRegistry(t)
+	err := ioutil.WriteFile(filepath.Join(data, "as-set", "AS-BAR"), []byte(
+		"as-set:             AS-BAR\n"+
+			"members:            AS4242420002\n"+
+			"members:            AS-MISSING\n"+
+			"members:            AS-FOO\n"+
+			"members:            not an asn\n"+
+			"mnt-by:             FOO-MNT\n"+
+			"source:             DN42\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := RegistryData
+	RegistryData = LoadRegistry(data, testRegistryCommit)
+	defer func() { RegistryData = saved }()
+
+	// the query succeeds with the members that could be resolved
+	r := httptest.NewRequest("GET", "/api/roa/json?asn=AS-BAR,AS64512", nil)
+	q, err := parseROAQuery(r)
+	if err != nil || q == nil {
+		t.Fatalf("unexpected result %v, %v", q, err)
+	}
+
+	if len(q.ASNs) != 3 || !q.ASNs["AS4242420001"] || !q.ASNs["AS4242420002"] ||
+		!q.ASNs["AS64512"] {
+		t.Errorf("unexpected ASNs %v", q.ASNs)
+	}
+	if strings.Join(q.Unresolved, ",") != "AS-MISSING,not an asn" {
+		t.Errorf("unexpected unresolved members %v", q.Unresolved)
+	}
+
+	// names given directly in the query must still be valid
+	r = httptest.NewRequest("GET", "/api/roa/json?asn=AS-BAR,AS-MISSING", nil)
+	if _, err := parseROAQuery(r); err == nil ||
+		!strings.Contains(err.Error(), "AS-MISSING") {
+		t.Errorf("expected an error for AS-MISSING, got %v", err)
+	}
+}
+
+func TestROAQueryInvalid(t *testing.T) {
+
+	saved := RegistryData
+	RegistryData = testLoadRegistry(t)
+	defer func() { RegistryData = saved }()
+
+	tests := []struct {
+		query string
+		err   string // empty if no query is expected
+	}{
+		{"", ""},
+		{"unrelated=1", ""},
+		{"asn=", ""},
+		{"asn=AS-MISSING", "invalid ASN or as-set"},
+		{"asn=AS99999999999", "invalid ASN or as-set"},
+		{"within=172.20.0.0", "invalid prefix for within"},
+		{"covers=not-a-prefix", "invalid prefix for covers"},
+		{"maxlen=129", "invalid length for maxlen"},
+		{"maxlen-ge=-1", "invalid length for maxlen-ge"},
+		{"maxlen-le=x", "invalid length for maxlen-le"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/roa/json?"+test.query, nil)
+		q, err := parseROAQuery(r)
+
+		if test.err == "" {
+			if q != nil || err != nil {
+				t.Errorf("'%s': expected no query, got %v, %v", test.query, q, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("'%s': expected error '%s', got %v", test.query, test.err, err)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
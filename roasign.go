@@ -0,0 +1,281 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ROA JSON signing
+//
+// The signature covers the compact JSON encoding of the 'roas' array,
+// this is the same scheme used by GoRTR, so that a mirrored copy of
+// /api/roa/json can be checked using either ECDSA or Ed25519 keys.
+//
+// For ECDSA keys, the signature is an ASN.1 encoded signature of the
+// SHA256 digest of the payload, for Ed25519 the payload is signed
+// directly. In both cases the signature is hex encoded.
+
+// the key used to sign ROA data, nil if signing is disabled
+var ROASigningKey crypto.Signer
+
+// PEM encoded public key, for publishing
+var ROAPublicKeyPEM []byte
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitROASignAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to load the signing key
+
+func InitialiseROASigning(keyFile string) {
+
+	// an empty path disables signing
+	if keyFile == "" {
+		return
+	}
+
+	signer, err := LoadSigningKey(keyFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  keyFile,
+		}).Fatal("Unable to load ROA signing key")
+	}
+
+	// pre-compute the PEM version of the public key
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  keyFile,
+		}).Fatal("Unable to marshal ROA public key")
+	}
+
+	ROASigningKey = signer
+	ROAPublicKeyPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	})
+
+	log.WithFields(log.Fields{
+		"path": keyFile,
+	}).Info("ROA signing enabled")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load a PEM encoded Ed25519 or ECDSA private key
+
+func LoadSigningKey(path string) (crypto.Signer, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in key file")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			return k, nil
+		case *ecdsa.PrivateKey:
+			return k, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+
+	return nil, errors.New("unsupported PEM block type: " + block.Type)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load a PEM encoded public key
+
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found in key file")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// sign a list of ROAs, returns the hex signature and signature date
+
+func SignROA(signer crypto.Signer, roas []*PrefixROA) (string, string, error) {
+
+	payload, err := json.Marshal(roas)
+	if err != nil {
+		return "", "", err
+	}
+
+	var sig []byte
+	switch signer.(type) {
+	case ed25519.PrivateKey:
+		// ed25519 signs the message directly
+		sig, err = signer.Sign(rand.Reader, payload, crypto.Hash(0))
+
+	default:
+		// otherwise sign the SHA256 digest
+		digest := sha256.Sum256(payload)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(sig),
+		time.Now().UTC().Format(time.RFC3339), nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// verify a JSON document in /api/roa/json format against a public key
+//
+// returns nil if the signature matches the roas in the document
+
+func VerifyROAJSON(data []byte, pub crypto.PublicKey) error {
+
+	// the roas are decoded in to the same structure used to
+	// generate them, so that re-marshalling produces the signed payload
+	var doc ROAJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	if doc.MetaData.Signature == "" {
+		return errors.New("document is not signed")
+	}
+
+	sig, err := hex.DecodeString(doc.MetaData.Signature)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(doc.Roas)
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return errors.New("signature verification failed")
+		}
+
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("signature verification failed")
+		}
+
+	default:
+		return errors.New("unsupported public key type")
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// verify a file from the command line and exit
+
+func VerifyROAFile(path string, keyFile string) {
+
+	pub, err := LoadPublicKey(keyFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  keyFile,
+		}).Fatal("Unable to load ROA public key")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("Unable to read ROA JSON file")
+	}
+
+	if err := VerifyROAJSON(data, pub); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("ROA JSON verification failed")
+	}
+
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Info("ROA JSON signature verified")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitROASignAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/roa/key", roaKeyHandler).Methods("GET")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the public key used to sign the ROA data
+
+func roaKeyHandler(w http.ResponseWriter, r *http.Request) {
+
+	if ROAPublicKeyPEM == nil {
+		http.Error(w, "ROA signing is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(ROAPublicKeyPEM)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
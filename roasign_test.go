@@ -0,0 +1,174 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+var testROAs = []*PrefixROA{
+	{Prefix: "172.20.0.0/24", MaxLen: 29, ASN: "AS4242420001", Source: "dn42"},
+	{Prefix: "fd42:1::/48", MaxLen: 64, ASN: "AS4242420001", Source: "dn42"},
+}
+
+// return a signed /api/roa/json document
+func testSignedROAJSON(t *testing.T, signer crypto.Signer,
+	roas []*PrefixROA) []byte {
+
+	sig, sdate, err := SignROA(signer, roas)
+	if err != nil {
+		t.Fatalf("SignROA: %v", err)
+	}
+
+	data, err := json.Marshal(&ROAJSON{
+		MetaData: ROAMetaData{
+			Counts:        uint(len(roas)),
+			Signature:     sig,
+			SignatureDate: sdate,
+		},
+		Roas: roas,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyROAJSON(t *testing.T) {
+
+	_, edkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eckey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]crypto.Signer{
+		"ed25519": edkey,
+		"ecdsa":   eckey,
+	}
+
+	for name, signer := range signers {
+		signed := testSignedROAJSON(t, signer, testROAs)
+
+		tampered := strings.Replace(string(signed),
+			`"maxLength":29`, `"maxLength":32`, 1)
+		if tampered == string(signed) {
+			t.Fatal("failed to tamper with the document")
+		}
+
+		unsigned, _ := json.Marshal(&ROAJSON{Roas: testROAs})
+
+		tests := []struct {
+			name string
+			data []byte
+			pub  crypto.PublicKey
+			err  string
+		}{
+			{"valid", signed, signer.Public(), ""},
+			{"tampered", []byte(tampered), signer.Public(), "verification failed"},
+			{"wrong key", signed, otherkey.Public(), "verification failed"},
+			{"unsigned", unsigned, signer.Public(), "not signed"},
+			{"bad hex", []byte(`{"metadata":{"signature":"zz"},"roas":[]}`),
+				signer.Public(), "invalid byte"},
+			{"bad json", []byte(`{`), signer.Public(), "unexpected end"},
+			{"unsupported key", signed, "not a key", "unsupported"},
+		}
+
+		for _, test := range tests {
+			err := VerifyROAJSON(test.data, test.pub)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("%s/%s: unexpected error: %v", name, test.name, err)
+			case test.err != "" && err == nil:
+				t.Errorf("%s/%s: expected error containing '%s'",
+					name, test.name, test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("%s/%s: expected error containing '%s', got '%v'",
+					name, test.name, test.err, err)
+			}
+		}
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "roasign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecder, _ := x509.MarshalECPrivateKey(eckey)
+	_, edkey, _ := ed25519.GenerateKey(rand.Reader)
+	edder, _ := x509.MarshalPKCS8PrivateKey(edkey)
+	rsakey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsader, _ := x509.MarshalPKCS8PrivateKey(rsakey)
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		ok    bool
+	}{
+		{"ec", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecder}, true},
+		{"ed25519", &pem.Block{Type: "PRIVATE KEY", Bytes: edder}, true},
+		{"rsa", &pem.Block{Type: "PRIVATE KEY", Bytes: rsader}, false},
+		{"certificate", &pem.Block{Type: "CERTIFICATE", Bytes: ecder}, false},
+		{"empty", nil, false},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name+".pem")
+		var data []byte
+		if test.block != nil {
+			data = pem.EncodeToMemory(test.block)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := LoadSigningKey(path)
+		if test.ok != (err == nil) {
+			t.Errorf("%s: expected ok=%v, got error %v", test.name, test.ok, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+
+		// a document signed with the loaded key verifies with its public key
+		signed := testSignedROAJSON(t, signer, testROAs)
+		if err := VerifyROAJSON(signed, signer.Public()); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,375 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// additional ROA sources
+//
+// ROAs from other federated registries may be merged with the dn42 data.
+// Sources are either JSON files in the /api/roa/json format, or registry
+// directories that use the dn42 registry layout, and are configured using
+// --ROASource name=path
+//
+// every ROA is tagged with its source, and ROAs from other sources are
+// checked against the dn42 filter rules and ROAs before being merged
+
+const ROA_SOURCE_DN42 = "dn42"
+
+type ROASource struct {
+	Name string
+	Path string
+	Type string
+}
+
+type ROAConflict struct {
+	Prefix string `json:"prefix"`
+	MaxLen uint8  `json:"maxLength"`
+	ASN    string `json:"asn"`
+	Reason string `json:"reason"`
+}
+
+type ROASourceStatus struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	Count     uint           `json:"count"`
+	Error     string         `json:"error,omitempty"`
+	Conflicts []*ROAConflict `json:"conflicts"`
+}
+
+var ROASources []*ROASource
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitROASourcesAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to configure the additional sources
+
+func InitialiseROASources(specs []string) {
+
+	for _, spec := range specs {
+
+		ix := strings.IndexRune(spec, '=')
+		if ix < 1 {
+			log.WithFields(log.Fields{
+				"source": spec,
+			}).Fatal("ROA source must be in the form name=path")
+		}
+
+		source := &ROASource{
+			Name: spec[:ix],
+			Path: spec[ix+1:],
+		}
+
+		if source.Name == ROA_SOURCE_DN42 {
+			log.WithFields(log.Fields{
+				"source": spec,
+			}).Fatal("ROA source name is reserved")
+		}
+
+		stat, err := os.Stat(source.Path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"source": spec,
+			}).Fatal("Unable to find ROA source")
+		}
+
+		// directories are treated as registries
+		if stat.IsDir() {
+			source.Type = "registry"
+
+			// allow either the registry or the data directory to be given
+			if _, err := os.Stat(source.Path + "/data"); err == nil {
+				source.Path += "/data"
+			}
+		} else {
+			source.Type = "json"
+		}
+
+		ROASources = append(ROASources, source)
+
+		log.WithFields(log.Fields{
+			"name": source.Name,
+			"path": source.Path,
+			"type": source.Type,
+		}).Info("Added ROA source")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitROASourcesAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/roa/sources", roaSourcesHandler).Methods("GET")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the status of each ROA source
+
+func roaSourcesHandler(w http.ResponseWriter, r *http.Request) {
+
+	// cache for up to a week, but set etag to commit to catch changes
+	w.Header().Set("Cache-Control", "public, max-age=7200, stale-if-error=604800")
+	w.Header().Set("ETag", ROAData.Commit)
+
+	(&Response{Data: ROAData.Sources}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// set the source for a list of ROAs
+
+func tagROA(roas []*PrefixROA, source string) []*PrefixROA {
+	for _, roa := range roas {
+		roa.Source = source
+	}
+	return roas
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load and merge all the additional sources
+
+func (roa *ROA) MergeSources() {
+
+	// pre-parse the dn42 ROAs for conflict detection
+	dn42 := make([]*net.IPNet, 0, len(roa.IPv4)+len(roa.IPv6))
+	dn42roa := make([]*PrefixROA, 0, cap(dn42))
+	for _, list := range [][]*PrefixROA{roa.IPv4, roa.IPv6} {
+		for _, vrp := range list {
+			if _, network, err := net.ParseCIDR(vrp.Prefix); err == nil {
+				dn42 = append(dn42, network)
+				dn42roa = append(dn42roa, vrp)
+			}
+		}
+	}
+
+	// always list the sources, even when there are none
+	roa.Sources = make([]*ROASourceStatus, 0, len(ROASources))
+
+	for _, source := range ROASources {
+
+		status := &ROASourceStatus{
+			Name:      source.Name,
+			Type:      source.Type,
+			Conflicts: make([]*ROAConflict, 0),
+		}
+		roa.Sources = append(roa.Sources, status)
+
+		vrps, err := source.Load()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"source": source.Name,
+				"path":   source.Path,
+			}).Error("Unable to load ROA source")
+			status.Error = err.Error()
+			continue
+		}
+
+		for _, vrp := range vrps {
+
+			_, network, err := net.ParseCIDR(vrp.Prefix)
+			if err != nil {
+				status.conflict(vrp, "invalid prefix")
+				continue
+			}
+
+			if reason := roa.checkConflict(vrp, network,
+				dn42, dn42roa); reason != "" {
+				status.conflict(vrp, reason)
+				continue
+			}
+
+			// limit the max-length to that permitted by the dn42 filters
+			if filter := roa.MatchFilter(network.IP); filter != nil &&
+				filter.Action == "permit" && vrp.MaxLen > filter.MaxLen {
+
+				log.WithFields(log.Fields{
+					"source": source.Name,
+					"prefix": vrp.Prefix,
+					"asn":    vrp.ASN,
+					"maxlen": vrp.MaxLen,
+					"filter": filter.Number,
+				}).Info("Clamped max-length of ROA from additional source")
+				vrp.MaxLen = filter.MaxLen
+			}
+
+			vrp.Source = source.Name
+			if network.IP.To4() != nil {
+				roa.IPv4 = append(roa.IPv4, vrp)
+			} else {
+				roa.IPv6 = append(roa.IPv6, vrp)
+			}
+			status.Count += 1
+		}
+
+		log.WithFields(log.Fields{
+			"source":    source.Name,
+			"count":     status.Count,
+			"conflicts": len(status.Conflicts),
+		}).Debug("Merged ROA source")
+	}
+}
+
+// record a conflict
+func (status *ROASourceStatus) conflict(vrp *PrefixROA, reason string) {
+
+	status.Conflicts = append(status.Conflicts, &ROAConflict{
+		Prefix: vrp.Prefix,
+		MaxLen: vrp.MaxLen,
+		ASN:    vrp.ASN,
+		Reason: reason,
+	})
+
+	log.WithFields(log.Fields{
+		"source": status.Name,
+		"prefix": vrp.Prefix,
+		"asn":    vrp.ASN,
+		"reason": reason,
+	}).Warn("Rejected ROA from additional source")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// check a ROA from another source against the dn42 filters and ROAs
+//
+// returns an empty string if there is no conflict
+//
+// - ROAs that match a dn42 deny rule are rejected, unless the rule is
+//   the default route (which covers space outside of dn42)
+// - ROAs within permitted dn42 space must follow the filter lengths
+//   and must not overlap a dn42 ROA for a different origin
+
+func (roa *ROA) checkConflict(vrp *PrefixROA, network *net.IPNet,
+	dn42 []*net.IPNet, dn42roa []*PrefixROA) string {
+
+	filter := roa.MatchFilter(network.IP)
+	if filter == nil {
+		return ""
+	}
+
+	if filter.Action == "deny" {
+		if plen, _ := filter.Network.Mask.Size(); plen != 0 {
+			return fmt.Sprintf("denied by dn42 filter rule %d (%s)",
+				filter.Number, filter.Prefix)
+		}
+		return ""
+	}
+
+	plen, _ := network.Mask.Size()
+	if plen < int(filter.MinLen) || plen > int(filter.MaxLen) {
+		return fmt.Sprintf("prefix length outside of dn42 filter rule %d (%s)",
+			filter.Number, filter.Prefix)
+	}
+
+	for ix, dnet := range dn42 {
+		if dn42roa[ix].ASN != vrp.ASN && netOverlaps(dnet, network) {
+			return fmt.Sprintf("overlaps dn42 ROA %s for %s",
+				dn42roa[ix].Prefix, dn42roa[ix].ASN)
+		}
+	}
+
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load the ROAs from a source
+
+func (source *ROASource) Load() ([]*PrefixROA, error) {
+
+	if source.Type == "registry" {
+		return source.loadRegistry()
+	}
+	return source.loadJSON()
+}
+
+// load ROAs from another registry
+func (source *ROASource) loadRegistry() ([]*PrefixROA, error) {
+
+	registry := LoadRegistry(source.Path, "")
+
+	// the registry is compiled using its own filter rules
+	roa := &ROA{}
+	if err := roa.loadFilter(source.Path+"/filter.txt", 4); err != nil {
+		return nil, err
+	}
+	if err := roa.loadFilter(source.Path+"/filter6.txt", 6); err != nil {
+		return nil, err
+	}
+
+	vrps := roa.CompileROA(registry, "route")
+	vrps = append(vrps, roa.CompileROA(registry, "route6")...)
+
+	return vrps, nil
+}
+
+// load ROAs from a JSON file
+func (source *ROASource) loadJSON() ([]*PrefixROA, error) {
+
+	data, err := ioutil.ReadFile(source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ASN may be a string or a number
+	var doc struct {
+		Roas []struct {
+			Prefix string      `json:"prefix"`
+			MaxLen uint8       `json:"maxLength"`
+			ASN    interface{} `json:"asn"`
+		} `json:"roas"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	vrps := make([]*PrefixROA, 0, len(doc.Roas))
+	for _, r := range doc.Roas {
+
+		var asn string
+		switch v := r.ASN.(type) {
+		case string:
+			asn = strings.TrimPrefix(strings.ToUpper(v), "AS")
+		case float64:
+			asn = strconv.FormatUint(uint64(v), 10)
+		}
+		if _, err := strconv.ParseUint(asn, 10, 32); err != nil {
+			return nil, errors.New("invalid ASN for prefix " + r.Prefix)
+		}
+
+		vrps = append(vrps, &PrefixROA{
+			Prefix: r.Prefix,
+			MaxLen: r.MaxLen,
+			ASN:    "AS" + asn,
+		})
+	}
+
+	return vrps, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
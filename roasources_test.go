@@ -0,0 +1,137 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestMergeSources(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "roasources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "other.json")
+	if err := ioutil.WriteFile(path, []byte(`{"roas":[
+		{"prefix":"10.1.0.0/16","maxLength":28,"asn":64512},
+		{"prefix":"10.2.0.0/16","maxLength":20,"asn":"AS64513"},
+		{"prefix":"172.20.0.0/24","maxLength":24,"asn":"AS64514"},
+		{"prefix":"192.168.1.0/24","maxLength":24,"asn":64515},
+		{"prefix":"10.3.0.0/25","maxLength":25,"asn":64516},
+		{"prefix":"203.0.113.0/24","maxLength":24,"asn":64517}
+	]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := ROASources
+	ROASources = []*ROASource{{Name: "other", Path: path, Type: "json"}}
+	defer func() { ROASources = saved }()
+
+	roa := testLoadFilters(t)
+	roa.IPv4 = []*PrefixROA{{Prefix: "172.20.0.0/24", MaxLen: 29,
+		ASN: "AS4242420001", Source: ROA_SOURCE_DN42}}
+	roa.MergeSources()
+
+	if len(roa.Sources) != 1 {
+		t.Fatalf("expected 1 source status, got %d", len(roa.Sources))
+	}
+	status := roa.Sources[0]
+
+	merged := make(map[string]*PrefixROA)
+	for _, vrp := range roa.IPv4 {
+		if vrp.Source == "other" {
+			merged[vrp.Prefix] = vrp
+		}
+	}
+
+	// accepted, with the max-length clamped to the filter
+	tests := []struct {
+		prefix string
+		maxlen uint8
+	}{
+		{"10.1.0.0/16", 24},
+		{"10.2.0.0/16", 20},
+		// outside of dn42 space
+		{"203.0.113.0/24", 24},
+	}
+	for _, test := range tests {
+		vrp := merged[test.prefix]
+		if vrp == nil {
+			t.Errorf("%s was not merged", test.prefix)
+			continue
+		}
+		if vrp.MaxLen != test.maxlen {
+			t.Errorf("%s: got max-length %d, expected %d",
+				test.prefix, vrp.MaxLen, test.maxlen)
+		}
+	}
+
+	// rejected
+	conflicts := map[string]string{
+		"172.20.0.0/24":  "overlaps dn42 ROA",
+		"192.168.1.0/24": "denied by dn42 filter rule 3",
+		"10.3.0.0/25":    "prefix length outside of dn42 filter rule 2",
+	}
+	if status.Count != uint(len(tests)) ||
+		len(status.Conflicts) != len(conflicts) {
+		t.Errorf("got %d merged and %d conflicts", status.Count,
+			len(status.Conflicts))
+	}
+	for _, conflict := range status.Conflicts {
+		if !strings.Contains(conflict.Reason, conflicts[conflict.Prefix]) {
+			t.Errorf("%s: unexpected conflict '%s'", conflict.Prefix,
+				conflict.Reason)
+		}
+		if merged[conflict.Prefix] != nil {
+			t.Errorf("%s was merged despite a conflict", conflict.Prefix)
+		}
+	}
+}
+
+func TestCheckConflictDoesNotModify(t *testing.T) {
+
+	roa := testLoadFilters(t)
+	vrp := &PrefixROA{Prefix: "10.1.0.0/16", MaxLen: 28, ASN: "AS64512"}
+
+	if reason := roa.checkConflict(vrp, testCIDR(t, vrp.Prefix),
+		nil, nil); reason != "" {
+		t.Fatalf("unexpected conflict: %s", reason)
+	}
+	if vrp.MaxLen != 28 {
+		t.Errorf("max-length was changed to %d", vrp.MaxLen)
+	}
+}
+
+func TestROASourcesHandlerEmpty(t *testing.T) {
+
+	saved, data := ROASources, ROAData
+	defer func() { ROASources, ROAData = saved, data }()
+
+	ROASources = nil
+	ROAData = testLoadFilters(t)
+	ROAData.MergeSources()
+
+	w := httptest.NewRecorder()
+	roaSourcesHandler(w, httptest.NewRequest("GET", "/api/roa/sources", nil))
+
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("expected an empty list, got '%s'", body)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,257 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// route origin validation (RFC 6811) of BGP table dumps
+
+const (
+	ROV_VALID     = "valid"
+	ROV_INVALID   = "invalid"
+	ROV_NOT_FOUND = "not-found"
+)
+
+// the largest dump that can be uploaded
+const ROV_MAX_UPLOAD = 64 * 1024 * 1024
+
+type RouteValidation struct {
+	Prefix string       `json:"prefix"`
+	Origin string       `json:"origin"`
+	State  string       `json:"state"`
+	Reason string       `json:"reason,omitempty"`
+	VRPs   []*PrefixROA `json:"vrps,omitempty"`
+}
+
+type RouteValidationReport struct {
+	Commit         string             `json:"commit"`
+	Routes         uint               `json:"routes"`
+	Valid          uint               `json:"valid"`
+	Invalid        uint               `json:"invalid"`
+	NotFound       uint               `json:"notFound"`
+	Invalids       []*RouteValidation `json:"invalids"`
+	UnknownOrigins []*RouteValidation `json:"unknownOrigins"`
+}
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitROAValidateAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitROAValidateAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/roa/validate", roaValidateHandler).Methods("POST")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// validate an uploaded BGP table dump
+
+func roaValidateHandler(w http.ResponseWriter, r *http.Request) {
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, ROV_MAX_UPLOAD))
+	if err != nil {
+		http.Error(w, "Unable to read table dump: "+err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	routes, err := ParseBGPDump(data)
+	if err != nil {
+		http.Error(w, "Unable to parse table dump: "+err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	report := ROAData.ValidateRoutes(RegistryData, routes)
+	(&Response{
+		Data:    report,
+		Records: append(report.Invalids, report.UnknownOrigins...),
+	}).Write(w, r)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// validate a BGP table dump from the command line
+//
+// the registry is loaded as it is on disk, without pulling or starting
+// the refresh timer, and only the ROA data is compiled
+
+func ValidateRoutesFile(path string, regDir string, gitPath string) {
+
+	report, err := validateRoutesFile(path, regDir, gitPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("Unable to validate table dump")
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+
+	log.WithFields(log.Fields{
+		"routes":    report.Routes,
+		"valid":     report.Valid,
+		"invalid":   report.Invalid,
+		"not-found": report.NotFound,
+	}).Info("Route validation complete")
+}
+
+func validateRoutesFile(path string, regDir string,
+	gitPath string) (*RouteValidationReport, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read table dump: %s", err)
+	}
+
+	routes, err := ParseBGPDump(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse table dump: %s", err)
+	}
+
+	dataPath := regDir + "/data"
+	if info, err := os.Stat(dataPath); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("unable to find registry directory %s", dataPath)
+	}
+
+	registry := LoadRegistry(dataPath, getCommitHash(regDir, gitPath))
+	ROAUpdate(registry, dataPath)
+	if ROAData == nil || ROAData.Commit != registry.Commit {
+		return nil, errors.New("unable to compile the ROA data")
+	}
+
+	return ROAData.ValidateRoutes(registry, routes), nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// validate a list of routes against the ROA data
+
+func (roa *ROA) ValidateRoutes(registry *Registry,
+	routes []*BGPRoute) *RouteValidationReport {
+
+	report := &RouteValidationReport{
+		Commit:         roa.Commit,
+		Invalids:       make([]*RouteValidation, 0),
+		UnknownOrigins: make([]*RouteValidation, 0),
+	}
+
+	// index the VRPs by prefix, so that covering VRPs can be
+	// found by walking up the parents of each route
+	index := make(map[string][]*PrefixROA)
+	for _, list := range [][]*PrefixROA{roa.IPv4, roa.IPv6,
+		roa.AS0IPv4, roa.AS0IPv6} {
+		for _, vrp := range list {
+			index[vrp.Prefix] = append(index[vrp.Prefix], vrp)
+		}
+	}
+
+	autnums := registry.Types["aut-num"]
+
+	for _, route := range routes {
+
+		_, network, err := net.ParseCIDR(route.Prefix)
+		if err != nil {
+			continue
+		}
+
+		result := validateRoute(index, network, route.Origin)
+		report.Routes += 1
+
+		switch result.State {
+		case ROV_VALID:
+			report.Valid += 1
+		case ROV_INVALID:
+			report.Invalid += 1
+			report.Invalids = append(report.Invalids, result)
+		case ROV_NOT_FOUND:
+			report.NotFound += 1
+		}
+
+		if autnums == nil || autnums.Objects[result.Origin] == nil {
+			report.UnknownOrigins = append(report.UnknownOrigins, result)
+		}
+	}
+
+	return report
+}
+
+//////////////////////////////////////////////////////////////////////////
+// validate a single route
+
+func validateRoute(index map[string][]*PrefixROA, network *net.IPNet,
+	origin uint32) *RouteValidation {
+
+	asn := "AS" + strconv.FormatUint(uint64(origin), 10)
+	plen, _ := network.Mask.Size()
+
+	result := &RouteValidation{
+		Prefix: network.String(),
+		Origin: asn,
+		State:  ROV_NOT_FOUND,
+	}
+
+	// find the covering VRPs
+	originMatched := false
+	for parent := network; ; parent = netParent(parent) {
+
+		for _, vrp := range index[parent.String()] {
+			result.VRPs = append(result.VRPs, vrp)
+
+			// AS0 and an origin of NONE can never match
+			if vrp.ASN != asn || origin == 0 {
+				continue
+			}
+
+			originMatched = true
+			if plen <= int(vrp.MaxLen) {
+				result.State = ROV_VALID
+				result.Reason = ""
+				result.VRPs = nil
+				return result
+			}
+		}
+
+		if l, _ := parent.Mask.Size(); l == 0 {
+			break
+		}
+	}
+
+	if len(result.VRPs) != 0 {
+		result.State = ROV_INVALID
+		if originMatched {
+			result.Reason = "prefix length exceeds max-length"
+		} else {
+			result.Reason = "no covering VRP for origin"
+		}
+	}
+
+	return result
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
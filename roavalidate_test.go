@@ -0,0 +1,147 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// helpers
+
+// summarise a validation report
+func testReportString(report *RouteValidationReport) string {
+
+	s := fmt.Sprintf("%d routes, %d valid, %d invalid, %d not found\n",
+		report.Routes, report.Valid, report.Invalid, report.NotFound)
+	for _, invalid := range report.Invalids {
+		s += fmt.Sprintf("invalid %s %s: %s\n", invalid.Prefix, invalid.Origin,
+			invalid.Reason)
+	}
+	for _, unknown := range report.UnknownOrigins {
+		s += fmt.Sprintf("unknown %s %s\n", unknown.Prefix, unknown.Origin)
+	}
+	return s
+}
+
+// the report for the test MRT file against the test registry
+const testMRTReport = "7 routes, 3 valid, 3 invalid, 1 not found\n" +
+	"invalid 172.20.0.0/24 AS4242420002: no covering VRP for origin\n" +
+	"invalid 172.20.1.0/24 AS0: no covering VRP for origin\n" +
+	"invalid 172.20.0.0/30 AS4242420001: prefix length exceeds max-length\n" +
+	"unknown 172.20.1.0/24 AS0\n" +
+	"unknown 10.1.0.0/16 AS64512\n"
+
+//////////////////////////////////////////////////////////////////////////
+
+func TestValidateRoutesFile(t *testing.T) {
+
+	savedROA, savedRegistry := ROAData, RegistryData
+	t.Cleanup(func() { ROAData, RegistryData = savedROA, savedRegistry })
+	ROAData, RegistryData = nil, nil
+
+	report, err := validateRoutesFile(testMRTPath, "testdata/registry", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := testReportString(report); s != testMRTReport {
+		t.Errorf("unexpected report:\n%s\nexpected:\n%s", s, testMRTReport)
+	}
+
+	// the registry is not installed for the API
+	if RegistryData != nil {
+		t.Error("the registry data was updated")
+	}
+
+	// errors are returned, rather than exiting
+	for _, test := range []struct {
+		path   string
+		regDir string
+		err    string
+	}{
+		{"testdata/mrt/missing.mrt", "testdata/registry", "unable to read table dump"},
+		{testMRTPath, "testdata/missing", "unable to find registry directory"},
+	} {
+		_, err := validateRoutesFile(test.path, test.regDir, "git")
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s %s: unexpected error %v", test.path, test.regDir, err)
+		}
+	}
+
+	// a truncated dump can't be parsed
+	data, err := ioutil.ReadFile(testMRTPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := t.TempDir() + "/truncated.mrt"
+	if err := ioutil.WriteFile(truncated, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = validateRoutesFile(truncated, "testdata/registry", "git")
+	if err == nil || !strings.Contains(err.Error(), "truncated MRT record") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestROAValidateAPI(t *testing.T) {
+
+	savedROA := ROAData
+	t.Cleanup(func() { ROAData = savedROA })
+
+	registry := testLoadRegistry(t)
+	ROAUpdate(registry, testRegistryPath)
+
+	savedRegistry := RegistryData
+	RegistryData = registry
+	t.Cleanup(func() { RegistryData = savedRegistry })
+
+	router := mux.NewRouter()
+	InitROAValidateAPI(router)
+
+	data, err := ioutil.ReadFile(testMRTPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(url string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", url, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("/roa/validate", data)
+	report := &RouteValidationReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), report); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if s := testReportString(report); s != testMRTReport {
+		t.Errorf("unexpected report:\n%s\nexpected:\n%s", s, testMRTReport)
+	}
+
+	// the invalid routes and unknown origins are streamed as NDJSON
+	w = post("/roa/validate?format=ndjson", data)
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 5 {
+		t.Errorf("unexpected NDJSON %s", w.Body.String())
+	}
+
+	w = post("/roa/validate", data[:len(data)-1])
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("truncated dump: status %d", w.Code)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
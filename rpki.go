@@ -0,0 +1,927 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// RPKI repository publisher
+//
+// Publishes the ROA data as real RPKI objects, so that the dn42 ROAs
+// can be consumed by standard relying party software (routinator,
+// rpki-client, fort) using a local trust anchor.
+//
+// The output directory contains:
+//
+// ta.key         the trust anchor private key, keep this private
+// dn42.tal       the trust anchor locator
+// ta/ta.cer      the self-signed trust anchor resource certificate
+// repo/          the publication point, containing
+//   AS<n>.roa    a ROA for each origin ASN
+//   dn42.crl     the CRL
+//   dn42.mft     the manifest
+//
+// The ta/ and repo/ directories should be published at the URI given
+// by --RPKIURI, e.g. using rsyncd.
+//
+// ROAs are only re-issued when their content changes or their EE
+// certificate nears expiry, the manifest and CRL are re-issued on
+// each update and periodically.
+
+const (
+	RPKI_TA_VALIDITY       = 10 * 365 * 24 * time.Hour
+	RPKI_ROA_VALIDITY      = 7 * 24 * time.Hour
+	RPKI_MANIFEST_VALIDITY = 24 * time.Hour
+	RPKI_REFRESH_INTERVAL  = 6 * time.Hour
+	RPKI_KEY_BITS          = 2048
+)
+
+// a published object
+type rpkiObject struct {
+	content []byte
+	data    []byte
+	cert    *x509.Certificate
+}
+
+// a revoked EE certificate, kept on the CRL until it expires
+type rpkiRevoked struct {
+	serial  *big.Int
+	revoked time.Time
+	expires time.Time
+}
+
+type RPKIPublisher struct {
+	Dir     string
+	BaseURI string
+
+	mutex    sync.Mutex
+	taKey    *rsa.PrivateKey
+	taCert   *x509.Certificate
+	tal      []byte
+	objects  map[string]*rpkiObject
+	manifest *rpkiObject
+	revoked  []*rpkiRevoked
+	number   int64
+	pending  *ROA
+	trigger  chan bool
+}
+
+// the publisher, nil if publishing is disabled
+var RPKI *RPKIPublisher
+
+//////////////////////////////////////////////////////////////////////////
+// register the api
+
+func init() {
+	EventBus.Listen("APIEndpoint", InitRPKIAPI)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the publisher
+
+func InitialiseRPKI(dir string, baseURI string) {
+
+	// an empty directory disables publishing
+	if dir == "" {
+		return
+	}
+
+	if !strings.HasSuffix(baseURI, "/") {
+		baseURI += "/"
+	}
+
+	p := &RPKIPublisher{
+		Dir:     dir,
+		BaseURI: baseURI,
+		objects: make(map[string]*rpkiObject),
+		trigger: make(chan bool, 1),
+	}
+
+	if err := p.initialise(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"dir":   dir,
+		}).Fatal("Unable to initialise RPKI repository")
+	}
+
+	RPKI = p
+	go p.run()
+
+	log.WithFields(log.Fields{
+		"dir": dir,
+		"uri": baseURI,
+	}).Info("RPKI publishing enabled")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called from main to initialise the API routing
+
+func InitRPKIAPI(params ...interface{}) {
+
+	router := params[0].(*mux.Router)
+
+	router.HandleFunc("/roa/rpki/tal", rpkiTALHandler).Methods("GET")
+
+}
+
+//////////////////////////////////////////////////////////////////////////
+// return the trust anchor locator
+
+func rpkiTALHandler(w http.ResponseWriter, r *http.Request) {
+
+	if RPKI == nil {
+		http.Error(w, "RPKI publishing is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(RPKI.tal)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// called at the end of each ROAUpdate to request new objects
+
+func RPKIUpdate(roa *ROA) {
+
+	if RPKI == nil {
+		return
+	}
+
+	RPKI.mutex.Lock()
+	RPKI.pending = roa
+	RPKI.mutex.Unlock()
+
+	// signal the publisher, without blocking if a run is already queued
+	select {
+	case RPKI.trigger <- true:
+	default:
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// publisher main loop
+
+func (p *RPKIPublisher) run() {
+
+	ticker := time.NewTicker(RPKI_REFRESH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.trigger:
+		case <-ticker.C:
+		}
+
+		p.mutex.Lock()
+		roa := p.pending
+		p.mutex.Unlock()
+
+		if roa == nil {
+			continue
+		}
+
+		if err := p.publish(roa); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"dir":   p.Dir,
+			}).Error("Failed to publish RPKI repository")
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// URIs and paths for the published objects
+
+func (p *RPKIPublisher) taURI() string {
+	return p.BaseURI + "ta/ta.cer"
+}
+
+func (p *RPKIPublisher) repoURI() string {
+	return p.BaseURI + "repo/"
+}
+
+func (p *RPKIPublisher) repoPath(name string) string {
+	return filepath.Join(p.Dir, "repo", name)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// load or create the trust anchor, and load any existing objects
+
+func (p *RPKIPublisher) initialise() error {
+
+	for _, d := range []string{"ta", "repo"} {
+		if err := os.MkdirAll(filepath.Join(p.Dir, d), 0755); err != nil {
+			return err
+		}
+	}
+
+	key, err := p.loadTAKey()
+	if err != nil {
+		return err
+	}
+	p.taKey = key
+
+	// the TA certificate is re-issued at startup, the TAL only depends
+	// on the key so remains stable
+	cert, err := p.issueTA()
+	if err != nil {
+		return err
+	}
+	p.taCert = cert
+
+	if err := writeFileAtomic(filepath.Join(p.Dir, "ta", "ta.cer"),
+		cert.Raw); err != nil {
+		return err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	p.tal = []byte(p.taURI() + "\n\n" + wrapBase64(spki))
+	if err := writeFileAtomic(filepath.Join(p.Dir, "dn42.tal"),
+		p.tal); err != nil {
+		return err
+	}
+
+	p.loadExisting()
+	return nil
+}
+
+// load the TA key, creating a new key if it doesn't exist
+func (p *RPKIPublisher) loadTAKey() (*rsa.PrivateKey, error) {
+
+	path := filepath.Join(p.Dir, "ta.key")
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "RSA PRIVATE KEY" {
+			return nil, errors.New("no RSA private key found in " + path)
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Info("Creating new RPKI trust anchor key")
+
+	key, err := rsa.GenerateKey(rand.Reader, RPKI_KEY_BITS)
+	if err != nil {
+		return nil, err
+	}
+
+	data = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// load objects from a previous run, so that they need not be re-issued
+func (p *RPKIPublisher) loadExisting() {
+
+	// revocations are recovered from the existing CRL
+	if data, err := ioutil.ReadFile(p.repoPath("dn42.crl")); err == nil {
+		if crl, err := x509.ParseRevocationList(data); err == nil &&
+			crl.CheckSignatureFrom(p.taCert) == nil {
+			for _, rc := range crl.RevokedCertificates {
+				p.revoked = append(p.revoked, &rpkiRevoked{
+					serial:  rc.SerialNumber,
+					revoked: rc.RevocationTime,
+					expires: rc.RevocationTime.Add(RPKI_ROA_VALIDITY),
+				})
+			}
+			if crl.Number != nil {
+				p.number = crl.Number.Int64()
+			}
+		}
+	}
+
+	// the previous manifest, so that it can be revoked
+	if data, err := ioutil.ReadFile(p.repoPath("dn42.mft")); err == nil {
+		if cert, ctype, content, err := verifyCMS(data,
+			p.taCert); err == nil && ctype.Equal(oidRPKIManifest) {
+			p.manifest = &rpkiObject{content: content, data: data, cert: cert}
+		}
+	}
+
+	files, _ := filepath.Glob(p.repoPath("*.roa"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		cert, ctype, content, err := verifyCMS(data, p.taCert)
+		if err == nil && (len(cert.CRLDistributionPoints) != 1 ||
+			cert.CRLDistributionPoints[0] != p.repoURI()+"dn42.crl") {
+			err = errors.New("repository URI has changed")
+		}
+		if err != nil || !ctype.Equal(oidRouteOriginAuthz) {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"error": err,
+			}).Debug("Ignoring existing RPKI object")
+			continue
+		}
+		p.objects[filepath.Base(file)] = &rpkiObject{
+			content: content,
+			data:    data,
+			cert:    cert,
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"roas":    len(p.objects),
+		"revoked": len(p.revoked),
+	}).Debug("Loaded existing RPKI objects")
+}
+
+//////////////////////////////////////////////////////////////////////////
+// publish the ROA data
+
+func (p *RPKIPublisher) publish(roa *ROA) error {
+
+	now := time.Now().UTC().Truncate(time.Second)
+	renew := now.Add(RPKI_ROA_VALIDITY / 2)
+
+	// group the VRPs by origin
+	origins := make(map[string][]*PrefixROA)
+	for _, list := range [][]*PrefixROA{
+		roa.IPv4, roa.IPv6, roa.AS0IPv4, roa.AS0IPv6} {
+		for _, vrp := range list {
+			origins[vrp.ASN] = append(origins[vrp.ASN], vrp)
+		}
+	}
+
+	issued := 0
+	current := make(map[string]*rpkiObject)
+
+	for asn, vrps := range origins {
+
+		name := asn + ".roa"
+		content, ipv4, ipv6, err := encodeROAContent(asn, vrps)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"asn":   asn,
+				"error": err,
+			}).Warn("Unable to encode RPKI ROA")
+			continue
+		}
+
+		// re-use the existing object if nothing has changed
+		if obj := p.objects[name]; obj != nil &&
+			bytes.Equal(obj.content, content) && obj.cert.NotAfter.After(renew) {
+			current[name] = obj
+			continue
+		}
+
+		ipext := ipAddrBlocksExtension(ipv4, ipv6, false)
+		cert, key, err := p.issueEE(p.repoURI()+name,
+			now.Add(RPKI_ROA_VALIDITY), ipext)
+		if err != nil {
+			return err
+		}
+
+		data, err := signCMS(oidRouteOriginAuthz, content, cert, key)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFileAtomic(p.repoPath(name), data); err != nil {
+			return err
+		}
+
+		current[name] = &rpkiObject{content: content, data: data, cert: cert}
+		issued += 1
+	}
+
+	// revoke replaced and withdrawn objects
+	for name, obj := range p.objects {
+		if !strings.HasSuffix(name, ".roa") {
+			continue
+		}
+		if current[name] != obj {
+			p.revoke(obj.cert, now)
+		}
+		if current[name] == nil {
+			if err := os.Remove(p.repoPath(name)); err != nil &&
+				!os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	p.objects = current
+
+	// remove any ROA files that are no longer known
+	files, _ := filepath.Glob(p.repoPath("*.roa"))
+	for _, file := range files {
+		if current[filepath.Base(file)] == nil {
+			os.Remove(file)
+		}
+	}
+
+	// the previous manifest is also revoked
+	if p.manifest != nil {
+		p.revoke(p.manifest.cert, now)
+	}
+
+	// serial numbers for the CRL and manifest must always increase
+	p.number += 1
+	if now.Unix() > p.number {
+		p.number = now.Unix()
+	}
+	number := big.NewInt(p.number)
+	next := now.Add(RPKI_MANIFEST_VALIDITY)
+
+	crl, err := p.issueCRL(number, now, next)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.repoPath("dn42.crl"), crl); err != nil {
+		return err
+	}
+
+	mft, err := p.issueManifest(number, now, next, crl)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.repoPath("dn42.mft"), mft.data); err != nil {
+		return err
+	}
+	p.manifest = mft
+
+	log.WithFields(log.Fields{
+		"roas":    len(current),
+		"issued":  issued,
+		"revoked": len(p.revoked),
+		"number":  p.number,
+	}).Info("Published RPKI repository")
+
+	return nil
+}
+
+// add a certificate to the CRL
+func (p *RPKIPublisher) revoke(cert *x509.Certificate, now time.Time) {
+	p.revoked = append(p.revoked, &rpkiRevoked{
+		serial:  cert.SerialNumber,
+		revoked: now,
+		expires: cert.NotAfter,
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// build the ROA content for an origin
+//
+// returns the DER encoded content and the prefixes for the EE certificate
+
+func encodeROAContent(asn string,
+	vrps []*PrefixROA) ([]byte, []*net.IPNet, []*net.IPNet, error) {
+
+	asid, err := strconv.ParseUint(strings.TrimPrefix(asn, "AS"), 10, 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// duplicate prefixes keep the longest max-length
+	type entry struct {
+		network *net.IPNet
+		maxlen  int
+	}
+	entries := make(map[string]*entry)
+	for _, vrp := range vrps {
+		_, network, err := net.ParseCIDR(vrp.Prefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if e := entries[network.String()]; e != nil {
+			if int(vrp.MaxLen) > e.maxlen {
+				e.maxlen = int(vrp.MaxLen)
+			}
+			continue
+		}
+		entries[network.String()] = &entry{network, int(vrp.MaxLen)}
+	}
+
+	var ipv4, ipv6 []*net.IPNet
+	var addr4, addr6 []roaIPAddress
+
+	// sorted by address, then prefix length
+	sorted := make([]*entry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		c := bytes.Compare(sorted[i].network.IP.To16(),
+			sorted[j].network.IP.To16())
+		if c != 0 {
+			return c < 0
+		}
+		pi, _ := sorted[i].network.Mask.Size()
+		pj, _ := sorted[j].network.Mask.Size()
+		return pi < pj
+	})
+
+	for _, e := range sorted {
+		address := roaIPAddress{Address: prefixBitString(e.network)}
+
+		// max-length is only included where it differs
+		if plen, _ := e.network.Mask.Size(); e.maxlen > plen {
+			address.MaxLength = e.maxlen
+		}
+
+		if e.network.IP.To4() != nil {
+			ipv4 = append(ipv4, e.network)
+			addr4 = append(addr4, address)
+		} else {
+			ipv6 = append(ipv6, e.network)
+			addr6 = append(addr6, address)
+		}
+	}
+
+	content := roaContent{ASID: int64(asid)}
+	if len(addr4) != 0 {
+		content.IPAddrBlocks = append(content.IPAddrBlocks,
+			roaIPAddressFamily{AddressFamily: afiIPv4, Addresses: addr4})
+	}
+	if len(addr6) != 0 {
+		content.IPAddrBlocks = append(content.IPAddrBlocks,
+			roaIPAddressFamily{AddressFamily: afiIPv6, Addresses: addr6})
+	}
+
+	data, err := asn1.Marshal(content)
+	return data, ipv4, ipv6, err
+}
+
+//////////////////////////////////////////////////////////////////////////
+// certificate issuing
+
+// return a subject key identifier for a public key
+func rpkiKeyID(key *rsa.PublicKey) []byte {
+	id := sha1.Sum(x509.MarshalPKCS1PublicKey(key))
+	return id[:]
+}
+
+// return a random serial number
+func rpkiSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+}
+
+// issue the self-signed trust anchor certificate, covering all resources
+func (p *RPKIPublisher) issueTA() (*x509.Certificate, error) {
+
+	serial, err := rpkiSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	_, all4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, all6, _ := net.ParseCIDR("::/0")
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dn42 Trust Anchor"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(RPKI_TA_VALIDITY),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          rpkiKeyID(&p.taKey.PublicKey),
+		ExtraExtensions: []pkix.Extension{
+			rpkiPolicyExtension(),
+			siaExtension(
+				[]asn1.ObjectIdentifier{oidADCARepository, oidADRPKIManifest},
+				[]string{p.repoURI(), p.repoURI() + "dn42.mft"},
+			),
+			ipAddrBlocksExtension([]*net.IPNet{all4}, []*net.IPNet{all6}, false),
+			asIdentifiersExtension(false),
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&p.taKey.PublicKey, p.taKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// issue a single use EE certificate for a signed object
+func (p *RPKIPublisher) issueEE(uri string, notAfter time.Time,
+	resources ...pkix.Extension) (*x509.Certificate, *rsa.PrivateKey, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, RPKI_KEY_BITS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rpkiSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ski := rpkiKeyID(&key.PublicKey)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hex.EncodeToString(ski)},
+		NotBefore:             time.Now().UTC().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		SubjectKeyId:          ski,
+		IssuingCertificateURL: []string{p.taURI()},
+		CRLDistributionPoints: []string{p.repoURI() + "dn42.crl"},
+		ExtraExtensions: append([]pkix.Extension{
+			rpkiPolicyExtension(),
+			siaExtension(
+				[]asn1.ObjectIdentifier{oidADSignedObject},
+				[]string{uri},
+			),
+		}, resources...),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, p.taCert,
+		&key.PublicKey, p.taKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// issue the CRL, expired revocations are dropped
+func (p *RPKIPublisher) issueCRL(number *big.Int,
+	now time.Time, next time.Time) ([]byte, error) {
+
+	revoked := make([]*rpkiRevoked, 0, len(p.revoked))
+	entries := make([]pkix.RevokedCertificate, 0, len(p.revoked))
+	for _, r := range p.revoked {
+		if r.expires.After(now) {
+			revoked = append(revoked, r)
+			entries = append(entries, pkix.RevokedCertificate{
+				SerialNumber:   r.serial,
+				RevocationTime: r.revoked,
+			})
+		}
+	}
+	p.revoked = revoked
+
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              number,
+		ThisUpdate:          now,
+		NextUpdate:          next,
+		RevokedCertificates: entries,
+	}, p.taCert, p.taKey)
+}
+
+// issue the manifest, listing the CRL and all ROAs
+func (p *RPKIPublisher) issueManifest(number *big.Int, now time.Time,
+	next time.Time, crl []byte) (*rpkiObject, error) {
+
+	hash := func(name string, data []byte) mftFileAndHash {
+		h := sha256.Sum256(data)
+		return mftFileAndHash{
+			File: name,
+			Hash: asn1.BitString{Bytes: h[:], BitLength: len(h) * 8},
+		}
+	}
+
+	names := make([]string, 0, len(p.objects))
+	for name := range p.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := []mftFileAndHash{hash("dn42.crl", crl)}
+	for _, name := range names {
+		files = append(files, hash(name, p.objects[name].data))
+	}
+
+	content, err := asn1.Marshal(mftContent{
+		ManifestNumber: number,
+		ThisUpdate:     now,
+		NextUpdate:     next,
+		FileHashAlg:    oidSHA256,
+		FileList:       files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// manifest EE certificates inherit their resources
+	cert, key, err := p.issueEE(p.repoURI()+"dn42.mft", next,
+		ipAddrBlocksExtension(nil, nil, true), asIdentifiersExtension(true))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := signCMS(oidRPKIManifest, content, cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rpkiObject{content: content, data: data, cert: cert}, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// verify a published repository and exit, called from main
+
+func VerifyRPKIRepository(dir string) {
+
+	roas, vrps, err := verifyRPKIRepository(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "RPKI verification FAILED: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("RPKI repository OK: %d ROAs, %d VRPs\n", roas, vrps)
+}
+
+func verifyRPKIRepository(dir string) (int, int, error) {
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ta", "ta.cer"))
+	if err != nil {
+		return 0, 0, err
+	}
+	ta, err := x509.ParseCertificate(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := ta.CheckSignatureFrom(ta); err != nil {
+		return 0, 0, fmt.Errorf("ta.cer: %s", err)
+	}
+
+	// the TAL must match the TA key
+	if tal, err := ioutil.ReadFile(filepath.Join(dir, "dn42.tal")); err == nil {
+		spki, _ := x509.MarshalPKIXPublicKey(ta.PublicKey)
+		if !strings.HasSuffix(string(tal), wrapBase64(spki)) {
+			return 0, 0, errors.New("dn42.tal does not match ta.cer")
+		}
+	}
+
+	repo := filepath.Join(dir, "repo")
+
+	// CRL
+	data, err = ioutil.ReadFile(filepath.Join(repo, "dn42.crl"))
+	if err != nil {
+		return 0, 0, err
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("dn42.crl: %s", err)
+	}
+	if err := crl.CheckSignatureFrom(ta); err != nil {
+		return 0, 0, fmt.Errorf("dn42.crl: %s", err)
+	}
+	if time.Now().After(crl.NextUpdate) {
+		return 0, 0, errors.New("dn42.crl: CRL is stale")
+	}
+	revoked := make(map[string]bool)
+	for _, rc := range crl.RevokedCertificates {
+		revoked[rc.SerialNumber.String()] = true
+	}
+
+	// helper closure to check a signed object
+	check := func(name string,
+		ctype asn1.ObjectIdentifier) ([]byte, []byte, error) {
+		data, err := ioutil.ReadFile(filepath.Join(repo, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, t, content, err := verifyCMS(data, ta)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if !t.Equal(ctype) {
+			return nil, nil, fmt.Errorf("%s: unexpected content type", name)
+		}
+		if revoked[cert.SerialNumber.String()] {
+			return nil, nil, fmt.Errorf("%s: EE certificate is revoked", name)
+		}
+		return data, content, nil
+	}
+
+	// manifest
+	_, content, err := check("dn42.mft", oidRPKIManifest)
+	if err != nil {
+		return 0, 0, err
+	}
+	var mft mftContent
+	if _, err := asn1.Unmarshal(content, &mft); err != nil {
+		return 0, 0, fmt.Errorf("dn42.mft: %s", err)
+	}
+	if time.Now().After(mft.NextUpdate) {
+		return 0, 0, errors.New("dn42.mft: manifest is stale")
+	}
+
+	listed := make(map[string]bool)
+	roas, vrps := 0, 0
+	for _, f := range mft.FileList {
+		listed[f.File] = true
+
+		var data []byte
+		if strings.HasSuffix(f.File, ".roa") {
+			var content []byte
+			data, content, err = check(f.File, oidRouteOriginAuthz)
+			if err != nil {
+				return 0, 0, err
+			}
+			var roa roaContent
+			if _, err := asn1.Unmarshal(content, &roa); err != nil {
+				return 0, 0, fmt.Errorf("%s: %s", f.File, err)
+			}
+			roas += 1
+			for _, family := range roa.IPAddrBlocks {
+				vrps += len(family.Addresses)
+			}
+		} else {
+			data, err = ioutil.ReadFile(filepath.Join(repo, f.File))
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		h := sha256.Sum256(data)
+		if !bytes.Equal(h[:], f.Hash.Bytes) {
+			return 0, 0, fmt.Errorf("%s: hash does not match manifest", f.File)
+		}
+	}
+
+	// and no unlisted objects
+	files, _ := filepath.Glob(filepath.Join(repo, "*.roa"))
+	for _, file := range files {
+		if !listed[filepath.Base(file)] {
+			return 0, 0, fmt.Errorf("%s: not listed on manifest",
+				filepath.Base(file))
+		}
+	}
+
+	return roas, vrps, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// helper funcs
+
+// base64 encode, wrapped at 64 characters
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n")
+	return b.String()
+}
+
+// write a file so that readers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,377 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+
+// return an initialised publisher in a temporary directory
+func testRPKIPublisher(t *testing.T, dir string) *RPKIPublisher {
+
+	p := &RPKIPublisher{
+		Dir:     dir,
+		BaseURI: "rsync://rpki.example.dn42/repo/",
+		objects: make(map[string]*rpkiObject),
+		trigger: make(chan bool, 1),
+	}
+	if err := p.initialise(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testRPKIDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rpki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// return ROA data with a route for each prefix and origin pair
+func testRPKIROA(routes ...string) *ROA {
+	roa := &ROA{}
+	for ix := 0; ix < len(routes); ix += 2 {
+		vrp := &PrefixROA{Prefix: routes[ix], ASN: routes[ix+1]}
+		plen := strings.SplitN(routes[ix], "/", 2)[1]
+		fmt.Sscan(plen, &vrp.MaxLen)
+		if strings.Contains(routes[ix], ":") {
+			roa.IPv6 = append(roa.IPv6, vrp)
+		} else {
+			roa.IPv4 = append(roa.IPv4, vrp)
+		}
+	}
+	return roa
+}
+
+func TestPrefixBitString(t *testing.T) {
+
+	tests := []struct {
+		prefix string
+		bytes  []byte
+		length int
+	}{
+		// examples from RFC 3779, section 2.1.1
+		{"10.0.0.0/8", []byte{0x0a}, 8},
+		{"10.64.0.0/12", []byte{0x0a, 0x40}, 12},
+		{"10.64.0.0/20", []byte{0x0a, 0x40, 0x00}, 20},
+		{"0.0.0.0/0", []byte{}, 0},
+		{"172.20.1.160/27", []byte{0xac, 0x14, 0x01, 0xa0}, 27},
+		{"fd42:1::/48", []byte{0xfd, 0x42, 0x00, 0x01, 0x00, 0x00}, 48},
+	}
+
+	for _, test := range tests {
+		bs := prefixBitString(testCIDR(t, test.prefix))
+		if !bytes.Equal(bs.Bytes, test.bytes) || bs.BitLength != test.length {
+			t.Errorf("%s: got %x/%d, expected %x/%d", test.prefix,
+				bs.Bytes, bs.BitLength, test.bytes, test.length)
+		}
+	}
+}
+
+func TestEncodeROAContent(t *testing.T) {
+
+	vrps := []*PrefixROA{
+		{Prefix: "fd42:1::/48", MaxLen: 64},
+		{Prefix: "172.20.1.0/24", MaxLen: 24},
+		{Prefix: "172.20.0.0/24", MaxLen: 26},
+		// a duplicate keeps the longest max-length
+		{Prefix: "172.20.0.0/24", MaxLen: 28},
+		{Prefix: "172.20.0.0/16", MaxLen: 16},
+	}
+
+	data, ipv4, ipv6, err := encodeROAContent("AS4242420001", vrps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipv4) != 3 || len(ipv6) != 1 {
+		t.Errorf("got %d ipv4 and %d ipv6 prefixes", len(ipv4), len(ipv6))
+	}
+
+	var content roaContent
+	if rest, err := asn1.Unmarshal(data, &content); err != nil || len(rest) != 0 {
+		t.Fatalf("unable to decode ROA content: %v", err)
+	}
+	if content.ASID != 4242420001 || len(content.IPAddrBlocks) != 2 {
+		t.Fatalf("unexpected content: %+v", content)
+	}
+
+	// sorted by address then length, max-length only where it differs
+	expected := []string{
+		"0001 ac14/16 0",
+		"0001 ac1400/24 28",
+		"0001 ac1401/24 0",
+		"0002 fd4200010000/48 64",
+	}
+	got := []string{}
+	for _, family := range content.IPAddrBlocks {
+		for _, address := range family.Addresses {
+			got = append(got, fmt.Sprintf("%x %x/%d %d", family.AddressFamily,
+				address.Address.Bytes, address.Address.BitLength,
+				address.MaxLength))
+		}
+	}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	// invalid input
+	for _, test := range []struct {
+		asn    string
+		prefix string
+	}{
+		{"ASX", "172.20.0.0/24"},
+		{"AS4242420001", "172.20.0.0"},
+	} {
+		if _, _, _, err := encodeROAContent(test.asn,
+			[]*PrefixROA{{Prefix: test.prefix}}); err == nil {
+			t.Errorf("%s %s: expected an error", test.asn, test.prefix)
+		}
+	}
+}
+
+func TestSignVerifyCMS(t *testing.T) {
+
+	p := testRPKIPublisher(t, testRPKIDir(t))
+	other := testRPKIPublisher(t, testRPKIDir(t))
+
+	content := []byte("signed object content")
+	cert, key, err := p.issueEE(p.repoURI()+"test.roa",
+		time.Now().Add(time.Hour), asIdentifiersExtension(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := signCMS(oidRouteOriginAuthz, content, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with the encapsulated content
+	ix := bytes.Index(data, content)
+	if ix < 0 {
+		t.Fatal("content not found in signed object")
+	}
+	tampered := append([]byte{}, data...)
+	tampered[ix] ^= 0xff
+
+	tests := []struct {
+		name   string
+		data   []byte
+		issuer *x509.Certificate
+		err    string
+	}{
+		{"valid", data, p.taCert, ""},
+		{"tampered", tampered, p.taCert, "message digest"},
+		{"wrong issuer", data, other.taCert, "verification error"},
+		{"truncated", data[:len(data)/2], p.taCert, "syntax error"},
+	}
+
+	for _, test := range tests {
+		ecert, ctype, econtent, err := verifyCMS(test.data, test.issuer)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !ctype.Equal(oidRouteOriginAuthz) || !bytes.Equal(econtent, content) ||
+			!ecert.Equal(cert) {
+			t.Errorf("%s: unexpected result %v %q", test.name, ctype, econtent)
+		}
+	}
+}
+
+func TestRPKIPublish(t *testing.T) {
+
+	dir := testRPKIDir(t)
+	p := testRPKIPublisher(t, dir)
+
+	roa := testRPKIROA(
+		"172.20.0.0/24", "AS4242420001",
+		"fd42:1::/48", "AS4242420001",
+		"172.20.1.0/24", "AS4242420002",
+		"10.0.0.0/8", "AS0",
+	)
+	if err := p.publish(roa); err != nil {
+		t.Fatal(err)
+	}
+
+	roas, vrps, err := verifyRPKIRepository(dir)
+	if err != nil || roas != 3 || vrps != 4 {
+		t.Fatalf("got %d ROAs, %d VRPs, error %v", roas, vrps, err)
+	}
+
+	// the manifest lists the CRL and every ROA
+	_, _, content, err := verifyCMS(p.manifest.data, p.taCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mft mftContent
+	if _, err := asn1.Unmarshal(content, &mft); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, f := range mft.FileList {
+		files = append(files, f.File)
+	}
+	if strings.Join(files, " ") != "dn42.crl AS0.roa AS4242420001.roa AS4242420002.roa" {
+		t.Errorf("unexpected manifest files: %v", files)
+	}
+
+	// change one origin and withdraw another
+	unchanged := p.objects["AS4242420001.roa"]
+	replaced := p.objects["AS4242420002.roa"]
+	withdrawn := p.objects["AS0.roa"]
+	manifest := p.manifest
+
+	roa = testRPKIROA(
+		"172.20.0.0/24", "AS4242420001",
+		"fd42:1::/48", "AS4242420001",
+		"172.20.2.0/24", "AS4242420002",
+	)
+	if err := p.publish(roa); err != nil {
+		t.Fatal(err)
+	}
+	if roas, vrps, err := verifyRPKIRepository(dir); err != nil ||
+		roas != 2 || vrps != 3 {
+		t.Fatalf("got %d ROAs, %d VRPs, error %v", roas, vrps, err)
+	}
+
+	if p.objects["AS4242420001.roa"] != unchanged {
+		t.Error("unchanged ROA was re-issued")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "repo", "AS0.roa")); !os.IsNotExist(err) {
+		t.Error("withdrawn ROA was not removed")
+	}
+
+	// the replaced objects are on the CRL
+	data, err := ioutil.ReadFile(filepath.Join(dir, "repo", "dn42.crl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := make(map[string]bool)
+	for _, rc := range crl.RevokedCertificates {
+		revoked[rc.SerialNumber.String()] = true
+	}
+	for name, obj := range map[string]*rpkiObject{
+		"replaced": replaced, "withdrawn": withdrawn, "manifest": manifest,
+	} {
+		if !revoked[obj.cert.SerialNumber.String()] {
+			t.Errorf("%s object was not revoked", name)
+		}
+	}
+	if revoked[unchanged.cert.SerialNumber.String()] {
+		t.Error("unchanged object was revoked")
+	}
+
+	// a restarted publisher re-uses the existing objects and revocations
+	number := p.number
+	restarted := testRPKIPublisher(t, dir)
+	if len(restarted.revoked) != len(p.revoked) || restarted.number != number {
+		t.Errorf("got %d revoked and number %d, expected %d and %d",
+			len(restarted.revoked), restarted.number, len(p.revoked), number)
+	}
+	if err := restarted.publish(roa); err != nil {
+		t.Fatal(err)
+	}
+	for name, obj := range restarted.objects {
+		if !bytes.Equal(obj.data, p.objects[name].data) {
+			t.Errorf("%s was re-issued after restart", name)
+		}
+	}
+	if restarted.number <= number {
+		t.Errorf("manifest number did not increase: %d", restarted.number)
+	}
+	if _, _, err := verifyRPKIRepository(dir); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyRPKIRepository(t *testing.T) {
+
+	// each test damages a freshly published repository
+	tests := []struct {
+		name   string
+		damage func(dir string) error
+		err    string
+	}{
+		{"unlisted ROA", func(dir string) error {
+			data, err := ioutil.ReadFile(filepath.Join(dir, "repo", "AS4242420001.roa"))
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, "repo", "AS1.roa"), data, 0644)
+		}, "not listed on manifest"},
+		{"replaced ROA", func(dir string) error {
+			// a validly signed object, but not the one on the manifest
+			return os.Rename(filepath.Join(dir, "repo", "AS4242420002.roa"),
+				filepath.Join(dir, "repo", "AS4242420001.roa"))
+		}, "hash does not match manifest"},
+		{"damaged CRL", func(dir string) error {
+			path := filepath.Join(dir, "repo", "dn42.crl")
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			data[len(data)-1] ^= 0xff
+			return ioutil.WriteFile(path, data, 0644)
+		}, "dn42.crl"},
+		{"missing manifest", func(dir string) error {
+			return os.Remove(filepath.Join(dir, "repo", "dn42.mft"))
+		}, "no such file"},
+		{"different TAL", func(dir string) error {
+			return ioutil.WriteFile(filepath.Join(dir, "dn42.tal"),
+				[]byte("rsync://other/ta.cer\n\nAAAA\n"), 0644)
+		}, "does not match"},
+	}
+
+	roa := testRPKIROA(
+		"172.20.0.0/24", "AS4242420001",
+		"172.20.1.0/24", "AS4242420002",
+	)
+
+	for _, test := range tests {
+		dir := testRPKIDir(t)
+		if err := testRPKIPublisher(t, dir).publish(roa); err != nil {
+			t.Fatal(err)
+		}
+		if err := test.damage(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err := verifyRPKIRepository(dir)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code
@@ -0,0 +1,553 @@
+//////////////////////////////////////////////////////////////////////////
+// DN42 Registry API Server
+//////////////////////////////////////////////////////////////////////////
+
+package main
+
+//////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// ASN.1 encoding of RPKI signed objects
+//
+// RPKI signed objects are CMS SignedData (RFC 5652) with the profile
+// defined in RFC 6488, containing either a ROA (RFC 9582) or a
+// manifest (RFC 9286). Certificates carry the RFC 3779 IP address and
+// AS number extensions.
+
+var (
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRouteOriginAuthz  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 24}
+	oidRPKIManifest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 26}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidIPAddrBlocks      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 7}
+	oidASIdentifiers     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 8}
+	oidSubjectInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 11}
+	oidCertPolicies      = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidRPKIPolicy        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 14, 2}
+	oidADCARepository    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 5}
+	oidADRPKIManifest    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 10}
+	oidADSignedObject    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 11}
+)
+
+// address family identifiers
+var (
+	afiIPv4 = []byte{0, 1}
+	afiIPv6 = []byte{0, 2}
+)
+
+//////////////////////////////////////////////////////////////////////////
+// CMS structures
+
+// the explicit and implicit tags are built by hand in raw values,
+// as encoding/asn1 does not apply tags to a RawValue
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+//////////////////////////////////////////////////////////////////////////
+// ROA and manifest content
+
+type roaIPAddress struct {
+	Address   asn1.BitString
+	MaxLength int `asn1:"optional"`
+}
+
+type roaIPAddressFamily struct {
+	AddressFamily []byte
+	Addresses     []roaIPAddress
+}
+
+type roaContent struct {
+	ASID         int64
+	IPAddrBlocks []roaIPAddressFamily
+}
+
+type mftFileAndHash struct {
+	File string `asn1:"ia5"`
+	Hash asn1.BitString
+}
+
+type mftContent struct {
+	ManifestNumber *big.Int
+	ThisUpdate     time.Time `asn1:"generalized"`
+	NextUpdate     time.Time `asn1:"generalized"`
+	FileHashAlg    asn1.ObjectIdentifier
+	FileList       []mftFileAndHash
+}
+
+//////////////////////////////////////////////////////////////////////////
+// helper funcs for building raw ASN.1 values
+
+// return a raw SEQUENCE containing pre-encoded elements
+func asn1Sequence(elements ...[]byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      bytes.Join(elements, nil),
+	}
+}
+
+// return a DER SET containing pre-encoded elements, sorted as required
+func asn1Set(elements ...[]byte) asn1.RawValue {
+	sorted := make([][]byte, len(elements))
+	copy(sorted, elements)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(sorted, nil),
+	}
+}
+
+// marshal, panicking on error as the structures are all internal
+func asn1MustMarshal(v interface{}) []byte {
+	data, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// encode a prefix as a BIT STRING
+func prefixBitString(network *net.IPNet) asn1.BitString {
+	plen, _ := network.Mask.Size()
+	ip := network.IP.To4()
+	if ip == nil {
+		ip = network.IP.To16()
+	}
+	return asn1.BitString{
+		Bytes:     append([]byte(nil), ip[:(plen+7)/8]...),
+		BitLength: plen,
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// RFC 3779 resource extensions
+
+// an interval of addresses
+type ipInterval struct {
+	start *big.Int
+	end   *big.Int
+}
+
+// encode a set of prefixes for one address family in canonical form,
+// adjacent and overlapping prefixes are merged and the result is
+// expressed as prefixes where possible, or ranges where not
+func encodeIPAddressOrRanges(networks []*net.IPNet, bits int) []byte {
+
+	intervals := make([]*ipInterval, 0, len(networks))
+	for _, network := range networks {
+		plen, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		if bits == 128 {
+			ip = network.IP.To16()
+		}
+		start := new(big.Int).SetBytes(ip)
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bits-plen))
+		end := new(big.Int).Add(start, size)
+		intervals = append(intervals, &ipInterval{
+			start: start,
+			end:   end.Sub(end, big.NewInt(1)),
+		})
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Cmp(intervals[j].start) < 0
+	})
+
+	// merge intervals that overlap or are adjacent
+	merged := make([]*ipInterval, 0, len(intervals))
+	for _, i := range intervals {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			next := new(big.Int).Add(last.end, big.NewInt(1))
+			if i.start.Cmp(next) <= 0 {
+				if i.end.Cmp(last.end) > 0 {
+					last.end = i.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, &ipInterval{start: i.start, end: i.end})
+	}
+
+	elements := make([][]byte, 0, len(merged))
+	for _, i := range merged {
+		elements = append(elements, encodeIPInterval(i, bits))
+	}
+
+	return asn1MustMarshal(asn1Sequence(elements...))
+}
+
+// encode a single interval as either a prefix or a range
+func encodeIPInterval(i *ipInterval, bits int) []byte {
+
+	size := new(big.Int).Sub(i.end, i.start)
+	size.Add(size, big.NewInt(1))
+
+	// is it a prefix ? the size must be a power of two and the
+	// start must be aligned to it
+	hostbits := size.BitLen() - 1
+	aligned := i.start.Sign() == 0 ||
+		i.start.TrailingZeroBits() >= uint(hostbits)
+	if size.Cmp(new(big.Int).Lsh(big.NewInt(1), uint(hostbits))) == 0 &&
+		aligned {
+		return asn1MustMarshal(intervalBitString(i.start, bits, bits-hostbits))
+	}
+
+	// otherwise a range, the min has trailing zeros removed and the
+	// max has trailing ones removed
+	minlen := bits - int(i.start.TrailingZeroBits())
+	if i.start.Sign() == 0 {
+		minlen = 0
+	}
+	ones := 0
+	for ones < bits && i.end.Bit(ones) == 1 {
+		ones++
+	}
+	maxlen := bits - ones
+
+	return asn1MustMarshal(struct {
+		Min asn1.BitString
+		Max asn1.BitString
+	}{
+		Min: intervalBitString(i.start, bits, minlen),
+		Max: intervalBitString(i.end, bits, maxlen),
+	})
+}
+
+// return the first n bits of an address as a BIT STRING
+func intervalBitString(value *big.Int, bits int, n int) asn1.BitString {
+
+	addr := value.FillBytes(make([]byte, bits/8))
+	data := append([]byte(nil), addr[:(n+7)/8]...)
+
+	// unused bits must be zero
+	if n%8 != 0 {
+		data[len(data)-1] &= byte(0xff << uint(8-n%8))
+	}
+
+	return asn1.BitString{Bytes: data, BitLength: n}
+}
+
+// return the IP address extension for a set of prefixes,
+// or for inherit if the list is nil
+func ipAddrBlocksExtension(ipv4 []*net.IPNet,
+	ipv6 []*net.IPNet, inherit bool) pkix.Extension {
+
+	family := func(afi []byte, choice []byte) []byte {
+		return asn1MustMarshal(asn1Sequence(
+			asn1MustMarshal(afi), choice,
+		))
+	}
+
+	var families [][]byte
+	if inherit {
+		null := asn1MustMarshal(asn1.NullRawValue)
+		families = append(families, family(afiIPv4, null), family(afiIPv6, null))
+	} else {
+		if len(ipv4) != 0 {
+			families = append(families,
+				family(afiIPv4, encodeIPAddressOrRanges(ipv4, 32)))
+		}
+		if len(ipv6) != 0 {
+			families = append(families,
+				family(afiIPv6, encodeIPAddressOrRanges(ipv6, 128)))
+		}
+	}
+
+	return pkix.Extension{
+		Id:       oidIPAddrBlocks,
+		Critical: true,
+		Value:    asn1MustMarshal(asn1Sequence(families...)),
+	}
+}
+
+// return the AS number extension covering all ASNs, or for inherit
+func asIdentifiersExtension(inherit bool) pkix.Extension {
+
+	var choice []byte
+	if inherit {
+		choice = asn1MustMarshal(asn1.NullRawValue)
+	} else {
+		choice = asn1MustMarshal(asn1Sequence(asn1MustMarshal(struct {
+			Min int64
+			Max int64
+		}{0, 4294967295})))
+	}
+
+	asnum := asn1MustMarshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      choice,
+	})
+
+	return pkix.Extension{
+		Id:       oidASIdentifiers,
+		Critical: true,
+		Value:    asn1MustMarshal(asn1Sequence(asnum)),
+	}
+}
+
+// return the subject information access extension
+func siaExtension(methods []asn1.ObjectIdentifier, uris []string) pkix.Extension {
+
+	elements := make([][]byte, len(methods))
+	for ix := range methods {
+		elements[ix] = asn1MustMarshal(struct {
+			Method   asn1.ObjectIdentifier
+			Location asn1.RawValue
+		}{
+			Method: methods[ix],
+			Location: asn1.RawValue{
+				Class: asn1.ClassContextSpecific,
+				Tag:   6,
+				Bytes: []byte(uris[ix]),
+			},
+		})
+	}
+
+	return pkix.Extension{
+		Id:    oidSubjectInfoAccess,
+		Value: asn1MustMarshal(asn1Sequence(elements...)),
+	}
+}
+
+// return the RPKI certificate policy extension
+func rpkiPolicyExtension() pkix.Extension {
+	return pkix.Extension{
+		Id:       oidCertPolicies,
+		Critical: true,
+		Value: asn1MustMarshal([]struct {
+			Policy asn1.ObjectIdentifier
+		}{{oidRPKIPolicy}}),
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// create a CMS signed object
+
+func signCMS(contentType asn1.ObjectIdentifier, content []byte,
+	cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, error) {
+
+	digest := sha256.Sum256(content)
+
+	// the signed attributes
+	attr := func(t asn1.ObjectIdentifier, value interface{}) []byte {
+		return asn1MustMarshal(cmsAttribute{
+			Type:   t,
+			Values: asn1Set(asn1MustMarshal(value)),
+		})
+	}
+	attrs := asn1Set(
+		attr(oidContentType, contentType),
+		attr(oidMessageDigest, digest[:]),
+		attr(oidSigningTime, time.Now().UTC().Truncate(time.Second)),
+	)
+
+	// the signature is calculated over the DER encoding of the
+	// attributes as a SET, and then stored with an implicit tag
+	attrsDER := asn1MustMarshal(attrs)
+	attrsDigest := sha256.Sum256(attrsDER)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key,
+		crypto.SHA256, attrsDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	attrs.Class = asn1.ClassContextSpecific
+	attrs.Tag = 0
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+
+	signedData := cmsSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: cmsEncapContentInfo{
+			EContentType: contentType,
+			EContent: asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        0,
+				IsCompound: true,
+				Bytes:      asn1MustMarshal(content),
+			},
+		},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      cert.Raw,
+		},
+		SignerInfos: []cmsSignerInfo{{
+			Version: 3,
+			SID: asn1.RawValue{
+				Class: asn1.ClassContextSpecific,
+				Tag:   0,
+				Bytes: cert.SubjectKeyId,
+			},
+			DigestAlgorithm: sha256Alg,
+			SignedAttrs:     attrs,
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidRSAEncryption,
+				Parameters: asn1.NullRawValue,
+			},
+			Signature: signature,
+		}},
+	}
+
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      asn1MustMarshal(signedData),
+		},
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// parse and verify a CMS signed object
+//
+// returns the EE certificate, content type and content
+
+func verifyCMS(data []byte, issuer *x509.Certificate) (*x509.Certificate,
+	asn1.ObjectIdentifier, []byte, error) {
+
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		return nil, nil, nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, nil, errors.New("not a CMS signed data object")
+	}
+
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, nil, nil, errors.New("expected exactly one signer")
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes,
+		&content); err != nil {
+		return nil, nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return nil, nil, nil, err
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, nil, nil, errors.New("EE certificate has expired")
+	}
+
+	si := sd.SignerInfos[0]
+	if !bytes.Equal(si.SID.Bytes, cert.SubjectKeyId) {
+		return nil, nil, nil, errors.New("signer does not match EE certificate")
+	}
+
+	// the signed attributes are re-tagged as a SET for checking
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		return nil, nil, nil, errors.New("no signed attributes")
+	}
+	attrsDER := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+
+	// check the message digest attribute
+	var attrs []cmsAttribute
+	if _, err := asn1.UnmarshalWithParams(attrsDER,
+		&attrs, "set"); err != nil {
+		return nil, nil, nil, err
+	}
+	digest := sha256.Sum256(content)
+	digestMatched := false
+	for _, a := range attrs {
+		if a.Type.Equal(oidMessageDigest) {
+			var md []byte
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &md); err != nil {
+				return nil, nil, nil, err
+			}
+			digestMatched = bytes.Equal(md, digest[:])
+		}
+	}
+	if !digestMatched {
+		return nil, nil, nil, errors.New("message digest does not match content")
+	}
+
+	// and finally the signature
+	attrsDigest := sha256.Sum256(attrsDER)
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, nil, errors.New("EE certificate key is not RSA")
+	}
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, attrsDigest[:],
+		si.Signature); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cert, sd.EncapContentInfo.EContentType, content, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// end of code